@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 
 	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/logging"
 	"github.com/rlnorthcutt/go-passwordless/store"
 	"github.com/rlnorthcutt/go-passwordless/transport"
 )
@@ -13,7 +15,7 @@ import (
 func main() {
 	ctx := context.Background()
 	memStore := store.NewMemStore()
-	logTransport := &transport.LogTransport{}
+	logTransport := &transport.LogTransport{Logger: logging.DevMode(slog.Default())}
 	mgr := passwordless.NewManager(memStore, logTransport)
 
 	fmt.Print("Enter your email: ")
@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http/httptest"
 	"os"
 
 	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/logging"
 	"github.com/rlnorthcutt/go-passwordless/store/session"
 	"github.com/rlnorthcutt/go-passwordless/transport"
 )
@@ -33,7 +35,7 @@ func main() {
 	}
 
 	// Initialize the passwordless manager with FileStore and LogTransport
-	mgr := passwordless.NewManager(fileStore, &transport.LogTransport{})
+	mgr := passwordless.NewManager(fileStore, &transport.LogTransport{Logger: logging.DevMode(slog.Default())})
 
 	// Prompt user for email
 	fmt.Print("Enter your email: ")
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/logging"
 	"github.com/rlnorthcutt/go-passwordless/store"
 	"github.com/rlnorthcutt/go-passwordless/transport"
 )
@@ -15,7 +17,7 @@ var mgr *passwordless.Manager
 
 func init() {
 	// Initialize passwordless manager with an in-memory token store and log transport.
-	mgr = passwordless.NewManager(store.NewMemStore(), &transport.LogTransport{})
+	mgr = passwordless.NewManager(store.NewMemStore(), &transport.LogTransport{Logger: logging.DevMode(slog.Default())})
 }
 
 func main() {
@@ -6,7 +6,6 @@ import (
 	"log"
 	"net/smtp"
 	"os"
-	"time"
 
 	"github.com/rlnorthcutt/go-passwordless"
 	"github.com/rlnorthcutt/go-passwordless/mocksmtp"
@@ -18,8 +17,14 @@ func main() {
 	// Start the mock SMTP server if using local testing
 	// `USE_MOCK_SMTP=true go run examples/smtp_auth/main.go`
 	if os.Getenv("USE_MOCK_SMTP") == "true" {
-		go mocksmtp.StartMockSMTPServer("2525")
-		time.Sleep(1 * time.Second) // Allow server to start
+		srv := mocksmtp.NewServer()
+		srv.ListenAddr = "127.0.0.1:2525"
+		srv.HTTPListenAddr = "127.0.0.1:8025"
+		if err := srv.Start(); err != nil {
+			log.Fatalf("Failed to start mock SMTP server: %v", err)
+		}
+		defer srv.Close()
+		log.Println("Captured mail is available at", srv.HTTPURL())
 	}
 
 	// Use the mock server by default if no real credentials are set
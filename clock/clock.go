@@ -0,0 +1,56 @@
+// Package clock lets the Manager, stores and rate limiters read the current
+// time through an interface, so expiry, lockout and cooldown logic can be
+// tested without sleeping (see the clocktest package).
+package clock
+
+import "time"
+
+// Clock reports the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the Clock backed by time.Now. It is used wherever no clock is configured.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// Ticker delivers ticks on C until stopped, like time.Ticker. Ticks are
+// dropped if the receiver falls behind.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// TickerClock is implemented by clocks that can also drive tickers, such as
+// Real and clocktest.Fake. See NewTicker.
+type TickerClock interface {
+	Clock
+	NewTicker(d time.Duration) Ticker
+}
+
+// NewTicker returns a ticker with period d driven by c. Clocks that do not
+// implement TickerClock fall back to a real ticker.
+func NewTicker(c Clock, d time.Duration) Ticker {
+	if tc, ok := c.(TickerClock); ok {
+		return tc.NewTicker(d)
+	}
+	return realClock{}.NewTicker(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (r realTicker) C() <-chan time.Time { return r.t.C }
+func (r realTicker) Stop()               { r.t.Stop() }
+
+// Or returns c, or Real if c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
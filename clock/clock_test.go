@@ -0,0 +1,84 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+)
+
+func TestClock(t *testing.T) {
+	t.Run("Real", func(t *testing.T) {
+		before := time.Now()
+		now := clock.Real.Now()
+		if now.Before(before) || now.After(time.Now()) {
+			t.Errorf("Expected Real clock to report the current time, got %v", now)
+		}
+	})
+
+	t.Run("Or", func(t *testing.T) {
+		if clock.Or(nil) != clock.Real {
+			t.Errorf("Expected Or(nil) to return Real")
+		}
+		fake := clocktest.NewFake(time.Unix(0, 0))
+		if clock.Or(fake) != fake {
+			t.Errorf("Expected Or to return the given clock")
+		}
+	})
+
+	t.Run("Fake", func(t *testing.T) {
+		start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		fake := clocktest.NewFake(start)
+		if !fake.Now().Equal(start) {
+			t.Fatalf("Expected %v, got %v", start, fake.Now())
+		}
+
+		fake.Advance(15 * time.Minute)
+		if want := start.Add(15 * time.Minute); !fake.Now().Equal(want) {
+			t.Errorf("Expected %v after Advance, got %v", want, fake.Now())
+		}
+
+		fake.Set(start)
+		if !fake.Now().Equal(start) {
+			t.Errorf("Expected %v after Set, got %v", start, fake.Now())
+		}
+	})
+
+	t.Run("FakeTicker", func(t *testing.T) {
+		fake := clocktest.NewFake(time.Unix(0, 0))
+		ticker := clock.NewTicker(fake, time.Minute)
+		defer ticker.Stop()
+
+		fake.Advance(59 * time.Second)
+		select {
+		case <-ticker.C():
+			t.Fatal("Expected no tick before the period elapsed")
+		default:
+		}
+
+		// Several missed periods deliver a single tick
+		fake.Advance(5 * time.Minute)
+		select {
+		case got := <-ticker.C():
+			if !got.Equal(fake.Now()) {
+				t.Errorf("Expected the tick to carry %v, got %v", fake.Now(), got)
+			}
+		default:
+			t.Fatal("Expected a tick once the period elapsed")
+		}
+		select {
+		case <-ticker.C():
+			t.Fatal("Expected missed ticks to be dropped")
+		default:
+		}
+
+		ticker.Stop()
+		fake.Advance(time.Hour)
+		select {
+		case <-ticker.C():
+			t.Fatal("Expected no tick after Stop")
+		default:
+		}
+	})
+}
@@ -0,0 +1,93 @@
+// Package clocktest provides a controllable clock.Clock for tests.
+package clocktest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+)
+
+// Fake is a clock.Clock that only moves when told to. Its tickers fire when
+// Advance or Set moves the clock past their next tick. It is safe for
+// concurrent use.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers map[*fakeTicker]struct{}
+}
+
+// NewFake returns a Fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake clock's current time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	f.fire()
+}
+
+// Set moves the clock to t.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = t
+	f.fire()
+}
+
+// NewTicker returns a ticker with period d that fires as the clock is moved.
+// Like time.Ticker, it delivers at most one pending tick.
+func (f *Fake) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("clocktest: non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{f: f, c: make(chan time.Time, 1), period: d, next: f.now.Add(d)}
+	if f.tickers == nil {
+		f.tickers = make(map[*fakeTicker]struct{})
+	}
+	f.tickers[t] = struct{}{}
+	return t
+}
+
+// fire delivers a tick to every ticker that is due. The caller must hold f.mu.
+func (f *Fake) fire() {
+	for t := range f.tickers {
+		if f.now.Before(t.next) {
+			continue
+		}
+		select {
+		case t.c <- f.now:
+		default:
+		}
+		// Skip the ticks that were missed, as time.Ticker does
+		missed := f.now.Sub(t.next) / t.period
+		t.next = t.next.Add((missed + 1) * t.period)
+	}
+}
+
+type fakeTicker struct {
+	f      *Fake
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	delete(t.f.tickers, t)
+}
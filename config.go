@@ -3,7 +3,15 @@ package passwordless
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"log/slog"
 	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/metrics"
+	"github.com/rlnorthcutt/go-passwordless/ratelimit"
+	"github.com/rlnorthcutt/go-passwordless/session"
+	"github.com/rlnorthcutt/go-passwordless/signedlink"
+	"github.com/rlnorthcutt/go-passwordless/tracing"
 )
 
 // Config holds all configurable aspects of the passwordless flow.
@@ -28,8 +36,76 @@ type Config struct {
 	// or "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789" for alphanumeric codes.
 	CodeCharset string
 
+	// SingleActiveToken, if true, makes StartLogin revoke every outstanding token
+	// for the recipient before issuing a new one, so only the most recently
+	// requested code is ever valid.
+	SingleActiveToken bool
+
 	// MaxFailedAttempts is the maximum number of failed attempts allowed before the token is invalidated.
 	MaxFailedAttempts int
+
+	// ResendInterval is the minimum time between deliveries of a code for the
+	// same token when calling ResendLogin (e.g., 30 seconds).
+	ResendInterval time.Duration
+
+	// MaxResends is the maximum number of times ResendLogin may re-send a code for a single token.
+	MaxResends int
+
+	// Logger receives structured log records for every lifecycle event. If nil,
+	// nothing is logged. Codes and code hashes are removed and recipients masked
+	// unless the logger is wrapped with logging.DevMode.
+	Logger *slog.Logger
+
+	// Metrics, if set, receives counters for logins, deliveries and verifications
+	// and histograms for delivery and verification latency (see the metrics package).
+	Metrics metrics.Recorder
+
+	// Tracer, if set, wraps store lookups, transport sends and verifications in
+	// spans (see the tracing package for an OpenTelemetry adapter).
+	Tracer tracing.Tracer
+
+	// Clock is the source of the current time for token expiry, resend cooldowns
+	// and event timestamps. If nil, the real clock is used. Tests can supply a
+	// clocktest.Fake to control time.
+	Clock clock.Clock
+
+	// SessionIssuer creates the session handed out by VerifyLoginSession and
+	// VerifyLoginLinkSession (e.g. a session.JWTIssuer).
+	SessionIssuer session.Issuer
+
+	// LinkSigner creates and verifies the stateless links handed out by
+	// GenerateSignedLink and VerifySignedLink (see the signedlink package).
+	LinkSigner *signedlink.Signer
+
+	// Hooks receive lifecycle events (login started, code delivered, verification
+	// succeeded or failed, ...). See Hook and Manager.AddHook. Set Hooks before
+	// the Manager is in use; use AddHook to register one afterwards.
+	Hooks []Hook
+
+	// HookTimeout bounds how long the Manager waits for hooks to handle a single
+	// event, so a slow hook cannot block a login indefinitely. Zero means
+	// DefaultHookTimeout (2 seconds).
+	HookTimeout time.Duration
+
+	// RecipientLimiter, if set, limits how often StartLogin may be called for a single recipient.
+	RecipientLimiter ratelimit.RateLimiter
+
+	// IPLimiter, if set, limits how often StartLogin may be called from a single
+	// caller IP. The IP is read from the context (see WithClientIP); calls without
+	// one are not limited.
+	IPLimiter ratelimit.RateLimiter
+
+	// VerifyLimiter, if set, limits how often VerifyLogin may be called from a single caller IP.
+	VerifyLimiter ratelimit.RateLimiter
+
+	// JanitorInterval is how often the janitor started by StartJanitor purges
+	// expired tokens (e.g., 1 minute).
+	JanitorInterval time.Duration
+
+	// JanitorBatchSize caps how many tokens the janitor deletes per store call,
+	// keeping each lock or transaction short. Zero or less deletes all expired
+	// tokens in one call.
+	JanitorBatchSize int
 }
 
 // DefaultConfig provides sensible defaults for a typical passwordless flow.
@@ -40,6 +116,11 @@ func DefaultConfig() Config {
 		IDGenerator:       defaultIDGenerator,
 		CodeCharset:       "0123456789", // numeric-only
 		MaxFailedAttempts: 3,
+		ResendInterval:    30 * time.Second,
+		MaxResends:        3,
+		HookTimeout:       DefaultHookTimeout,
+		JanitorInterval:   DefaultJanitorInterval,
+		JanitorBatchSize:  DefaultJanitorBatchSize,
 	}
 }
 
@@ -43,6 +43,21 @@ func TestDefaultConfig(t *testing.T) {
 				t.Errorf("Expected MaxFailedAttempts to be 3, got %d", dc.MaxFailedAttempts)
 			}
 		})
+
+		t.Run("Resend Limits", func(t *testing.T) {
+			if dc.ResendInterval != 30*time.Second {
+				t.Errorf("Expected ResendInterval to be 30s, got %v", dc.ResendInterval)
+			}
+			if dc.MaxResends != 3 {
+				t.Errorf("Expected MaxResends to be 3, got %d", dc.MaxResends)
+			}
+		})
+
+		t.Run("Janitor", func(t *testing.T) {
+			if dc.JanitorInterval != time.Minute || dc.JanitorBatchSize != 500 {
+				t.Errorf("Expected janitor defaults of 1m and 500, got %v and %d", dc.JanitorInterval, dc.JanitorBatchSize)
+			}
+		})
 	})
 }
 
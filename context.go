@@ -0,0 +1,50 @@
+package passwordless
+
+import "context"
+
+// contextKey is a custom type to avoid collisions with other context values.
+type contextKey string
+
+const (
+	ctxKeyClientIP        contextKey = "clientIP"
+	ctxKeyLocale          contextKey = "locale"
+	ctxKeyMessageMetadata contextKey = "messageMetadata"
+)
+
+// WithClientIP records the caller's IP address in the context so that the
+// Manager can apply per-IP rate limits.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ctxKeyClientIP, ip)
+}
+
+// ClientIPFromContext returns the IP address stored by WithClientIP, if any.
+func ClientIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(ctxKeyClientIP).(string)
+	return ip, ok && ip != ""
+}
+
+// WithLocale records the recipient's preferred language (a BCP 47 tag such as
+// "de-CH") in the context. The Manager passes it to the transport in
+// transport.Message.Locale.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, ctxKeyLocale, locale)
+}
+
+// LocaleFromContext returns the locale stored by WithLocale, if any.
+func LocaleFromContext(ctx context.Context) (string, bool) {
+	locale, ok := ctx.Value(ctxKeyLocale).(string)
+	return locale, ok && locale != ""
+}
+
+// WithMessageMetadata attaches application-defined values (e.g. the user's
+// name) to the context. The Manager passes them to the transport in
+// transport.Message.Metadata.
+func WithMessageMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	return context.WithValue(ctx, ctxKeyMessageMetadata, metadata)
+}
+
+// MessageMetadataFromContext returns the metadata stored by WithMessageMetadata, or nil.
+func MessageMetadataFromContext(ctx context.Context) map[string]string {
+	metadata, _ := ctx.Value(ctxKeyMessageMetadata).(map[string]string)
+	return metadata
+}
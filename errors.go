@@ -0,0 +1,94 @@
+package passwordless
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/ratelimit"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// The token errors are defined by the store package so that every TokenStore
+// and the Manager return the same values. They are re-exported here so most
+// callers only need to import this package.
+var (
+	ErrTokenNotFound = store.ErrTokenNotFound
+	ErrTokenExpired  = store.ErrTokenExpired
+	ErrInvalidCode   = store.ErrInvalidCode
+	ErrTokenLocked   = store.ErrTokenLocked
+	ErrTokenConsumed = store.ErrTokenConsumed
+	ErrTokenConflict = store.ErrTokenConflict
+)
+
+// ErrPurgeUnsupported is returned by StartJanitor when the store does not
+// implement store.Purger.
+var ErrPurgeUnsupported = store.ErrPurgeUnsupported
+
+// ErrRateLimited is matched (via errors.Is) by every *RateLimitError.
+var ErrRateLimited = ratelimit.ErrRateLimited
+
+// ErrResendTooSoon is matched (via errors.Is) by every *ResendTooSoonError.
+var ErrResendTooSoon = errors.New("resend requested too soon")
+
+// ErrResendLimitReached is returned by ResendLogin once a token has been
+// re-sent MaxResends times.
+var ErrResendLimitReached = errors.New("resend limit reached")
+
+// ErrLinkResendNeedsURL is returned by ResendLogin for a token that has a
+// login link; use ResendLoginLink, which takes the base URL for the new link.
+var ErrLinkResendNeedsURL = errors.New("token has a login link; resend it with ResendLoginLink")
+
+// ErrNoSessionIssuer is returned by VerifyLoginSession and VerifyLoginLinkSession
+// when Config.SessionIssuer is not set.
+var ErrNoSessionIssuer = errors.New("no session issuer configured")
+
+// ErrNoLinkSigner is returned by GenerateSignedLink and VerifySignedLink when
+// Config.LinkSigner is not set.
+var ErrNoLinkSigner = errors.New("no link signer configured")
+
+// ErrTransport is matched (via errors.Is) by every delivery failure returned by the Manager.
+var ErrTransport = errors.New("transport failure")
+
+// InvalidCodeError is returned by VerifyLogin and VerifyLoginLink when the
+// provided code or link does not match. See store.InvalidCodeError.
+type InvalidCodeError = store.InvalidCodeError
+
+// RateLimitError is returned when a configured RateLimiter rejects a call.
+// RetryAfter reports how long the caller should wait.
+type RateLimitError = ratelimit.RateLimitError
+
+// ResendTooSoonError is returned by ResendLogin when the previous code was
+// delivered less than ResendInterval ago.
+type ResendTooSoonError struct {
+	RetryAfter time.Duration // How long the caller should wait before trying again
+}
+
+func (e *ResendTooSoonError) Error() string {
+	return fmt.Sprintf("resend requested too soon, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// Is reports whether target is ErrResendTooSoon.
+func (e *ResendTooSoonError) Is(target error) bool {
+	return target == ErrResendTooSoon
+}
+
+// TransportError wraps an error returned by the configured Transport.
+// It matches ErrTransport via errors.Is and unwraps to the underlying error.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return "transport failure: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying transport error.
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrTransport.
+func (e *TransportError) Is(target error) bool {
+	return target == ErrTransport
+}
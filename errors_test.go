@@ -0,0 +1,106 @@
+package passwordless_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// FailingTransport always fails to deliver.
+type FailingTransport struct {
+	Err error
+}
+
+func (ft *FailingTransport) Send(ctx context.Context, recipient, code string) error {
+	return ft.Err
+}
+
+func TestManagerErrors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("NotFound", func(t *testing.T) {
+		mgr := passwordless.NewManager(store.NewMemStore(), &TestTransport{})
+
+		_, err := mgr.VerifyLogin(ctx, "missing", "123456")
+		if !errors.Is(err, passwordless.ErrTokenNotFound) {
+			t.Fatalf("Expected ErrTokenNotFound, got %v", err)
+		}
+	})
+
+	t.Run("InvalidCodeThenLocked", func(t *testing.T) {
+		tt := &TestTransport{}
+		mgr := passwordless.NewManager(store.NewMemStore(), tt)
+
+		tokenID, err := mgr.StartLogin(ctx, "user@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+
+		for i := 1; i < mgr.Config.MaxFailedAttempts; i++ {
+			_, err := mgr.VerifyLogin(ctx, tokenID, "wrong")
+			var invalid *passwordless.InvalidCodeError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Attempt %d: expected InvalidCodeError, got %v", i, err)
+			}
+			if !errors.Is(err, passwordless.ErrInvalidCode) {
+				t.Fatalf("Attempt %d: expected error to match ErrInvalidCode", i)
+			}
+			if invalid.Attempts != i {
+				t.Errorf("Attempt %d: expected Attempts %d, got %d", i, i, invalid.Attempts)
+			}
+			if want := mgr.Config.MaxFailedAttempts - i; invalid.AttemptsRemaining != want {
+				t.Errorf("Attempt %d: expected AttemptsRemaining %d, got %d", i, want, invalid.AttemptsRemaining)
+			}
+		}
+
+		_, err = mgr.VerifyLogin(ctx, tokenID, "wrong")
+		if !errors.Is(err, passwordless.ErrTokenLocked) {
+			t.Fatalf("Expected ErrTokenLocked, got %v", err)
+		}
+
+		_, err = mgr.VerifyLogin(ctx, tokenID, tt.LastCode)
+		if !errors.Is(err, passwordless.ErrTokenNotFound) {
+			t.Fatalf("Expected locked token to be gone, got %v", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		memStore := store.NewMemStore()
+		tt := &TestTransport{}
+		mgr := passwordless.NewManager(memStore, tt)
+
+		tokenID, err := mgr.StartLogin(ctx, "user@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		tok, _ := memStore.Exists(ctx, tokenID)
+		tok.ExpiresAt = time.Now().Add(-1 * time.Minute)
+		_ = memStore.Store(ctx, *tok)
+
+		_, err = mgr.VerifyLogin(ctx, tokenID, tt.LastCode)
+		if !errors.Is(err, passwordless.ErrTokenExpired) {
+			t.Fatalf("Expected ErrTokenExpired, got %v", err)
+		}
+	})
+
+	t.Run("TransportFailure", func(t *testing.T) {
+		sendErr := errors.New("connection refused")
+		mgr := passwordless.NewManager(store.NewMemStore(), &FailingTransport{Err: sendErr})
+
+		_, err := mgr.StartLogin(ctx, "user@example.com")
+		if !errors.Is(err, passwordless.ErrTransport) {
+			t.Fatalf("Expected ErrTransport, got %v", err)
+		}
+		if !errors.Is(err, sendErr) {
+			t.Fatalf("Expected error to unwrap to the transport error, got %v", err)
+		}
+		var te *passwordless.TransportError
+		if !errors.As(err, &te) {
+			t.Fatalf("Expected TransportError, got %T", err)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package passwordless
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/logging"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// DefaultHookTimeout is used when Config.HookTimeout is zero.
+const DefaultHookTimeout = 2 * time.Second
+
+// EventType identifies a lifecycle event emitted by the Manager.
+type EventType string
+
+const (
+	EventLoginStarted    EventType = "login_started"    // A token was created by StartLogin
+	EventCodeDelivered   EventType = "code_delivered"   // The transport accepted the code
+	EventDeliveryFailed  EventType = "delivery_failed"  // The transport returned an error
+	EventVerifySucceeded EventType = "verify_succeeded" // A code or link was redeemed
+	EventVerifyFailed    EventType = "verify_failed"    // A code or link was rejected
+	EventTokenExpired    EventType = "token_expired"    // Verification hit an expired token
+	EventTokenLocked     EventType = "token_locked"     // The token reached MaxFailedAttempts
+)
+
+// Event describes something that happened during a passwordless flow.
+type Event struct {
+	Type      EventType
+	TokenID   string
+	Recipient string    // Empty when the recipient is unknown (e.g. a failed verification)
+	Attempts  int       // Failed attempts so far, set for EventVerifyFailed and EventTokenLocked
+	Err       error     // The underlying error for failure events
+	Time      time.Time // When the event occurred
+}
+
+// Hook receives lifecycle events from the Manager.
+//
+// Hooks are called synchronously but each call is bounded by Config.HookTimeout:
+// the ctx passed to OnEvent is canceled when the timeout elapses and the Manager
+// stops waiting for the hook. The ctx is detached from the caller's cancellation
+// so a hook can finish work after the originating request has returned.
+type Hook interface {
+	OnEvent(ctx context.Context, ev Event)
+}
+
+// HookFunc adapts an ordinary function to the Hook interface.
+type HookFunc func(ctx context.Context, ev Event)
+
+// OnEvent calls f(ctx, ev).
+func (f HookFunc) OnEvent(ctx context.Context, ev Event) {
+	f(ctx, ev)
+}
+
+// Hooks composes several hooks into one, calling each in order.
+type Hooks []Hook
+
+// OnEvent calls every hook in hs.
+func (hs Hooks) OnEvent(ctx context.Context, ev Event) {
+	for _, h := range hs {
+		h.OnEvent(ctx, ev)
+	}
+}
+
+// AddHook registers an additional hook on the Manager. It is safe to call
+// while logins are in flight; events already being emitted do not see h.
+func (m *Manager) AddHook(h Hook) {
+	m.hooksMu.Lock()
+	defer m.hooksMu.Unlock()
+	// Copy on append so a concurrent emit keeps iterating its own snapshot.
+	hooks := m.Config.Hooks
+	m.Config.Hooks = append(hooks[:len(hooks):len(hooks)], h)
+}
+
+// emit delivers ev to every registered hook concurrently and waits until they
+// all return or Config.HookTimeout (DefaultHookTimeout if zero) elapses,
+// whichever comes first. A panicking hook is recovered and logged so it cannot
+// take down the caller.
+func (m *Manager) emit(ctx context.Context, ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = m.now()
+	}
+	m.logEvent(ctx, ev)
+	m.recordEvent(ev)
+
+	m.hooksMu.RLock()
+	hooks := m.Config.Hooks
+	m.hooksMu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+
+	timeout := m.Config.HookTimeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	hookCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, h := range hooks {
+		wg.Add(1)
+		go func(h Hook) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					m.logger().WarnContext(hookCtx, "hook panicked",
+						logging.KeyEvent, string(ev.Type), logging.KeyError, fmt.Sprint(r))
+				}
+			}()
+			h.OnEvent(hookCtx, ev)
+		}(h)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-hookCtx.Done():
+	}
+}
+
+// emitVerifyResult classifies the outcome of a verification and emits the
+// matching event. tok is the consumed token on success and may be nil otherwise.
+func (m *Manager) emitVerifyResult(ctx context.Context, tokenID string, tok *store.Token, err error) {
+	ev := Event{TokenID: tokenID, Err: err}
+	if tok != nil {
+		ev.Recipient = tok.Recipient
+	}
+
+	var invalid *InvalidCodeError
+	switch {
+	case err == nil:
+		ev.Type = EventVerifySucceeded
+	case errors.Is(err, ErrTokenExpired):
+		ev.Type = EventTokenExpired
+	case errors.Is(err, ErrTokenLocked):
+		ev.Type = EventTokenLocked
+		ev.Attempts = m.Config.MaxFailedAttempts
+	case errors.As(err, &invalid):
+		ev.Type = EventVerifyFailed
+		ev.Attempts = invalid.Attempts
+	default:
+		ev.Type = EventVerifyFailed
+	}
+
+	m.emit(ctx, ev)
+}
@@ -0,0 +1,263 @@
+package passwordless_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/store"
+	"github.com/rlnorthcutt/go-passwordless/transport"
+)
+
+// recordingHook collects every event it receives.
+type recordingHook struct {
+	mu     sync.Mutex
+	events []passwordless.Event
+}
+
+func (h *recordingHook) OnEvent(ctx context.Context, ev passwordless.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, ev)
+}
+
+func (h *recordingHook) types() []passwordless.EventType {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]passwordless.EventType, len(h.events))
+	for i, ev := range h.events {
+		out[i] = ev.Type
+	}
+	return out
+}
+
+func (h *recordingHook) last() passwordless.Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.events[len(h.events)-1]
+}
+
+func TestHooks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("CodeFlow", func(t *testing.T) {
+		hook := &recordingHook{}
+		tt := &TestTransport{}
+		mgr := passwordless.NewManager(store.NewMemStore(), tt)
+		mgr.AddHook(hook)
+
+		tokenID, err := mgr.StartLogin(ctx, "hooks@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		_, _ = mgr.VerifyLogin(ctx, tokenID, "wrong")
+		if ev := hook.last(); ev.Type != passwordless.EventVerifyFailed || ev.Attempts != 1 {
+			t.Errorf("Expected verify_failed with 1 attempt, got %+v", ev)
+		}
+
+		if ok, err := mgr.VerifyLogin(ctx, tokenID, tt.LastCode); !ok || err != nil {
+			t.Fatalf("VerifyLogin failed: ok=%v err=%v", ok, err)
+		}
+		if ev := hook.last(); ev.Recipient != "hooks@example.com" {
+			t.Errorf("Expected the success event to carry the recipient, got %q", ev.Recipient)
+		}
+
+		want := []passwordless.EventType{
+			passwordless.EventLoginStarted,
+			passwordless.EventCodeDelivered,
+			passwordless.EventVerifyFailed,
+			passwordless.EventVerifySucceeded,
+		}
+		assertEventTypes(t, hook.types(), want)
+	})
+
+	t.Run("DeliveryFailed", func(t *testing.T) {
+		hook := &recordingHook{}
+		mgr := passwordless.NewManager(store.NewMemStore(), &FailingTransport{Err: errors.New("boom")})
+		mgr.AddHook(hook)
+
+		_, _ = mgr.StartLogin(ctx, "hooks@example.com")
+		assertEventTypes(t, hook.types(), []passwordless.EventType{
+			passwordless.EventLoginStarted,
+			passwordless.EventDeliveryFailed,
+		})
+		if ev := hook.last(); ev.Err == nil {
+			t.Error("Expected delivery_failed to carry the transport error")
+		}
+	})
+
+	t.Run("LockedAndExpired", func(t *testing.T) {
+		hook := &recordingHook{}
+		memStore := store.NewMemStore()
+		tt := &TestTransport{}
+		mgr := passwordless.NewManager(memStore, tt)
+		mgr.AddHook(hook)
+
+		tokenID, _ := mgr.StartLogin(ctx, "hooks@example.com")
+		for i := 0; i < mgr.Config.MaxFailedAttempts; i++ {
+			_, _ = mgr.VerifyLogin(ctx, tokenID, "wrong")
+		}
+		if ev := hook.last(); ev.Type != passwordless.EventTokenLocked {
+			t.Errorf("Expected token_locked, got %s", ev.Type)
+		}
+
+		tokenID, _ = mgr.StartLogin(ctx, "hooks@example.com")
+		tok, _ := memStore.Exists(ctx, tokenID)
+		tok.ExpiresAt = time.Now().Add(-1 * time.Minute)
+		_ = memStore.Store(ctx, *tok)
+		_, _ = mgr.VerifyLogin(ctx, tokenID, tt.LastCode)
+		if ev := hook.last(); ev.Type != passwordless.EventTokenExpired {
+			t.Errorf("Expected token_expired, got %s", ev.Type)
+		}
+	})
+
+	t.Run("LoginLink", func(t *testing.T) {
+		hook := &recordingHook{}
+		mgr := passwordless.NewManager(store.NewMemStore(), &TestTransport{})
+		mgr.AddHook(hook)
+
+		link, err := mgr.GenerateLoginLink(ctx, "hooks@example.com", "https://myapp.com/login")
+		if err != nil {
+			t.Fatalf("GenerateLoginLink returned error: %v", err)
+		}
+		u, _ := url.Parse(link)
+		if ok, _ := mgr.VerifyLoginLink(ctx, u.Query().Get(passwordless.LinkTokenParam), u.Query().Get(passwordless.LinkSecretParam)); !ok {
+			t.Fatal("Expected login link to verify")
+		}
+		if ev := hook.last(); ev.Type != passwordless.EventVerifySucceeded {
+			t.Errorf("Expected verify_succeeded, got %s", ev.Type)
+		}
+	})
+
+	t.Run("ComposedHooks", func(t *testing.T) {
+		a, b := &recordingHook{}, &recordingHook{}
+		cfg := passwordless.DefaultConfig()
+		cfg.Hooks = []passwordless.Hook{passwordless.Hooks{a, b}}
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), &TestTransport{}, cfg)
+
+		_, _ = mgr.StartLogin(ctx, "hooks@example.com")
+		if len(a.types()) != 2 || len(b.types()) != 2 {
+			t.Fatalf("Expected both hooks to receive 2 events, got %d and %d", len(a.types()), len(b.types()))
+		}
+	})
+
+	t.Run("SlowHookTimesOut", func(t *testing.T) {
+		cfg := passwordless.DefaultConfig()
+		cfg.HookTimeout = 50 * time.Millisecond
+		tt := &TestTransport{}
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), tt, cfg)
+
+		release := make(chan struct{})
+		defer close(release)
+		mgr.AddHook(passwordless.HookFunc(func(ctx context.Context, ev passwordless.Event) {
+			select {
+			case <-release:
+			case <-time.After(time.Minute):
+			}
+		}))
+
+		tokenID, _ := mgr.StartLogin(ctx, "slow@example.com")
+
+		start := time.Now()
+		ok, err := mgr.VerifyLogin(ctx, tokenID, tt.LastCode)
+		if !ok || err != nil {
+			t.Fatalf("VerifyLogin failed: ok=%v err=%v", ok, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("Expected a slow hook to be abandoned after the timeout, took %v", elapsed)
+		}
+	})
+
+	t.Run("ZeroHookTimeoutUsesDefault", func(t *testing.T) {
+		mgr := passwordless.NewManager(store.NewMemStore(), &TestTransport{})
+		mgr.Config.HookTimeout = 0
+
+		var ctxErr error
+		mgr.AddHook(passwordless.HookFunc(func(ctx context.Context, ev passwordless.Event) {
+			if ev.Type == passwordless.EventLoginStarted {
+				ctxErr = ctx.Err()
+			}
+		}))
+		if _, err := mgr.StartLogin(ctx, "zero@example.com"); err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		if ctxErr != nil {
+			t.Fatalf("Expected a live hook context with a zero HookTimeout, got %v", ctxErr)
+		}
+	})
+
+	t.Run("ConcurrentAddHook", func(t *testing.T) {
+		mgr := passwordless.NewManager(store.NewMemStore(), &transport.LogTransport{})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				mgr.AddHook(&recordingHook{})
+			}()
+			go func() {
+				defer wg.Done()
+				_, _ = mgr.StartLogin(ctx, "concurrent@example.com")
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("PanickingHook", func(t *testing.T) {
+		var buf syncBuffer
+		cfg := passwordless.DefaultConfig()
+		cfg.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), &TestTransport{}, cfg)
+		mgr.AddHook(passwordless.HookFunc(func(ctx context.Context, ev passwordless.Event) {
+			panic("hook failure")
+		}))
+
+		if _, err := mgr.StartLogin(ctx, "panic@example.com"); err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		out := buf.String()
+		for _, want := range []string{"level=WARN", `msg="hook panicked"`, "event=login_started", `error="hook failure"`} {
+			if !strings.Contains(out, want) {
+				t.Errorf("Expected the panic to be logged with %q, got:\n%s", want, out)
+			}
+		}
+	})
+}
+
+func assertEventTypes(t *testing.T, got, want []passwordless.EventType) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected events %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected events %v, got %v", want, got)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer that hooks running in goroutines can log to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
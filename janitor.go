@@ -0,0 +1,123 @@
+package passwordless
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/logging"
+	"github.com/rlnorthcutt/go-passwordless/metrics"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// Defaults for Config.JanitorInterval and Config.JanitorBatchSize.
+const (
+	DefaultJanitorInterval  = time.Minute
+	DefaultJanitorBatchSize = 500
+)
+
+// Janitor periodically removes expired tokens from the Manager's store, so
+// abandoned logins do not accumulate. Create one with Manager.StartJanitor.
+type Janitor struct {
+	m      *Manager
+	purger store.Purger
+	ticker clock.Ticker
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu    sync.Mutex
+	swept int
+}
+
+// StartJanitor starts a goroutine that purges expired tokens every
+// Config.JanitorInterval, deleting at most Config.JanitorBatchSize tokens per
+// store call. It runs until ctx is done or the returned Janitor is closed.
+// It returns ErrPurgeUnsupported if the store does not implement store.Purger.
+func (m *Manager) StartJanitor(ctx context.Context) (*Janitor, error) {
+	purger, ok := m.Store.(store.Purger)
+	if !ok {
+		return nil, ErrPurgeUnsupported
+	}
+
+	interval := m.Config.JanitorInterval
+	if interval <= 0 {
+		interval = DefaultJanitorInterval
+	}
+
+	// The ticker follows Config.Clock, so a clocktest.Fake can drive sweeps.
+	// It is created before StartJanitor returns so no tick can be missed.
+	ctx, cancel := context.WithCancel(ctx)
+	j := &Janitor{
+		m:      m,
+		purger: purger,
+		ticker: clock.NewTicker(clock.Or(m.Config.Clock), interval),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go j.run(ctx)
+	return j, nil
+}
+
+func (j *Janitor) run(ctx context.Context) {
+	defer close(j.done)
+
+	defer j.ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-j.ticker.C():
+		}
+		// A wrapped store may turn out not to support purging; there is no
+		// point retrying.
+		if _, err := j.Sweep(ctx); errors.Is(err, ErrPurgeUnsupported) {
+			return
+		}
+	}
+}
+
+// Sweep purges expired tokens now, in batches of Config.JanitorBatchSize
+// until a batch comes back short, and reports how many were removed.
+func (j *Janitor) Sweep(ctx context.Context) (int, error) {
+	batch := j.m.Config.JanitorBatchSize
+	total := 0
+	var err error
+	for {
+		var n int
+		n, err = j.purger.PurgeExpired(ctx, batch)
+		total += n
+		if err != nil || batch <= 0 || n < batch || ctx.Err() != nil {
+			break
+		}
+	}
+
+	j.mu.Lock()
+	j.swept += total
+	j.mu.Unlock()
+
+	metrics.AddCounter(j.m.recorder(), metrics.TokensPurged, total, nil)
+	if total > 0 {
+		j.m.logger().InfoContext(ctx, "expired tokens purged", logging.KeyCount, total)
+	}
+	if err != nil && ctx.Err() == nil {
+		j.m.logger().WarnContext(ctx, "failed to purge expired tokens", logging.KeyError, err)
+	}
+	return total, err
+}
+
+// Swept reports how many tokens the janitor has removed since it started.
+func (j *Janitor) Swept() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.swept
+}
+
+// Close stops the janitor and waits for a sweep in progress to finish. It is
+// safe to call more than once.
+func (j *Janitor) Close() error {
+	j.cancel()
+	<-j.done
+	return nil
+}
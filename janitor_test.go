@@ -0,0 +1,136 @@
+package passwordless_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/metrics"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// plainStore hides every method of the wrapped store except TokenStore's.
+type plainStore struct {
+	store.TokenStore
+}
+
+func TestJanitor(t *testing.T) {
+	ctx := context.Background()
+
+	// newManager returns a Manager whose store and Manager share a fake clock.
+	newManager := func(cfg passwordless.Config) (*passwordless.Manager, *clocktest.Fake) {
+		clk := clocktest.NewFake(time.Now())
+		s := store.NewMemStore()
+		s.Clock = clk
+		cfg.Clock = clk
+		return passwordless.NewManagerWithConfig(s, &TestTransport{}, cfg), clk
+	}
+
+	t.Run("SweepsInBatches", func(t *testing.T) {
+		reg := metrics.NewRegistry()
+		cfg := passwordless.DefaultConfig()
+		cfg.JanitorBatchSize = 2
+		cfg.Metrics = reg
+		mgr, clk := newManager(cfg)
+
+		for i := 0; i < 5; i++ {
+			if _, err := mgr.StartLogin(ctx, "janitor@example.com"); err != nil {
+				t.Fatalf("StartLogin returned error: %v", err)
+			}
+		}
+		clk.Advance(cfg.TokenExpiry + time.Second)
+		live, _ := mgr.StartLogin(ctx, "janitor@example.com")
+
+		j, err := mgr.StartJanitor(ctx)
+		if err != nil {
+			t.Fatalf("StartJanitor returned error: %v", err)
+		}
+		defer j.Close()
+
+		n, err := j.Sweep(ctx)
+		if err != nil || n != 5 {
+			t.Fatalf("Expected 5 expired tokens swept, got %d (%v)", n, err)
+		}
+		if j.Swept() != 5 {
+			t.Errorf("Expected Swept to report 5, got %d", j.Swept())
+		}
+		if _, err := mgr.Store.Exists(ctx, live); err != nil {
+			t.Errorf("Expected the unexpired token to survive, got %v", err)
+		}
+		if c := reg.CounterValue(metrics.TokensPurged, nil); c != 5 {
+			t.Errorf("Expected 5 purged tokens counted, got %v", c)
+		}
+	})
+
+	t.Run("RunsPeriodically", func(t *testing.T) {
+		cfg := passwordless.DefaultConfig()
+		mgr, clk := newManager(cfg)
+
+		j, err := mgr.StartJanitor(ctx)
+		if err != nil {
+			t.Fatalf("StartJanitor returned error: %v", err)
+		}
+		defer j.Close()
+
+		mgr.StartLogin(ctx, "periodic@example.com")
+		mgr.StartLogin(ctx, "periodic@example.com")
+
+		// Moving the fake clock past the expiry also fires the janitor's ticker
+		clk.Advance(cfg.TokenExpiry + time.Second)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for j.Swept() < 2 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the janitor to sweep 2 tokens, swept %d", j.Swept())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	t.Run("StopsWithContext", func(t *testing.T) {
+		cfg := passwordless.DefaultConfig()
+		cfg.JanitorInterval = time.Millisecond
+		mgr, _ := newManager(cfg)
+
+		runCtx, cancel := context.WithCancel(ctx)
+		j, err := mgr.StartJanitor(runCtx)
+		if err != nil {
+			t.Fatalf("StartJanitor returned error: %v", err)
+		}
+		cancel()
+
+		closed := make(chan struct{})
+		go func() {
+			j.Close()
+			j.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close did not return after the context was canceled")
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		mgr := passwordless.NewManager(plainStore{store.NewMemStore()}, &TestTransport{})
+		if _, err := mgr.StartJanitor(ctx); !errors.Is(err, passwordless.ErrPurgeUnsupported) {
+			t.Errorf("Expected ErrPurgeUnsupported, got %v", err)
+		}
+
+		// The metrics decorator always offers PurgeExpired but reports when
+		// the store underneath cannot purge.
+		mgr.Store = metrics.InstrumentStore(plainStore{store.NewMemStore()}, metrics.Discard)
+		j, err := mgr.StartJanitor(ctx)
+		if err != nil {
+			t.Fatalf("StartJanitor returned error: %v", err)
+		}
+		defer j.Close()
+		if _, err := j.Sweep(ctx); !errors.Is(err, passwordless.ErrPurgeUnsupported) {
+			t.Errorf("Expected ErrPurgeUnsupported from the wrapped store, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package passwordless
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/rlnorthcutt/go-passwordless/logging"
+)
+
+// logger returns the configured logger with redaction applied.
+func (m *Manager) logger() *slog.Logger {
+	return logging.Redacted(m.Config.Logger)
+}
+
+// logEvent writes a structured record for ev. Failures that point at abuse or
+// an outage are logged at warning level, everything else at info.
+func (m *Manager) logEvent(ctx context.Context, ev Event) {
+	level := slog.LevelInfo
+	switch ev.Type {
+	case EventDeliveryFailed, EventTokenLocked:
+		level = slog.LevelWarn
+	}
+
+	l := m.logger()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{slog.String(logging.KeyTokenID, ev.TokenID)}
+	if ev.Recipient != "" {
+		attrs = append(attrs, slog.String(logging.KeyRecipient, ev.Recipient))
+	}
+	if ev.Attempts > 0 {
+		attrs = append(attrs, slog.Int(logging.KeyAttempts, ev.Attempts))
+	}
+	if ev.Err != nil {
+		attrs = append(attrs, slog.String(logging.KeyError, ev.Err.Error()))
+	}
+
+	l.LogAttrs(ctx, level, string(ev.Type), attrs...)
+}
@@ -0,0 +1,168 @@
+// Package logging provides the structured-logging conventions shared by the
+// Manager, stores and transports: common attribute keys, a no-op default and
+// redaction of secrets and recipients.
+//
+// Every component logs raw values under the keys below and passes its logger
+// through Redacted, which masks those values unless the logger was explicitly
+// marked with DevMode.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Attribute keys used consistently across the library.
+const (
+	KeyTokenID   = "token_id"
+	KeyRecipient = "recipient"
+	KeyCode      = "code"
+	KeyCodeHash  = "code_hash"
+	KeyLink      = "link"
+	KeyAttempts  = "attempts"
+	KeyError     = "error"
+	KeyServer    = "server"
+	KeyCount     = "count"
+	KeyEvent     = "event"
+)
+
+// redactedValue replaces secrets in log output.
+const redactedValue = "[REDACTED]"
+
+// addressPattern matches e-mail addresses and E.164 phone numbers in free text.
+var addressPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+|\+[0-9]{6,15}`)
+
+// discard is shared by every component that has no logger configured.
+var discard = slog.New(discardHandler{})
+
+// Discard returns a logger that drops all records.
+func Discard() *slog.Logger {
+	return discard
+}
+
+// DevMode marks l as a development logger: codes, code hashes, login links and
+// recipients are logged verbatim. Never use it in production.
+func DevMode(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return slog.New(devHandler{l.Handler()})
+}
+
+// Redacted returns a logger that masks recipients, including those echoed in
+// error messages, and removes codes, code hashes and login links, unless l was
+// created by DevMode. A nil l yields a no-op logger.
+func Redacted(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return discard
+	}
+	switch l.Handler().(type) {
+	case devHandler, redactingHandler, discardHandler:
+		return l
+	}
+	return slog.New(redactingHandler{l.Handler()})
+}
+
+// MaskRecipient hides most of an address while keeping it recognizable:
+// "user@example.com" becomes "u***@example.com" and "+15551234567" becomes
+// "*********67".
+func MaskRecipient(recipient string) string {
+	if at := strings.LastIndex(recipient, "@"); at > 0 {
+		return recipient[:1] + "***" + recipient[at:]
+	}
+	if len(recipient) <= 2 {
+		return strings.Repeat("*", len(recipient))
+	}
+	return strings.Repeat("*", len(recipient)-2) + recipient[len(recipient)-2:]
+}
+
+// MaskAddresses applies MaskRecipient to every e-mail address and phone number
+// in s, such as the address an SMTP server echoes in "550 <user@example.com>:
+// user unknown".
+func MaskAddresses(s string) string {
+	return addressPattern.ReplaceAllStringFunc(s, MaskRecipient)
+}
+
+// redact rewrites a single attribute according to its key.
+func redact(a slog.Attr) slog.Attr {
+	switch a.Key {
+	case KeyCode, KeyCodeHash, KeyLink:
+		return slog.String(a.Key, redactedValue)
+	case KeyRecipient:
+		return slog.String(a.Key, MaskRecipient(a.Value.Resolve().String()))
+	case KeyError:
+		return slog.String(a.Key, MaskAddresses(a.Value.Resolve().String()))
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		out := make([]any, len(attrs))
+		for i, ga := range attrs {
+			out[i] = redact(ga)
+		}
+		return slog.Group(a.Key, out...)
+	}
+	return a
+}
+
+// redactingHandler applies redact to every attribute before passing the
+// record on.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+func (h redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redact(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = redact(a)
+	}
+	return redactingHandler{h.next.WithAttrs(out)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{h.next.WithGroup(name)}
+}
+
+// devHandler passes everything through unchanged; its type marks the logger
+// as exempt from redaction.
+type devHandler struct {
+	next slog.Handler
+}
+
+func (h devHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h devHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h devHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return devHandler{h.next.WithAttrs(attrs)}
+}
+
+func (h devHandler) WithGroup(name string) slog.Handler {
+	return devHandler{h.next.WithGroup(name)}
+}
+
+// discardHandler drops all records.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,108 @@
+package logging_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/rlnorthcutt/go-passwordless/logging"
+)
+
+func TestMaskRecipient(t *testing.T) {
+	cases := map[string]string{
+		"user@example.com": "u***@example.com",
+		"+15551234567":     "**********67",
+		"ab":               "**",
+		"":                 "",
+	}
+	for in, want := range cases {
+		if got := logging.MaskRecipient(in); got != want {
+			t.Errorf("MaskRecipient(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMaskAddresses(t *testing.T) {
+	cases := map[string]string{
+		"550 <alice@example.com>: user unknown":     "550 <a***@example.com>: user unknown",
+		"sms to +15551234567 failed":                "sms to **********67 failed",
+		"dial tcp 127.0.0.1:25: connection refused": "dial tcp 127.0.0.1:25: connection refused",
+	}
+	for in, want := range cases {
+		if got := logging.MaskAddresses(in); got != want {
+			t.Errorf("MaskAddresses(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	newLogger := func() (*slog.Logger, *bytes.Buffer) {
+		var buf bytes.Buffer
+		return slog.New(slog.NewTextHandler(&buf, nil)), &buf
+	}
+
+	t.Run("RedactsSecrets", func(t *testing.T) {
+		base, buf := newLogger()
+		l := logging.Redacted(base)
+
+		l.Info("test", logging.KeyCode, "123456", logging.KeyCodeHash, []byte{1, 2, 3},
+			logging.KeyLink, "https://example.com/login?secret=s3cr3t",
+			logging.KeyRecipient, "user@example.com", logging.KeyTokenID, "tok-1")
+
+		out := buf.String()
+		for _, secret := range []string{"123456", "s3cr3t", "user@example.com"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("Expected %q to be redacted, got %s", secret, out)
+			}
+		}
+		for _, want := range []string{"u***@example.com", "tok-1", "[REDACTED]"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("Expected output to contain %q, got %s", want, out)
+			}
+		}
+	})
+
+	t.Run("MasksAddressesInErrors", func(t *testing.T) {
+		base, buf := newLogger()
+		l := logging.Redacted(base)
+
+		l.Info("test", logging.KeyError, errors.New("550 <alice@example.com>: user unknown"))
+
+		if out := buf.String(); strings.Contains(out, "alice@example.com") || !strings.Contains(out, "a***@example.com") {
+			t.Errorf("Expected the address in the error to be masked, got %s", out)
+		}
+	})
+
+	t.Run("RedactsWithAttrsAndGroups", func(t *testing.T) {
+		base, buf := newLogger()
+		l := logging.Redacted(base).With(logging.KeyRecipient, "user@example.com")
+
+		l.Info("test", slog.Group("req", logging.KeyCode, "654321"))
+
+		if out := buf.String(); strings.Contains(out, "user@example.com") || strings.Contains(out, "654321") {
+			t.Errorf("Expected attributes and groups to be redacted, got %s", out)
+		}
+	})
+
+	t.Run("DevModeLogsVerbatim", func(t *testing.T) {
+		base, buf := newLogger()
+		l := logging.Redacted(logging.DevMode(base))
+
+		l.Info("test", logging.KeyCode, "123456", logging.KeyRecipient, "user@example.com")
+
+		out := buf.String()
+		if !strings.Contains(out, "123456") || !strings.Contains(out, "user@example.com") {
+			t.Errorf("Expected dev mode to log secrets verbatim, got %s", out)
+		}
+	})
+
+	t.Run("NilIsDiscard", func(t *testing.T) {
+		l := logging.Redacted(nil)
+		if l == nil {
+			t.Fatal("Expected a non-nil logger")
+		}
+		l.Info("dropped") // must not panic
+	})
+}
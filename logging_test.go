@@ -0,0 +1,62 @@
+package passwordless_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+func TestManagerLogging(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+
+	cfg := passwordless.DefaultConfig()
+	cfg.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	tt := &TestTransport{}
+	mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), tt, cfg)
+
+	tokenID, err := mgr.StartLogin(ctx, "private@example.com")
+	if err != nil {
+		t.Fatalf("StartLogin returned error: %v", err)
+	}
+	_, _ = mgr.VerifyLogin(ctx, tokenID, "wrong")
+	_, _ = mgr.VerifyLogin(ctx, tokenID, tt.LastCode)
+
+	out := buf.String()
+	for _, want := range []string{"login_started", "verify_failed", "verify_succeeded", "token_id=" + tokenID, "attempts=1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected log output to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "private@example.com") || strings.Contains(out, tt.LastCode) {
+		t.Errorf("Expected recipient and code to be redacted, got:\n%s", out)
+	}
+}
+
+func TestManagerLoggingMasksErrors(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+
+	cfg := passwordless.DefaultConfig()
+	cfg.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	ft := &FailingTransport{Err: errors.New("550 <private@example.com>: user unknown")}
+	mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), ft, cfg)
+
+	if _, err := mgr.StartLogin(ctx, "private@example.com"); err == nil {
+		t.Fatal("Expected StartLogin to fail")
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "delivery_failed") || !strings.Contains(out, "user unknown") {
+		t.Errorf("Expected the delivery failure to be logged, got:\n%s", out)
+	}
+	if strings.Contains(out, "private@example.com") {
+		t.Errorf("Expected the recipient echoed in the error to be masked, got:\n%s", out)
+	}
+}
@@ -0,0 +1,41 @@
+package passwordless
+
+import (
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/metrics"
+)
+
+// recorder returns the configured metrics recorder, or one that discards everything.
+func (m *Manager) recorder() metrics.Recorder {
+	if m.Config.Metrics == nil {
+		return metrics.Discard
+	}
+	return m.Config.Metrics
+}
+
+// recordEvent updates the counters that correspond to ev.
+func (m *Manager) recordEvent(ev Event) {
+	rec := m.recorder()
+	switch ev.Type {
+	case EventLoginStarted:
+		rec.IncCounter(metrics.LoginsStarted, nil)
+	case EventCodeDelivered:
+		rec.IncCounter(metrics.Deliveries, metrics.Labels{"result": "success"})
+	case EventDeliveryFailed:
+		rec.IncCounter(metrics.Deliveries, metrics.Labels{"result": "failure"})
+	case EventVerifySucceeded:
+		rec.IncCounter(metrics.Verifications, metrics.Labels{"result": "success"})
+	case EventVerifyFailed:
+		rec.IncCounter(metrics.Verifications, metrics.Labels{"result": "failure"})
+	case EventTokenExpired:
+		rec.IncCounter(metrics.Verifications, metrics.Labels{"result": "expired"})
+	case EventTokenLocked:
+		rec.IncCounter(metrics.Verifications, metrics.Labels{"result": "locked"})
+	}
+}
+
+// observeDuration records the time elapsed since start in the named histogram.
+func (m *Manager) observeDuration(name string, start time.Time) {
+	m.recorder().ObserveHistogram(name, time.Since(start).Seconds(), nil)
+}
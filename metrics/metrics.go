@@ -0,0 +1,72 @@
+// Package metrics instruments the passwordless flow with counters and
+// histograms. The Manager reports through the Recorder interface, and
+// InstrumentStore and InstrumentTransport wrap a TokenStore or Transport to
+// time every call. Registry is a dependency-free Recorder that exposes its
+// values through expvar and the Prometheus text format.
+package metrics
+
+// Metric names reported by the Manager and the decorators in this package.
+const (
+	LoginsStarted        = "passwordless_logins_started_total"
+	Deliveries           = "passwordless_deliveries_total"
+	DeliveryDuration     = "passwordless_delivery_duration_seconds"
+	Verifications        = "passwordless_verifications_total"
+	VerificationDuration = "passwordless_verification_duration_seconds"
+	StoreOperations      = "passwordless_store_operations_total"
+	StoreDuration        = "passwordless_store_operation_duration_seconds"
+	TransportSends       = "passwordless_transport_sends_total"
+	TransportDuration    = "passwordless_transport_send_duration_seconds"
+	TokensPurged         = "passwordless_tokens_purged_total" // Tokens removed by the janitor
+)
+
+// Labels attaches dimensions such as {"result": "success"} to a measurement.
+type Labels map[string]string
+
+// Recorder receives measurements. Implementations must be safe for concurrent use.
+type Recorder interface {
+	// IncCounter adds one to the counter identified by name and labels.
+	IncCounter(name string, labels Labels)
+
+	// ObserveHistogram records value (for durations, in seconds) in the
+	// histogram identified by name and labels.
+	ObserveHistogram(name string, value float64, labels Labels)
+}
+
+// CounterAdder is implemented by Recorders that can add more than one to a
+// counter at a time.
+type CounterAdder interface {
+	// AddCounter adds n, which must not be negative, to the counter
+	// identified by name and labels.
+	AddCounter(name string, n float64, labels Labels)
+}
+
+// AddCounter adds n to a counter of rec, through CounterAdder when rec
+// implements it and by calling IncCounter n times otherwise.
+func AddCounter(rec Recorder, name string, n int, labels Labels) {
+	if a, ok := rec.(CounterAdder); ok {
+		if n > 0 {
+			a.AddCounter(name, float64(n), labels)
+		}
+		return
+	}
+	for i := 0; i < n; i++ {
+		rec.IncCounter(name, labels)
+	}
+}
+
+// Discard is a Recorder that drops every measurement.
+var Discard Recorder = discard{}
+
+type discard struct{}
+
+func (discard) IncCounter(string, Labels)                {}
+func (discard) AddCounter(string, float64, Labels)       {}
+func (discard) ObserveHistogram(string, float64, Labels) {}
+
+// result maps an error to the "result" label value used by the decorators.
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
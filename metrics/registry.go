@@ -0,0 +1,269 @@
+package metrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram upper bounds (in seconds) used when a
+// Registry is created without explicit buckets.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry is an in-memory Recorder. Values can be read through expvar
+// (see Expvar and PublishExpvar) or scraped in the Prometheus text exposition
+// format (see Handler and WritePrometheus).
+type Registry struct {
+	Buckets []float64 // Histogram upper bounds, ascending
+
+	mu         sync.Mutex
+	counters   map[string]map[string]*counter   // name -> label key -> series
+	histograms map[string]map[string]*histogram // name -> label key -> series
+}
+
+type counter struct {
+	labels Labels
+	value  float64
+}
+
+type histogram struct {
+	labels Labels
+	counts []uint64 // one per bucket, non-cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewRegistry creates an empty Registry using DefaultBuckets.
+func NewRegistry() *Registry {
+	return &Registry{
+		Buckets:    DefaultBuckets,
+		counters:   make(map[string]map[string]*counter),
+		histograms: make(map[string]map[string]*histogram),
+	}
+}
+
+// IncCounter adds one to a counter.
+func (r *Registry) IncCounter(name string, labels Labels) {
+	r.AddCounter(name, 1, labels)
+}
+
+// AddCounter adds n to a counter.
+func (r *Registry) AddCounter(name string, n float64, labels Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := labelKey(labels)
+	series, ok := r.counters[name]
+	if !ok {
+		series = make(map[string]*counter)
+		r.counters[name] = series
+	}
+	c, ok := series[key]
+	if !ok {
+		c = &counter{labels: copyLabels(labels)}
+		series[key] = c
+	}
+	c.value += n
+}
+
+// ObserveHistogram records a value in a histogram.
+func (r *Registry) ObserveHistogram(name string, value float64, labels Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := labelKey(labels)
+	series, ok := r.histograms[name]
+	if !ok {
+		series = make(map[string]*histogram)
+		r.histograms[name] = series
+	}
+	h, ok := series[key]
+	if !ok {
+		h = &histogram{labels: copyLabels(labels), counts: make([]uint64, len(r.Buckets))}
+		series[key] = h
+	}
+	for i, upper := range r.Buckets {
+		if value <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// CounterValue returns the current value of a counter, or 0 if it was never incremented.
+func (r *Registry) CounterValue(name string, labels Labels) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.counters[name][labelKey(labels)]; ok {
+		return c.value
+	}
+	return 0
+}
+
+// HistogramCount returns how many values a histogram has observed.
+func (r *Registry) HistogramCount(name string, labels Labels) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if h, ok := r.histograms[name][labelKey(labels)]; ok {
+		return h.count
+	}
+	return 0
+}
+
+// Expvar returns an expvar.Var that renders a JSON snapshot of the registry.
+func (r *Registry) Expvar() expvar.Var {
+	return expvar.Func(r.snapshot)
+}
+
+// PublishExpvar publishes the registry under name in the expvar namespace
+// (served at /debug/vars). Like expvar.Publish, it panics if name is already in use.
+func (r *Registry) PublishExpvar(name string) {
+	expvar.Publish(name, r.Expvar())
+}
+
+// snapshot builds the expvar representation: series are keyed by their
+// Prometheus-style name, e.g. `passwordless_deliveries_total{result="success"}`.
+func (r *Registry) snapshot() any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counters := make(map[string]float64)
+	for name, series := range r.counters {
+		for key, c := range series {
+			counters[name+key] = c.value
+		}
+	}
+
+	histograms := make(map[string]any)
+	for name, series := range r.histograms {
+		for key, h := range series {
+			buckets := make(map[string]uint64, len(r.Buckets))
+			var cumulative uint64
+			for i, upper := range r.Buckets {
+				cumulative += h.counts[i]
+				buckets[formatFloat(upper)] = cumulative
+			}
+			histograms[name+key] = map[string]any{
+				"count":   h.count,
+				"sum":     h.sum,
+				"buckets": buckets,
+			}
+		}
+	}
+
+	return map[string]any{"counters": counters, "histograms": histograms}
+}
+
+// Handler serves the registry in the Prometheus text exposition format (version 0.0.4).
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WritePrometheus(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// WritePrometheus writes every series in the Prometheus text exposition format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	for _, name := range sortedKeys(r.counters) {
+		series := r.counters[name]
+		fmt.Fprintf(bw, "# TYPE %s counter\n", name)
+		for _, key := range sortedKeys(series) {
+			fmt.Fprintf(bw, "%s%s %s\n", name, key, formatFloat(series[key].value))
+		}
+	}
+
+	for _, name := range sortedKeys(r.histograms) {
+		series := r.histograms[name]
+		fmt.Fprintf(bw, "# TYPE %s histogram\n", name)
+		for _, key := range sortedKeys(series) {
+			h := series[key]
+			var cumulative uint64
+			for i, upper := range r.Buckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", name, withLabel(h.labels, "le", formatFloat(upper)), cumulative)
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", name, withLabel(h.labels, "le", "+Inf"), h.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", name, key, formatFloat(h.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", name, key, h.count)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// labelKey renders labels in Prometheus syntax with sorted names, which also
+// serves as the identity of a series.
+func labelKey(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := sortedKeys(labels)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[name]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// withLabel renders labels plus one extra label (used for the histogram "le" label).
+func withLabel(labels Labels, name, value string) string {
+	extended := copyLabels(labels)
+	extended[name] = value
+	return labelKey(extended)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func copyLabels(labels Labels) Labels {
+	out := make(Labels, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,115 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rlnorthcutt/go-passwordless/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.Buckets = []float64{0.1, 1}
+
+	reg.IncCounter(metrics.Deliveries, metrics.Labels{"result": "success"})
+	reg.IncCounter(metrics.Deliveries, metrics.Labels{"result": "success"})
+	reg.IncCounter(metrics.Deliveries, metrics.Labels{"result": "failure"})
+	reg.IncCounter(metrics.LoginsStarted, nil)
+	reg.ObserveHistogram(metrics.DeliveryDuration, 0.05, nil)
+	reg.ObserveHistogram(metrics.DeliveryDuration, 0.5, nil)
+	reg.ObserveHistogram(metrics.DeliveryDuration, 3, nil)
+
+	t.Run("Values", func(t *testing.T) {
+		if v := reg.CounterValue(metrics.Deliveries, metrics.Labels{"result": "success"}); v != 2 {
+			t.Errorf("Expected 2 successful deliveries, got %v", v)
+		}
+		if n := reg.HistogramCount(metrics.DeliveryDuration, nil); n != 3 {
+			t.Errorf("Expected 3 observations, got %d", n)
+		}
+	})
+
+	t.Run("AddCounter", func(t *testing.T) {
+		metrics.AddCounter(reg, metrics.TokensPurged, 5, nil)
+		metrics.AddCounter(reg, metrics.TokensPurged, 0, nil)
+		if v := reg.CounterValue(metrics.TokensPurged, nil); v != 5 {
+			t.Errorf("Expected 5 purged tokens, got %v", v)
+		}
+
+		// Recorders without AddCounter get one IncCounter per unit.
+		inc := incOnly{metrics.NewRegistry()}
+		metrics.AddCounter(inc, metrics.TokensPurged, 3, nil)
+		if v := inc.reg.CounterValue(metrics.TokensPurged, nil); v != 3 {
+			t.Errorf("Expected 3 purged tokens, got %v", v)
+		}
+	})
+
+	t.Run("Prometheus", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+			t.Errorf("Unexpected content type %q", ct)
+		}
+		body, _ := io.ReadAll(rec.Body)
+		out := string(body)
+
+		for _, want := range []string{
+			"# TYPE passwordless_deliveries_total counter",
+			`passwordless_deliveries_total{result="success"} 2`,
+			`passwordless_deliveries_total{result="failure"} 1`,
+			"passwordless_logins_started_total 1",
+			"# TYPE passwordless_delivery_duration_seconds histogram",
+			`passwordless_delivery_duration_seconds_bucket{le="0.1"} 1`,
+			`passwordless_delivery_duration_seconds_bucket{le="1"} 2`,
+			`passwordless_delivery_duration_seconds_bucket{le="+Inf"} 3`,
+			"passwordless_delivery_duration_seconds_sum 3.55",
+			"passwordless_delivery_duration_seconds_count 3",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("Expected exposition to contain %q, got:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("EscapesLabelValues", func(t *testing.T) {
+		reg := metrics.NewRegistry()
+		reg.IncCounter("test_total", metrics.Labels{"v": "a\"b\\c\nd"})
+
+		var b strings.Builder
+		if err := reg.WritePrometheus(&b); err != nil {
+			t.Fatalf("WritePrometheus returned error: %v", err)
+		}
+		if want := `test_total{v="a\"b\\c\nd"} 1`; !strings.Contains(b.String(), want) {
+			t.Errorf("Expected %q in output, got:\n%s", want, b.String())
+		}
+	})
+
+	t.Run("Expvar", func(t *testing.T) {
+		var snapshot struct {
+			Counters   map[string]float64 `json:"counters"`
+			Histograms map[string]struct {
+				Count uint64 `json:"count"`
+			} `json:"histograms"`
+		}
+		if err := json.Unmarshal([]byte(reg.Expvar().String()), &snapshot); err != nil {
+			t.Fatalf("Failed to decode expvar output: %v", err)
+		}
+		if v := snapshot.Counters[`passwordless_deliveries_total{result="success"}`]; v != 2 {
+			t.Errorf("Expected expvar counter 2, got %v", v)
+		}
+		if h := snapshot.Histograms[metrics.DeliveryDuration]; h.Count != 3 {
+			t.Errorf("Expected expvar histogram count 3, got %d", h.Count)
+		}
+	})
+}
+
+// incOnly is a Recorder that does not implement CounterAdder.
+type incOnly struct{ reg *metrics.Registry }
+
+func (r incOnly) IncCounter(name string, labels metrics.Labels) { r.reg.IncCounter(name, labels) }
+func (r incOnly) ObserveHistogram(name string, value float64, labels metrics.Labels) {
+	r.reg.ObserveHistogram(name, value, labels)
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// InstrumentStore wraps s so that every call is counted in StoreOperations and
+// timed in StoreDuration, labeled by operation and result ("ok" or "error").
+// The returned store also implements store.Purger, delegating to s when s is
+// one and returning store.ErrPurgeUnsupported otherwise.
+func InstrumentStore(s store.TokenStore, r Recorder) store.TokenStore {
+	return &instrumentedStore{next: s, rec: r}
+}
+
+type instrumentedStore struct {
+	next store.TokenStore
+	rec  Recorder
+}
+
+// observe records a completed store operation that started at start.
+func (s *instrumentedStore) observe(op string, start time.Time, err error) {
+	labels := Labels{"operation": op, "result": result(err)}
+	s.rec.IncCounter(StoreOperations, labels)
+	s.rec.ObserveHistogram(StoreDuration, time.Since(start).Seconds(), labels)
+}
+
+func (s *instrumentedStore) Store(ctx context.Context, tok store.Token) (err error) {
+	defer func(start time.Time) { s.observe("store", start, err) }(time.Now())
+	return s.next.Store(ctx, tok)
+}
+
+func (s *instrumentedStore) Exists(ctx context.Context, tokenID string) (tok *store.Token, err error) {
+	defer func(start time.Time) { s.observe("exists", start, err) }(time.Now())
+	return s.next.Exists(ctx, tokenID)
+}
+
+func (s *instrumentedStore) UpdateAttempts(ctx context.Context, tokenID string, attempts int) (err error) {
+	defer func(start time.Time) { s.observe("update_attempts", start, err) }(time.Now())
+	return s.next.UpdateAttempts(ctx, tokenID, attempts)
+}
+
+func (s *instrumentedStore) Reissue(ctx context.Context, tok store.Token, prevResendCount int) (err error) {
+	defer func(start time.Time) { s.observe("reissue", start, err) }(time.Now())
+	return s.next.Reissue(ctx, tok, prevResendCount)
+}
+
+func (s *instrumentedStore) Verify(ctx context.Context, tokenID, code string) (ok bool, err error) {
+	defer func(start time.Time) { s.observe("verify", start, err) }(time.Now())
+	return s.next.Verify(ctx, tokenID, code)
+}
+
+func (s *instrumentedStore) VerifyAndConsume(ctx context.Context, tokenID, code string, maxAttempts int) (tok *store.Token, err error) {
+	defer func(start time.Time) { s.observe("verify_and_consume", start, err) }(time.Now())
+	return s.next.VerifyAndConsume(ctx, tokenID, code, maxAttempts)
+}
+
+func (s *instrumentedStore) VerifyLinkAndConsume(ctx context.Context, tokenID, secret string, maxAttempts int) (tok *store.Token, err error) {
+	defer func(start time.Time) { s.observe("verify_link_and_consume", start, err) }(time.Now())
+	return s.next.VerifyLinkAndConsume(ctx, tokenID, secret, maxAttempts)
+}
+
+func (s *instrumentedStore) Delete(ctx context.Context, tokenID string) (err error) {
+	defer func(start time.Time) { s.observe("delete", start, err) }(time.Now())
+	return s.next.Delete(ctx, tokenID)
+}
+
+func (s *instrumentedStore) DeleteByRecipient(ctx context.Context, recipient string) (n int, err error) {
+	defer func(start time.Time) { s.observe("delete_by_recipient", start, err) }(time.Now())
+	return s.next.DeleteByRecipient(ctx, recipient)
+}
+
+func (s *instrumentedStore) PurgeExpired(ctx context.Context, limit int) (n int, err error) {
+	defer func(start time.Time) { s.observe("purge_expired", start, err) }(time.Now())
+	p, ok := s.next.(store.Purger)
+	if !ok {
+		return 0, store.ErrPurgeUnsupported
+	}
+	return p.PurgeExpired(ctx, limit)
+}
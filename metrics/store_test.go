@@ -0,0 +1,50 @@
+package metrics_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/metrics"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+func TestInstrumentStore(t *testing.T) {
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+	s := metrics.InstrumentStore(store.NewMemStore(), reg)
+
+	codeHash := sha256.Sum256([]byte("123456"))
+	err := s.Store(ctx, store.Token{
+		ID:        "metrics-token",
+		Recipient: "metrics@example.com",
+		CodeHash:  codeHash[:],
+		ExpiresAt: time.Now().Add(time.Minute),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+	if _, err := s.VerifyAndConsume(ctx, "metrics-token", "123456", 3); err != nil {
+		t.Fatalf("VerifyAndConsume() error: %v", err)
+	}
+	_, _ = s.Exists(ctx, "metrics-token")
+
+	checks := []struct {
+		op, result string
+	}{
+		{"store", "ok"},
+		{"verify_and_consume", "ok"},
+		{"exists", "error"},
+	}
+	for _, c := range checks {
+		labels := metrics.Labels{"operation": c.op, "result": c.result}
+		if v := reg.CounterValue(metrics.StoreOperations, labels); v != 1 {
+			t.Errorf("Expected 1 %s/%s operation, got %v", c.op, c.result, v)
+		}
+		if n := reg.HistogramCount(metrics.StoreDuration, labels); n != 1 {
+			t.Errorf("Expected 1 %s/%s duration observation, got %d", c.op, c.result, n)
+		}
+	}
+}
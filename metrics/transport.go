@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/transport"
+)
+
+// InstrumentTransport wraps t so that every Send is counted in TransportSends
+// and timed in TransportDuration, labeled by result ("ok" or "error"). The
+// returned transport also implements transport.MessageTransport, delegating to
+// t's SendMessage when t has one.
+func InstrumentTransport(t transport.Transport, r Recorder) transport.Transport {
+	return &instrumentedTransport{next: t, rec: r}
+}
+
+type instrumentedTransport struct {
+	next transport.Transport
+	rec  Recorder
+}
+
+func (t *instrumentedTransport) Send(ctx context.Context, recipient, tokenCode string) error {
+	start := time.Now()
+	err := t.next.Send(ctx, recipient, tokenCode)
+	t.observe(start, err)
+	return err
+}
+
+func (t *instrumentedTransport) SendMessage(ctx context.Context, msg transport.Message) error {
+	start := time.Now()
+	err := transport.Adapt(t.next).SendMessage(ctx, msg)
+	t.observe(start, err)
+	return err
+}
+
+// observe records a single send that began at start.
+func (t *instrumentedTransport) observe(start time.Time, err error) {
+	labels := Labels{"result": result(err)}
+	t.rec.IncCounter(TransportSends, labels)
+	t.rec.ObserveHistogram(TransportDuration, time.Since(start).Seconds(), labels)
+}
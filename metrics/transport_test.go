@@ -0,0 +1,49 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rlnorthcutt/go-passwordless/metrics"
+	"github.com/rlnorthcutt/go-passwordless/transport"
+)
+
+type stubTransport struct {
+	err error
+}
+
+func (s *stubTransport) Send(ctx context.Context, recipient, code string) error {
+	return s.err
+}
+
+func TestInstrumentTransport(t *testing.T) {
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+	stub := &stubTransport{}
+	tr := metrics.InstrumentTransport(stub, reg)
+
+	_ = tr.Send(ctx, "a@example.com", "123456")
+	stub.err = errors.New("smtp down")
+	if err := tr.Send(ctx, "b@example.com", "654321"); !errors.Is(err, stub.err) {
+		t.Fatalf("Expected the transport error to be returned, got %v", err)
+	}
+
+	mt, ok := tr.(transport.MessageTransport)
+	if !ok {
+		t.Fatal("Expected the instrumented transport to implement MessageTransport")
+	}
+	_ = mt.SendMessage(ctx, transport.Message{Recipient: "c@example.com", Code: "111111"})
+	stub.err = nil
+	_ = mt.SendMessage(ctx, transport.Message{Recipient: "d@example.com", Code: "222222"})
+
+	for _, res := range []string{"ok", "error"} {
+		labels := metrics.Labels{"result": res}
+		if v := reg.CounterValue(metrics.TransportSends, labels); v != 2 {
+			t.Errorf("Expected 2 %s sends, got %v", res, v)
+		}
+		if n := reg.HistogramCount(metrics.TransportDuration, labels); n != 2 {
+			t.Errorf("Expected 2 %s duration observations, got %d", res, n)
+		}
+	}
+}
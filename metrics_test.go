@@ -0,0 +1,55 @@
+package passwordless_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/metrics"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+func TestManagerMetrics(t *testing.T) {
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+
+	cfg := passwordless.DefaultConfig()
+	cfg.Metrics = reg
+	tt := &TestTransport{}
+	mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), tt, cfg)
+
+	tokenID, err := mgr.StartLogin(ctx, "metrics@example.com")
+	if err != nil {
+		t.Fatalf("StartLogin returned error: %v", err)
+	}
+	_, _ = mgr.VerifyLogin(ctx, tokenID, "wrong")
+	_, _ = mgr.VerifyLogin(ctx, tokenID, tt.LastCode)
+
+	failing := passwordless.NewManagerWithConfig(store.NewMemStore(), &FailingTransport{Err: errors.New("down")}, cfg)
+	_, _ = failing.StartLogin(ctx, "metrics@example.com")
+
+	counters := []struct {
+		name   string
+		labels metrics.Labels
+		want   float64
+	}{
+		{metrics.LoginsStarted, nil, 2},
+		{metrics.Deliveries, metrics.Labels{"result": "success"}, 1},
+		{metrics.Deliveries, metrics.Labels{"result": "failure"}, 1},
+		{metrics.Verifications, metrics.Labels{"result": "failure"}, 1},
+		{metrics.Verifications, metrics.Labels{"result": "success"}, 1},
+	}
+	for _, c := range counters {
+		if v := reg.CounterValue(c.name, c.labels); v != c.want {
+			t.Errorf("%s%v: expected %v, got %v", c.name, c.labels, c.want, v)
+		}
+	}
+
+	if n := reg.HistogramCount(metrics.DeliveryDuration, nil); n != 2 {
+		t.Errorf("Expected 2 delivery duration observations, got %d", n)
+	}
+	if n := reg.HistogramCount(metrics.VerificationDuration, nil); n != 2 {
+		t.Errorf("Expected 2 verification duration observations, got %d", n)
+	}
+}
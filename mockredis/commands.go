@@ -0,0 +1,503 @@
+package mockredis
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Replies that are not plain strings, integers or arrays.
+type (
+	status     string   // Simple string reply, e.g. +OK
+	errorReply string   // Error reply, e.g. -ERR syntax error
+	nullArray  struct{} // *-1, returned by EXEC when a watched key changed
+)
+
+const (
+	errWrongType  errorReply = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errNotInteger errorReply = "ERR value is not an integer or out of range"
+	errSyntax     errorReply = "ERR syntax error"
+)
+
+// value is the data stored at a key. Exactly one of str, hash and set is set.
+type value struct {
+	str     *string
+	hash    map[string]string
+	set     map[string]struct{}
+	expires time.Time // Zero means no expiry
+}
+
+// db is one numbered database. Every change to a key, including its deletion
+// or expiry, gives it a new version, which is how WATCH notices changes.
+type db struct {
+	values   map[string]*value
+	versions map[string]uint64
+}
+
+func newDB() *db {
+	return &db{values: make(map[string]*value), versions: make(map[string]uint64)}
+}
+
+// get returns the value at key, or nil, deleting it first if it has expired.
+// The caller must hold s.mu.
+func (d *db) get(s *Server, key string, now time.Time) *value {
+	v, ok := d.values[key]
+	if !ok {
+		return nil
+	}
+	if !v.expires.IsZero() && !now.Before(v.expires) {
+		d.del(s, key)
+		return nil
+	}
+	return v
+}
+
+// touch records a change to key. The caller must hold s.mu.
+func (d *db) touch(s *Server, key string) {
+	d.versions[key] = s.nextVersion()
+}
+
+// del removes key and reports whether it existed. The caller must hold s.mu.
+func (d *db) del(s *Server, key string) bool {
+	if _, ok := d.values[key]; !ok {
+		return false
+	}
+	delete(d.values, key)
+	d.touch(s, key)
+	return true
+}
+
+// flush removes every key. The caller must hold s.mu.
+func (d *db) flush(s *Server) {
+	for key := range d.values {
+		d.del(s, key)
+	}
+}
+
+// call is one command being executed against a database, with s.mu held.
+type call struct {
+	s    *Server
+	d    *db
+	now  time.Time
+	args []string // Arguments after the command name
+}
+
+func (c *call) get(key string) *value { return c.d.get(c.s, key, c.now) }
+func (c *call) touch(key string)      { c.d.touch(c.s, key) }
+
+// hash returns the hash at key, creating it if create is set. It returns
+// errWrongType if key holds another type.
+func (c *call) hash(key string, create bool) (map[string]string, any) {
+	v := c.get(key)
+	switch {
+	case v == nil && create:
+		v = &value{hash: make(map[string]string)}
+		c.d.values[key] = v
+	case v == nil:
+		return nil, nil
+	case v.hash == nil:
+		return nil, errWrongType
+	}
+	return v.hash, nil
+}
+
+// set returns the set at key, creating it if create is set. It returns
+// errWrongType if key holds another type.
+func (c *call) set(key string, create bool) (map[string]struct{}, any) {
+	v := c.get(key)
+	switch {
+	case v == nil && create:
+		v = &value{set: make(map[string]struct{})}
+		c.d.values[key] = v
+	case v == nil:
+		return nil, nil
+	case v.set == nil:
+		return nil, errWrongType
+	}
+	return v.set, nil
+}
+
+// dropIfEmpty deletes key once its hash or set has no elements left, as
+// Redis does.
+func (c *call) dropIfEmpty(key string) {
+	if v := c.d.values[key]; v != nil && v.str == nil && len(v.hash) == 0 && len(v.set) == 0 {
+		delete(c.d.values, key)
+	}
+}
+
+// command is a data command. arity counts the command name; a negative
+// arity means at least -arity arguments.
+type command struct {
+	arity int
+	run   func(c *call) any
+}
+
+var commands = map[string]command{
+	"PING":      {-1, cmdPing},
+	"ECHO":      {2, func(c *call) any { return c.args[0] }},
+	"GET":       {2, cmdGet},
+	"SET":       {3, cmdSet},
+	"DEL":       {-2, cmdDel},
+	"EXISTS":    {-2, cmdExists},
+	"TYPE":      {2, cmdType},
+	"EXPIRE":    {3, func(c *call) any { return expire(c, time.Second, false) }},
+	"PEXPIRE":   {3, func(c *call) any { return expire(c, time.Millisecond, false) }},
+	"PEXPIREAT": {3, func(c *call) any { return expire(c, time.Millisecond, true) }},
+	"PERSIST":   {2, cmdPersist},
+	"TTL":       {2, func(c *call) any { return ttl(c, time.Second) }},
+	"PTTL":      {2, func(c *call) any { return ttl(c, time.Millisecond) }},
+	"HSET":      {-4, cmdHSet},
+	"HGET":      {3, cmdHGet},
+	"HMGET":     {-3, cmdHMGet},
+	"HGETALL":   {2, cmdHGetAll},
+	"HDEL":      {-3, cmdHDel},
+	"HLEN":      {2, cmdHLen},
+	"HEXISTS":   {3, cmdHExists},
+	"HINCRBY":   {4, cmdHIncrBy},
+	"SADD":      {-3, cmdSAdd},
+	"SREM":      {-3, cmdSRem},
+	"SMEMBERS":  {2, cmdSMembers},
+	"SISMEMBER": {3, cmdSIsMember},
+	"SCARD":     {2, cmdSCard},
+	"DBSIZE":    {1, cmdDBSize},
+	"FLUSHDB":   {1, func(c *call) any { c.d.flush(c.s); return status("OK") }},
+	"FLUSHALL":  {1, cmdFlushAll},
+}
+
+// checkArity returns an error reply if args (including the command name) do
+// not suit cmd.
+func checkArity(name string, cmd command, args []string) any {
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		return errorReply("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+	}
+	return nil
+}
+
+func cmdPing(c *call) any {
+	switch len(c.args) {
+	case 0:
+		return status("PONG")
+	case 1:
+		return c.args[0]
+	}
+	return errorReply("ERR wrong number of arguments for 'ping' command")
+}
+
+func cmdGet(c *call) any {
+	v := c.get(c.args[0])
+	switch {
+	case v == nil:
+		return nil
+	case v.str == nil:
+		return errWrongType
+	}
+	return *v.str
+}
+
+func cmdSet(c *call) any {
+	s := c.args[1]
+	c.d.values[c.args[0]] = &value{str: &s}
+	c.touch(c.args[0])
+	return status("OK")
+}
+
+func cmdDel(c *call) any {
+	n := 0
+	for _, key := range c.args {
+		if c.get(key) != nil && c.d.del(c.s, key) {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(c *call) any {
+	n := 0
+	for _, key := range c.args {
+		if c.get(key) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdType(c *call) any {
+	v := c.get(c.args[0])
+	switch {
+	case v == nil:
+		return status("none")
+	case v.hash != nil:
+		return status("hash")
+	case v.set != nil:
+		return status("set")
+	}
+	return status("string")
+}
+
+// expire implements EXPIRE, PEXPIRE and PEXPIREAT. A time in the past
+// deletes the key.
+func expire(c *call, unit time.Duration, absolute bool) any {
+	n, err := strconv.ParseInt(c.args[1], 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	key := c.args[0]
+	v := c.get(key)
+	if v == nil {
+		return 0
+	}
+
+	at := c.now.Add(time.Duration(n) * unit)
+	if absolute {
+		at = time.UnixMilli(n)
+	}
+	if !at.After(c.now) {
+		c.d.del(c.s, key)
+		return 1
+	}
+	v.expires = at
+	c.touch(key)
+	return 1
+}
+
+func cmdPersist(c *call) any {
+	v := c.get(c.args[0])
+	if v == nil || v.expires.IsZero() {
+		return 0
+	}
+	v.expires = time.Time{}
+	c.touch(c.args[0])
+	return 1
+}
+
+// ttl implements TTL and PTTL: -2 for a missing key, -1 for a key without
+// expiry, otherwise the time left rounded to unit.
+func ttl(c *call, unit time.Duration) any {
+	v := c.get(c.args[0])
+	switch {
+	case v == nil:
+		return -2
+	case v.expires.IsZero():
+		return -1
+	}
+	return int64((v.expires.Sub(c.now) + unit/2) / unit)
+}
+
+func cmdHSet(c *call) any {
+	if len(c.args)%2 != 1 {
+		return errorReply("ERR wrong number of arguments for 'hset' command")
+	}
+	key := c.args[0]
+	h, errReply := c.hash(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	added := 0
+	for i := 1; i < len(c.args); i += 2 {
+		if _, ok := h[c.args[i]]; !ok {
+			added++
+		}
+		h[c.args[i]] = c.args[i+1]
+	}
+	c.touch(key)
+	return added
+}
+
+func cmdHGet(c *call) any {
+	h, errReply := c.hash(c.args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	if v, ok := h[c.args[1]]; ok {
+		return v
+	}
+	return nil
+}
+
+func cmdHMGet(c *call) any {
+	h, errReply := c.hash(c.args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	out := make([]any, len(c.args)-1)
+	for i, field := range c.args[1:] {
+		if v, ok := h[field]; ok {
+			out[i] = v
+		}
+	}
+	return out
+}
+
+func cmdHGetAll(c *call) any {
+	h, errReply := c.hash(c.args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	fields := make([]string, 0, len(h))
+	for field := range h {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	out := make([]any, 0, 2*len(h))
+	for _, field := range fields {
+		out = append(out, field, h[field])
+	}
+	return out
+}
+
+func cmdHDel(c *call) any {
+	key := c.args[0]
+	h, errReply := c.hash(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	n := 0
+	for _, field := range c.args[1:] {
+		if _, ok := h[field]; ok {
+			delete(h, field)
+			n++
+		}
+	}
+	if n > 0 {
+		c.touch(key)
+		c.dropIfEmpty(key)
+	}
+	return n
+}
+
+func cmdHLen(c *call) any {
+	h, errReply := c.hash(c.args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	return len(h)
+}
+
+func cmdHExists(c *call) any {
+	h, errReply := c.hash(c.args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	if _, ok := h[c.args[1]]; ok {
+		return 1
+	}
+	return 0
+}
+
+func cmdHIncrBy(c *call) any {
+	by, err := strconv.ParseInt(c.args[2], 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	key := c.args[0]
+	h, errReply := c.hash(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	var n int64
+	if current, ok := h[c.args[1]]; ok {
+		if n, err = strconv.ParseInt(current, 10, 64); err != nil {
+			return errorReply("ERR hash value is not an integer")
+		}
+	}
+	n += by
+	h[c.args[1]] = strconv.FormatInt(n, 10)
+	c.touch(key)
+	return n
+}
+
+func cmdSAdd(c *call) any {
+	key := c.args[0]
+	set, errReply := c.set(key, true)
+	if errReply != nil {
+		return errReply
+	}
+	n := 0
+	for _, member := range c.args[1:] {
+		if _, ok := set[member]; !ok {
+			set[member] = struct{}{}
+			n++
+		}
+	}
+	c.touch(key)
+	return n
+}
+
+func cmdSRem(c *call) any {
+	key := c.args[0]
+	set, errReply := c.set(key, false)
+	if errReply != nil {
+		return errReply
+	}
+	n := 0
+	for _, member := range c.args[1:] {
+		if _, ok := set[member]; ok {
+			delete(set, member)
+			n++
+		}
+	}
+	if n > 0 {
+		c.touch(key)
+		c.dropIfEmpty(key)
+	}
+	return n
+}
+
+func cmdSMembers(c *call) any {
+	set, errReply := c.set(c.args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	members := sortedMembers(set)
+	out := make([]any, len(members))
+	for i, m := range members {
+		out[i] = m
+	}
+	return out
+}
+
+func cmdSIsMember(c *call) any {
+	set, errReply := c.set(c.args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	if _, ok := set[c.args[1]]; ok {
+		return 1
+	}
+	return 0
+}
+
+func cmdSCard(c *call) any {
+	set, errReply := c.set(c.args[0], false)
+	if errReply != nil {
+		return errReply
+	}
+	return len(set)
+}
+
+func cmdDBSize(c *call) any {
+	n := 0
+	for key := range c.d.values {
+		if c.get(key) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdFlushAll(c *call) any {
+	for _, d := range c.s.dbs {
+		d.flush(c.s)
+	}
+	return status("OK")
+}
+
+func sortedMembers(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for m := range set {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
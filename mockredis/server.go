@@ -0,0 +1,267 @@
+// Package mockredis provides an in-process Redis server for tests. It speaks
+// RESP2 and keeps data in memory, implementing the string, hash, set, key
+// expiry and transaction (WATCH, MULTI, EXEC) commands used by
+// store.RedisStore, along with AUTH and SELECT. Key expiry follows a clock the
+// test can control, and errors can be injected for any command.
+package mockredis
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+)
+
+// NumDatabases is the number of databases SELECT accepts, as in a default
+// Redis configuration.
+const NumDatabases = 16
+
+// Server is a mock Redis server. Configure its fields, then call Start. All
+// methods are safe for concurrent use once started.
+type Server struct {
+	// ListenAddr is the address to listen on. Defaults to "127.0.0.1:0", a
+	// random free port; see Addr for the address actually bound.
+	ListenAddr string
+
+	// Password, if set, must be sent with AUTH before any other command, as
+	// with requirepass. AUTH may name the user "default" or Username.
+	Password string
+
+	// Username is an additional user name accepted by AUTH with Password.
+	Username string
+
+	// Clock decides when keys expire. Defaults to clock.Real; with a
+	// clocktest.Fake, keys expire when the test advances it.
+	Clock clock.Clock
+
+	ln         net.Listener
+	done       chan struct{}
+	closeOnce  sync.Once
+	acceptDone chan struct{}
+	wg         sync.WaitGroup
+
+	mu          sync.Mutex
+	conns       map[net.Conn]struct{}
+	connections int
+	commands    map[string]int
+	dbs         [NumDatabases]*db
+	seq         uint64 // Last key version handed out
+	failures    []failure
+}
+
+// failure is an error reply injected with FailOnce.
+type failure struct {
+	command string
+	text    string
+}
+
+// NewServer returns a server with default settings. Call Start to run it.
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Start binds the listener and begins serving in the background.
+func (s *Server) Start() error {
+	addr := s.ListenAddr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.ln = ln
+	s.done = make(chan struct{})
+	s.acceptDone = make(chan struct{})
+	s.conns = make(map[net.Conn]struct{})
+	s.commands = make(map[string]int)
+	for i := range s.dbs {
+		s.dbs[i] = newDB()
+	}
+
+	go s.accept()
+	return nil
+}
+
+// Addr returns the address the server is listening on, e.g. "127.0.0.1:54321".
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the server, drops open connections and waits for all
+// goroutines to exit.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.ln.Close()
+		<-s.acceptDone
+	})
+	s.CloseConnections()
+	s.wg.Wait()
+	return err
+}
+
+// CloseConnections drops every open connection, as a server timing out idle
+// clients would. The server keeps accepting new connections and keeps its data.
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// FlushAll removes every key from every database.
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.dbs {
+		d.flush(s)
+	}
+}
+
+// Reset removes every key and clears injected failures and counters.
+func (s *Server) Reset() {
+	s.FlushAll()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = nil
+	s.connections = 0
+	s.commands = make(map[string]int)
+}
+
+// Keys returns the unexpired keys in database db, sorted.
+func (s *Server) Keys(db int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, now := s.dbs[db], s.now()
+	var keys []string
+	for key := range d.values {
+		if d.get(s, key, now) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TTL returns the time left before key in database db expires, and false if
+// the key does not exist or has no expiry.
+func (s *Server) TTL(db int, key string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	v := s.dbs[db].get(s, key, now)
+	if v == nil || v.expires.IsZero() {
+		return 0, false
+	}
+	return v.expires.Sub(now), true
+}
+
+// HGetAll returns a copy of the hash at key in database db, or nil if there
+// is none.
+func (s *Server) HGetAll(db int, key string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.dbs[db].get(s, key, s.now())
+	if v == nil || v.hash == nil {
+		return nil
+	}
+	out := make(map[string]string, len(v.hash))
+	for field, value := range v.hash {
+		out[field] = value
+	}
+	return out
+}
+
+// SMembers returns the members of the set at key in database db, sorted.
+func (s *Server) SMembers(db int, key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.dbs[db].get(s, key, s.now())
+	if v == nil {
+		return nil
+	}
+	return sortedMembers(v.set)
+}
+
+// FailOnce makes the next execution of command (e.g. "EXEC") reply with the
+// error text, such as "ERR injected failure" or "LOADING Redis is loading".
+func (s *Server) FailOnce(command, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{strings.ToUpper(command), text})
+}
+
+// Connections reports how many connections have been accepted.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// CommandCount reports how many times command (e.g. "WATCH") has been received.
+func (s *Server) CommandCount(command string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands[strings.ToUpper(command)]
+}
+
+func (s *Server) accept() {
+	defer close(s.acceptDone)
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.connections++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				conn.Close()
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+			}()
+			newSession(s, conn).serve()
+		}()
+	}
+}
+
+// injected returns the text of a failure injected for command, consuming it.
+// The caller must hold s.mu.
+func (s *Server) injected(command string) (string, bool) {
+	for i, f := range s.failures {
+		if f.command == command {
+			s.failures = append(s.failures[:i:i], s.failures[i+1:]...)
+			return f.text, true
+		}
+	}
+	return "", false
+}
+
+// nextVersion returns a new key version. The caller must hold s.mu.
+func (s *Server) nextVersion() uint64 {
+	s.seq++
+	return s.seq
+}
+
+func (s *Server) now() time.Time {
+	return clock.Or(s.Clock).Now()
+}
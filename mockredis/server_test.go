@@ -0,0 +1,314 @@
+package mockredis_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/mockredis"
+)
+
+// client is a minimal RESP client that renders replies as text: strings
+// as-is, errors prefixed with "-", integers prefixed with ":", arrays as
+// "[a b]" and nulls as "(nil)".
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func startServer(t *testing.T, srv *mockredis.Server) *mockredis.Server {
+	t.Helper()
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func dial(t *testing.T, srv *mockredis.Server) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, rd: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns its reply.
+func (c *client) do(args ...string) string {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		c.t.Fatalf("Write error: %v", err)
+	}
+	return c.read()
+}
+
+func (c *client) read() string {
+	c.t.Helper()
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("Read error: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-', ':':
+		return line
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "(nil)"
+		}
+		buf := make([]byte, n+2)
+		io.ReadFull(c.rd, buf)
+		return string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "(nil)"
+		}
+		items := make([]string, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	c.t.Fatalf("Unexpected reply %q", line)
+	return ""
+}
+
+// expect runs each command in turn and checks its reply.
+func (c *client) expect(steps ...[]string) {
+	c.t.Helper()
+	for _, step := range steps {
+		want, args := step[0], step[1:]
+		if got := c.do(args...); got != want {
+			c.t.Errorf("%s: got %q, want %q", strings.Join(args, " "), got, want)
+		}
+	}
+}
+
+func TestServer_Commands(t *testing.T) {
+	srv := startServer(t, mockredis.NewServer())
+	c := dial(t, srv)
+
+	c.expect(
+		[]string{"PONG", "PING"},
+		[]string{"hello", "ECHO", "hello"},
+		[]string{"OK", "SET", "s", "v"},
+		[]string{"v", "GET", "s"},
+		[]string{"(nil)", "GET", "missing"},
+
+		[]string{":2", "HSET", "h", "a", "1", "b", "2"},
+		[]string{":0", "HSET", "h", "a", "3"},
+		[]string{"3", "HGET", "h", "a"},
+		[]string{"[3 (nil)]", "HMGET", "h", "a", "c"},
+		[]string{"[a 3 b 2]", "HGETALL", "h"},
+		[]string{":8", "HINCRBY", "h", "a", "5"},
+		[]string{":1", "HEXISTS", "h", "b"},
+		[]string{":2", "HLEN", "h"},
+		[]string{"[]", "HGETALL", "missing"},
+		[]string{":2", "HDEL", "h", "a", "b"},
+		[]string{":0", "EXISTS", "h"},
+
+		[]string{":2", "SADD", "set", "x", "y"},
+		[]string{":0", "SADD", "set", "x"},
+		[]string{"[x y]", "SMEMBERS", "set"},
+		[]string{":1", "SISMEMBER", "set", "y"},
+		[]string{":1", "SREM", "set", "y", "z"},
+		[]string{":1", "SCARD", "set"},
+		[]string{"set", "TYPE", "set"},
+
+		[]string{":2", "DBSIZE"},
+		[]string{":2", "DEL", "s", "set", "missing"},
+		[]string{":0", "DBSIZE"},
+
+		[]string{"OK", "SELECT", "2"},
+		[]string{"OK", "SET", "in2", "v"},
+		[]string{"OK", "SELECT", "0"},
+		[]string{"(nil)", "GET", "in2"},
+	)
+	if keys := srv.Keys(2); len(keys) != 1 || keys[0] != "in2" {
+		t.Errorf("Expected the key in database 2, got %q", keys)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		c.do("SET", "str", "v")
+		for _, tt := range [][]string{
+			{"-WRONGTYPE", "HGETALL", "str"},
+			{"-WRONGTYPE", "SADD", "str", "x"},
+			{"-ERR value is not an integer", "HINCRBY", "h", "a", "x"},
+			{"-ERR wrong number of arguments for 'hget' command", "HGET", "h"},
+			{"-ERR unknown command 'NOPE'", "NOPE", "x"},
+			{"-ERR DB index is out of range", "SELECT", "16"},
+		} {
+			if got := c.do(tt[1:]...); !strings.HasPrefix(got, tt[0]) {
+				t.Errorf("%s: got %q, want prefix %q", strings.Join(tt[1:], " "), got, tt[0])
+			}
+		}
+	})
+
+	t.Run("InlineAndPipelined", func(t *testing.T) {
+		io.WriteString(c.conn, "PING\r\nECHO one\r\n*1\r\n$4\r\nPING\r\n")
+		for _, want := range []string{"PONG", "one", "PONG"} {
+			if got := c.read(); got != want {
+				t.Errorf("Got %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("FailOnce", func(t *testing.T) {
+		srv.FailOnce("get", "LOADING Redis is loading the dataset in memory")
+		c.expect(
+			[]string{"-LOADING Redis is loading the dataset in memory", "GET", "str"},
+			[]string{"v", "GET", "str"},
+		)
+		if n := srv.CommandCount("GET"); n < 2 {
+			t.Errorf("Expected GET to be counted, got %d", n)
+		}
+	})
+}
+
+func TestServer_Expiry(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	srv := startServer(t, &mockredis.Server{Clock: clk})
+	c := dial(t, srv)
+
+	c.expect(
+		[]string{":1", "HSET", "h", "f", "v"},
+		[]string{":-1", "PTTL", "h"},
+		[]string{":1", "PEXPIRE", "h", "1500"},
+		[]string{":1500", "PTTL", "h"},
+		[]string{":2", "TTL", "h"},
+		[]string{":0", "EXPIRE", "missing", "10"},
+		[]string{"OK", "SET", "gone", "v"},
+		[]string{":1", "PEXPIRE", "gone", "0"},
+		[]string{":0", "EXISTS", "gone"},
+		[]string{":-2", "PTTL", "gone"},
+	)
+	if ttl, ok := srv.TTL(0, "h"); !ok || ttl != 1500*time.Millisecond {
+		t.Errorf("Expected TTL 1.5s, got %v (%v)", ttl, ok)
+	}
+
+	clk.Advance(time.Second)
+	c.expect([]string{"v", "HGET", "h", "f"})
+	clk.Advance(500 * time.Millisecond)
+	c.expect([]string{"(nil)", "HGET", "h", "f"})
+
+	c.expect(
+		[]string{"OK", "SET", "p", "v"},
+		[]string{":1", "EXPIRE", "p", "10"},
+		[]string{":1", "PERSIST", "p"},
+		[]string{":-1", "TTL", "p"},
+		[]string{":1", "PEXPIREAT", "p", strconv.FormatInt(clk.Now().Add(time.Minute).UnixMilli(), 10)},
+	)
+	clk.Advance(time.Minute)
+	c.expect([]string{":0", "EXISTS", "p"})
+}
+
+func TestServer_Transactions(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	srv := startServer(t, &mockredis.Server{Clock: clk})
+	c, other := dial(t, srv), dial(t, srv)
+
+	t.Run("Exec", func(t *testing.T) {
+		c.expect(
+			[]string{"OK", "WATCH", "k"},
+			[]string{"OK", "MULTI"},
+			[]string{"QUEUED", "HSET", "k", "n", "1"},
+			[]string{"QUEUED", "HINCRBY", "k", "n", "1"},
+			[]string{"[:1 :2]", "EXEC"},
+		)
+	})
+
+	t.Run("WatchedKeyChanged", func(t *testing.T) {
+		c.expect([]string{"OK", "WATCH", "k"})
+		other.expect([]string{":3", "HINCRBY", "k", "n", "1"})
+		c.expect(
+			[]string{"OK", "MULTI"},
+			[]string{"QUEUED", "DEL", "k"},
+			[]string{"(nil)", "EXEC"},
+			[]string{":1", "EXISTS", "k"},
+		)
+	})
+
+	t.Run("WatchedKeyExpired", func(t *testing.T) {
+		c.expect(
+			[]string{":1", "PEXPIRE", "k", "1000"},
+			[]string{"OK", "WATCH", "k"},
+		)
+		clk.Advance(time.Second)
+		c.expect(
+			[]string{"OK", "MULTI"},
+			[]string{"QUEUED", "SET", "k", "v"},
+			[]string{"(nil)", "EXEC"},
+		)
+	})
+
+	t.Run("UnwatchedChange", func(t *testing.T) {
+		c.expect([]string{"OK", "WATCH", "k"})
+		other.expect([]string{"OK", "SET", "unrelated", "v"})
+		c.expect(
+			[]string{"OK", "MULTI"},
+			[]string{"QUEUED", "SET", "k", "v"},
+			[]string{"[OK]", "EXEC"},
+		)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		c.expect(
+			[]string{"OK", "MULTI"},
+			[]string{"-ERR MULTI calls can not be nested", "MULTI"},
+			[]string{"-ERR WATCH inside MULTI is not allowed", "WATCH", "k"},
+			[]string{"QUEUED", "HSET", "k", "f", "v"},
+			[]string{"QUEUED", "GET", "k"},
+			[]string{"[-WRONGTYPE Operation against a key holding the wrong kind of value v]", "EXEC"},
+		)
+		c.expect(
+			[]string{"OK", "MULTI"},
+			[]string{"-ERR wrong number of arguments for 'get' command", "GET"},
+			[]string{"-EXECABORT Transaction discarded because of previous errors.", "EXEC"},
+			[]string{"-ERR EXEC without MULTI", "EXEC"},
+			[]string{"OK", "MULTI"},
+			[]string{"QUEUED", "DEL", "k"},
+			[]string{"OK", "DISCARD"},
+			[]string{"v", "GET", "k"},
+		)
+	})
+}
+
+func TestServer_Auth(t *testing.T) {
+	srv := startServer(t, &mockredis.Server{Password: "s3cret", Username: "app"})
+	c := dial(t, srv)
+
+	c.expect(
+		[]string{"-NOAUTH Authentication required.", "GET", "k"},
+		[]string{"-WRONGPASS invalid username-password pair or user is disabled.", "AUTH", "wrong"},
+		[]string{"-WRONGPASS invalid username-password pair or user is disabled.", "AUTH", "nobody", "s3cret"},
+		[]string{"OK", "AUTH", "app", "s3cret"},
+		[]string{"(nil)", "GET", "k"},
+		[]string{"OK", "QUIT"},
+	)
+	if _, err := c.rd.ReadByte(); err != io.EOF {
+		t.Errorf("Expected QUIT to close the connection, got %v", err)
+	}
+
+	open := dial(t, startServer(t, mockredis.NewServer()))
+	if got := open.do("AUTH", "x"); !strings.HasPrefix(got, "-ERR AUTH <password> called without any password configured") {
+		t.Errorf("Unexpected reply to AUTH without a password: %q", got)
+	}
+}
@@ -0,0 +1,346 @@
+package mockredis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Limits on request sizes, as in a default Redis configuration.
+const (
+	maxArgs     = 1024 * 1024
+	maxBulkSize = 512 * 1024 * 1024
+)
+
+// errProtocol is a malformed request; the server replies and closes the connection.
+var errProtocol = errors.New("protocol error")
+
+// session is one client connection.
+type session struct {
+	s    *Server
+	conn net.Conn
+	rd   *bufio.Reader
+	wr   *bufio.Writer
+
+	db      int
+	authed  bool
+	multi   bool
+	dirty   bool // A command failed to queue; EXEC will abort
+	queued  [][]string
+	watched map[watchKey]uint64
+}
+
+// watchKey identifies a WATCHed key.
+type watchKey struct {
+	db  int
+	key string
+}
+
+func newSession(s *Server, conn net.Conn) *session {
+	return &session{
+		s:      s,
+		conn:   conn,
+		rd:     bufio.NewReader(conn),
+		wr:     bufio.NewWriter(conn),
+		authed: s.Password == "",
+	}
+}
+
+// serve answers commands until the client quits or the connection fails.
+// Replies to pipelined commands are flushed together.
+func (ss *session) serve() {
+	for {
+		args, err := readCommand(ss.rd)
+		if errors.Is(err, errProtocol) {
+			writeReply(ss.wr, errorReply("ERR Protocol error: "+err.Error()))
+			ss.wr.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := ss.handle(args)
+		if quit || ss.rd.Buffered() == 0 {
+			if err := ss.wr.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// handle answers one command and reports whether the connection should close.
+func (ss *session) handle(args []string) (quit bool) {
+	name := strings.ToUpper(args[0])
+	ss.s.mu.Lock()
+	ss.s.commands[name]++
+	ss.s.mu.Unlock()
+
+	switch {
+	case name == "QUIT":
+		writeReply(ss.wr, status("OK"))
+		return true
+	case name == "AUTH":
+		writeReply(ss.wr, ss.auth(args[1:]))
+		return false
+	case !ss.authed:
+		writeReply(ss.wr, errorReply("NOAUTH Authentication required."))
+		return false
+	}
+
+	var reply any
+	switch name {
+	case "MULTI":
+		reply = ss.startMulti()
+	case "EXEC":
+		reply = ss.exec()
+	case "DISCARD":
+		reply = ss.discard()
+	case "WATCH":
+		reply = ss.watch(args[1:])
+	case "UNWATCH":
+		ss.watched = nil
+		reply = status("OK")
+	default:
+		if ss.multi {
+			reply = ss.queue(name, args)
+		} else {
+			ss.s.mu.Lock()
+			reply = ss.run(name, args)
+			ss.s.mu.Unlock()
+		}
+	}
+	writeReply(ss.wr, reply)
+	return false
+}
+
+func (ss *session) auth(args []string) any {
+	if len(args) < 1 || len(args) > 2 {
+		return errorReply("ERR wrong number of arguments for 'auth' command")
+	}
+	if ss.s.Password == "" {
+		return errorReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	user, password := "default", args[len(args)-1]
+	if len(args) == 2 {
+		user = args[0]
+	}
+	if (user != "default" && user != ss.s.Username) || password != ss.s.Password {
+		return errorReply("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	ss.authed = true
+	return status("OK")
+}
+
+func (ss *session) startMulti() any {
+	if ss.multi {
+		return errorReply("ERR MULTI calls can not be nested")
+	}
+	ss.multi = true
+	return status("OK")
+}
+
+// queue validates a command sent inside MULTI and queues it.
+func (ss *session) queue(name string, args []string) any {
+	var reply any
+	if cmd, ok := commands[name]; ok {
+		reply = checkArity(name, cmd, args)
+	} else if name == "SELECT" {
+		if len(args) != 2 {
+			reply = errorReply("ERR wrong number of arguments for 'select' command")
+		}
+	} else {
+		reply = unknownCommand(args)
+	}
+	if reply != nil {
+		ss.dirty = true
+		return reply
+	}
+	ss.queued = append(ss.queued, args)
+	return status("QUEUED")
+}
+
+// exec runs the queued commands atomically, unless a watched key has changed.
+func (ss *session) exec() any {
+	if !ss.multi {
+		return errorReply("ERR EXEC without MULTI")
+	}
+	queued, dirty, watched := ss.queued, ss.dirty, ss.watched
+	ss.multi, ss.dirty, ss.queued, ss.watched = false, false, nil, nil
+	if dirty {
+		return errorReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	ss.s.mu.Lock()
+	defer ss.s.mu.Unlock()
+	if text, ok := ss.s.injected("EXEC"); ok {
+		return errorReply(text)
+	}
+	now := ss.s.now()
+	for wk, version := range watched {
+		d := ss.s.dbs[wk.db]
+		d.get(ss.s, wk.key, now) // Expire it first, which counts as a change
+		if d.versions[wk.key] != version {
+			return nullArray{}
+		}
+	}
+
+	replies := make([]any, len(queued))
+	for i, args := range queued {
+		replies[i] = ss.run(strings.ToUpper(args[0]), args)
+	}
+	return replies
+}
+
+func (ss *session) discard() any {
+	if !ss.multi {
+		return errorReply("ERR DISCARD without MULTI")
+	}
+	ss.multi, ss.dirty, ss.queued, ss.watched = false, false, nil, nil
+	return status("OK")
+}
+
+// watch records the current version of each key.
+func (ss *session) watch(keys []string) any {
+	if ss.multi {
+		return errorReply("ERR WATCH inside MULTI is not allowed")
+	}
+	if len(keys) == 0 {
+		return errorReply("ERR wrong number of arguments for 'watch' command")
+	}
+
+	ss.s.mu.Lock()
+	defer ss.s.mu.Unlock()
+	if ss.watched == nil {
+		ss.watched = make(map[watchKey]uint64)
+	}
+	d, now := ss.s.dbs[ss.db], ss.s.now()
+	for _, key := range keys {
+		wk := watchKey{ss.db, key}
+		if _, ok := ss.watched[wk]; ok {
+			continue
+		}
+		d.get(ss.s, key, now)
+		ss.watched[wk] = d.versions[key]
+	}
+	return status("OK")
+}
+
+// run executes SELECT or a data command. The caller must hold ss.s.mu.
+func (ss *session) run(name string, args []string) any {
+	if text, ok := ss.s.injected(name); ok {
+		return errorReply(text)
+	}
+	if name == "SELECT" {
+		return ss.selectDB(args)
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		return unknownCommand(args)
+	}
+	if reply := checkArity(name, cmd, args); reply != nil {
+		return reply
+	}
+	return cmd.run(&call{s: ss.s, d: ss.s.dbs[ss.db], now: ss.s.now(), args: args[1:]})
+}
+
+func (ss *session) selectDB(args []string) any {
+	if len(args) != 2 {
+		return errorReply("ERR wrong number of arguments for 'select' command")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errNotInteger
+	}
+	if n < 0 || n >= NumDatabases {
+		return errorReply("ERR DB index is out of range")
+	}
+	ss.db = n
+	return status("OK")
+}
+
+func unknownCommand(args []string) errorReply {
+	return errorReply(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s",
+		args[0], strings.Join(args[1:min(len(args), 4)], " ")))
+}
+
+// readCommand reads a RESP array of bulk strings, or an inline command
+// separated by spaces as typed into telnet.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	args := make([]string, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errProtocol, line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkSize {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a line without its CRLF (or LF) terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// writeReply encodes v as RESP2: nil as a null bulk string, strings as bulk
+// strings and []any as arrays.
+func writeReply(w *bufio.Writer, v any) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case nullArray:
+		w.WriteString("*-1\r\n")
+	case status:
+		w.WriteString("+" + string(v) + "\r\n")
+	case errorReply:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []any:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		panic(fmt.Sprintf("mockredis: cannot encode reply of type %T", v))
+	}
+}
@@ -0,0 +1,92 @@
+package mocksmtp
+
+import (
+	"time"
+)
+
+// Stage identifies the point in a session where a Fault applies.
+type Stage string
+
+const (
+	StageConnect  Stage = "CONNECT"  // the greeting
+	StageHelo     Stage = "EHLO"     // EHLO or HELO
+	StageStartTLS Stage = "STARTTLS" // before the TLS handshake
+	StageAuth     Stage = "AUTH"     // after the client's credentials
+	StageMail     Stage = "MAIL"
+	StageRcpt     Stage = "RCPT"
+	StageData     Stage = "DATA"    // the DATA command, before the 354 reply
+	StageMessage  Stage = "MESSAGE" // the end of the message data
+	StageRset     Stage = "RSET"
+	StageNoop     Stage = "NOOP"
+	StageQuit     Stage = "QUIT"
+)
+
+// Fault changes how the server answers at Stage. A 421 reply also closes the
+// connection, as a server shutting down would.
+type Fault struct {
+	Stage Stage
+
+	// Code replaces the normal reply (e.g. 451 or 550). Zero keeps the normal
+	// reply, which is useful together with Delay.
+	Code int
+
+	// Text accompanies Code. Defaults to a generic message for the code.
+	Text string
+
+	// Delay is waited before replying, to exercise client timeouts.
+	Delay time.Duration
+
+	// Times limits how often the fault fires. Zero means every time until Reset.
+	Times int
+}
+
+// Inject adds f. Faults are matched in the order they were added.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// Fail makes every reply at stage use code until Reset.
+func (s *Server) Fail(stage Stage, code int) {
+	s.Inject(Fault{Stage: stage, Code: code})
+}
+
+// FailOnce makes the next reply at stage use code.
+func (s *Server) FailOnce(stage Stage, code int) {
+	s.Inject(Fault{Stage: stage, Code: code, Times: 1})
+}
+
+// fault returns the first fault for stage, consuming one use of it.
+func (s *Server) fault(stage Stage) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.faults {
+		if f.Stage != stage {
+			continue
+		}
+		match := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return &match
+	}
+	return nil
+}
+
+// defaultText is the reply text used for injected codes without Text.
+func defaultText(code int) string {
+	switch {
+	case code == 421:
+		return "Service not available, closing transmission channel"
+	case code >= 500:
+		return "Permanent failure (injected)"
+	case code >= 400:
+		return "Temporary failure (injected)"
+	default:
+		return "OK"
+	}
+}
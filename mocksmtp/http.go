@@ -0,0 +1,240 @@
+package mocksmtp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Long-poll limits for GET /api/messages/next.
+const (
+	DefaultPollTimeout = 30 * time.Second
+	MaxPollTimeout     = 5 * time.Minute
+)
+
+// MessageSummary is the JSON form of a message in listings.
+type MessageSummary struct {
+	ID         int       `json:"id"`
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	Subject    string    `json:"subject"`
+	Size       int       `json:"size"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// MessageDetail is the JSON form of a single message.
+type MessageDetail struct {
+	MessageSummary
+	Helo          string              `json:"helo"`
+	TLS           bool                `json:"tls"`
+	AuthUser      string              `json:"auth_user,omitempty"`
+	AuthMechanism string              `json:"auth_mechanism,omitempty"`
+	RemoteAddr    string              `json:"remote_addr"`
+	Headers       map[string][]string `json:"headers"`
+	Text          string              `json:"text,omitempty"`
+	HTML          string              `json:"html,omitempty"`
+}
+
+func (m *Message) summary() MessageSummary {
+	return MessageSummary{
+		ID:         m.ID,
+		From:       m.From,
+		To:         m.To,
+		Subject:    m.Subject(),
+		Size:       len(m.Data),
+		ReceivedAt: m.ReceivedAt,
+	}
+}
+
+func (m *Message) detail() MessageDetail {
+	text, _ := m.Text()
+	html, _ := m.HTML()
+	return MessageDetail{
+		MessageSummary: m.summary(),
+		Helo:           m.Helo,
+		TLS:            m.TLS,
+		AuthUser:       m.AuthUser,
+		AuthMechanism:  m.AuthMechanism,
+		RemoteAddr:     m.RemoteAddr,
+		Headers:        m.Header,
+		Text:           text,
+		HTML:           html,
+	}
+}
+
+// Handler returns the HTTP inspection interface for captured mail:
+//
+//	GET    /                        HTML page listing messages
+//	GET    /api/messages            JSON list, oldest first (?to= filters by recipient)
+//	DELETE /api/messages            delete all messages
+//	GET    /api/messages/latest     newest message as JSON (?to= filters by recipient)
+//	GET    /api/messages/next       long-poll for the next message (?to=, ?after=<id>, ?timeout=30s)
+//	GET    /api/messages/{id}       one message as JSON, including decoded text and HTML bodies
+//	GET    /api/messages/{id}/raw   one message as message/rfc822 (.eml)
+//
+// Set Server.HTTPListenAddr to serve it from Start, or mount it on your own
+// http.Server or httptest.Server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleIndex)
+	mux.HandleFunc("GET /api/messages", s.handleList)
+	mux.HandleFunc("DELETE /api/messages", s.handleDelete)
+	mux.HandleFunc("GET /api/messages/latest", s.handleLatest)
+	mux.HandleFunc("GET /api/messages/next", s.handleNext)
+	mux.HandleFunc("GET /api/messages/{id}", s.handleGet)
+	mux.HandleFunc("GET /api/messages/{id}/raw", s.handleRaw)
+	return mux
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	to := r.URL.Query().Get("to")
+	list := []MessageSummary{}
+	for _, m := range s.Messages() {
+		if m.SentTo(to) {
+			list = append(list, m.summary())
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	s.DeleteMessages()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleLatest(w http.ResponseWriter, r *http.Request) {
+	to := r.URL.Query().Get("to")
+	msgs := s.Messages()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].SentTo(to) {
+			writeJSON(w, http.StatusOK, msgs[i].detail())
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "no messages")
+}
+
+// handleNext waits for a message newer than ?after (default: the newest
+// message when the request arrived). It answers 204 if none arrives in time.
+func (s *Server) handleNext(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	after := s.LastID()
+	if v := q.Get("after"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid after")
+			return
+		}
+		after = n
+	}
+	timeout := DefaultPollTimeout
+	if v := q.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid timeout")
+			return
+		}
+		timeout = min(d, MaxPollTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	m, err := s.Next(ctx, q.Get("to"), after)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, m.detail())
+	case errors.Is(err, context.DeadlineExceeded):
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrServerClosed):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		// The client went away.
+	}
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	if m := s.lookup(w, r); m != nil {
+		writeJSON(w, http.StatusOK, m.detail())
+	}
+}
+
+func (s *Server) handleRaw(w http.ResponseWriter, r *http.Request) {
+	m := s.lookup(w, r)
+	if m == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", `attachment; filename="message-`+strconv.Itoa(m.ID)+`.eml"`)
+	w.Write(m.Data)
+}
+
+// lookup resolves the {id} path value, writing an error response if it fails.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) *Message {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid message id")
+		return nil
+	}
+	m, ok := s.Message(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "message not found")
+		return nil
+	}
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mocksmtp</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .4em .8em; border-bottom: 1px solid #ddd; vertical-align: top; }
+pre { white-space: pre-wrap; margin: 0; }
+</style>
+</head>
+<body>
+<h1>mocksmtp &mdash; {{len .}} message(s)</h1>
+<table>
+<tr><th>#</th><th>Received</th><th>From</th><th>To</th><th>Subject</th><th>Text</th><th></th></tr>
+{{range .}}<tr>
+<td>{{.ID}}</td>
+<td>{{.ReceivedAt.Format "15:04:05"}}</td>
+<td>{{.From}}</td>
+<td>{{range .To}}{{.}}<br>{{end}}</td>
+<td>{{.Subject}}</td>
+<td><pre>{{.Text}}</pre></td>
+<td><a href="api/messages/{{.ID}}">JSON</a> <a href="api/messages/{{.ID}}/raw">.eml</a></td>
+</tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+// handleIndex renders a page for humans, newest message first.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	msgs := s.Messages()
+	details := make([]MessageDetail, len(msgs))
+	for i, m := range msgs {
+		details[len(msgs)-1-i] = m.detail()
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, details)
+}
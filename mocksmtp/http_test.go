@@ -0,0 +1,202 @@
+package mocksmtp_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/mocksmtp"
+)
+
+// getJSON fetches url, checks the status and decodes the body into v (if non-nil).
+func getJSON(t *testing.T, method, url string, want int, v any) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, url, want, resp.StatusCode, body)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to decode %s: %v", url, err)
+		}
+	}
+}
+
+func TestHandler_Messages(t *testing.T) {
+	srv := startServer(t, nil)
+	web := httptest.NewServer(srv.Handler())
+	defer web.Close()
+
+	var list []mocksmtp.MessageSummary
+	getJSON(t, "GET", web.URL+"/api/messages", http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("Expected no messages, got %d", len(list))
+	}
+
+	if err := sendMail(srv, nil, "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendMail(srv, nil, "b@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	getJSON(t, "GET", web.URL+"/api/messages", http.StatusOK, &list)
+	if len(list) != 2 || list[0].ID != 1 || list[1].Subject != "Grüße" || list[1].Size != len(testMessage) {
+		t.Errorf("Unexpected list: %+v", list)
+	}
+	getJSON(t, "GET", web.URL+"/api/messages?to=B@example.com", http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != 2 {
+		t.Errorf("Expected only message 2 for b@example.com, got %+v", list)
+	}
+
+	var m mocksmtp.MessageDetail
+	getJSON(t, "GET", web.URL+"/api/messages/1", http.StatusOK, &m)
+	if m.ID != 1 || m.From != "sender@example.com" || !m.TLS || m.HTML != "<p>123456</p>" ||
+		!strings.Contains(m.Text, "123456") || m.Headers["To"][0] != "rcpt@example.com" {
+		t.Errorf("Unexpected message: %+v", m)
+	}
+	getJSON(t, "GET", web.URL+"/api/messages/latest?to=a@example.com", http.StatusOK, &m)
+	if m.ID != 1 {
+		t.Errorf("Expected latest for a@example.com to be 1, got %d", m.ID)
+	}
+	getJSON(t, "GET", web.URL+"/api/messages/latest", http.StatusOK, &m)
+	if m.ID != 2 {
+		t.Errorf("Expected latest to be 2, got %d", m.ID)
+	}
+
+	resp, err := http.Get(web.URL + "/api/messages/2/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(raw) != testMessage || resp.Header.Get("Content-Type") != "message/rfc822" ||
+		!strings.Contains(resp.Header.Get("Content-Disposition"), "message-2.eml") {
+		t.Errorf("Unexpected raw response %v:\n%s", resp.Header, raw)
+	}
+
+	resp, err = http.Get(web.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "Grüße") || !strings.Contains(string(page), "api/messages/2/raw") {
+		t.Errorf("Expected the index to list messages, got:\n%s", page)
+	}
+
+	getJSON(t, "GET", web.URL+"/api/messages/99", http.StatusNotFound, nil)
+	getJSON(t, "GET", web.URL+"/api/messages/abc", http.StatusBadRequest, nil)
+
+	getJSON(t, "DELETE", web.URL+"/api/messages", http.StatusNoContent, nil)
+	getJSON(t, "GET", web.URL+"/api/messages", http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Errorf("Expected DELETE to remove messages, got %d", len(list))
+	}
+	getJSON(t, "GET", web.URL+"/api/messages/latest", http.StatusNotFound, nil)
+}
+
+func TestHandler_Next(t *testing.T) {
+	srv := startServer(t, nil)
+	web := httptest.NewServer(srv.Handler())
+	defer web.Close()
+
+	// A message already captured is not "next" unless after says so.
+	if err := sendMail(srv, nil, "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	var m mocksmtp.MessageDetail
+	getJSON(t, "GET", web.URL+"/api/messages/next?to=user@example.com&after=0", http.StatusOK, &m)
+	if m.ID != 1 {
+		t.Errorf("Expected message 1, got %d", m.ID)
+	}
+	getJSON(t, "GET", web.URL+"/api/messages/next?timeout=20ms", http.StatusNoContent, nil)
+	getJSON(t, "GET", web.URL+"/api/messages/next?timeout=soon", http.StatusBadRequest, nil)
+
+	got := make(chan mocksmtp.MessageDetail, 1)
+	go func() {
+		var m mocksmtp.MessageDetail
+		if resp, err := http.Get(web.URL + "/api/messages/next?to=user@example.com&after=1&timeout=5s"); err == nil {
+			json.NewDecoder(resp.Body).Decode(&m)
+			resp.Body.Close()
+		}
+		got <- m
+	}()
+	if err := sendMail(srv, nil, "other@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendMail(srv, nil, "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-got:
+		if m.ID != 3 || m.To[0] != "user@example.com" {
+			t.Errorf("Expected message 3 for user@example.com, got %d %v", m.ID, m.To)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Long-poll did not return")
+	}
+
+	// IDs keep increasing after DELETE, so pollers never see a message twice.
+	srv.DeleteMessages()
+	if err := sendMail(srv, nil, "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	getJSON(t, "GET", web.URL+"/api/messages/next?after=3", http.StatusOK, &m)
+	if m.ID != 4 {
+		t.Errorf("Expected message 4 after delete, got %d", m.ID)
+	}
+}
+
+func TestServer_HTTPListenAddr(t *testing.T) {
+	srv := startServer(t, func(s *mocksmtp.Server) {
+		s.HTTPListenAddr = "127.0.0.1:0"
+	})
+	if !strings.HasPrefix(srv.HTTPURL(), "http://127.0.0.1:") {
+		t.Fatalf("Unexpected HTTPURL %q", srv.HTTPURL())
+	}
+
+	// Closing the server ends pending long-polls.
+	done := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(srv.HTTPURL() + "/api/messages/next")
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var list []mocksmtp.MessageSummary
+	getJSON(t, "GET", srv.HTTPURL()+"/api/messages", http.StatusOK, &list)
+
+	srv.Close()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("Long-poll survived Close")
+	}
+	if _, err := http.Get(srv.HTTPURL() + "/api/messages"); err == nil {
+		t.Error("Expected the HTTP listener to be closed")
+	}
+
+	if plain := startServer(t, nil); plain.HTTPURL() != "" {
+		t.Errorf("Expected no HTTPURL without HTTPListenAddr, got %q", plain.HTTPURL())
+	}
+}
@@ -0,0 +1,169 @@
+package mocksmtp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// ErrNoPart is returned by Message.Text and Message.HTML when the message has
+// no body of that type.
+var ErrNoPart = errors.New("mocksmtp: message has no part of that type")
+
+// Message is an email accepted by the server, with its envelope and parsed headers.
+type Message struct {
+	ID int // Sequential, starting at 1
+
+	// Envelope.
+	From string   // MAIL FROM address (empty for the null sender)
+	To   []string // RCPT TO addresses
+
+	// Session details.
+	Helo          string
+	TLS           bool
+	AuthUser      string // Empty if the client did not authenticate
+	AuthMechanism string
+	RemoteAddr    string
+	ReceivedAt    time.Time
+
+	// Data is the message exactly as received (after dot-unstuffing), with CRLF line endings.
+	Data []byte
+
+	// Header and Body are Data split and parsed. If Data is not a valid
+	// RFC 5322 message, Header is empty and Body is all of Data.
+	Header mail.Header
+	Body   []byte
+}
+
+// envelope carries the session details for newMessage.
+type envelope struct {
+	From, Helo, AuthUser, AuthMechanism, RemoteAddr string
+	To                                              []string
+	TLS                                             bool
+	ReceivedAt                                      time.Time
+}
+
+func newMessage(env envelope, data []byte) *Message {
+	m := &Message{
+		From:          env.From,
+		To:            append([]string(nil), env.To...),
+		Helo:          env.Helo,
+		TLS:           env.TLS,
+		AuthUser:      env.AuthUser,
+		AuthMechanism: env.AuthMechanism,
+		RemoteAddr:    env.RemoteAddr,
+		ReceivedAt:    env.ReceivedAt,
+		Data:          data,
+		Header:        mail.Header{},
+		Body:          data,
+	}
+	if parsed, err := mail.ReadMessage(bytes.NewReader(data)); err == nil {
+		if body, err := io.ReadAll(parsed.Body); err == nil {
+			m.Header, m.Body = parsed.Header, body
+		}
+	}
+	return m
+}
+
+// SentTo reports whether recipient is one of the envelope recipients,
+// ignoring case. An empty recipient matches every message.
+func (m *Message) SentTo(recipient string) bool {
+	if recipient == "" {
+		return true
+	}
+	for _, to := range m.To {
+		if strings.EqualFold(to, recipient) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subject returns the decoded Subject header.
+func (m *Message) Subject() string {
+	subject := m.Header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		return decoded
+	}
+	return subject
+}
+
+// Text returns the decoded text/plain body, looking inside multipart messages.
+func (m *Message) Text() (string, error) {
+	return m.part("text/plain")
+}
+
+// HTML returns the decoded text/html body, looking inside multipart messages.
+func (m *Message) HTML() (string, error) {
+	return m.part("text/html")
+}
+
+func (m *Message) part(mediaType string) (string, error) {
+	return findPart(m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), bytes.NewReader(m.Body), mediaType)
+}
+
+// findPart searches an entity (and, recursively, its subparts) for the first
+// body of mediaType and returns it decoded.
+func findPart(contentType, encoding string, body io.Reader, mediaType string) (string, error) {
+	mt, params, err := mime.ParseMediaType(contentType)
+	if contentType == "" || err != nil {
+		mt = "text/plain"
+	}
+
+	if strings.HasPrefix(mt, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return "", ErrNoPart
+			}
+			if err != nil {
+				return "", err
+			}
+			// multipart.Reader already decodes quoted-printable parts and
+			// removes their Content-Transfer-Encoding header.
+			s, err := findPart(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p, mediaType)
+			if !errors.Is(err, ErrNoPart) {
+				return s, err
+			}
+		}
+	}
+
+	if mt != mediaType {
+		return "", ErrNoPart
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, newlineStripper{body})
+	}
+	b, err := io.ReadAll(body)
+	return string(b), err
+}
+
+// newlineStripper drops CR and LF so wrapped base64 can be decoded.
+type newlineStripper struct{ r io.Reader }
+
+func (n newlineStripper) Read(p []byte) (int, error) {
+	for {
+		c, err := n.r.Read(p)
+		j := 0
+		for _, b := range p[:c] {
+			if b != '\r' && b != '\n' {
+				p[j] = b
+				j++
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
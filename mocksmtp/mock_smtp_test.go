@@ -1,9 +1,12 @@
 package mocksmtp_test
 
 import (
-	"bufio"
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
 	"net"
+	"net/smtp"
+	"net/textproto"
 	"strings"
 	"testing"
 	"time"
@@ -11,78 +14,436 @@ import (
 	"github.com/rlnorthcutt/go-passwordless/mocksmtp"
 )
 
-func TestMockSMTPServer(t *testing.T) {
-	port := "2526"
-	mocksmtp.StartMockSMTPServer(port)
+const testMessage = "From: Sender <sender@example.com>\r\n" +
+	"To: rcpt@example.com\r\n" +
+	"Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative; boundary=BOUNDARY\r\n" +
+	"\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Your code is 123456.=0D=0A.leading dot\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PHA+MTIzNDU2PC9wPg==\r\n" +
+	"--BOUNDARY--\r\n"
 
-	// Allow the server to start
-	time.Sleep(500 * time.Millisecond)
+func startServer(t *testing.T, configure func(*mocksmtp.Server)) *mocksmtp.Server {
+	t.Helper()
+	srv := mocksmtp.NewServer()
+	if configure != nil {
+		configure(srv)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// dial opens a textproto connection and consumes the greeting.
+func dial(t *testing.T, srv *mocksmtp.Server) *textproto.Conn {
+	t.Helper()
+	c, err := textproto.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if _, _, err := c.ReadResponse(220); err != nil {
+		t.Fatalf("Unexpected greeting: %v", err)
+	}
+	return c
+}
+
+// cmd sends a command and checks the reply code.
+func cmd(t *testing.T, c *textproto.Conn, want int, format string, args ...any) string {
+	t.Helper()
+	if err := c.PrintfLine(format, args...); err != nil {
+		t.Fatalf("Failed to send %q: %v", format, err)
+	}
+	code, msg, err := c.ReadResponse(want)
+	if err != nil {
+		t.Fatalf("%s: expected %d, got %d %s", strings.Fields(format)[0], want, code, msg)
+	}
+	return msg
+}
+
+func sendMail(srv *mocksmtp.Server, auth smtp.Auth, to ...string) error {
+	c, err := smtp.Dial(srv.Addr())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.Hello("client.example.com"); err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		cfg := srv.ClientTLSConfig()
+		cfg.ServerName = srv.Host()
+		if err := c.StartTLS(cfg); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail("sender@example.com"); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(testMessage)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func TestServer_CapturesMessages(t *testing.T) {
+	srv := startServer(t, func(s *mocksmtp.Server) {
+		s.Credentials = map[string]string{"user": "pass"}
+	})
+
+	if err := sendMail(srv, smtp.PlainAuth("", "user", "pass", srv.Host()), "rcpt@example.com", "cc@example.com"); err != nil {
+		t.Fatalf("sendMail failed: %v", err)
+	}
+
+	msgs, err := srv.Wait(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	m := msgs[0]
+	if m.ID != 1 || m.From != "sender@example.com" || len(m.To) != 2 || m.To[1] != "cc@example.com" {
+		t.Errorf("Unexpected envelope: id=%d from=%q to=%v", m.ID, m.From, m.To)
+	}
+	if !m.TLS || m.AuthUser != "user" || m.AuthMechanism != "PLAIN" || m.Helo != "client.example.com" {
+		t.Errorf("Unexpected session details: tls=%v auth=%q/%q helo=%q", m.TLS, m.AuthUser, m.AuthMechanism, m.Helo)
+	}
+	if string(m.Data) != testMessage {
+		t.Errorf("Expected data to round-trip, got:\n%s", m.Data)
+	}
+	if m.Subject() != "Grüße" || m.Header.Get("To") != "rcpt@example.com" {
+		t.Errorf("Unexpected headers: subject=%q to=%q", m.Subject(), m.Header.Get("To"))
+	}
+	if text, err := m.Text(); err != nil || text != "Your code is 123456.\r\n.leading dot" {
+		t.Errorf("Unexpected text part %q (%v)", text, err)
+	}
+	if html, err := m.HTML(); err != nil || html != "<p>123456</p>" {
+		t.Errorf("Unexpected HTML part %q (%v)", html, err)
+	}
+	if got, ok := srv.Message(1); !ok || got != m {
+		t.Error("Expected Message(1) to return the captured message")
+	}
+
+	srv.Reset()
+	if n := len(srv.Messages()); n != 0 {
+		t.Errorf("Expected Reset to discard messages, got %d", n)
+	}
+}
 
-	t.Run("HELO Command", func(t *testing.T) {
-		conn, err := net.Dial("tcp", ":"+port)
-		if err != nil {
-			t.Fatalf("Failed to connect to mock SMTP server: %v", err)
+func TestServer_Auth(t *testing.T) {
+	srv := startServer(t, func(s *mocksmtp.Server) {
+		s.Credentials = map[string]string{"user": "pass"}
+	})
+
+	t.Run("CRAM-MD5", func(t *testing.T) {
+		if err := sendMail(srv, smtp.CRAMMD5Auth("user", "pass"), "rcpt@example.com"); err != nil {
+			t.Fatalf("sendMail failed: %v", err)
 		}
-		defer conn.Close()
+	})
 
-		readResponse(t, conn) // Read initial 220 message
-		sendCommand(t, conn, "HELO localhost\r\n", "250 Hello")
+	t.Run("LOGIN", func(t *testing.T) {
+		c := dial(t, srv)
+		cmd(t, c, 250, "EHLO client")
+		cmd(t, c, 334, "AUTH LOGIN")
+		cmd(t, c, 334, "dXNlcg==") // user
+		cmd(t, c, 235, "cGFzcw==") // pass
+		cmd(t, c, 503, "AUTH LOGIN")
 	})
 
-	t.Run("MAIL FROM and RCPT TO Commands", func(t *testing.T) {
-		conn, err := net.Dial("tcp", ":"+port)
-		if err != nil {
-			t.Fatalf("Failed to connect to mock SMTP server: %v", err)
+	t.Run("invalid credentials", func(t *testing.T) {
+		err := sendMail(srv, smtp.PlainAuth("", "user", "wrong", srv.Host()), "rcpt@example.com")
+		var tpErr *textproto.Error
+		if !errors.As(err, &tpErr) || tpErr.Code != 535 {
+			t.Fatalf("Expected 535, got %v", err)
 		}
-		defer conn.Close()
+	})
 
-		readResponse(t, conn)
-		sendCommand(t, conn, "MAIL FROM:<test@example.com>\r\n", "250 OK")
-		sendCommand(t, conn, "RCPT TO:<recipient@example.com>\r\n", "250 OK")
+	t.Run("unknown mechanism", func(t *testing.T) {
+		c := dial(t, srv)
+		cmd(t, c, 250, "EHLO client")
+		cmd(t, c, 504, "AUTH XOAUTH2")
 	})
 
-	t.Run("DATA Command", func(t *testing.T) {
-		conn, err := net.Dial("tcp", ":"+port)
-		if err != nil {
-			t.Fatalf("Failed to connect to mock SMTP server: %v", err)
+	if n := len(srv.Messages()); n != 1 {
+		t.Errorf("Expected 1 message, got %d", n)
+	}
+}
+
+func TestServer_Policies(t *testing.T) {
+	srv := startServer(t, func(s *mocksmtp.Server) {
+		s.Credentials = map[string]string{"user": "pass"}
+		s.RequireTLS = true
+		s.RequireAuth = true
+		s.MaxMessageSize = 64
+	})
+
+	c := dial(t, srv)
+	cmd(t, c, 503, "MAIL FROM:<a@example.com>")
+	msg := cmd(t, c, 250, "EHLO client")
+	for _, ext := range []string{"PIPELINING", "SIZE 64", "STARTTLS", "AUTH PLAIN LOGIN CRAM-MD5"} {
+		if !strings.Contains(msg, ext) {
+			t.Errorf("Expected EHLO to advertise %q, got:\n%s", ext, msg)
+		}
+	}
+	cmd(t, c, 530, "MAIL FROM:<a@example.com>")
+	cmd(t, c, 530, "AUTH PLAIN AHVzZXIAcGFzcw==")
+	cmd(t, c, 503, "RCPT TO:<b@example.com>")
+	cmd(t, c, 503, "DATA")
+
+	if err := sendMail(srv, nil, "rcpt@example.com"); err == nil || !strings.Contains(err.Error(), "530") {
+		t.Errorf("Expected 530 without AUTH, got %v", err)
+	}
+	if err := sendMail(srv, smtp.PlainAuth("", "user", "pass", srv.Host()), "rcpt@example.com"); err == nil || !strings.Contains(err.Error(), "552") {
+		t.Errorf("Expected 552 for an oversized message, got %v", err)
+	}
+	if n := len(srv.Messages()); n != 0 {
+		t.Errorf("Expected no messages, got %d", n)
+	}
+}
+
+func TestServer_Pipelining(t *testing.T) {
+	srv := startServer(t, nil)
+	c := dial(t, srv)
+	cmd(t, c, 250, "EHLO client")
+
+	// Send a whole transaction at once, then read the replies in order.
+	batch := "MAIL FROM:<a@example.com>\r\nRCPT TO:<b@example.com>\r\nRCPT TO:<c@example.com>\r\nDATA\r\n"
+	if _, err := c.W.WriteString(batch); err != nil {
+		t.Fatal(err)
+	}
+	c.W.Flush()
+	for _, want := range []int{250, 250, 250, 354} {
+		if code, msg, err := c.ReadResponse(want); err != nil {
+			t.Fatalf("Expected %d, got %d %s", want, code, msg)
+		}
+	}
+	cmd(t, c, 250, "Subject: pipelined\r\n\r\nhello\r\n.")
+
+	m := srv.Messages()[0]
+	if len(m.To) != 2 || string(m.Body) != "hello\r\n" {
+		t.Errorf("Unexpected message: to=%v body=%q", m.To, m.Body)
+	}
+}
+
+func TestServer_LineLength(t *testing.T) {
+	srv := startServer(t, nil)
+	c := dial(t, srv)
+	long := strings.Repeat("x", 5000)
+
+	cmd(t, c, 500, "NOOP %s", long)
+	cmd(t, c, 250, "EHLO client")
+	cmd(t, c, 250, "MAIL FROM:<a@example.com>")
+	cmd(t, c, 250, "RCPT TO:<b@example.com>")
+	cmd(t, c, 354, "DATA")
+	cmd(t, c, 500, "Subject: long\r\n\r\n%s\r\n.", long)
+	if n := len(srv.Messages()); n != 0 {
+		t.Errorf("Expected the message to be rejected, got %d", n)
+	}
+
+	// The session is still usable.
+	cmd(t, c, 250, "MAIL FROM:<a@example.com>")
+	cmd(t, c, 250, "RCPT TO:<b@example.com>")
+	cmd(t, c, 354, "DATA")
+	cmd(t, c, 250, "Subject: short\r\n\r\nhello\r\n.")
+}
+
+func TestServer_ImplicitTLS(t *testing.T) {
+	srv := startServer(t, func(s *mocksmtp.Server) { s.ImplicitTLS = true })
+
+	cfg := srv.ClientTLSConfig()
+	conn, err := tls.Dial("tcp", srv.Addr(), cfg)
+	if err != nil {
+		t.Fatalf("TLS dial failed: %v", err)
+	}
+	c, err := smtp.NewClient(conn, srv.Host())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		t.Error("Expected STARTTLS not to be offered over implicit TLS")
+	}
+	if err := c.Mail("a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rcpt("b@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := c.Data()
+	w.Write([]byte("Subject: hi\r\n\r\nbody\r\n"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m := srv.Messages(); len(m) != 1 || !m[0].TLS {
+		t.Errorf("Expected one message received over TLS, got %+v", m)
+	}
+}
+
+func TestServer_Faults(t *testing.T) {
+	srv := startServer(t, nil)
+
+	t.Run("once", func(t *testing.T) {
+		srv.FailOnce(mocksmtp.StageRcpt, 550)
+		err := sendMail(srv, nil, "rcpt@example.com")
+		var tpErr *textproto.Error
+		if !errors.As(err, &tpErr) || tpErr.Code != 550 {
+			t.Fatalf("Expected 550, got %v", err)
+		}
+		if err := sendMail(srv, nil, "rcpt@example.com"); err != nil {
+			t.Fatalf("Expected the fault to fire once, got %v", err)
 		}
-		defer conn.Close()
+	})
 
-		readResponse(t, conn)
-		sendCommand(t, conn, "DATA\r\n", "354 End data with <CR><LF>.<CR><LF>")
-		sendCommand(t, conn, "Test email body\r\n.\r\n", "250 Message accepted")
+	t.Run("every stage", func(t *testing.T) {
+		for _, stage := range []mocksmtp.Stage{
+			mocksmtp.StageConnect, mocksmtp.StageHelo, mocksmtp.StageStartTLS,
+			mocksmtp.StageMail, mocksmtp.StageRcpt, mocksmtp.StageData, mocksmtp.StageMessage,
+		} {
+			srv.Reset()
+			srv.Fail(stage, 451)
+			if err := sendMail(srv, nil, "rcpt@example.com"); err == nil || !strings.Contains(err.Error(), "451") {
+				t.Errorf("%s: expected 451, got %v", stage, err)
+			}
+			if n := len(srv.Messages()); n != 0 {
+				t.Errorf("%s: expected no message, got %d", stage, n)
+			}
+		}
+		srv.Reset()
 	})
 
-	t.Run("QUIT Command", func(t *testing.T) {
-		conn, err := net.Dial("tcp", ":"+port)
-		if err != nil {
-			t.Fatalf("Failed to connect to mock SMTP server: %v", err)
+	t.Run("421 closes the connection", func(t *testing.T) {
+		srv.FailOnce(mocksmtp.StageMail, 421)
+		c := dial(t, srv)
+		cmd(t, c, 250, "EHLO client")
+		cmd(t, c, 421, "MAIL FROM:<a@example.com>")
+		if _, err := c.ReadLine(); err == nil {
+			t.Error("Expected the server to close the connection")
 		}
-		defer conn.Close()
+	})
 
-		readResponse(t, conn)
-		sendCommand(t, conn, "QUIT\r\n", "221 Bye")
+	t.Run("delay", func(t *testing.T) {
+		srv.Inject(mocksmtp.Fault{Stage: mocksmtp.StageNoop, Delay: 200 * time.Millisecond, Times: 1})
+		c := dial(t, srv)
+		start := time.Now()
+		cmd(t, c, 250, "NOOP")
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Errorf("Expected a delayed reply, got one after %v", elapsed)
+		}
 	})
 }
 
-func sendCommand(t *testing.T, conn net.Conn, cmd string, expectedResponse string) {
-	_, err := conn.Write([]byte(cmd))
+func TestServer_Counters(t *testing.T) {
+	srv := startServer(t, nil)
+	c := dial(t, srv)
+	cmd(t, c, 250, "EHLO client")
+	cmd(t, c, 501, "MAIL TO:<a@example.com>")
+	cmd(t, c, 250, "NOOP")
+	cmd(t, c, 250, "RSET")
+	cmd(t, c, 250, "RSET")
+	cmd(t, c, 500, "BOGUS")
+
+	if srv.Connections() != 1 || srv.CommandCount("RSET") != 2 || srv.CommandCount("NOOP") != 1 {
+		t.Errorf("Unexpected counters: connections=%d RSET=%d NOOP=%d",
+			srv.Connections(), srv.CommandCount("RSET"), srv.CommandCount("NOOP"))
+	}
+
+	srv.CloseConnections()
+	if _, err := c.ReadLine(); err == nil {
+		t.Error("Expected CloseConnections to drop the session")
+	}
+}
+
+func TestServer_Lifecycle(t *testing.T) {
+	srv := mocksmtp.NewServer()
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Binding an address in use is an error, not a process exit.
+	busy := mocksmtp.NewServer()
+	busy.ListenAddr = srv.Addr()
+	if err := busy.Start(); err == nil {
+		busy.Close()
+		t.Fatal("Expected Start to fail on a port in use")
+	}
+
+	// Shutdown waits for a client that is mid-session to QUIT.
+	c, err := smtp.Dial(srv.Addr())
 	if err != nil {
-		t.Fatalf("Failed to send command %q: %v", cmd, err)
+		t.Fatal(err)
+	}
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := srv.Wait(context.Background(), 1)
+		waiting <- err
+	}()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		c.Quit()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := <-waiting; !errors.Is(err, mocksmtp.ErrServerClosed) {
+		t.Errorf("Expected Wait to return ErrServerClosed, got %v", err)
+	}
+	if _, err := net.DialTimeout("tcp", srv.Addr(), time.Second); err == nil {
+		t.Error("Expected the listener to be closed")
 	}
 
-	response := readResponse(t, conn)
-	if !strings.Contains(response, expectedResponse) {
-		t.Errorf("Expected response to contain %q, got %q", expectedResponse, response)
+	// Shutdown gives up on sessions that outlive the context.
+	srv = startServer(t, nil)
+	idle := dial(t, srv)
+	_ = idle
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
 	}
 }
 
-func readResponse(t *testing.T, conn net.Conn) string {
-	reader := bufio.NewReader(conn)
-	response, err := reader.ReadString('\n')
+func TestStartMockSMTPServer(t *testing.T) {
+	srv, err := mocksmtp.StartMockSMTPServer("0")
 	if err != nil {
-		t.Fatalf("Failed to read response: %v", err)
+		t.Fatalf("StartMockSMTPServer failed: %v", err)
+	}
+	defer srv.Close()
+
+	c, err := textproto.Dial("tcp", "127.0.0.1:"+srv.Port())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	if _, _, err := c.ReadResponse(220); err != nil {
+		t.Fatalf("Unexpected greeting: %v", err)
 	}
-	fmt.Println("Server Response:", strings.TrimSpace(response))
-	return strings.TrimSpace(response)
 }
@@ -0,0 +1,430 @@
+// Package mocksmtp provides an in-process SMTP server for tests. It speaks
+// ESMTP (EHLO, PIPELINING, SIZE, STARTTLS, implicit TLS and AUTH
+// PLAIN/LOGIN/CRAM-MD5), captures every accepted message for assertions, and
+// can inject delays and 4xx/5xx replies at any stage of a session.
+package mocksmtp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+)
+
+// ErrServerClosed is returned by Wait and Next once the server has been closed.
+var ErrServerClosed = errors.New("mocksmtp: server closed")
+
+// Defaults for Server.
+const (
+	DefaultHostname    = "mock-smtp-server"
+	DefaultReadTimeout = time.Minute
+)
+
+// Server is a mock SMTP server. Configure its fields, then call Start. All
+// methods are safe for concurrent use once started.
+type Server struct {
+	// ListenAddr is the address to listen on. Defaults to "127.0.0.1:0", a
+	// random free port; see Addr for the address actually bound.
+	ListenAddr string
+
+	// Hostname is announced in the greeting and EHLO reply (default DefaultHostname).
+	Hostname string
+
+	// TLSConfig is used for STARTTLS and implicit TLS. If nil, Start creates
+	// a self-signed certificate for localhost and 127.0.0.1; clients can trust
+	// it through ClientTLSConfig.
+	TLSConfig *tls.Config
+
+	// ImplicitTLS makes every connection speak TLS from the first byte (SMTPS).
+	ImplicitTLS bool
+
+	// DisableSTARTTLS hides the STARTTLS extension.
+	DisableSTARTTLS bool
+
+	// RequireTLS rejects AUTH and MAIL with 530 until STARTTLS has completed.
+	RequireTLS bool
+
+	// Credentials maps usernames to passwords. AUTH is only advertised when it
+	// is non-empty.
+	Credentials map[string]string
+
+	// AuthMechanisms are advertised when Credentials is set (default PLAIN,
+	// LOGIN and CRAM-MD5).
+	AuthMechanisms []string
+
+	// RequireAuth rejects MAIL with 530 until the client has authenticated.
+	RequireAuth bool
+
+	// MaxMessageSize is advertised with SIZE; larger messages are rejected
+	// with 552. Zero means no limit.
+	MaxMessageSize int
+
+	// ReadTimeout closes sessions that send nothing for this long (default
+	// DefaultReadTimeout).
+	ReadTimeout time.Duration
+
+	// Clock stamps Message.ReceivedAt. Defaults to clock.Real.
+	Clock clock.Clock
+
+	// HTTPListenAddr, if set, makes Start also serve Handler on this address
+	// (e.g. "127.0.0.1:8025"); see HTTPURL.
+	HTTPListenAddr string
+
+	ln         net.Listener
+	httpLn     net.Listener
+	httpSrv    *http.Server
+	tlsConfig  *tls.Config
+	clientTLS  *tls.Config
+	done       chan struct{}
+	closeOnce  sync.Once
+	acceptDone chan struct{}
+	wg         sync.WaitGroup
+
+	mu          sync.Mutex
+	conns       map[net.Conn]struct{}
+	connections int
+	commands    map[string]int
+	messages    []*Message
+	nextID      int
+	faults      []*Fault
+	changed     chan struct{}
+}
+
+// NewServer returns a server with default settings. Call Start to run it.
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Start binds the listener and begins serving in the background.
+func (s *Server) Start() error {
+	addr := s.ListenAddr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+
+	s.tlsConfig = s.TLSConfig
+	if s.tlsConfig == nil {
+		cert, pool, err := selfSignedCert()
+		if err != nil {
+			return err
+		}
+		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		s.clientTLS = &tls.Config{RootCAs: pool}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.ImplicitTLS {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	if s.HTTPListenAddr != "" {
+		s.httpLn, err = net.Listen("tcp", s.HTTPListenAddr)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		s.httpSrv = &http.Server{Handler: s.Handler()}
+	}
+
+	s.ln = ln
+	s.done = make(chan struct{})
+	s.acceptDone = make(chan struct{})
+	s.conns = make(map[net.Conn]struct{})
+	s.commands = make(map[string]int)
+	s.changed = make(chan struct{})
+
+	go s.accept()
+	if s.httpSrv != nil {
+		go s.httpSrv.Serve(s.httpLn)
+	}
+	return nil
+}
+
+// Addr returns the address the server is listening on, e.g. "127.0.0.1:54321".
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Host returns the host part of Addr.
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr())
+	return host
+}
+
+// Port returns the port part of Addr.
+func (s *Server) Port() string {
+	_, port, _ := net.SplitHostPort(s.Addr())
+	return port
+}
+
+// HTTPURL returns the base URL of the HTTP interface, e.g.
+// "http://127.0.0.1:8025", or "" if HTTPListenAddr is not set.
+func (s *Server) HTTPURL() string {
+	if s.httpLn == nil {
+		return ""
+	}
+	return "http://" + s.httpLn.Addr().String()
+}
+
+// ClientTLSConfig returns a client configuration that trusts the server's
+// generated certificate, or nil if TLSConfig was supplied.
+func (s *Server) ClientTLSConfig() *tls.Config {
+	if s.clientTLS == nil {
+		return nil
+	}
+	return s.clientTLS.Clone()
+}
+
+// Close stops the server immediately, dropping open sessions, and waits for
+// all goroutines to exit.
+func (s *Server) Close() error {
+	err := s.stop()
+	s.CloseConnections()
+	s.wg.Wait()
+	return err
+}
+
+// Shutdown stops accepting connections and waits for open sessions to end
+// (clients sending QUIT) until ctx is done, then drops whatever remains.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.stop()
+
+	idle := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return err
+	case <-ctx.Done():
+		s.CloseConnections()
+		<-idle
+		return ctx.Err()
+	}
+}
+
+// stop closes the listeners and signals sessions and long-polls that the
+// server is going away.
+func (s *Server) stop() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.ln.Close()
+		<-s.acceptDone
+		if s.httpSrv != nil {
+			err = errors.Join(err, s.httpSrv.Close())
+		}
+	})
+	return err
+}
+
+// CloseConnections drops every open session without a reply, as a server
+// timing out idle clients would. The server keeps accepting new connections.
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Messages returns the messages accepted so far, oldest first.
+func (s *Server) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Message(nil), s.messages...)
+}
+
+// Message returns the accepted message with the given ID.
+func (s *Server) Message(id int) (*Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.messages {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// Wait blocks until at least n messages have been accepted and returns them.
+func (s *Server) Wait(ctx context.Context, n int) ([]*Message, error) {
+	var msgs []*Message
+	err := s.waitUntil(ctx, func(all []*Message) bool {
+		if len(all) < n {
+			return false
+		}
+		msgs = append([]*Message(nil), all...)
+		return true
+	})
+	return msgs, err
+}
+
+// Next blocks until a message with an ID greater than after arrives for
+// recipient (any recipient if empty) and returns it. Recipients are compared
+// case-insensitively against the envelope.
+func (s *Server) Next(ctx context.Context, recipient string, after int) (*Message, error) {
+	var found *Message
+	err := s.waitUntil(ctx, func(all []*Message) bool {
+		for _, m := range all {
+			if m.ID > after && m.SentTo(recipient) {
+				found = m
+				return true
+			}
+		}
+		return false
+	})
+	return found, err
+}
+
+// LastID returns the ID of the most recently accepted message, or 0.
+func (s *Server) LastID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextID
+}
+
+// waitUntil calls done with the current messages, under the server's lock,
+// each time a message arrives until it returns true.
+func (s *Server) waitUntil(ctx context.Context, done func([]*Message) bool) error {
+	for {
+		s.mu.Lock()
+		if done(s.messages) {
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-s.done:
+			return ErrServerClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// DeleteMessages discards captured messages. IDs keep increasing.
+func (s *Server) DeleteMessages() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+}
+
+// Reset discards captured messages, faults and counters.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+	s.faults = nil
+	s.connections = 0
+	s.commands = make(map[string]int)
+}
+
+// Connections reports how many connections have been accepted.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// CommandCount reports how many times verb (e.g. "RSET") has been received.
+func (s *Server) CommandCount(verb string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands[verb]
+}
+
+func (s *Server) accept() {
+	defer close(s.acceptDone)
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.connections++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				conn.Close()
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+			}()
+			newSession(s, conn).serve()
+		}()
+	}
+}
+
+// store records an accepted message and wakes waiters.
+func (s *Server) store(m *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	m.ID = s.nextID
+	s.messages = append(s.messages, m)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) countCommand(verb string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands[verb]++
+}
+
+func (s *Server) hostname() string {
+	if s.Hostname == "" {
+		return DefaultHostname
+	}
+	return s.Hostname
+}
+
+func (s *Server) readTimeout() time.Duration {
+	if s.ReadTimeout == 0 {
+		return DefaultReadTimeout
+	}
+	return s.ReadTimeout
+}
+
+func (s *Server) authMechanisms() []string {
+	if len(s.Credentials) == 0 {
+		return nil
+	}
+	if len(s.AuthMechanisms) == 0 {
+		return []string{"PLAIN", "LOGIN", "CRAM-MD5"}
+	}
+	return s.AuthMechanisms
+}
+
+// certPool returns a pool trusting the leaf of cert.
+func certPool(cert tls.Certificate) (*x509.CertPool, error) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return pool, nil
+}
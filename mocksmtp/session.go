@@ -0,0 +1,515 @@
+package mocksmtp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+)
+
+// maxLineLength bounds command and data lines, terminator included (RFC 5321,
+// section 4.5.3.1.6, allows 1000; some slack is given for sloppy clients).
+// Longer lines are rejected.
+const maxLineLength = 4096
+
+// errLineTooLong is returned by readLine for a line longer than maxLineLength.
+// The rest of the line has been discarded, so the session can continue.
+var errLineTooLong = errors.New("line too long")
+
+// session is the state of one SMTP connection.
+type session struct {
+	srv  *Server
+	conn net.Conn
+	r    *bufio.Reader
+
+	tls      bool
+	helo     string
+	authUser string
+	authMech string
+
+	// Current transaction.
+	from   string
+	to     []string
+	inMail bool
+}
+
+func newSession(srv *Server, conn net.Conn) *session {
+	_, isTLS := conn.(*tls.Conn)
+	return &session{srv: srv, conn: conn, r: bufio.NewReaderSize(conn, maxLineLength), tls: isTLS}
+}
+
+// serve runs the command loop until QUIT, a read error or a closing fault.
+func (s *session) serve() {
+	if s.srv.ImplicitTLS {
+		tlsConn := s.conn.(*tls.Conn)
+		_ = tlsConn.SetDeadline(time.Now().Add(s.srv.readTimeout()))
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+	}
+
+	if handled, alive := s.inject(StageConnect); handled || !alive {
+		// A failed greeting ends the session (RFC 5321, section 3.1).
+		return
+	}
+	s.reply(220, s.srv.hostname()+" ESMTP mocksmtp ready")
+
+	for {
+		line, err := s.readLine()
+		if errors.Is(err, errLineTooLong) {
+			s.reply(500, "5.5.2 Line too long")
+			continue
+		}
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		arg = strings.TrimSpace(arg)
+		s.srv.countCommand(verb)
+
+		if !s.handle(verb, arg) {
+			return
+		}
+	}
+}
+
+// handle processes one command and reports whether the session continues.
+func (s *session) handle(verb, arg string) bool {
+	switch verb {
+	case "EHLO", "HELO":
+		return s.hello(verb, arg)
+	case "STARTTLS":
+		return s.startTLS()
+	case "AUTH":
+		return s.auth(arg)
+	case "MAIL":
+		return s.mail(arg)
+	case "RCPT":
+		return s.rcpt(arg)
+	case "DATA":
+		return s.data()
+	case "RSET":
+		if handled, ok := s.inject(StageRset); handled {
+			return ok
+		}
+		s.resetTransaction()
+		s.reply(250, "2.0.0 OK")
+	case "NOOP":
+		if handled, ok := s.inject(StageNoop); handled {
+			return ok
+		}
+		s.reply(250, "2.0.0 OK")
+	case "VRFY":
+		s.reply(252, "2.1.5 Cannot verify user")
+	case "QUIT":
+		if handled, _ := s.inject(StageQuit); !handled {
+			s.reply(221, "2.0.0 Bye")
+		}
+		return false
+	case "HELP", "EXPN", "TURN", "ETRN":
+		s.reply(502, "5.5.1 Command not implemented")
+	default:
+		s.reply(500, "5.5.2 Unrecognized command")
+	}
+	return true
+}
+
+func (s *session) hello(verb, arg string) bool {
+	if arg == "" {
+		s.reply(501, "5.5.4 Syntax: "+verb+" hostname")
+		return true
+	}
+	if handled, ok := s.inject(StageHelo); handled {
+		return ok
+	}
+	s.helo = arg
+	s.resetTransaction()
+
+	if verb == "HELO" {
+		s.reply(250, s.srv.hostname())
+		return true
+	}
+
+	lines := []string{s.srv.hostname() + " greets " + arg, "PIPELINING", "8BITMIME"}
+	if s.srv.MaxMessageSize > 0 {
+		lines = append(lines, "SIZE "+strconv.Itoa(s.srv.MaxMessageSize))
+	} else {
+		lines = append(lines, "SIZE")
+	}
+	if !s.tls && !s.srv.DisableSTARTTLS {
+		lines = append(lines, "STARTTLS")
+	}
+	if mechs := s.srv.authMechanisms(); len(mechs) > 0 {
+		lines = append(lines, "AUTH "+strings.Join(mechs, " "))
+	}
+	s.replyLines(250, lines)
+	return true
+}
+
+func (s *session) startTLS() bool {
+	if s.tls || s.srv.DisableSTARTTLS {
+		s.reply(502, "5.5.1 STARTTLS not available")
+		return true
+	}
+	if handled, ok := s.inject(StageStartTLS); handled {
+		return ok
+	}
+	s.reply(220, "2.0.0 Ready to start TLS")
+
+	tlsConn := tls.Server(s.conn, s.srv.tlsConfig)
+	_ = tlsConn.SetDeadline(time.Now().Add(s.srv.readTimeout()))
+	if err := tlsConn.Handshake(); err != nil {
+		return false
+	}
+	// Discard any pipelined plaintext and forget the pre-TLS state (RFC 3207, section 4.2).
+	s.conn = tlsConn
+	s.r = bufio.NewReaderSize(tlsConn, maxLineLength)
+	s.tls = true
+	s.helo = ""
+	s.authUser, s.authMech = "", ""
+	s.resetTransaction()
+	return true
+}
+
+func (s *session) auth(arg string) bool {
+	mechs := s.srv.authMechanisms()
+	switch {
+	case len(mechs) == 0:
+		s.reply(502, "5.5.1 AUTH not available")
+		return true
+	case s.helo == "":
+		s.reply(503, "5.5.1 Send EHLO first")
+		return true
+	case s.authUser != "":
+		s.reply(503, "5.5.1 Already authenticated")
+		return true
+	case s.inMail:
+		s.reply(503, "5.5.1 AUTH not allowed during a mail transaction")
+		return true
+	case s.srv.RequireTLS && !s.tls:
+		s.reply(530, "5.7.0 Must issue a STARTTLS command first")
+		return true
+	}
+
+	mech, initial, _ := strings.Cut(arg, " ")
+	mech = strings.ToUpper(mech)
+	offered := false
+	for _, m := range mechs {
+		offered = offered || strings.EqualFold(m, mech)
+	}
+	if !offered {
+		s.reply(504, "5.5.4 Unrecognized authentication type")
+		return true
+	}
+
+	user, ok, err := s.authExchange(mech, strings.TrimSpace(initial))
+	if err != nil {
+		return false
+	}
+	if handled, alive := s.inject(StageAuth); handled {
+		return alive
+	}
+	if !ok {
+		s.reply(535, "5.7.8 Authentication credentials invalid")
+		return true
+	}
+	s.authUser, s.authMech = user, mech
+	s.reply(235, "2.7.0 Authentication successful")
+	return true
+}
+
+// authExchange runs the SASL exchange for mech and returns the username and
+// whether the credentials are valid. A non-nil error means the connection failed.
+func (s *session) authExchange(mech, initial string) (string, bool, error) {
+	// challenge sends a 334 challenge and returns the decoded response;
+	// ok is false if the client cancelled or sent invalid base64.
+	challenge := func(c string) (resp string, ok bool, err error) {
+		s.reply(334, base64.StdEncoding.EncodeToString([]byte(c)))
+		line, err := s.readLine()
+		if errors.Is(err, errLineTooLong) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		b, decErr := base64.StdEncoding.DecodeString(line)
+		return string(b), line != "*" && decErr == nil, nil
+	}
+	decode := func(v string) (string, bool) {
+		b, err := base64.StdEncoding.DecodeString(v)
+		return string(b), err == nil
+	}
+	creds := s.srv.Credentials
+
+	switch mech {
+	case "PLAIN":
+		var resp string
+		var ok bool
+		var err error
+		if initial != "" && initial != "=" {
+			resp, ok = decode(initial)
+		} else if resp, ok, err = challenge(""); err != nil {
+			return "", false, err
+		}
+		parts := strings.Split(resp, "\x00")
+		if !ok || len(parts) != 3 {
+			return "", false, nil
+		}
+		pass, known := creds[parts[1]]
+		return parts[1], known && pass == parts[2], nil
+
+	case "LOGIN":
+		var user string
+		var ok bool
+		var err error
+		if initial != "" {
+			user, ok = decode(initial)
+		} else if user, ok, err = challenge("Username:"); err != nil {
+			return "", false, err
+		}
+		if !ok {
+			return "", false, nil
+		}
+		pass, ok, err := challenge("Password:")
+		if err != nil || !ok {
+			return "", false, err
+		}
+		want, known := creds[user]
+		return user, known && want == pass, nil
+
+	case "CRAM-MD5":
+		nonce := fmt.Sprintf("<%d.%d@%s>", time.Now().UnixNano(), s.srv.Connections(), s.srv.hostname())
+		resp, ok, err := challenge(nonce)
+		if err != nil || !ok {
+			return "", false, err
+		}
+		user, digest, _ := strings.Cut(resp, " ")
+		pass, known := creds[user]
+		mac := hmac.New(md5.New, []byte(pass))
+		mac.Write([]byte(nonce))
+		return user, known && hmac.Equal([]byte(digest), []byte(hex.EncodeToString(mac.Sum(nil)))), nil
+	}
+	return "", false, nil
+}
+
+func (s *session) mail(arg string) bool {
+	switch {
+	case s.helo == "":
+		s.reply(503, "5.5.1 Send EHLO or HELO first")
+		return true
+	case s.inMail:
+		s.reply(503, "5.5.1 Sender already specified")
+		return true
+	case s.srv.RequireTLS && !s.tls:
+		s.reply(530, "5.7.0 Must issue a STARTTLS command first")
+		return true
+	case s.srv.RequireAuth && s.authUser == "":
+		s.reply(530, "5.7.0 Authentication required")
+		return true
+	}
+
+	addr, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		s.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return true
+	}
+	for _, p := range params {
+		k, v, _ := strings.Cut(p, "=")
+		if strings.EqualFold(k, "SIZE") && s.srv.MaxMessageSize > 0 {
+			if n, err := strconv.Atoi(v); err == nil && n > s.srv.MaxMessageSize {
+				s.reply(552, "5.3.4 Message size exceeds fixed limit")
+				return true
+			}
+		}
+	}
+	if handled, alive := s.inject(StageMail); handled {
+		return alive
+	}
+	s.from, s.inMail = addr, true
+	s.reply(250, "2.1.0 OK")
+	return true
+}
+
+func (s *session) rcpt(arg string) bool {
+	if !s.inMail {
+		s.reply(503, "5.5.1 Need MAIL before RCPT")
+		return true
+	}
+	addr, _, ok := parsePath(arg, "TO:")
+	if !ok || addr == "" {
+		s.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return true
+	}
+	if handled, alive := s.inject(StageRcpt); handled {
+		return alive
+	}
+	s.to = append(s.to, addr)
+	s.reply(250, "2.1.5 OK")
+	return true
+}
+
+func (s *session) data() bool {
+	if !s.inMail {
+		s.reply(503, "5.5.1 Need MAIL command")
+		return true
+	}
+	if len(s.to) == 0 {
+		s.reply(554, "5.5.1 No valid recipients")
+		return true
+	}
+	if handled, alive := s.inject(StageData); handled {
+		return alive
+	}
+	s.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	var buf bytes.Buffer
+	tooBig, tooLong := false, false
+	for {
+		line, err := s.readLine()
+		if errors.Is(err, errLineTooLong) {
+			tooLong = true
+			continue
+		}
+		if err != nil {
+			return false
+		}
+		if line == "." {
+			break
+		}
+		line = strings.TrimPrefix(line, ".")
+		if max := s.srv.MaxMessageSize; max > 0 && buf.Len()+len(line)+2 > max {
+			tooBig = true
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+
+	if tooBig {
+		s.resetTransaction()
+		s.reply(552, "5.3.4 Message size exceeds fixed limit")
+		return true
+	}
+	if tooLong {
+		s.resetTransaction()
+		s.reply(500, "5.5.2 Line too long")
+		return true
+	}
+	if handled, alive := s.inject(StageMessage); handled {
+		s.resetTransaction()
+		return alive
+	}
+
+	s.srv.store(newMessage(envelope{
+		From:          s.from,
+		To:            s.to,
+		Helo:          s.helo,
+		TLS:           s.tls,
+		AuthUser:      s.authUser,
+		AuthMechanism: s.authMech,
+		RemoteAddr:    s.conn.RemoteAddr().String(),
+		ReceivedAt:    clock.Or(s.srv.Clock).Now(),
+	}, buf.Bytes()))
+	s.resetTransaction()
+	s.reply(250, "2.0.0 OK: queued")
+	return true
+}
+
+// inject applies any fault for stage. handled reports whether the fault
+// replaced the normal reply; alive is false if the session must end.
+func (s *session) inject(stage Stage) (handled, alive bool) {
+	f := s.srv.fault(stage)
+	if f == nil {
+		return false, true
+	}
+	if f.Delay > 0 {
+		timer := time.NewTimer(f.Delay)
+		select {
+		case <-timer.C:
+		case <-s.srv.done:
+			timer.Stop()
+			return true, false
+		}
+	}
+	if f.Code == 0 {
+		return false, true
+	}
+	text := f.Text
+	if text == "" {
+		text = defaultText(f.Code)
+	}
+	s.reply(f.Code, text)
+	return true, f.Code != 421
+}
+
+func (s *session) resetTransaction() {
+	s.from, s.to, s.inMail = "", nil, false
+}
+
+// readLine reads one CRLF- or LF-terminated line without its terminator. A
+// line that does not fit in the reader's buffer of maxLineLength bytes is
+// discarded and reported as errLineTooLong.
+func (s *session) readLine() (string, error) {
+	_ = s.conn.SetReadDeadline(time.Now().Add(s.srv.readTimeout()))
+	line, err := s.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = s.r.ReadSlice('\n')
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+func (s *session) reply(code int, text string) {
+	s.replyLines(code, []string{text})
+}
+
+// replyLines writes a (possibly multi-line) reply.
+func (s *session) replyLines(code int, lines []string) {
+	var b strings.Builder
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		fmt.Fprintf(&b, "%d%s%s\r\n", code, sep, line)
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.srv.readTimeout()))
+	_, _ = s.conn.Write([]byte(b.String()))
+}
+
+// parsePath parses "FROM:<addr> PARAM=..." (or "TO:"), returning the address
+// without angle brackets and any ESMTP parameters.
+func parsePath(arg, prefix string) (addr string, params []string, ok bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	fields := strings.Fields(arg[len(prefix):])
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+	path := fields[0]
+	if !strings.HasPrefix(path, "<") || !strings.HasSuffix(path, ">") {
+		return "", nil, false
+	}
+	return path[1 : len(path)-1], fields[1:], true
+}
@@ -2,72 +2,80 @@ package passwordless
 
 import (
 	"context"
-	"crypto/sha256"
-	"crypto/subtle"
-	"encoding/hex"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"net/url"
+
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// Query parameters of the links built by GenerateLoginLink and SendLoginLink.
+const (
+	LinkTokenParam  = "token"  // The token ID
+	LinkSecretParam = "secret" // The link secret, passed to VerifyLoginLink
 )
 
-// GenerateLoginLink generates a one-time login link containing a token and hashed code.
+// linkSecretBytes is the amount of randomness in a link secret.
+const linkSecretBytes = 32
+
+// GenerateLoginLink starts a login like StartLogin and also returns a one-time
+// login link for the same token. The link carries a random secret that is
+// independent of the code, so it reveals nothing about the code, and either
+// one can be used to complete the login. Transports implementing
+// transport.MessageTransport receive both, so one email can contain the code
+// and the link; plain transports receive only the code.
 func (m *Manager) GenerateLoginLink(ctx context.Context, recipient, baseURL string) (string, error) {
-	tokenID, err := m.StartLogin(ctx, recipient)
+	_, link, err := m.startLogin(ctx, recipient, baseURL, false)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate token: %w", err)
 	}
+	return link, nil
+}
 
-	// Retrieve the stored token to get the code hash
-	tok, err := m.Store.Exists(ctx, tokenID)
-	if err != nil {
-		return "", fmt.Errorf("failed to retrieve token: %w", err)
+// SendLoginLink starts a link-only login: no code is generated and the login
+// link itself is delivered to the recipient. Returns the generated token ID.
+func (m *Manager) SendLoginLink(ctx context.Context, recipient, baseURL string) (string, error) {
+	tokenID, _, err := m.startLogin(ctx, recipient, baseURL, true)
+	return tokenID, err
+}
+
+// VerifyLoginLink validates a one-time login link without requiring user input.
+// Wrong secrets count towards MaxFailedAttempts just like wrong codes.
+func (m *Manager) VerifyLoginLink(ctx context.Context, tokenID, secret string) (bool, error) {
+	if _, err := m.verifyLink(ctx, tokenID, secret); err != nil {
+		return false, err
 	}
+	return true, nil
+}
+
+// verifyLink implements VerifyLoginLink and returns the consumed token on success.
+func (m *Manager) verifyLink(ctx context.Context, tokenID, secret string) (*store.Token, error) {
+	return m.verify(ctx, tokenID, func(ctx context.Context) (*store.Token, error) {
+		return m.Store.VerifyLinkAndConsume(ctx, tokenID, secret, m.Config.MaxFailedAttempts)
+	})
+}
 
-	// Generate a secure hash for URL encoding (do not expose raw hash)
-	codeHash := sha256.Sum256(tok.CodeHash)
-	encodedCodeHash := hex.EncodeToString(codeHash[:])
+// generateLinkSecret returns a random, URL-safe link secret.
+func generateLinkSecret() (string, error) {
+	b := make([]byte, linkSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate link secret: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	// Construct the login URL
+// buildLoginLink appends the token ID and link secret to baseURL.
+func buildLoginLink(baseURL, tokenID, secret string) (string, error) {
 	parsedURL, err := url.Parse(baseURL)
 	if err != nil {
 		return "", fmt.Errorf("invalid base URL: %w", err)
 	}
 
 	query := parsedURL.Query()
-	query.Set("token", tokenID)
-	query.Set("hash", encodedCodeHash)
+	query.Set(LinkTokenParam, tokenID)
+	query.Set(LinkSecretParam, secret)
 	parsedURL.RawQuery = query.Encode()
 
 	return parsedURL.String(), nil
 }
-
-// VerifyLoginLink validates a one-time login link without requiring user input.
-func (m *Manager) VerifyLoginLink(ctx context.Context, tokenID, providedHash string) (bool, error) {
-	tok, err := m.Store.Exists(ctx, tokenID)
-	if err != nil {
-		return false, fmt.Errorf("token not found or expired")
-	}
-
-	// Hash the stored code and compare it with the hash from the URL
-	expectedHash := sha256.Sum256(tok.CodeHash)
-	expectedHashHex := make([]byte, hex.EncodedLen(len(expectedHash)))
-	hex.Encode(expectedHashHex, expectedHash[:])
-
-	providedHashBytes := []byte(providedHash)
-
-	if len(providedHashBytes) != len(expectedHashHex) ||
-		subtle.ConstantTimeCompare(expectedHashHex, providedHashBytes) != 1 {
-		tok.Attempts++
-		if tok.Attempts >= m.Config.MaxFailedAttempts {
-			_ = m.Store.Delete(ctx, tokenID)
-			return false, fmt.Errorf("too many failed attempts, token deleted")
-		}
-		if err := m.Store.UpdateAttempts(ctx, tokenID, tok.Attempts); err != nil {
-			return false, fmt.Errorf("failed to persist attempt count: %w", err)
-		}
-		return false, fmt.Errorf("invalid login link")
-	}
-
-	// If verification succeeds, delete token (one-time use)
-	_ = m.Store.Delete(ctx, tokenID)
-	return true, nil
-}
@@ -2,7 +2,11 @@ package passwordless_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -43,11 +47,11 @@ func TestLoginLinkFlow(t *testing.T) {
 		}
 
 		queryParams := parsedURL.Query()
-		token := queryParams.Get("token")
-		hash := queryParams.Get("hash")
+		token := queryParams.Get(passwordless.LinkTokenParam)
+		hash := queryParams.Get(passwordless.LinkSecretParam)
 
 		if token == "" || hash == "" {
-			t.Fatal("Missing token or hash in login link")
+			t.Fatal("Missing token or secret in login link")
 		}
 
 		t.Run("FailIncorrectHash", func(t *testing.T) {
@@ -79,8 +83,8 @@ func TestLoginLinkFlow(t *testing.T) {
 
 		parsedURL, _ := url.Parse(loginLink)
 		queryParams := parsedURL.Query()
-		token := queryParams.Get("token")
-		hash := queryParams.Get("hash")
+		token := queryParams.Get(passwordless.LinkTokenParam)
+		hash := queryParams.Get(passwordless.LinkSecretParam)
 
 		tok, _ := memStore.Exists(ctx, token)
 		tok.ExpiresAt = time.Now().Add(-1 * time.Minute) // Expire the token
@@ -95,3 +99,79 @@ func TestLoginLinkFlow(t *testing.T) {
 		}
 	})
 }
+
+func TestLoginLinkSecret(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("IndependentOfCode", func(t *testing.T) {
+		tt := &TestTransport{}
+		memStore := store.NewMemStore()
+		mgr := passwordless.NewManager(memStore, tt)
+
+		link, err := mgr.GenerateLoginLink(ctx, "user@example.com", "https://myapp.com/login")
+		if err != nil {
+			t.Fatalf("GenerateLoginLink returned error: %v", err)
+		}
+		u, _ := url.Parse(link)
+		token := u.Query().Get(passwordless.LinkTokenParam)
+		secret := u.Query().Get(passwordless.LinkSecretParam)
+
+		tok, _ := memStore.Exists(ctx, token)
+		codeHash := sha256.Sum256(tok.CodeHash)
+		if strings.Contains(link, hex.EncodeToString(codeHash[:])) || strings.Contains(link, tt.LastCode) {
+			t.Fatalf("Expected link not to contain anything derived from the code, got %s", link)
+		}
+
+		// The code is not accepted as a link secret, and vice versa
+		if ok, _ := mgr.VerifyLoginLink(ctx, token, tt.LastCode); ok {
+			t.Fatal("Expected the code to be rejected as a link secret")
+		}
+		if ok, _ := mgr.VerifyLogin(ctx, token, secret); ok {
+			t.Fatal("Expected the link secret to be rejected as a code")
+		}
+
+		if ok, err := mgr.VerifyLoginLink(ctx, token, secret); !ok || err != nil {
+			t.Fatalf("Expected link to verify, got %v", err)
+		}
+	})
+
+	t.Run("AttemptAccounting", func(t *testing.T) {
+		mgr := passwordless.NewManager(store.NewMemStore(), &TestTransport{})
+
+		link, _ := mgr.GenerateLoginLink(ctx, "user@example.com", "https://myapp.com/login")
+		u, _ := url.Parse(link)
+		token := u.Query().Get(passwordless.LinkTokenParam)
+
+		var invalid *passwordless.InvalidCodeError
+		if _, err := mgr.VerifyLoginLink(ctx, token, "wrong"); !errors.As(err, &invalid) || invalid.AttemptsRemaining != 2 {
+			t.Fatalf("Expected InvalidCodeError with 2 attempts remaining, got %v", err)
+		}
+		_, _ = mgr.VerifyLoginLink(ctx, token, "wrong")
+		if _, err := mgr.VerifyLoginLink(ctx, token, "wrong"); !errors.Is(err, passwordless.ErrTokenLocked) {
+			t.Fatalf("Expected ErrTokenLocked, got %v", err)
+		}
+	})
+
+	t.Run("LinkOnly", func(t *testing.T) {
+		tt := &TestTransport{}
+		mgr := passwordless.NewManager(store.NewMemStore(), tt)
+
+		tokenID, err := mgr.SendLoginLink(ctx, "user@example.com", "https://myapp.com/login")
+		if err != nil {
+			t.Fatalf("SendLoginLink returned error: %v", err)
+		}
+
+		// The transport receives the link instead of a code
+		u, err := url.Parse(tt.LastCode)
+		if err != nil || u.Host != "myapp.com" {
+			t.Fatalf("Expected the link to be delivered, got %q", tt.LastCode)
+		}
+		if u.Query().Get(passwordless.LinkTokenParam) != tokenID {
+			t.Fatalf("Expected delivered link to carry token %s, got %q", tokenID, tt.LastCode)
+		}
+
+		if ok, err := mgr.VerifyLoginLink(ctx, tokenID, u.Query().Get(passwordless.LinkSecretParam)); !ok || err != nil {
+			t.Fatalf("Expected delivered link to verify, got %v", err)
+		}
+	})
+}
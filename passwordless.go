@@ -5,11 +5,14 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
-	"log"
 	"math/big"
+	"sync"
 	"time"
 
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/metrics"
 	"github.com/rlnorthcutt/go-passwordless/store"
+	"github.com/rlnorthcutt/go-passwordless/tracing"
 	"github.com/rlnorthcutt/go-passwordless/transport"
 )
 
@@ -18,6 +21,8 @@ type Manager struct {
 	Transport         transport.Transport
 	Config            Config
 	MaxFailedAttempts int
+
+	hooksMu sync.RWMutex // Guards Config.Hooks against AddHook
 }
 
 // NewManager constructs a Manager using the default config (see config.go).
@@ -47,6 +52,21 @@ func NewManagerWithConfig(s store.TokenStore, t transport.Transport, cfg Config)
 	if cfg.MaxFailedAttempts == 0 {
 		cfg.MaxFailedAttempts = 3
 	}
+	if cfg.ResendInterval == 0 {
+		cfg.ResendInterval = 30 * time.Second
+	}
+	if cfg.MaxResends == 0 {
+		cfg.MaxResends = 3
+	}
+	if cfg.HookTimeout == 0 {
+		cfg.HookTimeout = DefaultHookTimeout
+	}
+	if cfg.JanitorInterval == 0 {
+		cfg.JanitorInterval = DefaultJanitorInterval
+	}
+	if cfg.JanitorBatchSize == 0 {
+		cfg.JanitorBatchSize = DefaultJanitorBatchSize
+	}
 
 	return &Manager{
 		Store:             s,
@@ -59,73 +79,168 @@ func NewManagerWithConfig(s store.TokenStore, t transport.Transport, cfg Config)
 // StartLogin generates a code, stores it, and sends it to the recipient.
 // Returns the generated token ID.
 func (m *Manager) StartLogin(ctx context.Context, recipient string) (string, error) {
-	// Generate code
-	code, err := m.generateCode(m.Config.CodeLength, m.Config.CodeCharset)
-	if err != nil {
-		return "", err
+	tokenID, _, err := m.startLogin(ctx, recipient, "", false)
+	return tokenID, err
+}
+
+// startLogin implements StartLogin, GenerateLoginLink and SendLoginLink. If
+// baseURL is set the token also gets a link secret and the login link is
+// returned and included in the message. If linkOnly is set no code is
+// generated, so the message carries only the link.
+func (m *Manager) startLogin(ctx context.Context, recipient, baseURL string, linkOnly bool) (string, string, error) {
+	// Enforce rate limits before doing any work
+	if err := m.checkStartLimits(ctx, recipient); err != nil {
+		return "", "", err
 	}
 
-	// Hash it
-	hash := sha256.Sum256([]byte(code))
+	// Generate code and hash it. A link-only token gets an empty hash that no
+	// code can match, so it can only be redeemed through its link, and
+	// ResendLoginLink can tell it apart. The hash is non-nil so SQL stores
+	// write an empty value rather than NULL.
+	var code string
+	hash := []byte{}
+	if !linkOnly {
+		var err error
+		code, err = m.generateCode(m.Config.CodeLength, m.Config.CodeCharset)
+		if err != nil {
+			return "", "", err
+		}
+		sum := sha256.Sum256([]byte(code))
+		hash = sum[:]
+	}
 
 	// Generate a token ID
 	tokenID := m.Config.IDGenerator()
 
 	// Build Token
+	now := m.now()
 	tok := store.Token{
-		ID:        tokenID,
-		Recipient: recipient,
-		CodeHash:  hash[:],
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(m.Config.TokenExpiry),
+		ID:         tokenID,
+		Recipient:  recipient,
+		CodeHash:   hash,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(m.Config.TokenExpiry),
+		LastSentAt: now,
+	}
+
+	// Give the token an independent link secret if a link was requested
+	var link string
+	if baseURL != "" {
+		secret, err := generateLinkSecret()
+		if err != nil {
+			return "", "", err
+		}
+		if link, err = buildLoginLink(baseURL, tokenID, secret); err != nil {
+			return "", "", err
+		}
+		linkHash := sha256.Sum256([]byte(secret))
+		tok.LinkHash = linkHash[:]
+	}
+
+	// Invalidate earlier codes so only the newest one can be guessed
+	if m.Config.SingleActiveToken {
+		if _, err := m.RevokeAll(ctx, recipient); err != nil {
+			return "", "", err
+		}
 	}
 
 	// Store the token
-	if err := m.Store.Store(ctx, tok); err != nil {
-		return "", err
+	if err := m.storeToken(ctx, tok); err != nil {
+		return "", "", err
 	}
+	m.emit(ctx, Event{Type: EventLoginStarted, TokenID: tokenID, Recipient: recipient})
 
-	// Send the code to the user
-	if err := m.Transport.Send(ctx, recipient, code); err != nil {
+	// Send the code and/or link to the user
+	if err := m.send(ctx, m.message(ctx, tok, code, link)); err != nil {
 		// If sending fails, remove the token
 		_ = m.Store.Delete(ctx, tokenID)
-		return "", err
+		m.emit(ctx, Event{Type: EventDeliveryFailed, TokenID: tokenID, Recipient: recipient, Err: err})
+		return "", "", &TransportError{Err: err}
 	}
+	m.emit(ctx, Event{Type: EventCodeDelivered, TokenID: tokenID, Recipient: recipient})
 
-	return tokenID, nil
+	return tokenID, link, nil
 }
 
 // VerifyLogin checks the user-provided code against the stored token.
+// The comparison and the resulting consume or attempt update happen in a single
+// store operation, so a code can only be redeemed once even under concurrency.
 func (m *Manager) VerifyLogin(ctx context.Context, tokenID, code string) (bool, error) {
-	tok, err := m.Store.Exists(ctx, tokenID)
-	if err != nil {
+	if _, err := m.verifyCode(ctx, tokenID, code); err != nil {
 		return false, err
 	}
+	return true, nil
+}
+
+// verifyCode implements VerifyLogin and returns the consumed token on success.
+func (m *Manager) verifyCode(ctx context.Context, tokenID, code string) (*store.Token, error) {
+	return m.verify(ctx, tokenID, func(ctx context.Context) (*store.Token, error) {
+		return m.Store.VerifyAndConsume(ctx, tokenID, code, m.Config.MaxFailedAttempts)
+	})
+}
+
+// verify applies the verification rate limit, runs consume inside a span and
+// reports the outcome. consume performs the store's atomic check-and-consume.
+func (m *Manager) verify(ctx context.Context, tokenID string, consume func(context.Context) (*store.Token, error)) (*store.Token, error) {
+	defer m.observeDuration(metrics.VerificationDuration, time.Now())
+
+	if ip, ok := ClientIPFromContext(ctx); ok && m.Config.VerifyLimiter != nil {
+		if err := m.Config.VerifyLimiter.Allow(ctx, "verify:ip:"+ip); err != nil {
+			return nil, err
+		}
+	}
 
-	// Check expiration time
-	if time.Now().After(tok.ExpiresAt) {
-		_ = m.Store.Delete(ctx, tokenID)
-		return false, fmt.Errorf("token expired")
+	ctx, span := m.tracer().Start(ctx, spanVerify)
+	span.SetAttribute(tracing.AttrTokenID, tokenID)
+	tok, err := consume(ctx)
+	span.End(err)
+
+	m.emitVerifyResult(ctx, tokenID, tok, err)
+	if err != nil {
+		return nil, err
 	}
+	return tok, nil
+}
+
+// send delivers msg through the configured transport. Transports that do not
+// implement transport.MessageTransport receive only the code (or the link).
+func (m *Manager) send(ctx context.Context, msg transport.Message) error {
+	defer m.observeDuration(metrics.DeliveryDuration, time.Now())
 
-	// Compare the provided code in constant time
-	if !store.VerifyToken(tok, code) {
-		tok.Attempts++
-		if tok.Attempts >= m.Config.MaxFailedAttempts {
-			_ = m.Store.Delete(ctx, tokenID)
-			return false, fmt.Errorf("too many failed attempts, token deleted")
+	ctx, span := m.tracer().Start(ctx, spanSend)
+	err := transport.Adapt(m.Transport).SendMessage(ctx, msg)
+	span.End(err)
+	return err
+}
+
+// message builds the login message for tok. The locale and metadata are taken
+// from ctx (see WithLocale and WithMessageMetadata).
+func (m *Manager) message(ctx context.Context, tok store.Token, code, link string) transport.Message {
+	locale, _ := LocaleFromContext(ctx)
+	return transport.Message{
+		Recipient: tok.Recipient,
+		Code:      code,
+		Link:      link,
+		ExpiresAt: tok.ExpiresAt,
+		Purpose:   transport.PurposeLogin,
+		Locale:    locale,
+		Metadata:  MessageMetadataFromContext(ctx),
+	}
+}
+
+// checkStartLimits consults the per-recipient and per-IP limiters for StartLogin.
+func (m *Manager) checkStartLimits(ctx context.Context, recipient string) error {
+	if m.Config.RecipientLimiter != nil {
+		if err := m.Config.RecipientLimiter.Allow(ctx, "start:recipient:"+recipient); err != nil {
+			return err
 		}
-		// Store updated attempt count
-		if err := m.Store.UpdateAttempts(ctx, tokenID, tok.Attempts); err != nil {
-			return false, fmt.Errorf("failed to persist attempt count: %w", err)
+	}
+	if ip, ok := ClientIPFromContext(ctx); ok && m.Config.IPLimiter != nil {
+		if err := m.Config.IPLimiter.Allow(ctx, "start:ip:"+ip); err != nil {
+			return err
 		}
-		log.Printf("invalid code, attempts remaining: %d", m.Config.MaxFailedAttempts-tok.Attempts)
-		return false, fmt.Errorf("invalid code")
 	}
-
-	// If verification succeeds, delete token (one-time use)
-	_ = m.Store.Delete(ctx, tokenID)
-	return true, nil
+	return nil
 }
 
 // generateCode produces a random code (numeric or alphanumeric) based on the config.
@@ -166,3 +281,8 @@ func (m *Manager) generateCode(length int, charset string) (string, error) {
 	}
 	return string(out), nil
 }
+
+// now returns the current time according to the configured clock.
+func (m *Manager) now() time.Time {
+	return clock.Or(m.Config.Clock).Now()
+}
@@ -2,10 +2,15 @@ package passwordless_test
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/ratelimit"
 	"github.com/rlnorthcutt/go-passwordless/store"
 	"github.com/rlnorthcutt/go-passwordless/transport"
 )
@@ -20,6 +25,17 @@ func (tt *TestTransport) Send(ctx context.Context, recipient, code string) error
 	return nil
 }
 
+// MessageRecorder is a transport.MessageTransport that keeps the last message
+type MessageRecorder struct {
+	TestTransport
+	LastMessage transport.Message
+}
+
+func (mr *MessageRecorder) SendMessage(ctx context.Context, msg transport.Message) error {
+	mr.LastMessage = msg
+	return nil
+}
+
 func TestPasswordlessFlow(t *testing.T) {
 	ctx := context.Background()
 	memStore := store.NewMemStore()
@@ -113,5 +129,183 @@ func TestPasswordlessFlow(t *testing.T) {
 		if mgr.Config.MaxFailedAttempts != 3 {
 			t.Errorf("Expected MaxFailedAttempts to be 3, got %d", mgr.Config.MaxFailedAttempts)
 		}
+		if mgr.Config.ResendInterval != 30*time.Second {
+			t.Errorf("Expected ResendInterval to be 30s, got %v", mgr.Config.ResendInterval)
+		}
+		if mgr.Config.MaxResends != 3 {
+			t.Errorf("Expected MaxResends to be 3, got %d", mgr.Config.MaxResends)
+		}
+	})
+}
+
+func TestVerifyLoginConcurrent(t *testing.T) {
+	ctx := context.Background()
+	testTransport := &TestTransport{}
+	mgr := passwordless.NewManager(store.NewMemStore(), testTransport)
+
+	tokenID, err := mgr.StartLogin(ctx, "race@example.com")
+	if err != nil {
+		t.Fatalf("StartLogin returned error: %v", err)
+	}
+
+	var successes atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := mgr.VerifyLogin(ctx, tokenID, testTransport.LastCode); ok {
+				successes.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := successes.Load(); n != 1 {
+		t.Fatalf("Expected the code to be redeemed exactly once, got %d", n)
+	}
+}
+
+func TestStartLoginRateLimit(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("PerRecipient", func(t *testing.T) {
+		cfg := passwordless.DefaultConfig()
+		cfg.RecipientLimiter = ratelimit.NewMemLimiter(2, time.Minute)
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), &TestTransport{}, cfg)
+
+		for i := 0; i < 2; i++ {
+			if _, err := mgr.StartLogin(ctx, "limited@example.com"); err != nil {
+				t.Fatalf("Call %d: StartLogin returned error: %v", i+1, err)
+			}
+		}
+
+		_, err := mgr.StartLogin(ctx, "limited@example.com")
+		var rle *passwordless.RateLimitError
+		if !errors.As(err, &rle) || !errors.Is(err, passwordless.ErrRateLimited) {
+			t.Fatalf("Expected RateLimitError, got %v", err)
+		}
+		if rle.RetryAfter <= 0 {
+			t.Errorf("Expected a positive RetryAfter, got %v", rle.RetryAfter)
+		}
+
+		if _, err := mgr.StartLogin(ctx, "other@example.com"); err != nil {
+			t.Fatalf("Expected another recipient to be allowed, got %v", err)
+		}
+	})
+
+	t.Run("PerIP", func(t *testing.T) {
+		cfg := passwordless.DefaultConfig()
+		cfg.IPLimiter = ratelimit.NewMemLimiter(1, time.Minute)
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), &TestTransport{}, cfg)
+
+		ipCtx := passwordless.WithClientIP(ctx, "203.0.113.7")
+		if _, err := mgr.StartLogin(ipCtx, "a@example.com"); err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		if _, err := mgr.StartLogin(ipCtx, "b@example.com"); !errors.Is(err, passwordless.ErrRateLimited) {
+			t.Fatalf("Expected ErrRateLimited for the same IP, got %v", err)
+		}
+		if _, err := mgr.StartLogin(ctx, "c@example.com"); err != nil {
+			t.Fatalf("Expected calls without an IP to be allowed, got %v", err)
+		}
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		cfg := passwordless.DefaultConfig()
+		cfg.VerifyLimiter = ratelimit.NewMemLimiter(1, time.Minute)
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), &TestTransport{}, cfg)
+
+		ipCtx := passwordless.WithClientIP(ctx, "203.0.113.8")
+		if _, err := mgr.VerifyLogin(ipCtx, "missing", "123456"); !errors.Is(err, passwordless.ErrTokenNotFound) {
+			t.Fatalf("Expected ErrTokenNotFound, got %v", err)
+		}
+		if _, err := mgr.VerifyLogin(ipCtx, "missing", "123456"); !errors.Is(err, passwordless.ErrRateLimited) {
+			t.Fatalf("Expected ErrRateLimited, got %v", err)
+		}
+	})
+}
+
+func TestTokenExpiryWithClock(t *testing.T) {
+	ctx := context.Background()
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	memStore := store.NewMemStore()
+	memStore.Clock = clk
+	tt := &TestTransport{}
+	cfg := passwordless.DefaultConfig()
+	cfg.Clock = clk
+	mgr := passwordless.NewManagerWithConfig(memStore, tt, cfg)
+
+	t.Run("ValidUntilTokenExpiry", func(t *testing.T) {
+		tokenID, err := mgr.StartLogin(ctx, "clock@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		tok, _ := memStore.Exists(ctx, tokenID)
+		if want := clk.Now().Add(cfg.TokenExpiry); !tok.ExpiresAt.Equal(want) {
+			t.Errorf("Expected ExpiresAt %v, got %v", want, tok.ExpiresAt)
+		}
+
+		clk.Advance(cfg.TokenExpiry - time.Second)
+		if ok, err := mgr.VerifyLogin(ctx, tokenID, tt.LastCode); err != nil || !ok {
+			t.Fatalf("Expected code to verify just before expiry, got ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("ExpiredAfterTokenExpiry", func(t *testing.T) {
+		tokenID, err := mgr.StartLogin(ctx, "clock@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+
+		clk.Advance(cfg.TokenExpiry + time.Second)
+		if _, err := mgr.VerifyLogin(ctx, tokenID, tt.LastCode); !errors.Is(err, passwordless.ErrTokenExpired) {
+			t.Fatalf("Expected ErrTokenExpired, got %v", err)
+		}
+	})
+}
+
+func TestLoginMessage(t *testing.T) {
+	ctx := passwordless.WithLocale(context.Background(), "de-CH")
+	ctx = passwordless.WithMessageMetadata(ctx, map[string]string{"name": "Ada"})
+
+	clk := clocktest.NewFake(time.Now())
+	cfg := passwordless.DefaultConfig()
+	cfg.Clock = clk
+	mr := &MessageRecorder{}
+	mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), mr, cfg)
+
+	t.Run("StartLogin", func(t *testing.T) {
+		tokenID, err := mgr.StartLogin(ctx, "message@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		msg := mr.LastMessage
+		if msg.Recipient != "message@example.com" || msg.Code == "" || msg.Link != "" {
+			t.Errorf("Expected a code-only message, got %+v", msg)
+		}
+		if !msg.ExpiresAt.Equal(clk.Now().Add(cfg.TokenExpiry)) || msg.Purpose != transport.PurposeLogin {
+			t.Errorf("Expected expiry and purpose to be set, got %+v", msg)
+		}
+		if msg.Locale != "de-CH" || msg.Metadata["name"] != "Ada" {
+			t.Errorf("Expected locale and metadata from the context, got %+v", msg)
+		}
+		if mr.LastCode != "" {
+			t.Errorf("Expected SendMessage to be preferred over Send")
+		}
+		if ok, err := mgr.VerifyLogin(ctx, tokenID, msg.Code); !ok || err != nil {
+			t.Errorf("Expected delivered code to verify, got %v", err)
+		}
+	})
+
+	t.Run("GenerateLoginLink", func(t *testing.T) {
+		link, err := mgr.GenerateLoginLink(ctx, "message@example.com", "https://myapp.com/login")
+		if err != nil {
+			t.Fatalf("GenerateLoginLink returned error: %v", err)
+		}
+		if mr.LastMessage.Code == "" || mr.LastMessage.Link != link {
+			t.Errorf("Expected one message with both the code and the link, got %+v", mr.LastMessage)
+		}
 	})
 }
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// DbLimiter is a SQL-backed sliding-window limiter. Every allowed event is
+// recorded as a row, and a key is limited once Limit rows fall within the last
+// Window. Because the state lives in the database it is shared by every
+// application instance, like DbStore. See db_limiter_sample.sql for the schema.
+type DbLimiter struct {
+	DB        *sql.DB       // Reference to the database connection
+	TableName string        // Name of the table used to record events; must be a plain identifier
+	Dialect   store.Dialect // Placeholder syntax of DB; defaults to store.DialectSQLite
+	Limit     int           // Maximum events per key within Window
+	Window    time.Duration // Length of the sliding window
+	Clock     clock.Clock   // Optional; defaults to the real clock
+}
+
+// NewDbLimiter initializes a DbLimiter allowing limit events per window for each key.
+func NewDbLimiter(db *sql.DB, tableName string, limit int, window time.Duration) *DbLimiter {
+	return &DbLimiter{
+		DB:        db,
+		TableName: tableName,
+		Limit:     limit,
+		Window:    window,
+	}
+}
+
+// maxAllowAttempts bounds how often Allow runs its transaction when the
+// database aborts it because of a concurrent call for the same key.
+const maxAllowAttempts = 3
+
+// Allow records an event for key unless Limit events already fall within the
+// window. It returns ErrInvalidLimit if Limit or Window is not positive.
+//
+// The count and the insert run in one serializable transaction, so two
+// concurrent calls cannot both take the last slot: SQLite serializes writers,
+// PostgreSQL aborts one of the transactions and MySQL makes the count a
+// locking read. An aborted transaction is retried.
+func (l *DbLimiter) Allow(ctx context.Context, key string) error {
+	if err := store.ValidateTableName(l.TableName); err != nil {
+		return err
+	}
+	if l.Limit <= 0 || l.Window <= 0 {
+		return fmt.Errorf("%w: %d per %s", ErrInvalidLimit, l.Limit, l.Window)
+	}
+
+	var err error
+	for i := 0; i < maxAllowAttempts; i++ {
+		err = l.allow(ctx, key)
+		var rle *RateLimitError
+		if err == nil || errors.As(err, &rle) || ctx.Err() != nil {
+			break
+		}
+	}
+	return err
+}
+
+// allow runs one attempt of Allow.
+func (l *DbLimiter) allow(ctx context.Context, key string) error {
+	now := clock.Or(l.Clock).Now()
+	windowStart := now.Add(-l.Window)
+
+	tx, err := l.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return fmt.Errorf("failed to begin rate limit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Drop events that have slid out of the window for this key.
+	query := l.query(`DELETE FROM %s WHERE limit_key = ? AND occurred_at <= ?`)
+	if _, err := tx.ExecContext(ctx, query, key, windowStart.UnixNano()); err != nil {
+		return fmt.Errorf("failed to prune rate limit events: %w", err)
+	}
+
+	var count int
+	var oldest sql.NullInt64
+	query = l.query(`SELECT COUNT(*), MIN(occurred_at) FROM %s WHERE limit_key = ?`)
+	if err := tx.QueryRowContext(ctx, query, key).Scan(&count, &oldest); err != nil {
+		return fmt.Errorf("failed to count rate limit events: %w", err)
+	}
+
+	if count >= l.Limit {
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit rate limit transaction: %w", err)
+		}
+		// The next slot opens when the oldest event leaves the window. Clock
+		// skew between instances must not make it negative.
+		retryAfter := time.Unix(0, oldest.Int64).Add(l.Window).Sub(now)
+		return &RateLimitError{RetryAfter: max(retryAfter, 0)}
+	}
+
+	query = l.query(`INSERT INTO %s (limit_key, occurred_at) VALUES (?, ?)`)
+	if _, err := tx.ExecContext(ctx, query, key, now.UnixNano()); err != nil {
+		return fmt.Errorf("failed to record rate limit event: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rate limit transaction: %w", err)
+	}
+	return nil
+}
+
+// query formats a statement written with %s for the table name and ?
+// placeholders for the limiter's table and dialect.
+func (l *DbLimiter) query(format string) string {
+	return l.Dialect.Rebind(fmt.Sprintf(format, l.TableName))
+}
@@ -0,0 +1,8 @@
+-- Schema for DbLimiter on SQLite and PostgreSQL. On MySQL, drop IF NOT EXISTS
+-- from the CREATE INDEX statement. occurred_at holds Unix nanoseconds.
+CREATE TABLE IF NOT EXISTS passwordless_rate_limits (
+	limit_key VARCHAR(255) NOT NULL,
+	occurred_at BIGINT NOT NULL
+  );
+
+CREATE INDEX IF NOT EXISTS passwordless_rate_limits_key ON passwordless_rate_limits (limit_key, occurred_at);
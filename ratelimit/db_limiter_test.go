@@ -0,0 +1,134 @@
+package ratelimit_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/ratelimit"
+	"github.com/rlnorthcutt/go-passwordless/store"
+	_ "modernc.org/sqlite"
+)
+
+func TestDbLimiter(t *testing.T) {
+	ctx := context.Background()
+	dbFile := "test_rate_limits.db"
+
+	// Cleanup before and after the test
+	os.Remove(dbFile)
+	defer os.Remove(dbFile)
+
+	db, err := sql.Open("sqlite", dbFile)
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+
+	sqlScript, err := os.ReadFile("db_limiter_sample.sql")
+	if err != nil {
+		t.Fatalf("Failed to read SQL file: %v", err)
+	}
+	if _, err := db.Exec(string(sqlScript)); err != nil {
+		t.Fatalf("Failed to create rate limit table: %v", err)
+	}
+
+	clk := clocktest.NewFake(time.Now())
+	limiter := ratelimit.NewDbLimiter(db, "passwordless_rate_limits", 2, time.Minute)
+	limiter.Clock = clk
+
+	t.Run("LimitsWithinWindow", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if err := limiter.Allow(ctx, "start:recipient:db@example.com"); err != nil {
+				t.Fatalf("Call %d: expected to be allowed, got %v", i+1, err)
+			}
+		}
+
+		err := limiter.Allow(ctx, "start:recipient:db@example.com")
+		var rle *ratelimit.RateLimitError
+		if !errors.As(err, &rle) || !errors.Is(err, ratelimit.ErrRateLimited) {
+			t.Fatalf("Expected RateLimitError, got %v", err)
+		}
+		if rle.RetryAfter != time.Minute {
+			t.Errorf("Expected RetryAfter of 1m, got %v", rle.RetryAfter)
+		}
+
+		if err := limiter.Allow(ctx, "start:recipient:other@example.com"); err != nil {
+			t.Fatalf("Expected a different key to be allowed, got %v", err)
+		}
+	})
+
+	t.Run("WindowSlides", func(t *testing.T) {
+		clk.Advance(time.Minute + time.Second)
+		if err := limiter.Allow(ctx, "start:recipient:db@example.com"); err != nil {
+			t.Fatalf("Expected events to have left the window, got %v", err)
+		}
+	})
+
+	t.Run("InvalidLimit", func(t *testing.T) {
+		for _, bad := range []*ratelimit.DbLimiter{
+			ratelimit.NewDbLimiter(db, "passwordless_rate_limits", 0, time.Minute),
+			ratelimit.NewDbLimiter(db, "passwordless_rate_limits", 2, 0),
+		} {
+			if err := bad.Allow(ctx, "key"); !errors.Is(err, ratelimit.ErrInvalidLimit) {
+				t.Errorf("Limit %d per %v: expected ErrInvalidLimit, got %v", bad.Limit, bad.Window, err)
+			}
+		}
+	})
+
+	t.Run("InvalidTableName", func(t *testing.T) {
+		bad := ratelimit.NewDbLimiter(db, "rate_limits--", 2, time.Minute)
+		if err := bad.Allow(ctx, "key"); !errors.Is(err, store.ErrInvalidTableName) {
+			t.Errorf("Expected ErrInvalidTableName, got %v", err)
+		}
+	})
+}
+
+func TestDbLimiter_Concurrent(t *testing.T) {
+	ctx := context.Background()
+
+	// Writers wait for each other instead of failing with SQLITE_BUSY.
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "limits.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+	sqlScript, err := os.ReadFile("db_limiter_sample.sql")
+	if err != nil {
+		t.Fatalf("Failed to read SQL file: %v", err)
+	}
+	if _, err := db.Exec(string(sqlScript)); err != nil {
+		t.Fatalf("Failed to create rate limit table: %v", err)
+	}
+
+	limiter := ratelimit.NewDbLimiter(db, "passwordless_rate_limits", 5, time.Minute)
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- limiter.Allow(ctx, "start:recipient:race@example.com")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	allowed := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			allowed++
+		case !errors.Is(err, ratelimit.ErrRateLimited):
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if allowed != limiter.Limit {
+		t.Errorf("Expected exactly %d events allowed, got %d", limiter.Limit, allowed)
+	}
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+)
+
+// MemLimiter is an in-memory token-bucket limiter. Each key gets a bucket
+// holding up to Limit tokens that refills evenly over Interval, so short
+// bursts are allowed while the long-run rate stays at Limit per Interval.
+type MemLimiter struct {
+	Limit    int           // Bucket capacity (maximum burst)
+	Interval time.Duration // Time to refill an empty bucket
+	Clock    clock.Clock   // Optional; defaults to the real clock
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewMemLimiter creates a limiter allowing limit events per interval for each key.
+func NewMemLimiter(limit int, interval time.Duration) *MemLimiter {
+	return &MemLimiter{
+		Limit:    limit,
+		Interval: interval,
+		buckets:  make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket for key. It returns ErrInvalidLimit if
+// Limit or Interval is not positive.
+func (l *MemLimiter) Allow(ctx context.Context, key string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if l.Limit <= 0 || l.Interval <= 0 {
+		return fmt.Errorf("%w: %d per %s", ErrInvalidLimit, l.Limit, l.Interval)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := clock.Or(l.Clock).Now()
+	l.sweep(now)
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Limit), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return nil
+	}
+
+	return &RateLimitError{RetryAfter: time.Duration((1 - b.tokens) * float64(l.refillEvery()))}
+}
+
+// refillEvery is the time it takes to regain a single token.
+func (l *MemLimiter) refillEvery() time.Duration {
+	return l.Interval / time.Duration(l.Limit)
+}
+
+// refill returns the number of tokens in b at time now.
+func (l *MemLimiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + float64(now.Sub(b.last))/float64(l.refillEvery())
+	if tokens > float64(l.Limit) {
+		tokens = float64(l.Limit)
+	}
+	return tokens
+}
+
+// sweep drops buckets that have refilled completely, since they are
+// indistinguishable from a new bucket. It runs at most once per Interval.
+func (l *MemLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.Interval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.Limit) {
+			delete(l.buckets, key)
+		}
+	}
+}
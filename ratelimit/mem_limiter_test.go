@@ -0,0 +1,101 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/ratelimit"
+)
+
+func TestMemLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("AllowsBurstThenLimits", func(t *testing.T) {
+		limiter := ratelimit.NewMemLimiter(3, time.Minute)
+
+		for i := 0; i < 3; i++ {
+			if err := limiter.Allow(ctx, "user@example.com"); err != nil {
+				t.Fatalf("Call %d: expected to be allowed, got %v", i+1, err)
+			}
+		}
+
+		err := limiter.Allow(ctx, "user@example.com")
+		if !errors.Is(err, ratelimit.ErrRateLimited) {
+			t.Fatalf("Expected ErrRateLimited, got %v", err)
+		}
+		var rle *ratelimit.RateLimitError
+		if !errors.As(err, &rle) {
+			t.Fatalf("Expected RateLimitError, got %T", err)
+		}
+		// One token refills every 20 seconds.
+		if rle.RetryAfter <= 0 || rle.RetryAfter > 20*time.Second {
+			t.Errorf("Expected RetryAfter in (0, 20s], got %v", rle.RetryAfter)
+		}
+	})
+
+	t.Run("KeysAreIndependent", func(t *testing.T) {
+		limiter := ratelimit.NewMemLimiter(1, time.Minute)
+
+		if err := limiter.Allow(ctx, "a"); err != nil {
+			t.Fatalf("Expected key a to be allowed, got %v", err)
+		}
+		if err := limiter.Allow(ctx, "b"); err != nil {
+			t.Fatalf("Expected key b to be allowed, got %v", err)
+		}
+		if err := limiter.Allow(ctx, "a"); err == nil {
+			t.Fatal("Expected key a to be limited")
+		}
+	})
+
+	t.Run("Refills", func(t *testing.T) {
+		clk := clocktest.NewFake(time.Now())
+		limiter := ratelimit.NewMemLimiter(2, time.Minute)
+		limiter.Clock = clk
+
+		_ = limiter.Allow(ctx, "k")
+		_ = limiter.Allow(ctx, "k")
+		if err := limiter.Allow(ctx, "k"); err == nil {
+			t.Fatal("Expected bucket to be empty")
+		}
+
+		clk.Advance(30 * time.Second)
+		if err := limiter.Allow(ctx, "k"); err != nil {
+			t.Fatalf("Expected a token to have refilled, got %v", err)
+		}
+		if err := limiter.Allow(ctx, "k"); err == nil {
+			t.Fatal("Expected only one token to have refilled")
+		}
+	})
+
+	t.Run("InvalidLimit", func(t *testing.T) {
+		for _, limiter := range []*ratelimit.MemLimiter{
+			ratelimit.NewMemLimiter(0, time.Minute),
+			ratelimit.NewMemLimiter(-1, time.Minute),
+			ratelimit.NewMemLimiter(3, 0),
+			ratelimit.NewMemLimiter(3, -time.Minute),
+		} {
+			if err := limiter.Allow(ctx, "user@example.com"); !errors.Is(err, ratelimit.ErrInvalidLimit) {
+				t.Errorf("Limit %d per %v: expected ErrInvalidLimit, got %v", limiter.Limit, limiter.Interval, err)
+			}
+		}
+
+		// A struct literal works as well as the constructor.
+		limiter := &ratelimit.MemLimiter{Limit: 1, Interval: time.Minute}
+		if err := limiter.Allow(ctx, "user@example.com"); err != nil {
+			t.Errorf("Expected a literal limiter to allow, got %v", err)
+		}
+	})
+
+	t.Run("CanceledContext", func(t *testing.T) {
+		limiter := ratelimit.NewMemLimiter(1, time.Minute)
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		if err := limiter.Allow(cctx, "k"); err != context.Canceled {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+	})
+}
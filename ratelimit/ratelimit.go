@@ -0,0 +1,37 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRateLimited is matched (via errors.Is) by every *RateLimitError.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// ErrInvalidLimit is returned by Allow when a limiter's limit or period is not
+// positive.
+var ErrInvalidLimit = errors.New("rate limit and period must be positive")
+
+// RateLimiter decides whether an event identified by key may proceed.
+// Keys are opaque strings such as "start:recipient:user@example.com".
+type RateLimiter interface {
+	// Allow records an event for key. It returns nil if the event is within the
+	// limit, or a *RateLimitError describing when to retry if it is not.
+	Allow(ctx context.Context, key string) error
+}
+
+// RateLimitError is returned when a limit has been exceeded.
+type RateLimitError struct {
+	RetryAfter time.Duration // How long the caller should wait before trying again
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// Is reports whether target is ErrRateLimited.
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
@@ -0,0 +1,34 @@
+package render
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// LocaleFromRequest returns the language the client prefers most according
+// to its Accept-Language header, or "" if it states none. Pass the result to
+// passwordless.WithLocale so it reaches the Renderer.
+func LocaleFromRequest(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return normalizeLocale(best)
+}
@@ -0,0 +1,25 @@
+package render_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rlnorthcutt/go-passwordless/render"
+)
+
+func TestLocaleFromRequest(t *testing.T) {
+	cases := map[string]string{
+		"":                             "",
+		"de-CH":                        "de-ch",
+		"fr;q=0.5, de-CH, en;q=0.9":    "de-ch",
+		"en;q=0.4, pt_BR;q=0.8, *;q=1": "pt-br",
+		"es;q=bogus, it;q=0.1":         "it",
+	}
+	for header, want := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", header)
+		if got := render.LocaleFromRequest(r); got != want {
+			t.Errorf("LocaleFromRequest(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Package render turns a login message into the subject, plain-text, HTML and
+// SMS bodies that transports deliver.
+//
+// Templates is the default Renderer. It keeps one template set per locale,
+// written with text/template (subject, text, SMS) and html/template (HTML),
+// and ships English defaults embedded in the library. Additional or
+// replacement locales can be loaded from any fs.FS, such as an embed.FS.
+package render
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoTemplate is returned when no locale has the templates for a purpose.
+var ErrNoTemplate = errors.New("no template for purpose")
+
+// Data is the input to a Renderer. Templates see its fields directly, e.g.
+// {{.Code}} or {{.Metadata.name}}.
+type Data struct {
+	Recipient string            // The user's address
+	Code      string            // The one-time code; empty for link-only logins
+	Link      string            // The one-time login link; empty if none was requested
+	ExpiresAt time.Time         // When the code and link stop working
+	Purpose   string            // Selects the template, e.g. "login"
+	Locale    string            // Preferred locale as a BCP 47 tag; empty for the default
+	Metadata  map[string]string // Application-defined values
+}
+
+// Content is a rendered message.
+type Content struct {
+	Subject string // Email subject line
+	Text    string // Plain-text email body
+	HTML    string // HTML email body; empty if the template set has none
+	SMS     string // Short body for SMS; falls back to Text if the template set has none
+	Locale  string // The locale whose templates were used
+}
+
+// Renderer renders a message for delivery.
+type Renderer interface {
+	Render(ctx context.Context, d Data) (*Content, error)
+}
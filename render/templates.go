@@ -0,0 +1,280 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+// Template file names within a locale directory, for a given purpose.
+const (
+	subjectSuffix = ".subject.txt"
+	textSuffix    = ".txt"
+	htmlSuffix    = ".html"
+	smsSuffix     = ".sms.txt"
+)
+
+// DefaultLocale is the locale of the templates shipped with the library.
+const DefaultLocale = "en"
+
+//go:embed templates
+var defaultFS embed.FS
+
+var (
+	defaultOnce      sync.Once
+	defaultTemplates *Templates
+)
+
+// Default returns the shared Templates holding only the built-in templates.
+func Default() *Templates {
+	defaultOnce.Do(func() {
+		sub, _ := fs.Sub(defaultFS, "templates")
+		defaultTemplates = &Templates{DefaultLocale: DefaultLocale}
+		if err := defaultTemplates.Load(sub); err != nil {
+			panic("render: invalid built-in templates: " + err.Error())
+		}
+	})
+	return defaultTemplates
+}
+
+// Templates renders messages from per-locale template sets.
+//
+// A template set is a directory named after its locale ("en", "de", "pt-br")
+// containing, for each purpose, the files <purpose>.subject.txt and
+// <purpose>.txt (required) and <purpose>.html and <purpose>.sms.txt (optional).
+type Templates struct {
+	// DefaultLocale is used when neither the message nor ResolveLocale names a
+	// locale that has templates. If empty, DefaultLocale ("en") is used.
+	DefaultLocale string
+
+	// ResolveLocale, if set, is consulted when the message carries no locale
+	// with templates, e.g. to look up the recipient's saved language.
+	ResolveLocale func(ctx context.Context, recipient string) string
+
+	mu   sync.RWMutex
+	sets map[string]*templateSet // locale -> templates
+}
+
+// templateSet holds the parsed templates of a single locale.
+type templateSet struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// NewTemplates returns Templates preloaded with the built-in templates. Use
+// Load to add locales or replace the built-in wording.
+func NewTemplates() *Templates {
+	t := &Templates{DefaultLocale: DefaultLocale}
+	t.copyFrom(Default())
+	return t
+}
+
+// Load parses every locale directory at the root of fsys, replacing any
+// locale that is already loaded. For example, with
+//
+//	//go:embed mail
+//	var mailFS embed.FS
+//
+//	sub, _ := fs.Sub(mailFS, "mail")
+//	err := templates.Load(sub)
+func (t *Templates) Load(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return fmt.Errorf("failed to read templates: %w", err)
+	}
+
+	loaded := make(map[string]*templateSet)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		set, err := parseSet(fsys, e.Name())
+		if err != nil {
+			return err
+		}
+		loaded[normalizeLocale(e.Name())] = set
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sets == nil {
+		t.sets = make(map[string]*templateSet)
+	}
+	for locale, set := range loaded {
+		t.sets[locale] = set
+	}
+	return nil
+}
+
+// Locales returns the locales that have templates.
+func (t *Templates) Locales() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	locales := make([]string, 0, len(t.sets))
+	for locale := range t.sets {
+		locales = append(locales, locale)
+	}
+	return locales
+}
+
+// Render executes the templates for d.Purpose in the best matching locale:
+// d.Locale, then the locale from ResolveLocale, then DefaultLocale. Each is
+// tried as given and then without its region ("de-CH", then "de").
+func (t *Templates) Render(ctx context.Context, d Data) (*Content, error) {
+	for _, locale := range t.candidates(ctx, d) {
+		set := t.set(locale)
+		if set == nil || set.text.Lookup(d.Purpose+textSuffix) == nil {
+			continue
+		}
+		c, err := set.render(d)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %q message for locale %q: %w", d.Purpose, locale, err)
+		}
+		c.Locale = locale
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrNoTemplate, d.Purpose)
+}
+
+// candidates lists the locales to try for d, most preferred first.
+func (t *Templates) candidates(ctx context.Context, d Data) []string {
+	preferred := []string{d.Locale}
+	if t.ResolveLocale != nil {
+		preferred = append(preferred, t.ResolveLocale(ctx, d.Recipient))
+	}
+	def := t.DefaultLocale
+	if def == "" {
+		def = DefaultLocale
+	}
+	preferred = append(preferred, def)
+
+	var out []string
+	for _, locale := range preferred {
+		locale = normalizeLocale(locale)
+		for locale != "" {
+			out = append(out, locale)
+			i := strings.LastIndex(locale, "-")
+			if i < 0 {
+				break
+			}
+			locale = locale[:i]
+		}
+	}
+	return out
+}
+
+// set returns the templates for locale, or nil.
+func (t *Templates) set(locale string) *templateSet {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sets[locale]
+}
+
+// copyFrom shares every template set of other with t.
+func (t *Templates) copyFrom(other *Templates) {
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+
+	t.sets = make(map[string]*templateSet, len(other.sets))
+	for locale, set := range other.sets {
+		t.sets[locale] = set
+	}
+}
+
+// parseSet parses the templates in directory dir of fsys.
+func parseSet(fsys fs.FS, dir string) (*templateSet, error) {
+	set := &templateSet{
+		text: texttemplate.New(dir),
+		html: htmltemplate.New(dir),
+	}
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read templates for %q: %w", dir, err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		src, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s/%s: %w", dir, name, err)
+		}
+		switch {
+		case strings.HasSuffix(name, htmlSuffix):
+			_, err = set.html.New(name).Parse(string(src))
+		case strings.HasSuffix(name, textSuffix):
+			_, err = set.text.New(name).Parse(string(src))
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s/%s: %w", dir, name, err)
+		}
+	}
+	return set, nil
+}
+
+// render executes the templates for d.Purpose.
+func (s *templateSet) render(d Data) (*Content, error) {
+	subject, err := s.execText(d.Purpose+subjectSuffix, d)
+	if err != nil {
+		return nil, err
+	}
+	if subject == "" {
+		return nil, errors.New("missing or empty subject template")
+	}
+	text, err := s.execText(d.Purpose+textSuffix, d)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Content{
+		// A subject is a single line; drop the template's trailing newline.
+		Subject: strings.Join(strings.Fields(subject), " "),
+		Text:    text,
+		SMS:     text,
+	}
+	if s.text.Lookup(d.Purpose+smsSuffix) != nil {
+		if c.SMS, err = s.execText(d.Purpose+smsSuffix, d); err != nil {
+			return nil, err
+		}
+		c.SMS = strings.TrimSpace(c.SMS)
+	}
+	if tmpl := s.html.Lookup(d.Purpose + htmlSuffix); tmpl != nil {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, d); err != nil {
+			return nil, err
+		}
+		c.HTML = buf.String()
+	}
+	return c, nil
+}
+
+// execText executes the named text template, returning "" if it does not exist.
+func (s *templateSet) execText(name string, d Data) (string, error) {
+	tmpl := s.text.Lookup(name)
+	if tmpl == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// normalizeLocale lower-cases a locale tag and uses "-" as the separator.
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
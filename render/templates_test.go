@@ -0,0 +1,119 @@
+package render_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/render"
+)
+
+func TestTemplates(t *testing.T) {
+	ctx := context.Background()
+	expires := time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)
+
+	t.Run("Defaults", func(t *testing.T) {
+		c, err := render.Default().Render(ctx, render.Data{
+			Code:      "123456",
+			Link:      "https://example.com/login?token=a&secret=b",
+			ExpiresAt: expires,
+			Purpose:   "login",
+		})
+		if err != nil {
+			t.Fatalf("Render() error: %v", err)
+		}
+		if c.Locale != "en" || c.Subject != "Your login code is 123456" {
+			t.Errorf("Unexpected subject or locale: %+v", c)
+		}
+		if !strings.Contains(c.Text, "123456") || !strings.Contains(c.Text, "secret=b") || !strings.Contains(c.Text, "15:04 UTC") {
+			t.Errorf("Expected text to contain code, link and expiry, got %q", c.Text)
+		}
+		// html/template escapes the link for the href attribute
+		if !strings.Contains(c.HTML, `href="https://example.com/login?token=a&amp;secret=b"`) {
+			t.Errorf("Expected HTML to contain the escaped link, got %q", c.HTML)
+		}
+		if c.SMS != "Your login code is 123456" {
+			t.Errorf("Unexpected SMS body %q", c.SMS)
+		}
+	})
+
+	t.Run("LinkOnly", func(t *testing.T) {
+		c, err := render.Default().Render(ctx, render.Data{Link: "https://example.com/l", Purpose: "login"})
+		if err != nil {
+			t.Fatalf("Render() error: %v", err)
+		}
+		if c.Subject != "Your login link" || strings.Contains(c.Text, "code") {
+			t.Errorf("Expected a link-only message, got %+v", c)
+		}
+	})
+
+	t.Run("Locales", func(t *testing.T) {
+		tmpl := render.NewTemplates()
+		err := tmpl.Load(fstest.MapFS{
+			"de/login.subject.txt": {Data: []byte("Ihr Anmeldecode: {{.Code}}\n")},
+			"de/login.txt":         {Data: []byte("Hallo {{.Metadata.name}}, Ihr Code lautet {{.Code}}.")},
+		})
+		if err != nil {
+			t.Fatalf("Load() error: %v", err)
+		}
+
+		c, err := tmpl.Render(ctx, render.Data{
+			Code:     "654321",
+			Purpose:  "login",
+			Locale:   "de-CH",
+			Metadata: map[string]string{"name": "Ada"},
+		})
+		if err != nil {
+			t.Fatalf("Render() error: %v", err)
+		}
+		if c.Locale != "de" || c.Subject != "Ihr Anmeldecode: 654321" || c.Text != "Hallo Ada, Ihr Code lautet 654321." {
+			t.Errorf("Expected the German templates, got %+v", c)
+		}
+		if c.HTML != "" || c.SMS != c.Text {
+			t.Errorf("Expected no HTML and SMS to fall back to text, got %+v", c)
+		}
+
+		// Unknown locales fall back to the default
+		c, _ = tmpl.Render(ctx, render.Data{Code: "1", Purpose: "login", Locale: "fr"})
+		if c.Locale != "en" {
+			t.Errorf("Expected fallback to en, got %q", c.Locale)
+		}
+	})
+
+	t.Run("ResolveLocale", func(t *testing.T) {
+		tmpl := render.NewTemplates()
+		_ = tmpl.Load(fstest.MapFS{
+			"pt-br/login.subject.txt": {Data: []byte("Seu código")},
+			"pt-br/login.txt":         {Data: []byte("{{.Code}}")},
+		})
+		tmpl.ResolveLocale = func(ctx context.Context, recipient string) string {
+			if recipient == "ana@example.com" {
+				return "pt_BR"
+			}
+			return ""
+		}
+
+		c, err := tmpl.Render(ctx, render.Data{Recipient: "ana@example.com", Code: "1", Purpose: "login"})
+		if err != nil {
+			t.Fatalf("Render() error: %v", err)
+		}
+		if c.Locale != "pt-br" {
+			t.Errorf("Expected the recipient's locale, got %q", c.Locale)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := render.Default().Render(ctx, render.Data{Purpose: "unknown"}); !errors.Is(err, render.ErrNoTemplate) {
+			t.Errorf("Expected ErrNoTemplate, got %v", err)
+		}
+
+		tmpl := render.NewTemplates()
+		err := tmpl.Load(fstest.MapFS{"en/login.txt": {Data: []byte("{{.Code")}})
+		if err == nil {
+			t.Error("Expected a parse error for a broken template")
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package passwordless
+
+import (
+	"context"
+	"crypto/sha256"
+)
+
+// ResendLogin generates a fresh code for an existing token and delivers it to
+// the token's recipient. The token ID stays the same, so a client that is
+// already waiting on it keeps working, and the previous code stops being valid.
+//
+// Resends are limited by Config.ResendInterval and Config.MaxResends. The
+// failed-attempt count carries over, so re-sending never grants extra guesses.
+// The token's expiry is extended to a full TokenExpiry from now. If another
+// resend of the same token wins a race with this one, ErrTokenConflict is
+// returned and no code is sent.
+//
+// Tokens with a login link (from GenerateLoginLink or SendLoginLink) need a
+// base URL to build the new link; ResendLogin returns ErrLinkResendNeedsURL
+// for them, use ResendLoginLink instead.
+func (m *Manager) ResendLogin(ctx context.Context, tokenID string) error {
+	return m.resendLogin(ctx, tokenID, "")
+}
+
+// ResendLoginLink re-sends a token like ResendLogin and also gives it a new
+// login link built from baseURL, so the previous link stops working. A
+// link-only token from SendLoginLink receives only the new link; any other
+// token receives a fresh code as well.
+func (m *Manager) ResendLoginLink(ctx context.Context, tokenID, baseURL string) error {
+	return m.resendLogin(ctx, tokenID, baseURL)
+}
+
+// resendLogin implements ResendLogin and ResendLoginLink. If baseURL is set
+// the token's link secret is rotated and the new link is delivered.
+func (m *Manager) resendLogin(ctx context.Context, tokenID, baseURL string) error {
+	tok, err := m.loadToken(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	if len(tok.LinkHash) > 0 && baseURL == "" {
+		return ErrLinkResendNeedsURL
+	}
+
+	if tok.ResendCount >= m.Config.MaxResends {
+		return ErrResendLimitReached
+	}
+
+	// Tokens created before resend tracking existed have no LastSentAt.
+	now := m.now()
+	lastSent := tok.LastSentAt
+	if lastSent.IsZero() {
+		lastSent = tok.CreatedAt
+	}
+	if wait := lastSent.Add(m.Config.ResendInterval).Sub(now); wait > 0 {
+		return &ResendTooSoonError{RetryAfter: wait}
+	}
+
+	// Resends count against the recipient's limits just like new logins
+	if err := m.checkStartLimits(ctx, tok.Recipient); err != nil {
+		return err
+	}
+
+	// A link-only token keeps its empty code hash and gets no code
+	prev := *tok
+	var code string
+	if len(tok.CodeHash) > 0 {
+		code, err = m.generateCode(m.Config.CodeLength, m.Config.CodeCharset)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256([]byte(code))
+		tok.CodeHash = hash[:]
+	}
+
+	var link string
+	if baseURL != "" {
+		secret, err := generateLinkSecret()
+		if err != nil {
+			return err
+		}
+		if link, err = buildLoginLink(baseURL, tokenID, secret); err != nil {
+			return err
+		}
+		linkHash := sha256.Sum256([]byte(secret))
+		tok.LinkHash = linkHash[:]
+	}
+
+	tok.ExpiresAt = now.Add(m.Config.TokenExpiry)
+	tok.ResendCount++
+	tok.LastSentAt = now
+
+	if err := m.Store.Reissue(ctx, *tok, prev.ResendCount); err != nil {
+		return err
+	}
+
+	if err := m.send(ctx, m.message(ctx, *tok, code, link)); err != nil {
+		// If sending fails, restore the previous code and link so the resend isn't counted
+		_ = m.Store.Reissue(ctx, prev, tok.ResendCount)
+		m.emit(ctx, Event{Type: EventDeliveryFailed, TokenID: tokenID, Recipient: tok.Recipient, Err: err})
+		return &TransportError{Err: err}
+	}
+	m.emit(ctx, Event{Type: EventCodeDelivered, TokenID: tokenID, Recipient: tok.Recipient})
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package passwordless_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// readBarrierStore holds each Exists call until every expected reader has
+// arrived, so concurrent requests all read a token before any of them writes it.
+type readBarrierStore struct {
+	*store.MemStore
+	readers *sync.WaitGroup // Optional; Exists passes straight through when nil
+}
+
+func (s *readBarrierStore) Exists(ctx context.Context, tokenID string) (*store.Token, error) {
+	tok, err := s.MemStore.Exists(ctx, tokenID)
+	if s.readers != nil {
+		s.readers.Done()
+		s.readers.Wait()
+	}
+	return tok, err
+}
+
+func TestResendLogin(t *testing.T) {
+	ctx := context.Background()
+
+	clk := clocktest.NewFake(time.Now())
+	newManager := func() (*passwordless.Manager, *TestTransport) {
+		cfg := passwordless.DefaultConfig()
+		cfg.Clock = clk
+		cfg.ResendInterval = 30 * time.Second
+		cfg.MaxResends = 2
+		tt := &TestTransport{}
+		memStore := store.NewMemStore()
+		memStore.Clock = clk
+		return passwordless.NewManagerWithConfig(memStore, tt, cfg), tt
+	}
+
+	t.Run("KeepsTokenIDAndReplacesCode", func(t *testing.T) {
+		mgr, tt := newManager()
+
+		tokenID, err := mgr.StartLogin(ctx, "resend@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		firstCode := tt.LastCode
+
+		clk.Advance(31 * time.Second)
+		if err := mgr.ResendLogin(ctx, tokenID); err != nil {
+			t.Fatalf("ResendLogin returned error: %v", err)
+		}
+		secondCode := tt.LastCode
+
+		if firstCode != secondCode {
+			if ok, _ := mgr.VerifyLogin(ctx, tokenID, firstCode); ok {
+				t.Fatal("Expected the previous code to stop working after a resend")
+			}
+		}
+		ok, err := mgr.VerifyLogin(ctx, tokenID, secondCode)
+		if err != nil || !ok {
+			t.Fatalf("Expected the re-sent code to verify for the same token ID, got ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("Cooldown", func(t *testing.T) {
+		mgr, _ := newManager()
+
+		tokenID, err := mgr.StartLogin(ctx, "cooldown@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		clk.Advance(10 * time.Second)
+
+		err = mgr.ResendLogin(ctx, tokenID)
+		var tooSoon *passwordless.ResendTooSoonError
+		if !errors.As(err, &tooSoon) || !errors.Is(err, passwordless.ErrResendTooSoon) {
+			t.Fatalf("Expected ResendTooSoonError, got %v", err)
+		}
+		if tooSoon.RetryAfter != 20*time.Second {
+			t.Errorf("Expected RetryAfter of 20s, got %v", tooSoon.RetryAfter)
+		}
+
+		clk.Advance(20 * time.Second)
+		if err := mgr.ResendLogin(ctx, tokenID); err != nil {
+			t.Fatalf("Expected resend to be allowed once the cooldown passed, got %v", err)
+		}
+	})
+
+	t.Run("MaxResends", func(t *testing.T) {
+		mgr, _ := newManager()
+
+		tokenID, err := mgr.StartLogin(ctx, "max@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+
+		for i := 0; i < 2; i++ {
+			clk.Advance(31 * time.Second)
+			if err := mgr.ResendLogin(ctx, tokenID); err != nil {
+				t.Fatalf("Resend %d returned error: %v", i+1, err)
+			}
+		}
+
+		clk.Advance(31 * time.Second)
+		if err := mgr.ResendLogin(ctx, tokenID); !errors.Is(err, passwordless.ErrResendLimitReached) {
+			t.Fatalf("Expected ErrResendLimitReached, got %v", err)
+		}
+	})
+
+	t.Run("ConcurrentResends", func(t *testing.T) {
+		memStore := store.NewMemStore()
+		memStore.Clock = clk
+		rs := &readBarrierStore{MemStore: memStore}
+		cfg := passwordless.DefaultConfig()
+		cfg.Clock = clk
+		mgr := passwordless.NewManagerWithConfig(rs, &TestTransport{}, cfg)
+
+		tokenID, err := mgr.StartLogin(ctx, "race@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		clk.Advance(cfg.ResendInterval)
+
+		rs.readers = &sync.WaitGroup{}
+		rs.readers.Add(2)
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() { errs <- mgr.ResendLogin(ctx, tokenID) }()
+		}
+
+		var sent, conflicts int
+		for i := 0; i < 2; i++ {
+			switch err := <-errs; {
+			case err == nil:
+				sent++
+			case errors.Is(err, passwordless.ErrTokenConflict):
+				conflicts++
+			default:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}
+		if sent != 1 || conflicts != 1 {
+			t.Errorf("Expected one resend and one conflict, got %d and %d", sent, conflicts)
+		}
+		if tok, _ := memStore.Exists(ctx, tokenID); tok == nil || tok.ResendCount != 1 {
+			t.Errorf("Expected the resend to be counted once, got %+v", tok)
+		}
+	})
+
+	t.Run("LinkOnly", func(t *testing.T) {
+		cfg := passwordless.DefaultConfig()
+		cfg.Clock = clk
+		mr := &MessageRecorder{}
+		memStore := store.NewMemStore()
+		memStore.Clock = clk
+		mgr := passwordless.NewManagerWithConfig(memStore, mr, cfg)
+
+		tokenID, err := mgr.SendLoginLink(ctx, "link@example.com", "https://myapp.com/login")
+		if err != nil {
+			t.Fatalf("SendLoginLink returned error: %v", err)
+		}
+		firstLink := mr.LastMessage.Link
+
+		clk.Advance(cfg.ResendInterval)
+		if err := mgr.ResendLogin(ctx, tokenID); !errors.Is(err, passwordless.ErrLinkResendNeedsURL) {
+			t.Fatalf("Expected ErrLinkResendNeedsURL from ResendLogin, got %v", err)
+		}
+		if err := mgr.ResendLoginLink(ctx, tokenID, "https://myapp.com/login"); err != nil {
+			t.Fatalf("ResendLoginLink returned error: %v", err)
+		}
+
+		msg := mr.LastMessage
+		if msg.Code != "" {
+			t.Errorf("Expected no code for a link-only token, got %q", msg.Code)
+		}
+		if msg.Link == "" || msg.Link == firstLink {
+			t.Fatalf("Expected a new link, got %q", msg.Link)
+		}
+		if ok, _ := mgr.VerifyLoginLink(ctx, tokenID, linkSecret(t, firstLink)); ok {
+			t.Fatal("Expected the previous link to stop working after a resend")
+		}
+		if ok, err := mgr.VerifyLoginLink(ctx, tokenID, linkSecret(t, msg.Link)); !ok || err != nil {
+			t.Fatalf("Expected the re-sent link to verify, got ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("CodeAndLink", func(t *testing.T) {
+		cfg := passwordless.DefaultConfig()
+		cfg.Clock = clk
+		mr := &MessageRecorder{}
+		memStore := store.NewMemStore()
+		memStore.Clock = clk
+		mgr := passwordless.NewManagerWithConfig(memStore, mr, cfg)
+
+		firstLink, err := mgr.GenerateLoginLink(ctx, "both@example.com", "https://myapp.com/login")
+		if err != nil {
+			t.Fatalf("GenerateLoginLink returned error: %v", err)
+		}
+		u, _ := url.Parse(firstLink)
+		id := u.Query().Get(passwordless.LinkTokenParam)
+		firstCode := mr.LastMessage.Code
+
+		clk.Advance(cfg.ResendInterval)
+		if err := mgr.ResendLoginLink(ctx, id, "https://myapp.com/login"); err != nil {
+			t.Fatalf("ResendLoginLink returned error: %v", err)
+		}
+
+		msg := mr.LastMessage
+		if msg.Code == "" || msg.Link == "" || msg.Link == firstLink {
+			t.Fatalf("Expected a new code and a new link, got code %q and link %q", msg.Code, msg.Link)
+		}
+		if ok, _ := mgr.VerifyLoginLink(ctx, id, linkSecret(t, firstLink)); ok {
+			t.Fatal("Expected the previous link to stop working after a resend")
+		}
+		if msg.Code != firstCode {
+			if ok, _ := mgr.VerifyLogin(ctx, id, firstCode); ok {
+				t.Fatal("Expected the previous code to stop working after a resend")
+			}
+		}
+		if ok, err := mgr.VerifyLogin(ctx, id, msg.Code); !ok || err != nil {
+			t.Fatalf("Expected the re-sent code to verify, got ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("UnknownToken", func(t *testing.T) {
+		mgr, _ := newManager()
+
+		if err := mgr.ResendLogin(ctx, "missing"); !errors.Is(err, passwordless.ErrTokenNotFound) {
+			t.Fatalf("Expected ErrTokenNotFound, got %v", err)
+		}
+	})
+
+	t.Run("TransportFailureRestoresCode", func(t *testing.T) {
+		memStore := store.NewMemStore()
+		memStore.Clock = clk
+		ft := &FailingTransport{}
+		cfg := passwordless.DefaultConfig()
+		cfg.Clock = clk
+		mgr := passwordless.NewManagerWithConfig(memStore, ft, cfg)
+
+		tokenID, err := mgr.StartLogin(ctx, "fail@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		before, _ := memStore.Exists(ctx, tokenID)
+
+		clk.Advance(cfg.ResendInterval)
+		ft.Err = errors.New("mailbox unavailable")
+		if err := mgr.ResendLogin(ctx, tokenID); !errors.Is(err, passwordless.ErrTransport) {
+			t.Fatalf("Expected ErrTransport, got %v", err)
+		}
+
+		after, err := memStore.Exists(ctx, tokenID)
+		if err != nil {
+			t.Fatalf("Expected token to survive a failed resend: %v", err)
+		}
+		if after.ResendCount != before.ResendCount || string(after.CodeHash) != string(before.CodeHash) {
+			t.Fatal("Expected the previous code to be restored after a failed resend")
+		}
+	})
+}
+
+// linkSecret extracts the link secret from a login link.
+func linkSecret(t *testing.T, link string) string {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("Invalid login link %q: %v", link, err)
+	}
+	return u.Query().Get(passwordless.LinkSecretParam)
+}
@@ -0,0 +1,22 @@
+package passwordless
+
+import (
+	"context"
+
+	"github.com/rlnorthcutt/go-passwordless/logging"
+)
+
+// RevokeAll invalidates every outstanding token issued to recipient, e.g. to
+// "log out everywhere" or in response to a suspected compromise. It returns
+// the number of tokens that were removed.
+func (m *Manager) RevokeAll(ctx context.Context, recipient string) (int, error) {
+	n, err := m.Store.DeleteByRecipient(ctx, recipient)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		m.logger().InfoContext(ctx, "tokens revoked",
+			logging.KeyRecipient, recipient, logging.KeyCount, n)
+	}
+	return n, nil
+}
@@ -0,0 +1,79 @@
+package passwordless_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+func TestRevokeAll(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RevokesEveryToken", func(t *testing.T) {
+		tt := &TestTransport{}
+		mgr := passwordless.NewManager(store.NewMemStore(), tt)
+
+		first, _ := mgr.StartLogin(ctx, "revoke@example.com")
+		second, _ := mgr.StartLogin(ctx, "revoke@example.com")
+		other, _ := mgr.StartLogin(ctx, "other@example.com")
+		otherCode := tt.LastCode
+
+		n, err := mgr.RevokeAll(ctx, "revoke@example.com")
+		if err != nil {
+			t.Fatalf("RevokeAll returned error: %v", err)
+		}
+		if n != 2 {
+			t.Errorf("Expected 2 tokens revoked, got %d", n)
+		}
+
+		for _, id := range []string{first, second} {
+			if _, err := mgr.VerifyLogin(ctx, id, "000000"); !errors.Is(err, passwordless.ErrTokenNotFound) {
+				t.Errorf("Expected revoked token %s to be gone, got %v", id, err)
+			}
+		}
+		if ok, err := mgr.VerifyLogin(ctx, other, otherCode); err != nil || !ok {
+			t.Errorf("Expected another recipient's token to still verify, got ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("SingleActiveToken", func(t *testing.T) {
+		tt := &TestTransport{}
+		cfg := passwordless.DefaultConfig()
+		cfg.SingleActiveToken = true
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), tt, cfg)
+
+		first, err := mgr.StartLogin(ctx, "single@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		firstCode := tt.LastCode
+
+		second, err := mgr.StartLogin(ctx, "single@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+
+		if _, err := mgr.VerifyLogin(ctx, first, firstCode); !errors.Is(err, passwordless.ErrTokenNotFound) {
+			t.Fatalf("Expected the earlier token to be revoked, got %v", err)
+		}
+		if ok, err := mgr.VerifyLogin(ctx, second, tt.LastCode); err != nil || !ok {
+			t.Fatalf("Expected the newest token to verify, got ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("MultipleTokensByDefault", func(t *testing.T) {
+		tt := &TestTransport{}
+		mgr := passwordless.NewManager(store.NewMemStore(), tt)
+
+		first, _ := mgr.StartLogin(ctx, "multi@example.com")
+		firstCode := tt.LastCode
+		_, _ = mgr.StartLogin(ctx, "multi@example.com")
+
+		if ok, err := mgr.VerifyLogin(ctx, first, firstCode); err != nil || !ok {
+			t.Fatalf("Expected the earlier token to remain valid, got ok=%v err=%v", ok, err)
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package passwordless
+
+import (
+	"context"
+
+	"github.com/rlnorthcutt/go-passwordless/session"
+)
+
+// VerifyLoginSession verifies a code like VerifyLogin and, on success, issues
+// a session for the token's recipient through Config.SessionIssuer.
+func (m *Manager) VerifyLoginSession(ctx context.Context, tokenID, code string) (*session.Session, error) {
+	if m.Config.SessionIssuer == nil {
+		return nil, ErrNoSessionIssuer
+	}
+
+	tok, err := m.verifyCode(ctx, tokenID, code)
+	if err != nil {
+		return nil, err
+	}
+	return m.Config.SessionIssuer.Issue(ctx, tok.Recipient)
+}
+
+// VerifyLoginLinkSession verifies a login link like VerifyLoginLink and, on
+// success, issues a session for the token's recipient through Config.SessionIssuer.
+func (m *Manager) VerifyLoginLinkSession(ctx context.Context, tokenID, secret string) (*session.Session, error) {
+	if m.Config.SessionIssuer == nil {
+		return nil, ErrNoSessionIssuer
+	}
+
+	tok, err := m.verifyLink(ctx, tokenID, secret)
+	if err != nil {
+		return nil, err
+	}
+	return m.Config.SessionIssuer.Issue(ctx, tok.Recipient)
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// DefaultCookieName is the cookie name used by the examples and recommended
+// for the session token.
+const DefaultCookieName = "pwdless_auth"
+
+// SetCookie writes s.Token to the response as an HttpOnly, Secure, SameSite=Lax
+// cookie that expires with the session.
+func SetCookie(w http.ResponseWriter, name string, s *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    s.Token,
+		Path:     "/",
+		Expires:  s.ExpiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ReadCookie returns the session token carried by r, or ErrNoSession.
+func ReadCookie(r *http.Request, name string) (string, error) {
+	c, err := r.Cookie(name)
+	if errors.Is(err, http.ErrNoCookie) || (err == nil && c.Value == "") {
+		return "", ErrNoSession
+	}
+	if err != nil {
+		return "", err
+	}
+	return c.Value, nil
+}
+
+// ClearCookie tells the client to drop the session cookie.
+func ClearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// FromRequest reads the session cookie from r and validates it with iss.
+func FromRequest(ctx context.Context, iss Issuer, r *http.Request, name string) (*Session, error) {
+	token, err := ReadCookie(r, name)
+	if err != nil {
+		return nil, err
+	}
+	return iss.Validate(ctx, token)
+}
@@ -0,0 +1,64 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/session"
+)
+
+func TestCookies(t *testing.T) {
+	ctx := context.Background()
+	iss := session.NewJWTIssuer(testSecret, time.Hour)
+
+	t.Run("SetAndRead", func(t *testing.T) {
+		s, err := iss.Issue(ctx, "cookie@example.com")
+		if err != nil {
+			t.Fatalf("Issue() error: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		session.SetCookie(w, session.DefaultCookieName, s)
+
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("Expected 1 cookie, got %d", len(cookies))
+		}
+		c := cookies[0]
+		if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode {
+			t.Errorf("Expected an HttpOnly, Secure, SameSite=Lax cookie, got %+v", c)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(c)
+
+		got, err := session.FromRequest(ctx, iss, req, session.DefaultCookieName)
+		if err != nil {
+			t.Fatalf("FromRequest() error: %v", err)
+		}
+		if got.Subject != "cookie@example.com" {
+			t.Errorf("Expected subject cookie@example.com, got %q", got.Subject)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if _, err := session.ReadCookie(req, session.DefaultCookieName); !errors.Is(err, session.ErrNoSession) {
+			t.Fatalf("Expected ErrNoSession, got %v", err)
+		}
+	})
+
+	t.Run("Clear", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		session.ClearCookie(w, session.DefaultCookieName)
+
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+			t.Fatalf("Expected an expiring cookie, got %+v", cookies)
+		}
+	})
+}
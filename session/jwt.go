@@ -0,0 +1,195 @@
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+)
+
+// MinSecretLength is the shortest HMAC secret JWTIssuer accepts.
+const MinSecretLength = 32
+
+// jwtHeader is the fixed, pre-encoded header of every token. Tokens with any
+// other header (in particular "alg":"none") are rejected.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// jwtClaims are the registered claims carried by a session token.
+type jwtClaims struct {
+	ID        string `json:"jti"`
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// JWTIssuer issues HS256-signed JSON Web Tokens.
+type JWTIssuer struct {
+	Secret   []byte        // HMAC key, at least MinSecretLength bytes
+	TTL      time.Duration // Lifetime of issued sessions
+	Issuer   string        // Optional "iss" claim, checked on validation when set
+	Registry Registry      // Optional; enables revocation when set
+	Clock    clock.Clock   // Optional; defaults to the real clock
+}
+
+// NewJWTIssuer creates a JWTIssuer signing with secret and issuing sessions valid for ttl.
+func NewJWTIssuer(secret []byte, ttl time.Duration) *JWTIssuer {
+	return &JWTIssuer{
+		Secret: secret,
+		TTL:    ttl,
+	}
+}
+
+// Issue signs a new session for subject and records it in the registry, if any.
+func (j *JWTIssuer) Issue(ctx context.Context, subject string) (*Session, error) {
+	if len(j.Secret) < MinSecretLength {
+		return nil, fmt.Errorf("session secret must be at least %d bytes", MinSecretLength)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	now := clock.Or(j.Clock).Now()
+	claims := jwtClaims{
+		ID:        hex.EncodeToString(id),
+		Subject:   subject,
+		Issuer:    j.Issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(j.TTL).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode session claims: %w", err)
+	}
+
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	s := claims.session(signingInput + "." + j.sign(signingInput))
+
+	if j.Registry != nil {
+		if err := j.Registry.Add(ctx, s); err != nil {
+			return nil, fmt.Errorf("failed to register session: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Validate checks the signature, expiry and (if a registry is set) revocation status of token.
+func (j *JWTIssuer) Validate(ctx context.Context, token string) (*Session, error) {
+	s, err := j.parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !clock.Or(j.Clock).Now().Before(s.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+
+	if j.Registry != nil {
+		active, err := j.Registry.Active(ctx, s.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return nil, ErrSessionRevoked
+		}
+	}
+	return s, nil
+}
+
+// Refresh validates token, issues a replacement and revokes the original.
+func (j *JWTIssuer) Refresh(ctx context.Context, token string) (*Session, error) {
+	old, err := j.Validate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := j.Issue(ctx, old.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.Registry != nil {
+		if err := j.Registry.Remove(ctx, old.ID); err != nil {
+			return nil, fmt.Errorf("failed to revoke previous session: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Revoke removes the session identified by token from the registry. Expired
+// tokens are accepted so that logging out never fails just because the
+// session timed out first.
+func (j *JWTIssuer) Revoke(ctx context.Context, token string) error {
+	if j.Registry == nil {
+		return ErrNoRegistry
+	}
+
+	s, err := j.parse(token)
+	if err != nil {
+		return err
+	}
+	return j.Registry.Remove(ctx, s.ID)
+}
+
+// parse verifies the signature of token and decodes its claims without
+// checking expiry or revocation.
+func (j *JWTIssuer) parse(token string) (*Session, error) {
+	header, rest, ok := strings.Cut(token, ".")
+	if !ok || header != jwtHeader || len(j.Secret) < MinSecretLength {
+		return nil, ErrInvalidSession
+	}
+	payload, sig, ok := strings.Cut(rest, ".")
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(j.sign(header+"."+payload))) {
+		return nil, ErrInvalidSession
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, errors.Join(ErrInvalidSession, err)
+	}
+	if claims.ID == "" || claims.Subject == "" {
+		return nil, ErrInvalidSession
+	}
+	if j.Issuer != "" && claims.Issuer != j.Issuer {
+		return nil, ErrInvalidSession
+	}
+
+	return claims.session(token), nil
+}
+
+// sign returns the encoded HMAC-SHA256 signature of signingInput.
+func (j *JWTIssuer) sign(signingInput string) string {
+	mac := hmac.New(sha256.New, j.Secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// session converts the claims into a Session carrying token.
+func (c jwtClaims) session(token string) *Session {
+	return &Session{
+		ID:        c.ID,
+		Subject:   c.Subject,
+		IssuedAt:  time.Unix(c.IssuedAt, 0),
+		ExpiresAt: time.Unix(c.ExpiresAt, 0),
+		Token:     token,
+	}
+}
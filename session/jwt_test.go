@@ -0,0 +1,160 @@
+package session_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/session"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestJWTIssuer(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("IssueAndValidate", func(t *testing.T) {
+		iss := session.NewJWTIssuer(testSecret, time.Hour)
+
+		s, err := iss.Issue(ctx, "user@example.com")
+		if err != nil {
+			t.Fatalf("Issue() error: %v", err)
+		}
+		if strings.Count(s.Token, ".") != 2 {
+			t.Fatalf("Expected a three-part JWT, got %q", s.Token)
+		}
+		t.Logf("[DEBUG] Issued session %s", s.ID)
+
+		got, err := iss.Validate(ctx, s.Token)
+		if err != nil {
+			t.Fatalf("Validate() error: %v", err)
+		}
+		if got.Subject != "user@example.com" || got.ID != s.ID {
+			t.Errorf("Expected subject and ID to round-trip, got %+v", got)
+		}
+	})
+
+	t.Run("ShortSecret", func(t *testing.T) {
+		iss := session.NewJWTIssuer([]byte("short"), time.Hour)
+		if _, err := iss.Issue(ctx, "user@example.com"); err == nil {
+			t.Fatal("Expected an error for a short secret")
+		}
+	})
+
+	t.Run("RejectsEmptySecret", func(t *testing.T) {
+		// An issuer left without a secret must not accept tokens signed
+		// with an empty key.
+		s, _ := session.NewJWTIssuer(testSecret, time.Hour).Issue(ctx, "user@example.com")
+		parts := strings.Split(s.Token, ".")
+		mac := hmac.New(sha256.New, nil)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		token := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+		iss := &session.JWTIssuer{TTL: time.Hour}
+		if _, err := iss.Validate(ctx, token); !errors.Is(err, session.ErrInvalidSession) {
+			t.Errorf("Expected ErrInvalidSession, got %v", err)
+		}
+	})
+
+	t.Run("RejectsTampering", func(t *testing.T) {
+		iss := session.NewJWTIssuer(testSecret, time.Hour)
+		s, _ := iss.Issue(ctx, "user@example.com")
+		parts := strings.Split(s.Token, ".")
+
+		forged := base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"x","sub":"admin@example.com","iat":0,"exp":99999999999}`))
+		none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+		other := session.NewJWTIssuer([]byte("ffffffffffffffffffffffffffffffff"), time.Hour)
+		otherSession, _ := other.Issue(ctx, "user@example.com")
+
+		cases := map[string]string{
+			"Garbage":         "not-a-token",
+			"ForgedClaims":    parts[0] + "." + forged + "." + parts[2],
+			"AlgNone":         none + "." + parts[1] + ".",
+			"WrongSecret":     otherSession.Token,
+			"MissingSig":      parts[0] + "." + parts[1],
+			"TruncatedSig":    s.Token[:len(s.Token)-2],
+			"EmptyToken":      "",
+			"ExtraSeparators": s.Token + ".extra",
+		}
+		for name, token := range cases {
+			if _, err := iss.Validate(ctx, token); !errors.Is(err, session.ErrInvalidSession) {
+				t.Errorf("%s: expected ErrInvalidSession, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("IssuerClaim", func(t *testing.T) {
+		a := &session.JWTIssuer{Secret: testSecret, TTL: time.Hour, Issuer: "app-a"}
+		b := &session.JWTIssuer{Secret: testSecret, TTL: time.Hour, Issuer: "app-b"}
+
+		s, _ := a.Issue(ctx, "user@example.com")
+		if _, err := b.Validate(ctx, s.Token); !errors.Is(err, session.ErrInvalidSession) {
+			t.Fatalf("Expected a token from another issuer to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		clk := clocktest.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+		iss := session.NewJWTIssuer(testSecret, time.Hour)
+		iss.Clock = clk
+
+		s, _ := iss.Issue(ctx, "user@example.com")
+
+		clk.Advance(59 * time.Minute)
+		if _, err := iss.Validate(ctx, s.Token); err != nil {
+			t.Fatalf("Expected session to be valid before TTL, got %v", err)
+		}
+
+		clk.Advance(time.Minute)
+		if _, err := iss.Validate(ctx, s.Token); !errors.Is(err, session.ErrSessionExpired) {
+			t.Fatalf("Expected ErrSessionExpired, got %v", err)
+		}
+	})
+
+	t.Run("RevokeWithoutRegistry", func(t *testing.T) {
+		iss := session.NewJWTIssuer(testSecret, time.Hour)
+		s, _ := iss.Issue(ctx, "user@example.com")
+
+		if err := iss.Revoke(ctx, s.Token); !errors.Is(err, session.ErrNoRegistry) {
+			t.Fatalf("Expected ErrNoRegistry, got %v", err)
+		}
+	})
+
+	t.Run("RevokeAndRefresh", func(t *testing.T) {
+		iss := session.NewJWTIssuer(testSecret, time.Hour)
+		iss.Registry = session.NewStoreRegistry(store.NewMemStore())
+
+		s, err := iss.Issue(ctx, "user@example.com")
+		if err != nil {
+			t.Fatalf("Issue() error: %v", err)
+		}
+
+		refreshed, err := iss.Refresh(ctx, s.Token)
+		if err != nil {
+			t.Fatalf("Refresh() error: %v", err)
+		}
+		if refreshed.ID == s.ID || refreshed.Subject != s.Subject {
+			t.Errorf("Expected a new session for the same subject, got %+v", refreshed)
+		}
+		if _, err := iss.Validate(ctx, s.Token); !errors.Is(err, session.ErrSessionRevoked) {
+			t.Errorf("Expected the refreshed-away session to be revoked, got %v", err)
+		}
+
+		if err := iss.Revoke(ctx, refreshed.Token); err != nil {
+			t.Fatalf("Revoke() error: %v", err)
+		}
+		if _, err := iss.Validate(ctx, refreshed.Token); !errors.Is(err, session.ErrSessionRevoked) {
+			t.Fatalf("Expected ErrSessionRevoked, got %v", err)
+		}
+		if _, err := iss.Refresh(ctx, refreshed.Token); !errors.Is(err, session.ErrSessionRevoked) {
+			t.Fatalf("Expected a revoked session not to refresh, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// Registry keeps track of active sessions server-side so they can be revoked
+// before they expire.
+type Registry interface {
+	// Add records s as active until s.ExpiresAt.
+	Add(ctx context.Context, s *Session) error
+
+	// Active reports whether the session with the given ID is still recorded.
+	Active(ctx context.Context, sessionID string) (bool, error)
+
+	// Remove revokes a single session.
+	Remove(ctx context.Context, sessionID string) error
+
+	// RemoveAll revokes every session for subject and reports how many were removed.
+	RemoveAll(ctx context.Context, subject string) (int, error)
+}
+
+// StoreRegistry is a Registry backed by any store.TokenStore (MemStore,
+// DbStore, ...). Each session is kept as a token whose ID is the session ID
+// and whose recipient is the subject, so the store's expiry handling and
+// recipient index apply unchanged.
+//
+// Use a store (or table) separate from the one holding login tokens, so that
+// revoking login codes does not also end sessions.
+type StoreRegistry struct {
+	Store store.TokenStore
+}
+
+// NewStoreRegistry creates a Registry that records sessions in s.
+func NewStoreRegistry(s store.TokenStore) *StoreRegistry {
+	return &StoreRegistry{Store: s}
+}
+
+// Add stores s as a token. Its code hash is random, so the entry can never be
+// redeemed as a login code even if the store is shared by mistake.
+func (r *StoreRegistry) Add(ctx context.Context, s *Session) error {
+	hash := make([]byte, 32)
+	if _, err := rand.Read(hash); err != nil {
+		return fmt.Errorf("failed to generate registry entry: %w", err)
+	}
+
+	return r.Store.Store(ctx, store.Token{
+		ID:         s.ID,
+		Recipient:  s.Subject,
+		CodeHash:   hash,
+		CreatedAt:  s.IssuedAt,
+		ExpiresAt:  s.ExpiresAt,
+		LastSentAt: s.IssuedAt,
+	})
+}
+
+// Active reports whether the session is still stored and unexpired.
+func (r *StoreRegistry) Active(ctx context.Context, sessionID string) (bool, error) {
+	_, err := r.Store.Exists(ctx, sessionID)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, store.ErrTokenNotFound), errors.Is(err, store.ErrTokenExpired):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Remove deletes the session from the store.
+func (r *StoreRegistry) Remove(ctx context.Context, sessionID string) error {
+	return r.Store.Delete(ctx, sessionID)
+}
+
+// RemoveAll deletes every session for subject.
+func (r *StoreRegistry) RemoveAll(ctx context.Context, subject string) (int, error) {
+	return r.Store.DeleteByRecipient(ctx, subject)
+}
@@ -0,0 +1,68 @@
+package session_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/session"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+func TestStoreRegistry(t *testing.T) {
+	ctx := context.Background()
+	memStore := store.NewMemStore()
+	reg := session.NewStoreRegistry(memStore)
+
+	now := time.Now()
+	sessions := []*session.Session{
+		{ID: "s1", Subject: "a@example.com", IssuedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "s2", Subject: "a@example.com", IssuedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{ID: "s3", Subject: "b@example.com", IssuedAt: now, ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, s := range sessions {
+		if err := reg.Add(ctx, s); err != nil {
+			t.Fatalf("Add(%s) error: %v", s.ID, err)
+		}
+	}
+
+	t.Run("Active", func(t *testing.T) {
+		if ok, err := reg.Active(ctx, "s1"); err != nil || !ok {
+			t.Fatalf("Expected s1 to be active, got ok=%v err=%v", ok, err)
+		}
+		if ok, err := reg.Active(ctx, "unknown"); err != nil || ok {
+			t.Fatalf("Expected unknown session to be inactive, got ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("NotRedeemableAsLoginCode", func(t *testing.T) {
+		if ok, _ := memStore.Verify(ctx, "s3", "s3"); ok {
+			t.Fatal("Expected a registry entry not to verify as a login code")
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		if err := reg.Remove(ctx, "s1"); err != nil {
+			t.Fatalf("Remove() error: %v", err)
+		}
+		if ok, _ := reg.Active(ctx, "s1"); ok {
+			t.Fatal("Expected s1 to be inactive after Remove")
+		}
+	})
+
+	t.Run("RemoveAll", func(t *testing.T) {
+		n, err := reg.RemoveAll(ctx, "a@example.com")
+		if err != nil {
+			t.Fatalf("RemoveAll() error: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("Expected 1 remaining session removed, got %d", n)
+		}
+		if ok, _ := reg.Active(ctx, "s2"); ok {
+			t.Error("Expected s2 to be inactive after RemoveAll")
+		}
+		if ok, _ := reg.Active(ctx, "s3"); !ok {
+			t.Error("Expected another subject's session to remain active")
+		}
+	})
+}
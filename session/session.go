@@ -0,0 +1,59 @@
+// Package session issues, validates, refreshes and revokes the sessions that
+// follow a successful passwordless login.
+//
+// The Manager hands the verified recipient to an Issuer (see
+// Manager.VerifyLoginSession). JWTIssuer is the default implementation: it
+// produces HMAC-signed JWTs using only the standard library and, when given a
+// Registry, records every session server-side so it can be revoked before it
+// expires.
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Errors returned when a session token is rejected.
+var (
+	// ErrInvalidSession is returned for tokens that are malformed or carry a bad signature.
+	ErrInvalidSession = errors.New("invalid session")
+
+	// ErrSessionExpired is returned for correctly signed tokens past their expiry.
+	ErrSessionExpired = errors.New("session expired")
+
+	// ErrSessionRevoked is returned for tokens whose session was removed from the registry.
+	ErrSessionRevoked = errors.New("session revoked")
+
+	// ErrNoRegistry is returned by Revoke when the issuer has no Registry to revoke against.
+	ErrNoRegistry = errors.New("session revocation requires a registry")
+
+	// ErrNoSession is returned by ReadCookie when the request carries no session cookie.
+	ErrNoSession = errors.New("no session cookie")
+)
+
+// Session is an authenticated session for a verified recipient.
+type Session struct {
+	ID        string    // Unique session identifier (the JWT "jti" claim)
+	Subject   string    // The verified recipient
+	IssuedAt  time.Time // When the session was issued
+	ExpiresAt time.Time // When the session stops being valid
+	Token     string    // Signed token to hand to the client (e.g. in a cookie)
+}
+
+// Issuer creates and checks sessions.
+type Issuer interface {
+	// Issue creates a new session for subject.
+	Issue(ctx context.Context, subject string) (*Session, error)
+
+	// Validate parses token and returns its session if it is authentic,
+	// unexpired and not revoked.
+	Validate(ctx context.Context, token string) (*Session, error)
+
+	// Refresh validates token and replaces it with a new session for the same
+	// subject. The old session is revoked if revocation is supported.
+	Refresh(ctx context.Context, token string) (*Session, error)
+
+	// Revoke invalidates the session identified by token.
+	Revoke(ctx context.Context, token string) error
+}
@@ -0,0 +1,73 @@
+package passwordless_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/session"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+func TestVerifyLoginSession(t *testing.T) {
+	ctx := context.Background()
+	issuer := session.NewJWTIssuer([]byte("0123456789abcdef0123456789abcdef"), time.Hour)
+
+	t.Run("Code", func(t *testing.T) {
+		tt := &TestTransport{}
+		cfg := passwordless.DefaultConfig()
+		cfg.SessionIssuer = issuer
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), tt, cfg)
+
+		tokenID, err := mgr.StartLogin(ctx, "session@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+
+		if _, err := mgr.VerifyLoginSession(ctx, tokenID, "wrong"); !errors.Is(err, passwordless.ErrInvalidCode) {
+			t.Fatalf("Expected ErrInvalidCode, got %v", err)
+		}
+
+		s, err := mgr.VerifyLoginSession(ctx, tokenID, tt.LastCode)
+		if err != nil {
+			t.Fatalf("VerifyLoginSession returned error: %v", err)
+		}
+		if s.Subject != "session@example.com" {
+			t.Errorf("Expected session for session@example.com, got %q", s.Subject)
+		}
+		if _, err := issuer.Validate(ctx, s.Token); err != nil {
+			t.Errorf("Expected issued session to validate, got %v", err)
+		}
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		cfg := passwordless.DefaultConfig()
+		cfg.SessionIssuer = issuer
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), &TestTransport{}, cfg)
+
+		link, err := mgr.GenerateLoginLink(ctx, "link@example.com", "https://example.com/login")
+		if err != nil {
+			t.Fatalf("GenerateLoginLink returned error: %v", err)
+		}
+		u, _ := url.Parse(link)
+
+		s, err := mgr.VerifyLoginLinkSession(ctx, u.Query().Get(passwordless.LinkTokenParam), u.Query().Get(passwordless.LinkSecretParam))
+		if err != nil {
+			t.Fatalf("VerifyLoginLinkSession returned error: %v", err)
+		}
+		if s.Subject != "link@example.com" {
+			t.Errorf("Expected session for link@example.com, got %q", s.Subject)
+		}
+	})
+
+	t.Run("NoIssuer", func(t *testing.T) {
+		mgr := passwordless.NewManager(store.NewMemStore(), &TestTransport{})
+
+		if _, err := mgr.VerifyLoginSession(ctx, "any", "123456"); !errors.Is(err, passwordless.ErrNoSessionIssuer) {
+			t.Fatalf("Expected ErrNoSessionIssuer, got %v", err)
+		}
+	})
+}
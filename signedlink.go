@@ -0,0 +1,65 @@
+package passwordless
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/metrics"
+	"github.com/rlnorthcutt/go-passwordless/session"
+	"github.com/rlnorthcutt/go-passwordless/signedlink"
+)
+
+// GenerateSignedLink creates a stateless login link for recipient through
+// Config.LinkSigner. Unlike GenerateLoginLink, nothing is written to the token
+// store: the link carries its own signed payload.
+func (m *Manager) GenerateSignedLink(ctx context.Context, recipient, baseURL string) (string, error) {
+	if m.Config.LinkSigner == nil {
+		return "", ErrNoLinkSigner
+	}
+	if err := m.checkStartLimits(ctx, recipient); err != nil {
+		return "", err
+	}
+
+	link, err := m.Config.LinkSigner.Link(baseURL, recipient, signedlink.PurposeLogin)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign login link: %w", err)
+	}
+	m.emit(ctx, Event{Type: EventLoginStarted, Recipient: recipient})
+	return link, nil
+}
+
+// VerifySignedLink validates a token produced by GenerateSignedLink (the
+// signedlink.QueryParam value of the link) and returns the recipient it was
+// issued to. If the signer has a replay cache, the link can only be used once.
+func (m *Manager) VerifySignedLink(ctx context.Context, token string) (string, error) {
+	if m.Config.LinkSigner == nil {
+		return "", ErrNoLinkSigner
+	}
+	defer m.observeDuration(metrics.VerificationDuration, time.Now())
+
+	ctx, span := m.tracer().Start(ctx, spanVerify)
+	c, err := m.Config.LinkSigner.Verify(ctx, token, signedlink.PurposeLogin)
+	span.End(err)
+
+	if err != nil {
+		m.emitVerifyResult(ctx, "", nil, err)
+		return "", err
+	}
+	m.emit(ctx, Event{Type: EventVerifySucceeded, Recipient: c.Recipient})
+	return c.Recipient, nil
+}
+
+// VerifySignedLinkSession verifies a signed link like VerifySignedLink and, on
+// success, issues a session for its recipient through Config.SessionIssuer.
+func (m *Manager) VerifySignedLinkSession(ctx context.Context, token string) (*session.Session, error) {
+	if m.Config.SessionIssuer == nil {
+		return nil, ErrNoSessionIssuer
+	}
+
+	recipient, err := m.VerifySignedLink(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return m.Config.SessionIssuer.Issue(ctx, recipient)
+}
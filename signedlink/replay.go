@@ -0,0 +1,128 @@
+package signedlink
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// ReplayCache remembers which link nonces have been redeemed.
+type ReplayCache interface {
+	// Use records nonce as redeemed until expiresAt. It returns ErrLinkUsed
+	// if the nonce was already recorded.
+	Use(ctx context.Context, nonce string, expiresAt time.Time) error
+}
+
+// memReplaySweepInterval is how often MemReplayCache drops expired nonces.
+const memReplaySweepInterval = time.Minute
+
+// MemReplayCache is an in-memory ReplayCache. Entries are dropped once the
+// link they belong to has expired, so the cache stays small. State is local
+// to the process.
+type MemReplayCache struct {
+	Clock clock.Clock // Optional; defaults to the real clock
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemReplayCache creates an empty in-memory replay cache.
+func NewMemReplayCache() *MemReplayCache {
+	return &MemReplayCache{
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Use records nonce unless it is already present.
+func (c *MemReplayCache) Use(ctx context.Context, nonce string, expiresAt time.Time) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := clock.Or(c.Clock).Now()
+	c.sweep(now)
+
+	if c.nonces == nil {
+		c.nonces = make(map[string]time.Time)
+	}
+	// An expired entry may outlive its link until the next sweep
+	if exp, ok := c.nonces[nonce]; ok && now.Before(exp) {
+		return ErrLinkUsed
+	}
+	c.nonces[nonce] = expiresAt
+	return nil
+}
+
+// sweep drops nonces whose links have expired. It runs at most once per
+// memReplaySweepInterval, so Use does not scan every nonce on each call.
+func (c *MemReplayCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < memReplaySweepInterval {
+		return
+	}
+	c.lastSweep = now
+	for n, exp := range c.nonces {
+		if !now.Before(exp) {
+			delete(c.nonces, n)
+		}
+	}
+}
+
+// DbReplayCache is a SQL-backed ReplayCache shared by every application
+// instance, like DbStore. See replay_cache_sample.sql for the schema.
+type DbReplayCache struct {
+	DB        *sql.DB       // Reference to the database connection
+	TableName string        // Name of the table used to record nonces; must be a plain identifier
+	Dialect   store.Dialect // Placeholder syntax of DB; defaults to store.DialectSQLite
+	Clock     clock.Clock   // Optional; defaults to the real clock
+}
+
+// NewDbReplayCache initializes a DbReplayCache recording nonces in tableName.
+func NewDbReplayCache(db *sql.DB, tableName string) *DbReplayCache {
+	return &DbReplayCache{
+		DB:        db,
+		TableName: tableName,
+	}
+}
+
+// Use inserts nonce. The primary key on the nonce column rejects a second
+// insert, so only one concurrent request can redeem a link.
+func (c *DbReplayCache) Use(ctx context.Context, nonce string, expiresAt time.Time) error {
+	if err := store.ValidateTableName(c.TableName); err != nil {
+		return err
+	}
+	now := clock.Or(c.Clock).Now()
+
+	// Drop nonces whose links can no longer be presented anyway.
+	query := c.query(`DELETE FROM %s WHERE expires_at <= ?`)
+	if _, err := c.DB.ExecContext(ctx, query, now.UnixNano()); err != nil {
+		return fmt.Errorf("failed to prune link nonces: %w", err)
+	}
+
+	query = c.query(`INSERT INTO %s (nonce, expires_at) VALUES (?, ?)`)
+	if _, err := c.DB.ExecContext(ctx, query, nonce, expiresAt.UnixNano()); err != nil {
+		var count int
+		query = c.query(`SELECT COUNT(*) FROM %s WHERE nonce = ?`)
+		if qerr := c.DB.QueryRowContext(ctx, query, nonce).Scan(&count); qerr == nil && count > 0 {
+			return ErrLinkUsed
+		}
+		return fmt.Errorf("failed to record link nonce: %w", err)
+	}
+	return nil
+}
+
+// query formats a statement written with %s for the table name and ?
+// placeholders for the cache's table and dialect.
+func (c *DbReplayCache) query(format string) string {
+	return c.Dialect.Rebind(fmt.Sprintf(format, c.TableName))
+}
@@ -0,0 +1,8 @@
+-- Schema for DbReplayCache on SQLite and PostgreSQL. On MySQL, drop IF NOT
+-- EXISTS from the CREATE INDEX statement. expires_at holds Unix nanoseconds.
+CREATE TABLE IF NOT EXISTS passwordless_link_nonces (
+	nonce VARCHAR(255) PRIMARY KEY,
+	expires_at BIGINT NOT NULL
+  );
+
+CREATE INDEX IF NOT EXISTS passwordless_link_nonces_expires ON passwordless_link_nonces (expires_at);
@@ -0,0 +1,102 @@
+package signedlink_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/signedlink"
+	"github.com/rlnorthcutt/go-passwordless/store"
+	_ "modernc.org/sqlite"
+)
+
+func TestReplayCaches(t *testing.T) {
+	dbFile := "test_link_nonces.db"
+
+	// Cleanup before and after the test
+	os.Remove(dbFile)
+	defer os.Remove(dbFile)
+
+	db, err := sql.Open("sqlite", dbFile)
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+
+	sqlScript, err := os.ReadFile("replay_cache_sample.sql")
+	if err != nil {
+		t.Fatalf("Failed to read SQL file: %v", err)
+	}
+	if _, err := db.Exec(string(sqlScript)); err != nil {
+		t.Fatalf("Failed to create nonce table: %v", err)
+	}
+
+	memClk := clocktest.NewFake(time.Now())
+	mem := signedlink.NewMemReplayCache()
+	mem.Clock = memClk
+
+	dbClk := clocktest.NewFake(time.Now())
+	dbCache := signedlink.NewDbReplayCache(db, "passwordless_link_nonces")
+	dbCache.Clock = dbClk
+
+	caches := map[string]struct {
+		cache signedlink.ReplayCache
+		clk   *clocktest.Fake
+	}{
+		"MemReplayCache": {mem, memClk},
+		"DbReplayCache":  {dbCache, dbClk},
+	}
+
+	for name, tc := range caches {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			expiresAt := tc.clk.Now().Add(time.Minute)
+
+			if err := tc.cache.Use(ctx, "nonce-1", expiresAt); err != nil {
+				t.Fatalf("First Use() error: %v", err)
+			}
+			if err := tc.cache.Use(ctx, "nonce-1", expiresAt); !errors.Is(err, signedlink.ErrLinkUsed) {
+				t.Fatalf("Expected ErrLinkUsed, got %v", err)
+			}
+			if err := tc.cache.Use(ctx, "nonce-2", expiresAt); err != nil {
+				t.Fatalf("Expected a different nonce to be accepted, got %v", err)
+			}
+
+			// Once the link has expired its nonce is forgotten.
+			tc.clk.Advance(2 * time.Minute)
+			if err := tc.cache.Use(ctx, "nonce-1", tc.clk.Now().Add(time.Minute)); err != nil {
+				t.Errorf("Expected expired nonce to be pruned, got %v", err)
+			}
+		})
+	}
+
+	// Between sweeps an expired nonce is still in the map but must not block
+	// its nonce; a struct literal works without the constructor.
+	t.Run("ExpiredBeforeSweep", func(t *testing.T) {
+		ctx := context.Background()
+		clk := clocktest.NewFake(time.Now())
+		cache := &signedlink.MemReplayCache{Clock: clk}
+
+		if err := cache.Use(ctx, "short", clk.Now().Add(10*time.Second)); err != nil {
+			t.Fatalf("First Use() error: %v", err)
+		}
+		clk.Advance(11 * time.Second)
+		if err := cache.Use(ctx, "short", clk.Now().Add(10*time.Second)); err != nil {
+			t.Errorf("Expected an expired nonce to be accepted before the next sweep, got %v", err)
+		}
+		if err := cache.Use(ctx, "short", clk.Now().Add(10*time.Second)); !errors.Is(err, signedlink.ErrLinkUsed) {
+			t.Errorf("Expected ErrLinkUsed for the re-recorded nonce, got %v", err)
+		}
+	})
+
+	t.Run("InvalidTableName", func(t *testing.T) {
+		bad := signedlink.NewDbReplayCache(db, "nonces; DROP TABLE users")
+		if err := bad.Use(context.Background(), "nonce", time.Now().Add(time.Minute)); !errors.Is(err, store.ErrInvalidTableName) {
+			t.Errorf("Expected ErrInvalidTableName, got %v", err)
+		}
+	})
+}
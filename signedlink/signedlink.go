@@ -0,0 +1,243 @@
+// Package signedlink creates and verifies stateless magic links.
+//
+// Instead of pointing at a token held in a TokenStore, a signed link carries
+// its own payload (recipient, purpose, expiry and a random nonce) protected
+// by an HMAC-SHA256 signature and, optionally, AES-GCM encryption. Any
+// instance that knows the secret can verify it. Single-use enforcement is
+// optional and handled by a small ReplayCache keyed on the nonce.
+package signedlink
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// MinSecretLength is the shortest HMAC secret Signer accepts.
+const MinSecretLength = 32
+
+// QueryParam is the URL query parameter Link stores the signed token in.
+const QueryParam = "link"
+
+// PurposeLogin is the purpose used by the Manager for login links.
+const PurposeLogin = "login"
+
+// Errors returned when a link is rejected.
+var (
+	// ErrInvalidLink is returned for links that are malformed, carry a bad
+	// signature or were issued for a different purpose.
+	ErrInvalidLink = errors.New("invalid signed link")
+
+	// ErrLinkExpired is returned for authentic links past their expiry. It is
+	// store.ErrTokenExpired, so callers can treat both kinds of link alike.
+	ErrLinkExpired = store.ErrTokenExpired
+
+	// ErrLinkUsed is returned by a ReplayCache for a nonce that was already
+	// redeemed. It is store.ErrTokenConsumed.
+	ErrLinkUsed = store.ErrTokenConsumed
+)
+
+// Claims are the contents of a verified link.
+type Claims struct {
+	Recipient string    // The recipient the link was issued to
+	Purpose   string    // What the link may be used for (e.g. PurposeLogin)
+	Nonce     string    // Random value unique to this link
+	IssuedAt  time.Time // When the link was signed
+	ExpiresAt time.Time // When the link stops being valid
+}
+
+// payload is the serialized form of Claims.
+type payload struct {
+	Recipient string `json:"sub"`
+	Purpose   string `json:"pur"`
+	Nonce     string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Signer signs and verifies links.
+type Signer struct {
+	Secret        []byte        // HMAC key, at least MinSecretLength bytes
+	EncryptionKey []byte        // Optional AES key (16, 24 or 32 bytes); hides the payload when set
+	TTL           time.Duration // Lifetime of signed links
+	Replay        ReplayCache   // Optional; makes every link single-use when set
+	Clock         clock.Clock   // Optional; defaults to the real clock
+}
+
+// NewSigner creates a Signer signing with secret and issuing links valid for ttl.
+func NewSigner(secret []byte, ttl time.Duration) *Signer {
+	return &Signer{
+		Secret: secret,
+		TTL:    ttl,
+	}
+}
+
+// Sign returns a token for recipient that is valid for purpose until TTL elapses.
+func (s *Signer) Sign(recipient, purpose string) (string, error) {
+	if len(s.Secret) < MinSecretLength {
+		return "", fmt.Errorf("link secret must be at least %d bytes", MinSecretLength)
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate link nonce: %w", err)
+	}
+
+	now := clock.Or(s.Clock).Now()
+	body, err := json.Marshal(payload{
+		Recipient: recipient,
+		Purpose:   purpose,
+		Nonce:     hex.EncodeToString(nonce),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.TTL).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode link payload: %w", err)
+	}
+
+	if s.EncryptionKey != nil {
+		if body, err = s.seal(body); err != nil {
+			return "", err
+		}
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(body)
+	return encoded + "." + s.sign(encoded), nil
+}
+
+// Link signs a token for recipient and purpose and appends it to baseURL as QueryParam.
+func (s *Signer) Link(baseURL, recipient, purpose string) (string, error) {
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	token, err := s.Sign(recipient, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	query := parsedURL.Query()
+	query.Set(QueryParam, token)
+	parsedURL.RawQuery = query.Encode()
+
+	return parsedURL.String(), nil
+}
+
+// Verify checks the signature, purpose and expiry of token and, if a replay
+// cache is set, marks its nonce as used so the link cannot be redeemed again.
+func (s *Signer) Verify(ctx context.Context, token, purpose string) (*Claims, error) {
+	c, err := s.parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Purpose != purpose {
+		return nil, ErrInvalidLink
+	}
+	if !clock.Or(s.Clock).Now().Before(c.ExpiresAt) {
+		return nil, ErrLinkExpired
+	}
+
+	if s.Replay != nil {
+		if err := s.Replay.Use(ctx, c.Nonce, c.ExpiresAt); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// parse verifies the signature of token and decodes its payload without
+// checking purpose, expiry or replay.
+func (s *Signer) parse(token string) (*Claims, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok || len(s.Secret) < MinSecretLength {
+		return nil, ErrInvalidLink
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(s.sign(encoded))) {
+		return nil, ErrInvalidLink
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	if s.EncryptionKey != nil {
+		if body, err = s.open(body); err != nil {
+			return nil, ErrInvalidLink
+		}
+	}
+
+	var p payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, errors.Join(ErrInvalidLink, err)
+	}
+	if p.Recipient == "" || p.Nonce == "" {
+		return nil, ErrInvalidLink
+	}
+
+	return &Claims{
+		Recipient: p.Recipient,
+		Purpose:   p.Purpose,
+		Nonce:     p.Nonce,
+		IssuedAt:  time.Unix(p.IssuedAt, 0),
+		ExpiresAt: time.Unix(p.ExpiresAt, 0),
+	}, nil
+}
+
+// sign returns the encoded HMAC-SHA256 signature of encoded.
+func (s *Signer) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// seal encrypts body with AES-GCM, prefixing the random GCM nonce.
+func (s *Signer) seal(body []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, body, nil), nil
+}
+
+// open reverses seal.
+func (s *Signer) open(sealed []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidLink
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// aead returns an AES-GCM cipher keyed with EncryptionKey.
+func (s *Signer) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
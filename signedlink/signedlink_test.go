@@ -0,0 +1,153 @@
+package signedlink_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/signedlink"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSigner(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("SignAndVerify", func(t *testing.T) {
+		s := signedlink.NewSigner(testSecret, 15*time.Minute)
+
+		token, err := s.Sign("user@example.com", signedlink.PurposeLogin)
+		if err != nil {
+			t.Fatalf("Sign() error: %v", err)
+		}
+
+		c, err := s.Verify(ctx, token, signedlink.PurposeLogin)
+		if err != nil {
+			t.Fatalf("Verify() error: %v", err)
+		}
+		if c.Recipient != "user@example.com" || c.Nonce == "" {
+			t.Errorf("Expected recipient and nonce to round-trip, got %+v", c)
+		}
+
+		// Without a replay cache the link may be used repeatedly.
+		if _, err := s.Verify(ctx, token, signedlink.PurposeLogin); err != nil {
+			t.Errorf("Expected second Verify to succeed without a replay cache, got %v", err)
+		}
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		s := signedlink.NewSigner(testSecret, 15*time.Minute)
+
+		link, err := s.Link("https://example.com/login?next=/home", "user@example.com", signedlink.PurposeLogin)
+		if err != nil {
+			t.Fatalf("Link() error: %v", err)
+		}
+		u, _ := url.Parse(link)
+		if u.Query().Get("next") != "/home" {
+			t.Errorf("Expected existing query parameters to be kept, got %q", link)
+		}
+		if _, err := s.Verify(ctx, u.Query().Get(signedlink.QueryParam), signedlink.PurposeLogin); err != nil {
+			t.Errorf("Expected link token to verify, got %v", err)
+		}
+	})
+
+	t.Run("ShortSecret", func(t *testing.T) {
+		s := signedlink.NewSigner([]byte("short"), time.Minute)
+		if _, err := s.Sign("user@example.com", signedlink.PurposeLogin); err == nil {
+			t.Fatal("Expected an error for a short secret")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		clk := clocktest.NewFake(time.Now())
+		s := signedlink.NewSigner(testSecret, time.Minute)
+		s.Clock = clk
+
+		token, _ := s.Sign("user@example.com", signedlink.PurposeLogin)
+		clk.Advance(2 * time.Minute)
+
+		_, err := s.Verify(ctx, token, signedlink.PurposeLogin)
+		if !errors.Is(err, signedlink.ErrLinkExpired) || !errors.Is(err, store.ErrTokenExpired) {
+			t.Fatalf("Expected ErrLinkExpired, got %v", err)
+		}
+	})
+
+	t.Run("WrongPurpose", func(t *testing.T) {
+		s := signedlink.NewSigner(testSecret, time.Minute)
+		token, _ := s.Sign("user@example.com", "email-change")
+
+		if _, err := s.Verify(ctx, token, signedlink.PurposeLogin); !errors.Is(err, signedlink.ErrInvalidLink) {
+			t.Fatalf("Expected ErrInvalidLink, got %v", err)
+		}
+	})
+
+	t.Run("RejectsTampering", func(t *testing.T) {
+		s := signedlink.NewSigner(testSecret, time.Minute)
+		token, _ := s.Sign("user@example.com", signedlink.PurposeLogin)
+		_, sig, _ := strings.Cut(token, ".")
+
+		forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin@example.com","pur":"login","jti":"x","iat":0,"exp":99999999999}`))
+		other := signedlink.NewSigner([]byte("ffffffffffffffffffffffffffffffff"), time.Minute)
+		otherToken, _ := other.Sign("user@example.com", signedlink.PurposeLogin)
+
+		cases := map[string]string{
+			"Garbage":      "not-a-token",
+			"ForgedBody":   forged + "." + sig,
+			"OtherSecret":  otherToken,
+			"MissingSig":   strings.TrimSuffix(token, sig),
+			"TruncatedSig": token[:len(token)-2],
+		}
+		for name, tok := range cases {
+			if _, err := s.Verify(ctx, tok, signedlink.PurposeLogin); !errors.Is(err, signedlink.ErrInvalidLink) {
+				t.Errorf("%s: expected ErrInvalidLink, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		s := signedlink.NewSigner(testSecret, time.Minute)
+		s.EncryptionKey = []byte("fedcba9876543210fedcba9876543210")
+
+		token, err := s.Sign("secret@example.com", signedlink.PurposeLogin)
+		if err != nil {
+			t.Fatalf("Sign() error: %v", err)
+		}
+		encoded, _, _ := strings.Cut(token, ".")
+		body, _ := base64.RawURLEncoding.DecodeString(encoded)
+		if strings.Contains(string(body), "secret@example.com") {
+			t.Fatal("Expected the recipient to be hidden in an encrypted link")
+		}
+
+		c, err := s.Verify(ctx, token, signedlink.PurposeLogin)
+		if err != nil {
+			t.Fatalf("Verify() error: %v", err)
+		}
+		if c.Recipient != "secret@example.com" {
+			t.Errorf("Expected recipient to round-trip, got %q", c.Recipient)
+		}
+
+		plain := signedlink.NewSigner(testSecret, time.Minute)
+		if _, err := plain.Verify(ctx, token, signedlink.PurposeLogin); !errors.Is(err, signedlink.ErrInvalidLink) {
+			t.Errorf("Expected a signer without the key to reject the link, got %v", err)
+		}
+	})
+
+	t.Run("SingleUse", func(t *testing.T) {
+		s := signedlink.NewSigner(testSecret, time.Minute)
+		s.Replay = signedlink.NewMemReplayCache()
+
+		token, _ := s.Sign("user@example.com", signedlink.PurposeLogin)
+		if _, err := s.Verify(ctx, token, signedlink.PurposeLogin); err != nil {
+			t.Fatalf("First Verify() error: %v", err)
+		}
+		if _, err := s.Verify(ctx, token, signedlink.PurposeLogin); !errors.Is(err, signedlink.ErrLinkUsed) {
+			t.Fatalf("Expected ErrLinkUsed on second use, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package passwordless_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/session"
+	"github.com/rlnorthcutt/go-passwordless/signedlink"
+)
+
+func TestSignedLinkFlow(t *testing.T) {
+	ctx := context.Background()
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	newManager := func() *passwordless.Manager {
+		signer := signedlink.NewSigner(secret, 15*time.Minute)
+		signer.Replay = signedlink.NewMemReplayCache()
+
+		cfg := passwordless.DefaultConfig()
+		cfg.LinkSigner = signer
+		cfg.SessionIssuer = session.NewJWTIssuer(secret, time.Hour)
+		// No token store: signed links never touch it.
+		return passwordless.NewManagerWithConfig(nil, &TestTransport{}, cfg)
+	}
+
+	t.Run("VerifySignedLink", func(t *testing.T) {
+		mgr := newManager()
+
+		link, err := mgr.GenerateSignedLink(ctx, "edge@example.com", "https://example.com/login")
+		if err != nil {
+			t.Fatalf("GenerateSignedLink returned error: %v", err)
+		}
+		u, _ := url.Parse(link)
+		token := u.Query().Get(signedlink.QueryParam)
+
+		recipient, err := mgr.VerifySignedLink(ctx, token)
+		if err != nil {
+			t.Fatalf("VerifySignedLink returned error: %v", err)
+		}
+		if recipient != "edge@example.com" {
+			t.Errorf("Expected recipient edge@example.com, got %q", recipient)
+		}
+
+		if _, err := mgr.VerifySignedLink(ctx, token); !errors.Is(err, passwordless.ErrTokenConsumed) {
+			t.Errorf("Expected ErrTokenConsumed on reuse, got %v", err)
+		}
+	})
+
+	t.Run("Session", func(t *testing.T) {
+		mgr := newManager()
+
+		link, _ := mgr.GenerateSignedLink(ctx, "edge@example.com", "https://example.com/login")
+		u, _ := url.Parse(link)
+
+		s, err := mgr.VerifySignedLinkSession(ctx, u.Query().Get(signedlink.QueryParam))
+		if err != nil {
+			t.Fatalf("VerifySignedLinkSession returned error: %v", err)
+		}
+		if s.Subject != "edge@example.com" {
+			t.Errorf("Expected session for edge@example.com, got %q", s.Subject)
+		}
+	})
+
+	t.Run("NoSigner", func(t *testing.T) {
+		mgr := passwordless.NewManager(nil, &TestTransport{})
+
+		if _, err := mgr.GenerateSignedLink(ctx, "edge@example.com", "https://example.com/login"); !errors.Is(err, passwordless.ErrNoLinkSigner) {
+			t.Fatalf("Expected ErrNoLinkSigner, got %v", err)
+		}
+	})
+}
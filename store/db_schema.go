@@ -0,0 +1,283 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+)
+
+// LatestSchemaVersion is the schema version Migrate brings a DbStore table to.
+// It equals len(dbMigrations).
+const LatestSchemaVersion = 3
+
+// maxTableNameLen leaves room for the suffixes of the index and version table
+// names within the 63-character identifier limit of PostgreSQL.
+const maxTableNameLen = 48
+
+// ErrInvalidTableName is returned when the table name of DbStore (or of
+// ratelimit.DbLimiter and signedlink.DbReplayCache) is not a plain SQL
+// identifier. The name is interpolated into every statement, so anything else
+// is refused rather than quoted.
+var ErrInvalidTableName = errors.New("invalid table name")
+
+// dbMigration upgrades a token table by one schema version.
+type dbMigration struct {
+	// columns lists the columns the migration adds. When Migrate adopts a
+	// table it has not versioned before, a migration whose columns all exist
+	// already is recorded without running.
+	columns    []string
+	statements func(d Dialect, table string) []string
+}
+
+// dbMigrations[i] upgrades a table from schema version i to i+1. Published
+// entries must never change; add a new one instead and keep schemaStatements
+// in step with the result.
+var dbMigrations = []dbMigration{
+	{statements: baselineStatements}, // 1: token table with indexes on recipient and expires_at
+	{columns: []string{"resend_count", "last_sent_at"}, statements: resendStatements}, // 2: resend bookkeeping
+	{columns: []string{"link_hash"}, statements: linkHashStatements},                  // 3: magic link secrets
+}
+
+// baselineStatements creates the original token table, as shipped in the
+// first db_store_sample.sql, if it does not already exist.
+func baselineStatements(d Dialect, table string) []string {
+	if d == DialectMySQL {
+		// MySQL has no CREATE INDEX IF NOT EXISTS, so the indexes are part of
+		// the table definition.
+		return []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	id VARCHAR(255) NOT NULL PRIMARY KEY,
+	recipient VARCHAR(320) NOT NULL,
+	code_hash VARBINARY(255) NOT NULL,
+	expires_at DATETIME(6) NOT NULL,
+	created_at DATETIME(6) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	INDEX %[2]s_recipient (recipient),
+	INDEX %[2]s_expires_at (expires_at)
+)`, table, indexPrefix(table))}
+	}
+
+	bytes, ts := d.bytesType(), d.timestampType()
+	return append([]string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id TEXT PRIMARY KEY,
+	recipient TEXT NOT NULL,
+	code_hash %s NOT NULL,
+	expires_at %[3]s NOT NULL,
+	created_at %[3]s NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0
+)`, table, bytes, ts)}, indexStatements(d, table)...)
+}
+
+// resendStatements adds the resend counter and the time the code was last
+// sent, which existing rows take from created_at.
+func resendStatements(d Dialect, table string) []string {
+	integer := "INTEGER"
+	if d == DialectMySQL {
+		integer = "INT"
+	}
+	return []string{
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN resend_count %s NOT NULL DEFAULT 0`, table, integer),
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN last_sent_at %s NULL`, table, d.timestampType()),
+		fmt.Sprintf(`UPDATE %s SET last_sent_at = created_at WHERE last_sent_at IS NULL`, table),
+	}
+}
+
+// linkHashStatements adds the optional hash of the magic link secret.
+func linkHashStatements(d Dialect, table string) []string {
+	return []string{fmt.Sprintf(`ALTER TABLE %s ADD COLUMN link_hash %s NULL`, table, d.bytesType())}
+}
+
+// schemaStatements creates the latest schema if it does not already exist.
+// last_sent_at is nullable because migrated tables cannot add it as NOT NULL;
+// DbStore reads a missing value as created_at.
+func schemaStatements(d Dialect, table string) []string {
+	if d == DialectMySQL {
+		return []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	id VARCHAR(255) NOT NULL PRIMARY KEY,
+	recipient VARCHAR(320) NOT NULL,
+	code_hash VARBINARY(255) NOT NULL,
+	expires_at DATETIME(6) NOT NULL,
+	created_at DATETIME(6) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	resend_count INT NOT NULL DEFAULT 0,
+	last_sent_at DATETIME(6) NULL,
+	link_hash VARBINARY(255) NULL,
+	INDEX %[2]s_recipient (recipient),
+	INDEX %[2]s_expires_at (expires_at)
+)`, table, indexPrefix(table))}
+	}
+
+	bytes, ts := d.bytesType(), d.timestampType()
+	return append([]string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id TEXT PRIMARY KEY,
+	recipient TEXT NOT NULL,
+	code_hash %[2]s NOT NULL,
+	expires_at %[3]s NOT NULL,
+	created_at %[3]s NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	resend_count INTEGER NOT NULL DEFAULT 0,
+	last_sent_at %[3]s NULL,
+	link_hash %[2]s NULL
+)`, table, bytes, ts)}, indexStatements(d, table)...)
+}
+
+// indexStatements creates the indexes on recipient and expires_at for SQLite
+// and PostgreSQL. PostgreSQL qualifies the indexed table, SQLite the index name.
+func indexStatements(d Dialect, table string) []string {
+	index, indexed := table, indexPrefix(table)
+	if d == DialectPostgres {
+		index, indexed = indexPrefix(table), table
+	}
+	return []string{
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_recipient ON %s (recipient)`, index, indexed),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_expires_at ON %s (expires_at)`, index, indexed),
+	}
+}
+
+// CreateSchema creates the token table and its indexes on recipient and
+// expires_at if they do not exist, without recording a schema version. Use it
+// when schema changes are managed outside this package; otherwise use Migrate.
+func (s *DbStore) CreateSchema(ctx context.Context) error {
+	if err := ValidateTableName(s.TableName); err != nil {
+		return err
+	}
+	for _, stmt := range schemaStatements(s.Dialect, s.TableName) {
+		if _, err := s.DB.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// Migrate brings the token table up to LatestSchemaVersion, creating it if
+// needed, and records each applied version in the <TableName>_schema_version
+// table. It is safe to call on every start-up.
+//
+// A table that Migrate has not versioned before, such as one created from
+// db_store_sample.sql or by CreateSchema, is adopted: migrations whose columns
+// it already has are recorded without running, and the rest are applied.
+//
+// Each version is applied in a transaction, but MySQL commits DDL implicitly,
+// so a failed migration there may need manual cleanup. Run Migrate from one
+// instance at a time; a concurrent run fails on the version table's primary key.
+func (s *DbStore) Migrate(ctx context.Context) error {
+	if err := ValidateTableName(s.TableName); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version INTEGER PRIMARY KEY, applied_at %s NOT NULL)`,
+		s.versionTable(), s.Dialect.timestampType())
+	if _, err := s.DB.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create schema version table: %w", err)
+	}
+
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion {
+		return fmt.Errorf("schema version %d is newer than the latest known version %d", current, LatestSchemaVersion)
+	}
+
+	for v := current + 1; v <= LatestSchemaVersion; v++ {
+		if err := s.applyMigration(ctx, v, current == 0); err != nil {
+			return fmt.Errorf("failed to migrate schema to version %d: %w", v, err)
+		}
+		s.logger().InfoContext(ctx, "schema migrated", "table", s.TableName, "version", v)
+	}
+	return nil
+}
+
+// SchemaVersion returns the newest version recorded by Migrate, or 0 if the
+// version table is empty. It fails if Migrate has never run.
+func (s *DbStore) SchemaVersion(ctx context.Context) (int, error) {
+	if err := ValidateTableName(s.TableName); err != nil {
+		return 0, err
+	}
+	var version int
+	query := fmt.Sprintf(`SELECT COALESCE(MAX(version), 0) FROM %s`, s.versionTable())
+	if err := s.DB.QueryRowContext(ctx, query).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// applyMigration runs the statements for version v and records it. When
+// adopting an unversioned table whose columns for v already exist, it only
+// records the version.
+func (s *DbStore) applyMigration(ctx context.Context, v int, adopt bool) error {
+	m := dbMigrations[v-1]
+	run := !adopt || len(m.columns) == 0 || !s.hasColumns(ctx, m.columns)
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if run {
+		for _, stmt := range m.statements(s.Dialect, s.TableName) {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+	}
+	query := s.Dialect.Rebind(fmt.Sprintf(`INSERT INTO %s (version, applied_at) VALUES (?, ?)`, s.versionTable()))
+	if _, err := tx.ExecContext(ctx, query, v, clock.Or(s.Clock).Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// hasColumns reports whether the token table has all of columns. It selects
+// them from no rows, which fails if any is missing.
+func (s *DbStore) hasColumns(ctx context.Context, columns []string) bool {
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE 1 = 0`, strings.Join(columns, ", "), s.TableName)
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return false
+	}
+	return rows.Close() == nil
+}
+
+func (s *DbStore) versionTable() string {
+	return s.TableName + "_schema_version"
+}
+
+// query formats a statement written with %s for the table name and ?
+// placeholders for the store's table and dialect.
+func (s *DbStore) query(format string) (string, error) {
+	if err := ValidateTableName(s.TableName); err != nil {
+		return "", err
+	}
+	return s.Dialect.Rebind(fmt.Sprintf(format, s.TableName)), nil
+}
+
+// ValidateTableName accepts an identifier of letters, digits and underscores
+// not starting with a digit, optionally qualified by a schema name
+// ("auth.tokens"), and returns an error wrapping ErrInvalidTableName for
+// anything else.
+func ValidateTableName(name string) error {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 || len(parts[len(parts)-1]) > maxTableNameLen {
+		return fmt.Errorf("%w: %q", ErrInvalidTableName, name)
+	}
+	for _, part := range parts {
+		if part == "" || (part[0] >= '0' && part[0] <= '9') {
+			return fmt.Errorf("%w: %q", ErrInvalidTableName, name)
+		}
+		for _, r := range part {
+			if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+				return fmt.Errorf("%w: %q", ErrInvalidTableName, name)
+			}
+		}
+	}
+	return nil
+}
+
+// indexPrefix returns table without its schema qualifier.
+func indexPrefix(table string) string {
+	return table[strings.LastIndex(table, ".")+1:]
+}
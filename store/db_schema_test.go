@@ -0,0 +1,335 @@
+package store_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/store"
+	_ "modernc.org/sqlite"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "tokens.db"))
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// sqliteIndexes returns the names of the indexes SQLite has on table.
+func sqliteIndexes(t *testing.T, db *sql.DB, table string) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL ORDER BY name`, table)
+	if err != nil {
+		t.Fatalf("Failed to list indexes: %v", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestDbStore_Migrate(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	s := store.NewDbStore(db, "tokens")
+
+	for i := 0; i < 2; i++ {
+		if err := s.Migrate(ctx); err != nil {
+			t.Fatalf("Migrate #%d failed: %v", i+1, err)
+		}
+	}
+	if v, err := s.SchemaVersion(ctx); err != nil || v != store.LatestSchemaVersion {
+		t.Errorf("Expected schema version %d, got %d (%v)", store.LatestSchemaVersion, v, err)
+	}
+	var applied int
+	db.QueryRow(`SELECT COUNT(*) FROM tokens_schema_version`).Scan(&applied)
+	if applied != store.LatestSchemaVersion {
+		t.Errorf("Expected each version to be recorded once, got %d rows", applied)
+	}
+	if got := strings.Join(sqliteIndexes(t, db, "tokens"), ","); got != "tokens_expires_at,tokens_recipient" {
+		t.Errorf("Unexpected indexes %q", got)
+	}
+
+	// The migrated table works with the store.
+	hash := sha256.Sum256([]byte("123456"))
+	tok := store.Token{ID: "migrated", Recipient: "m@example.com", CodeHash: hash[:],
+		ExpiresAt: time.Now().Add(time.Minute), CreatedAt: time.Now()}
+	if err := s.Store(ctx, tok); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if _, err := s.VerifyAndConsume(ctx, "migrated", "123456", 3); err != nil {
+		t.Errorf("VerifyAndConsume failed: %v", err)
+	}
+}
+
+func TestDbStore_MigrateAdoptsExistingTable(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	s := store.NewDbStore(db, "tokens")
+
+	if err := s.CreateSchema(ctx); err != nil {
+		t.Fatalf("CreateSchema failed: %v", err)
+	}
+	if err := s.CreateSchema(ctx); err != nil {
+		t.Fatalf("CreateSchema is not idempotent: %v", err)
+	}
+	if _, err := s.SchemaVersion(ctx); err == nil {
+		t.Error("Expected SchemaVersion to fail before Migrate has run")
+	}
+
+	hash := sha256.Sum256([]byte("123456"))
+	tok := store.Token{ID: "kept", Recipient: "k@example.com", CodeHash: hash[:],
+		ExpiresAt: time.Now().Add(time.Minute), CreatedAt: time.Now()}
+	if err := s.Store(ctx, tok); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if v, _ := s.SchemaVersion(ctx); v != store.LatestSchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", store.LatestSchemaVersion, v)
+	}
+	if _, err := s.Exists(ctx, "kept"); err != nil {
+		t.Errorf("Expected existing tokens to survive Migrate, got %v", err)
+	}
+}
+
+// baselineSchema is db_store_sample.sql as first published, before the
+// resend and link columns were added.
+const baselineSchema = `CREATE TABLE IF NOT EXISTS passwordless_tokens (
+	id TEXT PRIMARY KEY,
+	recipient TEXT NOT NULL,
+	code_hash BLOB NOT NULL,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0
+  );`
+
+func TestDbStore_MigrateUpgradesBaseline(t *testing.T) {
+	ctx := context.Background()
+	created := time.Now().UTC().Truncate(time.Second)
+	hash := sha256.Sum256([]byte("123456"))
+
+	tests := []struct {
+		name  string
+		extra []string // Statements run after the baseline schema
+	}{
+		{name: "Baseline"},
+		{name: "ManualLinkHash", extra: []string{`ALTER TABLE passwordless_tokens ADD COLUMN link_hash BLOB`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openSQLite(t)
+			for _, stmt := range append([]string{baselineSchema}, tt.extra...) {
+				if _, err := db.Exec(stmt); err != nil {
+					t.Fatalf("Failed to create the old schema: %v", err)
+				}
+			}
+			_, err := db.Exec(`INSERT INTO passwordless_tokens (id, recipient, code_hash, expires_at, created_at, attempts)
+				VALUES (?, ?, ?, ?, ?, ?)`, "old", "o@example.com", hash[:], created.Add(time.Hour), created, 1)
+			if err != nil {
+				t.Fatalf("Failed to insert an old row: %v", err)
+			}
+
+			s := store.NewDbStore(db, "passwordless_tokens")
+			if err := s.Migrate(ctx); err != nil {
+				t.Fatalf("Migrate failed: %v", err)
+			}
+			if v, _ := s.SchemaVersion(ctx); v != store.LatestSchemaVersion {
+				t.Errorf("Expected schema version %d, got %d", store.LatestSchemaVersion, v)
+			}
+
+			// Old rows get the defaults of the new columns.
+			tok, err := s.Exists(ctx, "old")
+			if err != nil {
+				t.Fatalf("Expected the old row to survive Migrate, got %v", err)
+			}
+			if tok.ResendCount != 0 || !tok.LastSentAt.Equal(created) || tok.LinkHash != nil || tok.Attempts != 1 {
+				t.Errorf("Unexpected migrated token %+v", tok)
+			}
+
+			// New rows use every column.
+			secret := sha256.Sum256([]byte("link-secret"))
+			tok.ID, tok.LinkHash, tok.ResendCount = "new", secret[:], 2
+			if err := s.Store(ctx, *tok); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			if err := s.Reissue(ctx, *tok, 2); err != nil {
+				t.Errorf("Reissue failed: %v", err)
+			}
+			if _, err := s.VerifyLinkAndConsume(ctx, "new", "link-secret", 3); err != nil {
+				t.Errorf("VerifyLinkAndConsume failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestDbStore_InvalidTableName(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	for _, name := range []string{
+		"",
+		"tokens; DROP TABLE users",
+		"tokens--",
+		`"tokens"`,
+		"1tokens",
+		"a.b.c",
+		".tokens",
+		strings.Repeat("t", 49),
+	} {
+		s := store.NewDbStore(db, name)
+		if err := s.Migrate(ctx); !errors.Is(err, store.ErrInvalidTableName) {
+			t.Errorf("Migrate(%q): expected ErrInvalidTableName, got %v", name, err)
+		}
+		if err := s.Store(ctx, store.Token{ID: "x"}); !errors.Is(err, store.ErrInvalidTableName) {
+			t.Errorf("Store(%q): expected ErrInvalidTableName, got %v", name, err)
+		}
+		if _, err := s.Exists(ctx, "x"); !errors.Is(err, store.ErrInvalidTableName) {
+			t.Errorf("Exists(%q): expected ErrInvalidTableName, got %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"tokens", "Auth_Tokens2", "main.tokens"} {
+		if err := store.NewDbStore(db, name).CreateSchema(ctx); err != nil {
+			t.Errorf("CreateSchema(%q) failed: %v", name, err)
+		}
+	}
+}
+
+func TestDialect_Placeholder(t *testing.T) {
+	tests := []struct {
+		dialect store.Dialect
+		want    string
+	}{
+		{store.DialectSQLite, "?"},
+		{store.DialectMySQL, "?"},
+		{store.DialectPostgres, "$3"},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.Placeholder(3); got != tt.want {
+			t.Errorf("%v.Placeholder(3) = %q, want %q", tt.dialect, got, tt.want)
+		}
+	}
+
+	if got := store.DialectPostgres.Rebind("DELETE FROM t WHERE a = ? AND b = ?"); got != "DELETE FROM t WHERE a = $1 AND b = $2" {
+		t.Errorf("Unexpected rebound query %q", got)
+	}
+}
+
+func TestDbStore_Dialects(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		dialect store.Dialect
+		insert  string // Substring of the INSERT statement
+		schema  []string
+	}{
+		{
+			dialect: store.DialectPostgres,
+			insert:  "VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+			schema:  []string{"BYTEA", "TIMESTAMPTZ", "CREATE INDEX IF NOT EXISTS tokens_expires_at ON auth.tokens (expires_at)"},
+		},
+		{
+			dialect: store.DialectMySQL,
+			insert:  "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			schema:  []string{"VARBINARY", "DATETIME(6)", "INDEX tokens_recipient (recipient)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect.String(), func(t *testing.T) {
+			rec := &recorder{}
+			db := sql.OpenDB(rec)
+			defer db.Close()
+			s := &store.DbStore{DB: db, TableName: "auth.tokens", Dialect: tt.dialect}
+
+			if err := s.CreateSchema(ctx); err != nil {
+				t.Fatalf("CreateSchema failed: %v", err)
+			}
+			ddl := strings.Join(rec.take(), "\n")
+			for _, want := range tt.schema {
+				if !strings.Contains(ddl, want) {
+					t.Errorf("Expected schema to contain %q, got:\n%s", want, ddl)
+				}
+			}
+
+			if err := s.Store(ctx, store.Token{ID: "x"}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			if err := s.UpdateAttempts(ctx, "x", 1); err != nil {
+				t.Fatalf("UpdateAttempts failed: %v", err)
+			}
+			got := rec.take()
+			if len(got) != 2 || !strings.Contains(got[0], tt.insert) {
+				t.Errorf("Expected INSERT with %q, got %q", tt.insert, got)
+			}
+			if tt.dialect == store.DialectPostgres && !strings.Contains(got[1], "attempts = $1 WHERE id = $2") {
+				t.Errorf("Expected numbered placeholders, got %q", got[1])
+			}
+		})
+	}
+}
+
+// recorder is a database/sql driver that records statements instead of
+// running them. Every Exec affects one row and every query returns no rows.
+type recorder struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	q := r.queries
+	r.queries = nil
+	return q
+}
+
+func (r *recorder) Connect(context.Context) (driver.Conn, error) { return recorderConn{r}, nil }
+func (r *recorder) Driver() driver.Driver                        { return nil }
+
+type recorderConn struct{ r *recorder }
+
+func (c recorderConn) Prepare(query string) (driver.Stmt, error) {
+	c.r.mu.Lock()
+	c.r.queries = append(c.r.queries, query)
+	c.r.mu.Unlock()
+	return recorderStmt{}, nil
+}
+func (c recorderConn) Close() error              { return nil }
+func (c recorderConn) Begin() (driver.Tx, error) { return recorderTx{}, nil }
+
+type recorderTx struct{}
+
+func (recorderTx) Commit() error   { return nil }
+func (recorderTx) Rollback() error { return nil }
+
+type recorderStmt struct{}
+
+func (recorderStmt) Close() error                               { return nil }
+func (recorderStmt) NumInput() int                              { return -1 }
+func (recorderStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (recorderStmt) Query([]driver.Value) (driver.Rows, error)  { return recorderRows{}, nil }
+
+type recorderRows struct{}
+
+func (recorderRows) Columns() []string         { return nil }
+func (recorderRows) Close() error              { return nil }
+func (recorderRows) Next([]driver.Value) error { return io.EOF }
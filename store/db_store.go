@@ -5,12 +5,24 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/logging"
 )
 
-// DbStore represents a database-backed token store.
+// maxVerifyRetries bounds how often VerifyAndConsume re-reads a token after
+// losing a race with a concurrent update.
+const maxVerifyRetries = 16
+
+// DbStore represents a database-backed token store. Create its table with
+// Migrate or CreateSchema, or from db_store_sample.sql.
 type DbStore struct {
-	DB        *sql.DB // Reference to the database connection
-	TableName string  // Name of the table used to store tokens
+	DB        *sql.DB      // Reference to the database connection
+	TableName string       // Name of the table used to store tokens; a plain identifier, optionally schema-qualified
+	Dialect   Dialect      // Optional; SQL flavour of DB (defaults to DialectSQLite)
+	Logger    *slog.Logger // Optional; receives debug records and database errors (redacted)
+	Clock     clock.Clock  // Optional; decides whether tokens have expired (defaults to the real clock)
 }
 
 // NewDbStore initializes a new DbStore with a reference to *sql.DB and a table name.
@@ -21,53 +33,78 @@ func NewDbStore(db *sql.DB, tableName string) *DbStore {
 	}
 }
 
-// Store saves a new token in the database.
+// Store saves a new token in the database. Times are written in UTC, so that
+// databases comparing them as text (SQLite) order them correctly.
 func (s *DbStore) Store(ctx context.Context, tok Token) error {
-	query := fmt.Sprintf(`
-		INSERT INTO %s (id, recipient, code_hash, expires_at, created_at, attempts)
-		VALUES (?, ?, ?, ?, ?, ?)`, s.TableName)
+	query, err := s.query(`
+		INSERT INTO %s (id, recipient, code_hash, link_hash, expires_at, created_at, attempts, resend_count, last_sent_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
 
-	_, err := s.DB.ExecContext(ctx, query,
+	_, err = s.DB.ExecContext(ctx, query,
 		tok.ID,
 		tok.Recipient,
 		tok.CodeHash,
-		tok.ExpiresAt,
-		tok.CreatedAt,
+		tok.LinkHash,
+		tok.ExpiresAt.UTC(),
+		tok.CreatedAt.UTC(),
 		tok.Attempts,
+		tok.ResendCount,
+		tok.LastSentAt.UTC(),
 	)
 	if err != nil {
+		s.logger().ErrorContext(ctx, "failed to store token",
+			logging.KeyTokenID, tok.ID, logging.KeyError, err)
 		return fmt.Errorf("failed to store token: %w", err)
 	}
+	s.logger().DebugContext(ctx, "token stored",
+		logging.KeyTokenID, tok.ID, logging.KeyRecipient, tok.Recipient)
 	return nil
 }
 
 // Exists checks if a token exists in the database and returns it.
 // If the token is expired, it is deleted automatically.
 func (s *DbStore) Exists(ctx context.Context, tokenID string) (*Token, error) {
-	query := fmt.Sprintf(`
-                SELECT id, recipient, code_hash, expires_at, created_at, attempts
-                FROM %s WHERE id = ?`, s.TableName)
+	query, err := s.query(`
+                SELECT id, recipient, code_hash, link_hash, expires_at, created_at, attempts, resend_count, last_sent_at
+                FROM %s WHERE id = ?`)
+	if err != nil {
+		return nil, err
+	}
 
 	var tok Token
-	err := s.DB.QueryRowContext(ctx, query, tokenID).Scan(
+	var lastSentAt sql.NullTime // NULL in rows written before the column existed
+	err = s.DB.QueryRowContext(ctx, query, tokenID).Scan(
 		&tok.ID,
 		&tok.Recipient,
 		&tok.CodeHash,
+		&tok.LinkHash,
 		&tok.ExpiresAt,
 		&tok.CreatedAt,
 		&tok.Attempts,
+		&tok.ResendCount,
+		&lastSentAt,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("token not found")
+			return nil, ErrTokenNotFound
 		}
+		s.logger().ErrorContext(ctx, "failed to load token",
+			logging.KeyTokenID, tokenID, logging.KeyError, err)
 		return nil, fmt.Errorf("database error: %w", err)
 	}
+	tok.LastSentAt = tok.CreatedAt
+	if lastSentAt.Valid {
+		tok.LastSentAt = lastSentAt.Time
+	}
 
 	// Check if the token has expired and delete it
-	if IsTokenExpired(&tok) {
+	if IsTokenExpired(&tok, s.Clock) {
 		_ = s.Delete(ctx, tokenID) // Purge expired token
-		return nil, fmt.Errorf("token expired and was deleted")
+		s.logger().DebugContext(ctx, "token expired", logging.KeyTokenID, tokenID)
+		return nil, ErrTokenExpired
 	}
 
 	return &tok, nil
@@ -75,7 +112,10 @@ func (s *DbStore) Exists(ctx context.Context, tokenID string) (*Token, error) {
 
 // UpdateAttempts updates the failed-attempt counter for a token without altering other fields.
 func (s *DbStore) UpdateAttempts(ctx context.Context, tokenID string, attempts int) error {
-	query := fmt.Sprintf(`UPDATE %s SET attempts = ? WHERE id = ?`, s.TableName)
+	query, err := s.query(`UPDATE %s SET attempts = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
 
 	res, err := s.DB.ExecContext(ctx, query, attempts, tokenID)
 	if err != nil {
@@ -83,7 +123,42 @@ func (s *DbStore) UpdateAttempts(ctx context.Context, tokenID string, attempts i
 	}
 
 	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
-		return fmt.Errorf("token not found")
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+// Reissue replaces the code and link hashes, expiry and resend bookkeeping of a token.
+// The UPDATE is guarded by the resend count the caller read, so of two
+// concurrent reissues only one matches a row.
+func (s *DbStore) Reissue(ctx context.Context, tok Token, prevResendCount int) error {
+	query, err := s.query(`
+		UPDATE %s SET code_hash = ?, link_hash = ?, expires_at = ?, resend_count = ?, last_sent_at = ?
+		WHERE id = ? AND resend_count = ?`)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.DB.ExecContext(ctx, query,
+		tok.CodeHash,
+		tok.LinkHash,
+		tok.ExpiresAt.UTC(),
+		tok.ResendCount,
+		tok.LastSentAt.UTC(),
+		tok.ID,
+		prevResendCount,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reissue token: %w", err)
+	}
+
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		// Either the token is gone or another request reissued it first
+		if _, err := s.Exists(ctx, tok.ID); err != nil {
+			return err
+		}
+		return ErrTokenConflict
 	}
 
 	return nil
@@ -97,22 +172,183 @@ func (s *DbStore) Verify(ctx context.Context, tokenID, code string) (bool, error
 	}
 
 	if !VerifyToken(tok, code) {
-		return false, fmt.Errorf("invalid code")
+		return false, ErrInvalidCode
 	}
 
-	// If verification succeeds, delete token (one-time use)
-	if err := s.Delete(ctx, tokenID); err != nil {
+	// If verification succeeds, delete token (one-time use). If no row was
+	// removed, a concurrent request redeemed the token first.
+	query, err := s.query(`DELETE FROM %s WHERE id = ?`)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.DB.ExecContext(ctx, query, tokenID)
+	if err != nil {
 		return false, fmt.Errorf("failed to delete token after verification: %w", err)
 	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return false, ErrTokenConsumed
+	}
 	return true, nil
 }
 
+// VerifyAndConsume checks the code and updates the token atomically.
+//
+// Every write is a conditional statement guarded by the attempt and resend
+// counts that were read, so it only succeeds if no other request modified the
+// token in between; in particular a code superseded by Reissue cannot be
+// redeemed.
+// When a write loses such a race the token is re-read and the check repeated;
+// if the token has disappeared by then, ErrTokenConsumed is returned.
+func (s *DbStore) VerifyAndConsume(ctx context.Context, tokenID, code string, maxAttempts int) (*Token, error) {
+	return s.consume(ctx, tokenID, maxAttempts, func(tok *Token) bool {
+		return VerifyToken(tok, code)
+	})
+}
+
+// VerifyLinkAndConsume checks the link secret like VerifyAndConsume checks a code.
+func (s *DbStore) VerifyLinkAndConsume(ctx context.Context, tokenID, secret string, maxAttempts int) (*Token, error) {
+	return s.consume(ctx, tokenID, maxAttempts, func(tok *Token) bool {
+		return VerifyLink(tok, secret)
+	})
+}
+
+// consume implements VerifyAndConsume and VerifyLinkAndConsume; match reports
+// whether the presented credential is correct for the token.
+func (s *DbStore) consume(ctx context.Context, tokenID string, maxAttempts int, match func(*Token) bool) (*Token, error) {
+	deleteQuery, err := s.query(`DELETE FROM %s WHERE id = ? AND attempts = ? AND resend_count = ?`)
+	if err != nil {
+		return nil, err
+	}
+	updateQuery, err := s.query(`UPDATE %s SET attempts = ? WHERE id = ? AND attempts = ? AND resend_count = ?`)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < maxVerifyRetries; i++ {
+		tok, err := s.Exists(ctx, tokenID)
+		if err != nil {
+			if i > 0 && errors.Is(err, ErrTokenNotFound) {
+				return nil, ErrTokenConsumed
+			}
+			return nil, err
+		}
+
+		if match(tok) {
+			ok, err := s.execConditional(ctx, deleteQuery, tokenID, tok.Attempts, tok.ResendCount)
+			if err != nil {
+				return nil, fmt.Errorf("failed to consume token: %w", err)
+			}
+			if ok {
+				s.logger().DebugContext(ctx, "token consumed", logging.KeyTokenID, tokenID)
+				return tok, nil
+			}
+			continue
+		}
+
+		attempts := tok.Attempts + 1
+		if attempts >= maxAttempts {
+			ok, err := s.execConditional(ctx, deleteQuery, tokenID, tok.Attempts, tok.ResendCount)
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete locked token: %w", err)
+			}
+			if ok {
+				s.logger().DebugContext(ctx, "token locked",
+					logging.KeyTokenID, tokenID, logging.KeyAttempts, attempts)
+				return nil, ErrTokenLocked
+			}
+			continue
+		}
+
+		ok, err := s.execConditional(ctx, updateQuery, attempts, tokenID, tok.Attempts, tok.ResendCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update token attempts: %w", err)
+		}
+		if ok {
+			return nil, NewInvalidCodeError(attempts, maxAttempts)
+		}
+	}
+
+	return nil, fmt.Errorf("failed to verify token: too many concurrent modifications")
+}
+
+// logger returns the configured logger with redaction applied.
+func (s *DbStore) logger() *slog.Logger {
+	return logging.Redacted(s.Logger)
+}
+
+// execConditional runs a guarded write and reports whether it affected a row.
+func (s *DbStore) execConditional(ctx context.Context, query string, args ...any) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
 // Delete removes a token from the database.
 func (s *DbStore) Delete(ctx context.Context, tokenID string) error {
-	query := fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, s.TableName)
-	_, err := s.DB.ExecContext(ctx, query, tokenID)
+	query, err := s.query(`DELETE FROM %s WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, query, tokenID)
 	if err != nil {
 		return fmt.Errorf("failed to delete token: %w", err)
 	}
 	return nil
 }
+
+// DeleteByRecipient removes every token issued to recipient. The schema
+// indexes the recipient column so this does not scan the table.
+func (s *DbStore) DeleteByRecipient(ctx context.Context, recipient string) (int, error) {
+	query, err := s.query(`DELETE FROM %s WHERE recipient = ?`)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.DB.ExecContext(ctx, query, recipient)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete tokens for recipient: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted tokens: %w", err)
+	}
+	return int(n), nil
+}
+
+// PurgeExpired deletes up to limit expired tokens (all of them if limit <= 0)
+// using the index on expires_at.
+func (s *DbStore) PurgeExpired(ctx context.Context, limit int) (int, error) {
+	format := `DELETE FROM %[1]s WHERE expires_at < ?`
+	args := []any{clock.Or(s.Clock).Now().UTC()}
+	if limit > 0 {
+		// MySQL cannot use LIMIT in a subquery; PostgreSQL has no DELETE ... LIMIT.
+		format = `DELETE FROM %[1]s WHERE id IN (SELECT id FROM %[1]s WHERE expires_at < ? ORDER BY expires_at LIMIT ?)`
+		if s.Dialect == DialectMySQL {
+			format = `DELETE FROM %[1]s WHERE expires_at < ? ORDER BY expires_at LIMIT ?`
+		}
+		args = append(args, limit)
+	}
+	query, err := s.query(format)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		s.logger().ErrorContext(ctx, "failed to purge expired tokens", logging.KeyError, err)
+		return 0, fmt.Errorf("failed to purge expired tokens: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count purged tokens: %w", err)
+	}
+	if n > 0 {
+		s.logger().DebugContext(ctx, "expired tokens purged", logging.KeyCount, n)
+	}
+	return int(n), nil
+}
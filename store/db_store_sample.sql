@@ -1,8 +1,27 @@
+-- SQLite schema for DbStore. DbStore.Migrate and DbStore.CreateSchema create
+-- the same table, with types for PostgreSQL and MySQL too.
 CREATE TABLE IF NOT EXISTS passwordless_tokens (
 	id TEXT PRIMARY KEY,
 	recipient TEXT NOT NULL,
 	code_hash BLOB NOT NULL,
 	expires_at DATETIME NOT NULL,
 	created_at DATETIME NOT NULL,
-	attempts INTEGER NOT NULL DEFAULT 0
-  );
\ No newline at end of file
+	attempts INTEGER NOT NULL DEFAULT 0,
+	resend_count INTEGER NOT NULL DEFAULT 0,
+	last_sent_at DATETIME NULL,
+	link_hash BLOB NULL
+  );
+
+CREATE INDEX IF NOT EXISTS passwordless_tokens_recipient ON passwordless_tokens (recipient);
+CREATE INDEX IF NOT EXISTS passwordless_tokens_expires_at ON passwordless_tokens (expires_at);
+
+-- Upgrading a table created from an earlier edition of this file, if you do
+-- not use DbStore.Migrate: run the statements for the columns it lacks.
+-- last_sent_at is nullable so that it can be added to existing rows; DbStore
+-- reads NULL as created_at.
+--
+-- ALTER TABLE passwordless_tokens ADD COLUMN resend_count INTEGER NOT NULL DEFAULT 0;
+-- ALTER TABLE passwordless_tokens ADD COLUMN last_sent_at DATETIME NULL;
+-- UPDATE passwordless_tokens SET last_sent_at = created_at WHERE last_sent_at IS NULL;
+--
+-- ALTER TABLE passwordless_tokens ADD COLUMN link_hash BLOB NULL;
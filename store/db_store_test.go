@@ -4,10 +4,15 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
 	"github.com/rlnorthcutt/go-passwordless/store"
 	_ "modernc.org/sqlite"
 )
@@ -23,8 +28,9 @@ func TestDbStore(t *testing.T) {
 
 	t.Logf("[DEBUG] Using database file: %s", dbFile)
 
-	// Open the database using modernc.org/sqlite driver
-	db, err := sql.Open("sqlite", dbFile)
+	// Open the database using modernc.org/sqlite driver. The busy timeout lets
+	// concurrent writers wait for the lock instead of failing with SQLITE_BUSY.
+	db, err := sql.Open("sqlite", dbFile+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		t.Fatalf("Failed to open sqlite using modernc: %v", err)
 	}
@@ -105,6 +111,53 @@ func TestDbStore(t *testing.T) {
 		})
 	})
 
+	// Concurrent redemption of a single token
+	t.Run("ConcurrentVerify", func(t *testing.T) {
+		runStoreConcurrentVerifyTest(t, dbStore)
+	})
+
+	// Replacing the code of an existing token
+	t.Run("Reissue", func(t *testing.T) {
+		runStoreReissueTest(t, dbStore)
+	})
+
+	// A code superseded by Reissue between the read and the write
+	t.Run("ReissueDuringVerify", func(t *testing.T) {
+		runDbStoreReissueDuringVerifyTest(t, db, dbFile+"?_pragma=busy_timeout(5000)", tableName)
+	})
+
+	// Error conditions shared by every store
+	t.Run("Errors", func(t *testing.T) {
+		runStoreErrorsTest(t, dbStore)
+	})
+
+	// Revoking every token for a recipient
+	t.Run("DeleteByRecipient", func(t *testing.T) {
+		runStoreDeleteByRecipientTest(t, dbStore)
+	})
+
+	// Redeeming a token through its link secret
+	t.Run("VerifyLink", func(t *testing.T) {
+		runStoreVerifyLinkTest(t, dbStore)
+	})
+
+	// Expiry driven by a fake clock
+	t.Run("Expiry", func(t *testing.T) {
+		clk := clocktest.NewFake(time.Now())
+		runStoreExpiryTest(t, &store.DbStore{DB: db, TableName: tableName, Clock: clk}, clk)
+	})
+
+	// Bulk removal of expired tokens, in a table of its own so tokens left by
+	// other subtests are not counted
+	t.Run("PurgeExpired", func(t *testing.T) {
+		clk := clocktest.NewFake(time.Now())
+		purging := &store.DbStore{DB: db, TableName: "purge_tokens", Clock: clk}
+		if err := purging.CreateSchema(context.Background()); err != nil {
+			t.Fatalf("Failed to create purge table: %v", err)
+		}
+		runStorePurgeExpiredTest(t, purging, clk)
+	})
+
 	// Manual deletion test
 	t.Run("ManualDeletion", func(t *testing.T) {
 		tokenID := "testid-delete"
@@ -144,3 +197,92 @@ func TestDbStore(t *testing.T) {
 		})
 	})
 }
+
+// runDbStoreReissueDuringVerifyTest reissues a token after VerifyAndConsume has
+// read it but before its conditional DELETE runs, and checks that the old
+// code is then rejected instead of redeemed.
+func runDbStoreReissueDuringVerifyTest(t *testing.T, db *sql.DB, dsn, tableName string) {
+	ctx := context.Background()
+	plain := store.NewDbStore(db, tableName)
+	tokenID := "reissue-during-verify"
+	oldHash := sha256.Sum256([]byte("111111"))
+	newHash := sha256.Sum256([]byte("222222"))
+	now := time.Now()
+
+	err := plain.Store(ctx, store.Token{
+		ID:        tokenID,
+		Recipient: "race@example.com",
+		CodeHash:  oldHash[:],
+		ExpiresAt: now.Add(5 * time.Minute),
+		CreatedAt: now,
+	})
+	if err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+	defer plain.Delete(ctx, tokenID)
+
+	var once sync.Once
+	intercepted := sql.OpenDB(&interceptor{
+		drv: db.Driver(),
+		dsn: dsn,
+		beforeExec: func(query string) {
+			if !strings.HasPrefix(query, "DELETE") {
+				return
+			}
+			once.Do(func() {
+				err := plain.Reissue(ctx, store.Token{
+					ID:          tokenID,
+					CodeHash:    newHash[:],
+					ExpiresAt:   now.Add(10 * time.Minute),
+					ResendCount: 1,
+					LastSentAt:  now,
+				}, 0)
+				if err != nil {
+					t.Errorf("Reissue() error: %v", err)
+				}
+			})
+		},
+	})
+	defer intercepted.Close()
+
+	racing := store.NewDbStore(intercepted, tableName)
+	tok, err := racing.VerifyAndConsume(ctx, tokenID, "111111", 5)
+	var invalid *store.InvalidCodeError
+	if tok != nil || !errors.As(err, &invalid) {
+		t.Fatalf("Expected the superseded code to be rejected, got token %v, err %v", tok, err)
+	}
+	if tok, err := plain.Exists(ctx, tokenID); err != nil || !store.VerifyToken(tok, "222222") {
+		t.Fatalf("Expected the reissued token to remain, got %v, err %v", tok, err)
+	}
+}
+
+// interceptor is a database/sql connector that calls beforeExec with every
+// statement before passing it to the wrapped driver.
+type interceptor struct {
+	drv        driver.Driver
+	dsn        string
+	beforeExec func(query string)
+}
+
+func (i *interceptor) Connect(context.Context) (driver.Conn, error) {
+	conn, err := i.drv.Open(i.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return interceptorConn{conn, i}, nil
+}
+func (i *interceptor) Driver() driver.Driver { return i.drv }
+
+type interceptorConn struct {
+	driver.Conn
+	i *interceptor
+}
+
+func (c interceptorConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.i.beforeExec(query)
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c interceptorConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect selects the SQL flavor DbStore generates: bind parameter syntax
+// and the column types and index statements used by CreateSchema and Migrate.
+type Dialect int
+
+const (
+	// DialectSQLite uses ? placeholders. It is the zero value, so existing
+	// DbStore values keep their behavior.
+	DialectSQLite Dialect = iota
+
+	// DialectPostgres uses $1, $2, ... placeholders, BYTEA and TIMESTAMPTZ.
+	DialectPostgres
+
+	// DialectMySQL uses ? placeholders, VARBINARY and DATETIME(6). The DSN
+	// must set parseTime=true so timestamps scan into time.Time.
+	DialectMySQL
+)
+
+// String returns the dialect's name.
+func (d Dialect) String() string {
+	switch d {
+	case DialectSQLite:
+		return "sqlite"
+	case DialectPostgres:
+		return "postgres"
+	case DialectMySQL:
+		return "mysql"
+	default:
+		return "Dialect(" + strconv.Itoa(int(d)) + ")"
+	}
+}
+
+// Placeholder returns the bind parameter for the n-th argument, counting from 1.
+func (d Dialect) Placeholder(n int) string {
+	if d == DialectPostgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// Rebind rewrites the ? placeholders in query for the dialect. The query must
+// not contain a literal question mark, since every one is replaced.
+func (d Dialect) Rebind(query string) string {
+	if d.Placeholder(1) == "?" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(d.Placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// timestampType is the column type used for time.Time values.
+func (d Dialect) timestampType() string {
+	switch d {
+	case DialectPostgres:
+		return "TIMESTAMPTZ"
+	case DialectMySQL:
+		return "DATETIME(6)"
+	default:
+		return "DATETIME"
+	}
+}
+
+// bytesType is the column type used for hashes.
+func (d Dialect) bytesType() string {
+	switch d {
+	case DialectPostgres:
+		return "BYTEA"
+	case DialectMySQL:
+		return "VARBINARY(255)"
+	default:
+		return "BLOB"
+	}
+}
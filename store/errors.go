@@ -0,0 +1,57 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors returned by every TokenStore implementation. Callers should
+// compare against them with errors.Is rather than matching error strings.
+var (
+	// ErrTokenNotFound is returned when no token exists for the given ID.
+	ErrTokenNotFound = errors.New("token not found")
+
+	// ErrTokenExpired is returned when a token exists but its ExpiresAt has passed.
+	// Stores remove the expired token before returning this error.
+	ErrTokenExpired = errors.New("token expired")
+
+	// ErrInvalidCode is returned when the provided code does not match the stored hash.
+	ErrInvalidCode = errors.New("invalid code")
+
+	// ErrTokenLocked is returned when a token has been invalidated because it
+	// reached the maximum number of failed attempts.
+	ErrTokenLocked = errors.New("too many failed attempts")
+
+	// ErrTokenConsumed is returned when a token was redeemed by another request
+	// between being looked up and being consumed.
+	ErrTokenConsumed = errors.New("token already consumed")
+
+	// ErrTokenConflict is returned by Reissue when another request reissued
+	// the token after the caller read it.
+	ErrTokenConflict = errors.New("token reissued concurrently")
+)
+
+// InvalidCodeError reports a failed verification together with the attempt
+// accounting for the token. It matches ErrInvalidCode via errors.Is.
+type InvalidCodeError struct {
+	Attempts          int // Failed attempts recorded so far, including this one
+	AttemptsRemaining int // Attempts left before the token is locked
+}
+
+// NewInvalidCodeError builds the error reported after a failed attempt that
+// brought the token's counter to attempts.
+func NewInvalidCodeError(attempts, maxAttempts int) *InvalidCodeError {
+	return &InvalidCodeError{
+		Attempts:          attempts,
+		AttemptsRemaining: maxAttempts - attempts,
+	}
+}
+
+func (e *InvalidCodeError) Error() string {
+	return fmt.Sprintf("invalid code, attempts remaining: %d", e.AttemptsRemaining)
+}
+
+// Is reports whether target is ErrInvalidCode.
+func (e *InvalidCodeError) Is(target error) bool {
+	return target == ErrInvalidCode
+}
@@ -2,19 +2,30 @@ package store
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"sync"
-	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/logging"
 )
 
 type MemStore struct {
-	mu     sync.Mutex
-	tokens map[string]Token
+	mu          sync.Mutex
+	tokens      map[string]Token
+	byRecipient map[string]map[string]struct{} // recipient -> token IDs
+
+	// Logger receives debug records for stored, consumed, expired and locked
+	// tokens. If nil, nothing is logged. Recipients are masked (see logging.Redacted).
+	Logger *slog.Logger
+
+	// Clock is used to decide whether tokens have expired. If nil, the real clock is used.
+	Clock clock.Clock
 }
 
 func NewMemStore() *MemStore {
 	return &MemStore{
-		tokens: make(map[string]Token),
+		tokens:      make(map[string]Token),
+		byRecipient: make(map[string]map[string]struct{}),
 	}
 }
 
@@ -27,7 +38,18 @@ func (m *MemStore) Store(ctx context.Context, tok Token) error {
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if old, ok := m.tokens[tok.ID]; ok {
+		m.unindex(old)
+	}
 	m.tokens[tok.ID] = tok
+	ids, ok := m.byRecipient[tok.Recipient]
+	if !ok {
+		ids = make(map[string]struct{})
+		m.byRecipient[tok.Recipient] = ids
+	}
+	ids[tok.ID] = struct{}{}
+	m.logger().DebugContext(ctx, "token stored",
+		logging.KeyTokenID, tok.ID, logging.KeyRecipient, tok.Recipient)
 	return nil
 }
 
@@ -43,12 +65,13 @@ func (m *MemStore) Exists(ctx context.Context, tokenID string) (*Token, error) {
 
 	tok, ok := m.tokens[tokenID]
 	if !ok {
-		return nil, fmt.Errorf("token not found")
+		return nil, ErrTokenNotFound
 	}
 
-	if time.Now().After(tok.ExpiresAt) {
-		delete(m.tokens, tokenID)
-		return nil, fmt.Errorf("token expired")
+	if IsTokenExpired(&tok, m.Clock) {
+		m.remove(tokenID)
+		m.logger().DebugContext(ctx, "token expired", logging.KeyTokenID, tokenID)
+		return nil, ErrTokenExpired
 	}
 
 	return &tok, nil
@@ -66,7 +89,7 @@ func (m *MemStore) UpdateAttempts(ctx context.Context, tokenID string, attempts
 
 	tok, ok := m.tokens[tokenID]
 	if !ok {
-		return fmt.Errorf("token not found")
+		return ErrTokenNotFound
 	}
 
 	tok.Attempts = attempts
@@ -75,6 +98,34 @@ func (m *MemStore) UpdateAttempts(ctx context.Context, tokenID string, attempts
 	return nil
 }
 
+func (m *MemStore) Reissue(ctx context.Context, tok Token, prevResendCount int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.tokens[tok.ID]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	if existing.ResendCount != prevResendCount {
+		return ErrTokenConflict
+	}
+
+	existing.CodeHash = tok.CodeHash
+	existing.LinkHash = tok.LinkHash
+	existing.ExpiresAt = tok.ExpiresAt
+	existing.ResendCount = tok.ResendCount
+	existing.LastSentAt = tok.LastSentAt
+	m.tokens[tok.ID] = existing
+
+	return nil
+}
+
 func (m *MemStore) Verify(ctx context.Context, tokenID, code string) (bool, error) {
 	select {
 	case <-ctx.Done():
@@ -87,23 +138,152 @@ func (m *MemStore) Verify(ctx context.Context, tokenID, code string) (bool, erro
 
 	tok, ok := m.tokens[tokenID]
 	if !ok {
-		return false, fmt.Errorf("token not found")
+		return false, ErrTokenNotFound
 	}
 
-	if IsTokenExpired(&tok) {
-		delete(m.tokens, tokenID)
-		return false, fmt.Errorf("token expired")
+	if IsTokenExpired(&tok, m.Clock) {
+		m.remove(tokenID)
+		m.logger().DebugContext(ctx, "token expired", logging.KeyTokenID, tokenID)
+		return false, ErrTokenExpired
 	}
 
 	if !VerifyToken(&tok, code) {
-		return false, nil
+		return false, ErrInvalidCode
 	}
 
 	// If match, consume it (delete immediately):
-	delete(m.tokens, tokenID)
+	m.remove(tokenID)
 	return true, nil
 }
 
+// VerifyAndConsume checks the code and updates the token while holding the
+// store's lock, so concurrent callers are fully serialized.
+func (m *MemStore) VerifyAndConsume(ctx context.Context, tokenID, code string, maxAttempts int) (*Token, error) {
+	return m.consume(ctx, tokenID, maxAttempts, func(tok *Token) bool {
+		return VerifyToken(tok, code)
+	})
+}
+
+// VerifyLinkAndConsume checks the link secret like VerifyAndConsume checks a code.
+func (m *MemStore) VerifyLinkAndConsume(ctx context.Context, tokenID, secret string, maxAttempts int) (*Token, error) {
+	return m.consume(ctx, tokenID, maxAttempts, func(tok *Token) bool {
+		return VerifyLink(tok, secret)
+	})
+}
+
+// consume implements VerifyAndConsume and VerifyLinkAndConsume; match reports
+// whether the presented credential is correct for the token.
+func (m *MemStore) consume(ctx context.Context, tokenID string, maxAttempts int, match func(*Token) bool) (*Token, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tok, ok := m.tokens[tokenID]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+
+	if IsTokenExpired(&tok, m.Clock) {
+		m.remove(tokenID)
+		m.logger().DebugContext(ctx, "token expired", logging.KeyTokenID, tokenID)
+		return nil, ErrTokenExpired
+	}
+
+	if !match(&tok) {
+		tok.Attempts++
+		if tok.Attempts >= maxAttempts {
+			m.remove(tokenID)
+			m.logger().DebugContext(ctx, "token locked",
+				logging.KeyTokenID, tokenID, logging.KeyAttempts, tok.Attempts)
+			return nil, ErrTokenLocked
+		}
+		m.tokens[tokenID] = tok
+		return nil, NewInvalidCodeError(tok.Attempts, maxAttempts)
+	}
+
+	m.remove(tokenID)
+	m.logger().DebugContext(ctx, "token consumed", logging.KeyTokenID, tokenID)
+	return &tok, nil
+}
+
+// DeleteByRecipient removes every token issued to recipient.
+func (m *MemStore) DeleteByRecipient(ctx context.Context, recipient string) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := m.byRecipient[recipient]
+	n := len(ids)
+	for id := range ids {
+		delete(m.tokens, id)
+	}
+	delete(m.byRecipient, recipient)
+	return n, nil
+}
+
+// PurgeExpired removes up to limit expired tokens (all of them if limit <= 0).
+// The map is scanned while holding the store's lock.
+func (m *MemStore) PurgeExpired(ctx context.Context, limit int) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := clock.Or(m.Clock).Now()
+	n := 0
+	for id, tok := range m.tokens {
+		if limit > 0 && n >= limit {
+			break
+		}
+		if now.After(tok.ExpiresAt) {
+			m.remove(id)
+			n++
+		}
+	}
+	if n > 0 {
+		m.logger().DebugContext(ctx, "expired tokens purged", logging.KeyCount, n)
+	}
+	return n, nil
+}
+
+// remove deletes a token and its recipient index entry. The caller must hold m.mu.
+func (m *MemStore) remove(tokenID string) {
+	tok, ok := m.tokens[tokenID]
+	if !ok {
+		return
+	}
+	delete(m.tokens, tokenID)
+	m.unindex(tok)
+}
+
+// unindex drops tok from the recipient index. The caller must hold m.mu.
+func (m *MemStore) unindex(tok Token) {
+	ids := m.byRecipient[tok.Recipient]
+	delete(ids, tok.ID)
+	if len(ids) == 0 {
+		delete(m.byRecipient, tok.Recipient)
+	}
+}
+
+// logger returns the configured logger with redaction applied.
+func (m *MemStore) logger() *slog.Logger {
+	return logging.Redacted(m.Logger)
+}
+
 func (m *MemStore) Delete(ctx context.Context, tokenID string) error {
 	select {
 	case <-ctx.Done():
@@ -114,6 +294,6 @@ func (m *MemStore) Delete(ctx context.Context, tokenID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	delete(m.tokens, tokenID)
+	m.remove(tokenID)
 	return nil
 }
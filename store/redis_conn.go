@@ -0,0 +1,249 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisError is an error reply from the server, such as "WRONGTYPE ...". The
+// connection is still usable after one.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn is a RESP2 connection to a Redis server.
+type redisConn struct {
+	conn     net.Conn
+	rd       *bufio.Reader
+	wr       *bufio.Writer
+	lastUsed time.Time
+	written  int64 // Bytes sent since the pool handed the connection out
+}
+
+// dialRedis connects to s.Addr, negotiates TLS if configured, authenticates
+// and selects s.DB.
+func dialRedis(ctx context.Context, s *RedisStore) (*redisConn, error) {
+	timeout := s.DialTimeout
+	if timeout == 0 {
+		timeout = DefaultRedisDialTimeout
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	d := net.Dialer{}
+	conn, err := d.DialContext(dialCtx, "tcp", s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.TLSConfig != nil {
+		cfg := s.TLSConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(s.Addr)
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	c := &redisConn{conn: conn, rd: bufio.NewReader(conn)}
+	c.wr = bufio.NewWriter(c)
+	var setup [][]string
+	switch {
+	case s.Username != "":
+		setup = append(setup, []string{"AUTH", s.Username, s.Password})
+	case s.Password != "":
+		setup = append(setup, []string{"AUTH", s.Password})
+	}
+	if s.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.DB)})
+	}
+	for _, cmd := range setup {
+		if _, err := c.do(dialCtx, cmd...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis %s: %w", cmd[0], err)
+		}
+	}
+	return c, nil
+}
+
+// do sends one command and returns its reply. An error reply is returned as
+// a redisError.
+func (c *redisConn) do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.pipeline(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if rerr, ok := replies[0].(redisError); ok {
+		return nil, rerr
+	}
+	return replies[0], nil
+}
+
+// pipeline sends cmds in one write and reads one reply per command. Error
+// replies are returned in place as redisError values.
+func (c *redisConn) pipeline(ctx context.Context, cmds [][]string) ([]any, error) {
+	stop := watchConn(ctx, c.conn)
+	defer stop()
+
+	for _, args := range cmds {
+		writeCommand(c.wr, args)
+	}
+	if err := c.wr.Flush(); err != nil {
+		return nil, connError(ctx, err)
+	}
+	replies := make([]any, len(cmds))
+	for i := range cmds {
+		reply, err := readReply(c.rd)
+		if err != nil {
+			return nil, connError(ctx, err)
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// Write sends p on the connection and counts the bytes that went out, so a
+// caller can tell whether a failed command may have reached the server.
+func (c *redisConn) Write(p []byte) (int, error) {
+	n, err := c.conn.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+func (c *redisConn) close() error {
+	return c.conn.Close()
+}
+
+// writeCommand encodes args as a RESP array of bulk strings.
+func writeCommand(w *bufio.Writer, args []string) {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		w.WriteString(arg)
+		w.WriteString("\r\n")
+	}
+}
+
+// readReply decodes one RESP2 reply: simple and bulk strings as string,
+// integers as int64, errors as redisError, arrays as []any and null bulk
+// strings and arrays as nil.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
+
+// watchConn applies ctx's deadline to conn and interrupts any blocked read
+// or write when ctx is canceled. The returned function stops watching and
+// clears the deadline.
+func watchConn(ctx context.Context, conn net.Conn) (stop func()) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stopAfter := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	return func() {
+		stopAfter()
+		_ = conn.SetDeadline(time.Time{})
+	}
+}
+
+// connError prefers ctx's error over err once ctx is done, so callers see
+// context.Canceled or context.DeadlineExceeded instead of an I/O timeout.
+func connError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
+// replyString converts a bulk or simple string reply; nil becomes "".
+func replyString(reply any) string {
+	s, _ := reply.(string)
+	return s
+}
+
+// replyInt converts an integer reply.
+func replyInt(reply any) (int64, error) {
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: expected an integer reply, got %T", reply)
+	}
+	return n, nil
+}
+
+// replyStrings converts an array reply of strings.
+func replyStrings(reply any) ([]string, error) {
+	if reply == nil {
+		return nil, nil
+	}
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("redis: expected an array reply, got %T", reply)
+	}
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = replyString(item)
+	}
+	return out, nil
+}
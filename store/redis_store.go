@@ -0,0 +1,708 @@
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/logging"
+)
+
+// ErrRedisClosed is returned by RedisStore once Close has been called.
+var ErrRedisClosed = errors.New("redis store is closed")
+
+// Defaults for RedisStore.
+const (
+	DefaultRedisKeyPrefix   = "passwordless:"
+	DefaultRedisPoolSize    = 10
+	DefaultRedisDialTimeout = 5 * time.Second
+	DefaultRedisExpiryGrace = 5 * time.Minute
+)
+
+// redisHealthCheckInterval is how long a connection may sit idle before it
+// is checked with PING on reuse.
+const redisHealthCheckInterval = 30 * time.Second
+
+// RedisStore keeps tokens in Redis, so several application instances can
+// share them without a SQL database. It speaks RESP2 itself and needs no
+// client library; any server compatible with Redis 4.0 or later will do.
+//
+// Each token is a hash at <KeyPrefix>token:<ID> whose key expires with the
+// token, and each recipient has a set of token IDs at
+// <KeyPrefix>recipient:<Recipient> for DeleteByRecipient. Because Redis
+// removes expired keys itself, RedisStore does not implement Purger and
+// needs no janitor.
+//
+// Every read-modify-write (attempt counting, consume-on-verify, Reissue) runs
+// as a WATCH/MULTI/EXEC transaction that is retried when another request
+// changes the token in between, so concurrent verifications are as safe as
+// with MemStore.
+//
+// It is safe for concurrent use. Call Close when done to close idle connections.
+type RedisStore struct {
+	Addr      string      // Server address, e.g. "localhost:6379"
+	Username  string      // Optional; ACL user name (Redis 6+)
+	Password  string      // Optional; sent with AUTH after connecting
+	DB        int         // Optional; database selected after connecting
+	TLSConfig *tls.Config // Optional; connect over TLS
+
+	// KeyPrefix is prepended to every key (default DefaultRedisKeyPrefix).
+	KeyPrefix string
+
+	// PoolSize bounds the number of open connections, and therefore of
+	// concurrent commands (default DefaultRedisPoolSize).
+	PoolSize int
+
+	// DialTimeout bounds connecting and authenticating (default DefaultRedisDialTimeout).
+	DialTimeout time.Duration
+
+	// ExpiryGrace keeps a token's key for this long after its ExpiresAt
+	// (default DefaultRedisExpiryGrace), so a late verification reports
+	// ErrTokenExpired rather than ErrTokenNotFound and clocks that differ
+	// between servers do not remove tokens early. Negative means none.
+	ExpiryGrace time.Duration
+
+	// Logger receives debug records for stored, consumed, expired and locked
+	// tokens. If nil, nothing is logged. Recipients are masked (see logging.Redacted).
+	Logger *slog.Logger
+
+	// Clock is used to decide whether tokens have expired and to compute key
+	// TTLs. If nil, the real clock is used.
+	Clock clock.Clock
+
+	initOnce sync.Once
+	slots    chan struct{}
+
+	mu     sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+// NewRedisStore returns a store using the Redis server at addr.
+func NewRedisStore(addr string) *RedisStore {
+	return &RedisStore{Addr: addr}
+}
+
+// Store saves tok, replacing any token with the same ID. The key expires
+// ExpiryGrace after tok.ExpiresAt.
+func (s *RedisStore) Store(ctx context.Context, tok Token) error {
+	key, rkey := s.tokenKey(tok.ID), s.recipientKey(tok.Recipient)
+	ttl := s.ttl(tok.ExpiresAt)
+
+	_, err := s.transact(ctx, []string{key, rkey}, func(c *redisConn) ([][]string, error) {
+		replies, err := c.pipeline(ctx, [][]string{
+			{"HGET", key, "recipient"},
+			{"PTTL", rkey},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var cmds [][]string
+		if old, ok := replies[0].(string); ok && old != tok.Recipient {
+			cmds = append(cmds, []string{"SREM", s.recipientKey(old), tok.ID})
+		}
+		cmds = append(cmds,
+			[]string{"DEL", key},
+			append([]string{"HSET", key}, redisFields(tok)...),
+			[]string{"PEXPIRE", key, ttl},
+			[]string{"SADD", rkey, tok.ID},
+		)
+		return append(cmds, s.extendTTL(rkey, replies[1], ttl)...), nil
+	})
+	if err != nil {
+		s.logger().ErrorContext(ctx, "failed to store token",
+			logging.KeyTokenID, tok.ID, logging.KeyError, err)
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+	s.logger().DebugContext(ctx, "token stored",
+		logging.KeyTokenID, tok.ID, logging.KeyRecipient, tok.Recipient)
+	return nil
+}
+
+// Exists returns the token with the given ID. An expired token is deleted and
+// reported as ErrTokenExpired.
+func (s *RedisStore) Exists(ctx context.Context, tokenID string) (*Token, error) {
+	var reply any
+	err := s.withConn(ctx, func(c *redisConn) (err error) {
+		reply, err = c.do(ctx, "HGETALL", s.tokenKey(tokenID))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	tok, err := parseRedisToken(tokenID, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsTokenExpired(tok, s.Clock) {
+		if err := s.Delete(ctx, tokenID); err != nil {
+			return nil, err
+		}
+		s.logger().DebugContext(ctx, "token expired", logging.KeyTokenID, tokenID)
+		return nil, ErrTokenExpired
+	}
+	return tok, nil
+}
+
+// UpdateAttempts sets the token's attempt count without touching its expiry.
+func (s *RedisStore) UpdateAttempts(ctx context.Context, tokenID string, attempts int) error {
+	key := s.tokenKey(tokenID)
+	_, err := s.transact(ctx, []string{key}, func(c *redisConn) ([][]string, error) {
+		if err := s.mustExist(ctx, c, key); err != nil {
+			return nil, err
+		}
+		return [][]string{{"HSET", key, "attempts", strconv.Itoa(attempts)}}, nil
+	})
+	return err
+}
+
+// Reissue replaces the code and link hashes, expiry and resend bookkeeping of an
+// existing token and moves its key's expiry to match. The token's key is
+// watched, so a concurrent reissue makes the transaction read it again and
+// fail with ErrTokenConflict.
+func (s *RedisStore) Reissue(ctx context.Context, tok Token, prevResendCount int) error {
+	key := s.tokenKey(tok.ID)
+	ttl := s.ttl(tok.ExpiresAt)
+
+	_, err := s.transact(ctx, []string{key}, func(c *redisConn) ([][]string, error) {
+		reply, err := c.do(ctx, "HGETALL", key)
+		if err != nil {
+			return nil, err
+		}
+		existing, err := parseRedisToken(tok.ID, reply)
+		if err != nil {
+			return nil, err
+		}
+		if existing.ResendCount != prevResendCount {
+			return nil, ErrTokenConflict
+		}
+		rkey := s.recipientKey(existing.Recipient)
+		replies, err := c.pipeline(ctx, [][]string{{"WATCH", rkey}, {"PTTL", rkey}})
+		if err != nil {
+			return nil, err
+		}
+
+		cmds := [][]string{
+			{"HSET", key,
+				"code_hash", string(tok.CodeHash),
+				"link_hash", string(tok.LinkHash),
+				"expires_at", formatRedisTime(tok.ExpiresAt),
+				"resend_count", strconv.Itoa(tok.ResendCount),
+				"last_sent_at", formatRedisTime(tok.LastSentAt)},
+			{"PEXPIRE", key, ttl},
+		}
+		return append(cmds, s.extendTTL(rkey, replies[1], ttl)...), nil
+	})
+	return err
+}
+
+// Verify checks code against the stored token and deletes the token if it
+// matches. A mismatch is not counted as an attempt.
+func (s *RedisStore) Verify(ctx context.Context, tokenID, code string) (bool, error) {
+	var result error
+	_, err := s.transact(ctx, []string{s.tokenKey(tokenID)}, func(c *redisConn) ([][]string, error) {
+		tok, err := s.read(ctx, c, tokenID)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case IsTokenExpired(tok, s.Clock):
+			result = ErrTokenExpired
+		case !VerifyToken(tok, code):
+			result = ErrInvalidCode
+			return nil, nil
+		default:
+			result = nil
+		}
+		return s.removeCmds(tok), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if errors.Is(result, ErrTokenExpired) {
+		s.logger().DebugContext(ctx, "token expired", logging.KeyTokenID, tokenID)
+	}
+	return result == nil, result
+}
+
+// VerifyAndConsume checks the code and updates the token atomically.
+//
+// The token is read under WATCH and the delete or attempt increment is
+// queued with MULTI/EXEC, which Redis discards if the token changed in the
+// meantime. The check is then repeated; if the token has disappeared by then,
+// ErrTokenConsumed is returned.
+func (s *RedisStore) VerifyAndConsume(ctx context.Context, tokenID, code string, maxAttempts int) (*Token, error) {
+	return s.consume(ctx, tokenID, maxAttempts, func(tok *Token) bool {
+		return VerifyToken(tok, code)
+	})
+}
+
+// VerifyLinkAndConsume checks the link secret like VerifyAndConsume checks a code.
+func (s *RedisStore) VerifyLinkAndConsume(ctx context.Context, tokenID, secret string, maxAttempts int) (*Token, error) {
+	return s.consume(ctx, tokenID, maxAttempts, func(tok *Token) bool {
+		return VerifyLink(tok, secret)
+	})
+}
+
+// consume implements VerifyAndConsume and VerifyLinkAndConsume; match reports
+// whether the presented credential is correct for the token.
+func (s *RedisStore) consume(ctx context.Context, tokenID string, maxAttempts int, match func(*Token) bool) (*Token, error) {
+	var (
+		consumed *Token
+		attempts int
+		result   error
+		reads    int
+	)
+	_, err := s.transact(ctx, []string{s.tokenKey(tokenID)}, func(c *redisConn) ([][]string, error) {
+		reads++
+		tok, err := s.read(ctx, c, tokenID)
+		if err != nil {
+			if reads > 1 && errors.Is(err, ErrTokenNotFound) {
+				return nil, ErrTokenConsumed
+			}
+			return nil, err
+		}
+
+		consumed, attempts, result = nil, tok.Attempts, nil
+		switch {
+		case IsTokenExpired(tok, s.Clock):
+			result = ErrTokenExpired
+		case match(tok):
+			consumed = tok
+		default:
+			attempts++
+			if attempts < maxAttempts {
+				result = NewInvalidCodeError(attempts, maxAttempts)
+				return [][]string{{"HINCRBY", s.tokenKey(tokenID), "attempts", "1"}}, nil
+			}
+			result = ErrTokenLocked
+		}
+		return s.removeCmds(tok), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case consumed != nil:
+		s.logger().DebugContext(ctx, "token consumed", logging.KeyTokenID, tokenID)
+	case errors.Is(result, ErrTokenExpired):
+		s.logger().DebugContext(ctx, "token expired", logging.KeyTokenID, tokenID)
+	case errors.Is(result, ErrTokenLocked):
+		s.logger().DebugContext(ctx, "token locked",
+			logging.KeyTokenID, tokenID, logging.KeyAttempts, attempts)
+	}
+	return consumed, result
+}
+
+// Delete removes a token and its recipient index entry. Deleting a missing
+// token is not an error.
+func (s *RedisStore) Delete(ctx context.Context, tokenID string) error {
+	_, err := s.transact(ctx, []string{s.tokenKey(tokenID)}, func(c *redisConn) ([][]string, error) {
+		tok, err := s.read(ctx, c, tokenID)
+		if errors.Is(err, ErrTokenNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.removeCmds(tok), nil
+	})
+	return err
+}
+
+// DeleteByRecipient removes every token listed in the recipient's set, and
+// the set itself.
+func (s *RedisStore) DeleteByRecipient(ctx context.Context, recipient string) (int, error) {
+	rkey := s.recipientKey(recipient)
+	results, err := s.transact(ctx, []string{rkey}, func(c *redisConn) ([][]string, error) {
+		reply, err := c.do(ctx, "SMEMBERS", rkey)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := replyStrings(reply)
+		if err != nil || len(ids) == 0 {
+			return nil, err
+		}
+		del := []string{"DEL"}
+		for _, id := range ids {
+			del = append(del, s.tokenKey(id))
+		}
+		return [][]string{del, {"DEL", rkey}}, nil
+	})
+	if err != nil || results == nil {
+		return 0, err
+	}
+	n, err := replyInt(results[0])
+	return int(n), err
+}
+
+// Ping checks that the server is reachable.
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.withConn(ctx, func(c *redisConn) error {
+		_, err := c.do(ctx, "PING")
+		return err
+	})
+}
+
+// Close closes idle connections. Connections in use are closed when their
+// command finishes, and later calls fail with ErrRedisClosed.
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.closed = true
+	s.mu.Unlock()
+
+	var errs []error
+	for _, c := range idle {
+		errs = append(errs, c.close())
+	}
+	return errors.Join(errs...)
+}
+
+// read fetches and decodes a token on c.
+func (s *RedisStore) read(ctx context.Context, c *redisConn, tokenID string) (*Token, error) {
+	reply, err := c.do(ctx, "HGETALL", s.tokenKey(tokenID))
+	if err != nil {
+		return nil, err
+	}
+	return parseRedisToken(tokenID, reply)
+}
+
+// mustExist returns ErrTokenNotFound unless key exists.
+func (s *RedisStore) mustExist(ctx context.Context, c *redisConn, key string) error {
+	reply, err := c.do(ctx, "EXISTS", key)
+	if err != nil {
+		return err
+	}
+	n, err := replyInt(reply)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// removeCmds deletes tok and its recipient index entry.
+func (s *RedisStore) removeCmds(tok *Token) [][]string {
+	return [][]string{
+		{"DEL", s.tokenKey(tok.ID)},
+		{"SREM", s.recipientKey(tok.Recipient), tok.ID},
+	}
+}
+
+// extendTTL returns a PEXPIRE for rkey if its current TTL (a PTTL reply) is
+// shorter than ttl, so a recipient's set outlives all of its tokens.
+func (s *RedisStore) extendTTL(rkey string, pttl any, ttl string) [][]string {
+	current, _ := pttl.(int64)
+	if want, _ := strconv.ParseInt(ttl, 10, 64); current >= want {
+		return nil
+	}
+	return [][]string{{"PEXPIRE", rkey, ttl}}
+}
+
+// transact runs a WATCH/MULTI/EXEC transaction on keys. prepare reads what it
+// needs on c and returns the commands to queue; if it returns none (or an
+// error), the transaction is abandoned. When EXEC is aborted because a
+// watched key changed, prepare is called again, up to maxVerifyRetries times.
+// The EXEC replies are returned, or nil if nothing was queued.
+func (s *RedisStore) transact(ctx context.Context, keys []string, prepare func(c *redisConn) ([][]string, error)) ([]any, error) {
+	var results []any
+	err := s.withConn(ctx, func(c *redisConn) error {
+		for i := 0; i < maxVerifyRetries; i++ {
+			if _, err := c.do(ctx, append([]string{"WATCH"}, keys...)...); err != nil {
+				return err
+			}
+			cmds, err := prepare(c)
+			if err != nil || len(cmds) == 0 {
+				// If UNWATCH gets no reply the connection must not be reused,
+				// so its error takes precedence over a token error.
+				if _, uerr := c.do(ctx, "UNWATCH"); uerr != nil && !isRedisError(uerr) {
+					return uerr
+				}
+				return err
+			}
+
+			batch := append([][]string{{"MULTI"}}, cmds...)
+			replies, err := c.pipeline(ctx, append(batch, []string{"EXEC"}))
+			if err != nil {
+				return err
+			}
+			switch exec := replies[len(replies)-1].(type) {
+			case nil:
+				continue // A watched key changed; read it again
+			case redisError:
+				return exec
+			case []any:
+				for _, reply := range exec {
+					if rerr, ok := reply.(redisError); ok {
+						return rerr
+					}
+				}
+				results = exec
+				return nil
+			default:
+				return fmt.Errorf("redis: unexpected EXEC reply %T", exec)
+			}
+		}
+		return fmt.Errorf("redis transaction failed: too many concurrent modifications")
+	})
+	return results, err
+}
+
+// withConn runs fn on a pooled connection, dialing one if none is idle.
+func (s *RedisStore) withConn(ctx context.Context, fn func(c *redisConn) error) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	s.init()
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.slots }()
+
+	c, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+	reused := !c.lastUsed.IsZero()
+	c.written = 0
+	err = fn(c)
+	if s.release(c, err) || !reused || c.written > 0 || !isConnClosed(err) || ctx.Err() != nil {
+		return err
+	}
+
+	// The server had closed the idle connection (a restart or its idle
+	// timeout) before any byte of the command went out, so it was never run;
+	// try once more on a new connection. Once bytes have been sent the server
+	// may have run the command, so the error is returned instead. Connections
+	// idle for longer than redisHealthCheckInterval are checked by get first.
+	if c, err = dialRedis(ctx, s); err != nil {
+		return err
+	}
+	err = fn(c)
+	s.release(c, err)
+	return err
+}
+
+// release returns c to the pool, unless err leaves it in an unknown state,
+// and reports whether it did. A reply of any kind leaves the connection in a
+// known state; I/O errors and cancellation do not.
+func (s *RedisStore) release(c *redisConn, err error) bool {
+	if err == nil || isRedisError(err) || isTokenError(err) {
+		s.put(c)
+		return true
+	}
+	c.close()
+	return false
+}
+
+// isTokenError reports whether err is one of the sentinel errors describing
+// the token, including *InvalidCodeError, which are outcomes of a completed
+// exchange with the server rather than connection failures.
+func isTokenError(err error) bool {
+	for _, target := range []error{
+		ErrTokenNotFound, ErrTokenExpired, ErrInvalidCode,
+		ErrTokenLocked, ErrTokenConsumed, ErrTokenConflict,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRedisError reports whether err is an error reply from the server.
+func isRedisError(err error) bool {
+	var rerr redisError
+	return errors.As(err, &rerr)
+}
+
+// isConnClosed reports whether err shows the server had closed the connection.
+func isConnClosed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+func (s *RedisStore) init() {
+	s.initOnce.Do(func() {
+		size := s.PoolSize
+		if size <= 0 {
+			size = DefaultRedisPoolSize
+		}
+		s.slots = make(chan struct{}, size)
+	})
+}
+
+// get returns a healthy idle connection or dials a new one.
+func (s *RedisStore) get(ctx context.Context) (*redisConn, error) {
+	for {
+		c, err := s.popIdle()
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			break
+		}
+		if clock.Or(s.Clock).Now().Sub(c.lastUsed) > redisHealthCheckInterval {
+			if _, err := c.do(ctx, "PING"); err != nil {
+				c.close()
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				continue
+			}
+		}
+		return c, nil
+	}
+	return dialRedis(ctx, s)
+}
+
+// popIdle takes the most recently used idle connection, or nil if there is none.
+func (s *RedisStore) popIdle() (*redisConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrRedisClosed
+	}
+	n := len(s.idle)
+	if n == 0 {
+		return nil, nil
+	}
+	c := s.idle[n-1]
+	s.idle = s.idle[:n-1]
+	return c, nil
+}
+
+// put returns c to the idle list, or closes it if the store is closed.
+func (s *RedisStore) put(c *redisConn) {
+	c.lastUsed = clock.Or(s.Clock).Now()
+
+	s.mu.Lock()
+	closed := s.closed
+	if !closed {
+		s.idle = append(s.idle, c)
+	}
+	s.mu.Unlock()
+
+	if closed {
+		c.close()
+	}
+}
+
+func (s *RedisStore) tokenKey(tokenID string) string {
+	return s.keyPrefix() + "token:" + tokenID
+}
+
+func (s *RedisStore) recipientKey(recipient string) string {
+	return s.keyPrefix() + "recipient:" + recipient
+}
+
+func (s *RedisStore) keyPrefix() string {
+	if s.KeyPrefix == "" {
+		return DefaultRedisKeyPrefix
+	}
+	return s.KeyPrefix
+}
+
+// ttl returns the key TTL in milliseconds for a token expiring at expiresAt.
+// It is relative to the store's clock, so the server's clock does not matter.
+func (s *RedisStore) ttl(expiresAt time.Time) string {
+	grace := s.ExpiryGrace
+	switch {
+	case grace == 0:
+		grace = DefaultRedisExpiryGrace
+	case grace < 0:
+		grace = 0
+	}
+	ms := (expiresAt.Sub(clock.Or(s.Clock).Now()) + grace).Milliseconds()
+	return strconv.FormatInt(max(ms, 1), 10)
+}
+
+// logger returns the configured logger with redaction applied.
+func (s *RedisStore) logger() *slog.Logger {
+	return logging.Redacted(s.Logger)
+}
+
+// redisFields encodes tok, minus its ID, as HSET field/value pairs. Hashes
+// are stored as raw bytes and times as RFC 3339 in UTC.
+func redisFields(tok Token) []string {
+	return []string{
+		"recipient", tok.Recipient,
+		"code_hash", string(tok.CodeHash),
+		"link_hash", string(tok.LinkHash),
+		"attempts", strconv.Itoa(tok.Attempts),
+		"resend_count", strconv.Itoa(tok.ResendCount),
+		"expires_at", formatRedisTime(tok.ExpiresAt),
+		"created_at", formatRedisTime(tok.CreatedAt),
+		"last_sent_at", formatRedisTime(tok.LastSentAt),
+	}
+}
+
+// parseRedisToken decodes an HGETALL reply. An empty reply means the key does
+// not exist and yields ErrTokenNotFound.
+func parseRedisToken(tokenID string, reply any) (*Token, error) {
+	pairs, err := replyStrings(reply)
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, ErrTokenNotFound
+	}
+
+	tok := &Token{ID: tokenID}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := pairs[i+1]
+		switch pairs[i] {
+		case "recipient":
+			tok.Recipient = value
+		case "code_hash":
+			tok.CodeHash = redisBytes(value)
+		case "link_hash":
+			tok.LinkHash = redisBytes(value)
+		case "attempts":
+			tok.Attempts, err = strconv.Atoi(value)
+		case "resend_count":
+			tok.ResendCount, err = strconv.Atoi(value)
+		case "expires_at":
+			tok.ExpiresAt, err = time.Parse(time.RFC3339Nano, value)
+		case "created_at":
+			tok.CreatedAt, err = time.Parse(time.RFC3339Nano, value)
+		case "last_sent_at":
+			tok.LastSentAt, err = time.Parse(time.RFC3339Nano, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode token field %s: %w", pairs[i], err)
+		}
+	}
+	return tok, nil
+}
+
+func formatRedisTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// redisBytes decodes a stored hash; an empty value means none.
+func redisBytes(value string) []byte {
+	if value == "" {
+		return nil
+	}
+	return []byte(value)
+}
@@ -0,0 +1,298 @@
+package store_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/mockredis"
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+// newRedisStore starts srv, configured by the caller, and returns a store
+// connected to it.
+func newRedisStore(t *testing.T, srv *mockredis.Server) *store.RedisStore {
+	t.Helper()
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start mock Redis: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	s := store.NewRedisStore(srv.Addr())
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRedisStore_Keys(t *testing.T) {
+	ctx := context.Background()
+	srv := mockredis.NewServer()
+	s := newRedisStore(t, srv)
+	codeHash := sha256.Sum256([]byte("123456"))
+	now := time.Now()
+
+	tok := store.Token{
+		ID:         "keys-1",
+		Recipient:  "keys@example.com",
+		CodeHash:   codeHash[:],
+		ExpiresAt:  now.Add(10 * time.Minute),
+		CreatedAt:  now,
+		LastSentAt: now,
+	}
+	if err := s.Store(ctx, tok); err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+
+	fields := srv.HGetAll(0, "passwordless:token:keys-1")
+	if fields["recipient"] != "keys@example.com" || fields["code_hash"] != string(codeHash[:]) || fields["attempts"] != "0" {
+		t.Errorf("Unexpected token hash: %q", fields)
+	}
+	if members := srv.SMembers(0, "passwordless:recipient:keys@example.com"); len(members) != 1 || members[0] != "keys-1" {
+		t.Errorf("Expected the recipient set to hold the token ID, got %q", members)
+	}
+
+	// The key expires ExpiryGrace after the token
+	assertTTL := func(key string, want time.Duration) {
+		t.Helper()
+		got, ok := srv.TTL(0, key)
+		if !ok || got < want-5*time.Second || got > want {
+			t.Errorf("Expected %s to expire in about %v, got %v (%v)", key, want, got, ok)
+		}
+	}
+	assertTTL("passwordless:token:keys-1", 10*time.Minute+store.DefaultRedisExpiryGrace)
+	assertTTL("passwordless:recipient:keys@example.com", 10*time.Minute+store.DefaultRedisExpiryGrace)
+
+	t.Run("ReissueExtendsTTL", func(t *testing.T) {
+		err := s.Reissue(ctx, store.Token{ID: "keys-1", CodeHash: codeHash[:], ExpiresAt: now.Add(20 * time.Minute)}, 0)
+		if err != nil {
+			t.Fatalf("Reissue() error: %v", err)
+		}
+		assertTTL("passwordless:token:keys-1", 20*time.Minute+store.DefaultRedisExpiryGrace)
+		assertTTL("passwordless:recipient:keys@example.com", 20*time.Minute+store.DefaultRedisExpiryGrace)
+	})
+
+	t.Run("SetOutlivesItsTokens", func(t *testing.T) {
+		short := tok
+		short.ID = "keys-2"
+		short.ExpiresAt = now.Add(time.Minute)
+		if err := s.Store(ctx, short); err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		assertTTL("passwordless:token:keys-2", time.Minute+store.DefaultRedisExpiryGrace)
+		assertTTL("passwordless:recipient:keys@example.com", 20*time.Minute+store.DefaultRedisExpiryGrace)
+	})
+
+	t.Run("ReplacingMovesRecipient", func(t *testing.T) {
+		moved := tok
+		moved.Recipient = "other@example.com"
+		if err := s.Store(ctx, moved); err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		if members := srv.SMembers(0, "passwordless:recipient:keys@example.com"); len(members) != 1 || members[0] != "keys-2" {
+			t.Errorf("Expected keys-1 to leave its old recipient's set, got %q", members)
+		}
+		if n, err := s.DeleteByRecipient(ctx, "other@example.com"); err != nil || n != 1 {
+			t.Errorf("Expected 1 token for the new recipient, got %d (%v)", n, err)
+		}
+	})
+
+	t.Run("KeyPrefix", func(t *testing.T) {
+		prefixed := store.NewRedisStore(srv.Addr())
+		prefixed.KeyPrefix = "app1:"
+		defer prefixed.Close()
+		if err := prefixed.Store(ctx, tok); err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		if srv.HGetAll(0, "app1:token:keys-1") == nil {
+			t.Errorf("Expected the prefixed key to exist, keys are %q", srv.Keys(0))
+		}
+	})
+}
+
+func TestRedisStore_NativeExpiry(t *testing.T) {
+	ctx := context.Background()
+	codeHash := sha256.Sum256([]byte("123456"))
+
+	newStore := func(grace time.Duration) (*store.RedisStore, *mockredis.Server, *clocktest.Fake) {
+		clk := clocktest.NewFake(time.Now())
+		srv := &mockredis.Server{Clock: clk}
+		s := newRedisStore(t, srv)
+		s.Clock = clk
+		s.ExpiryGrace = grace
+		err := s.Store(ctx, store.Token{
+			ID:        "native",
+			Recipient: "native@example.com",
+			CodeHash:  codeHash[:],
+			ExpiresAt: clk.Now().Add(time.Minute),
+			CreatedAt: clk.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		return s, srv, clk
+	}
+
+	t.Run("WithinGrace", func(t *testing.T) {
+		s, srv, clk := newStore(0)
+		clk.Advance(2 * time.Minute)
+		if _, err := s.Exists(ctx, "native"); !errors.Is(err, store.ErrTokenExpired) {
+			t.Errorf("Expected ErrTokenExpired during the grace period, got %v", err)
+		}
+		if keys := srv.Keys(0); len(keys) != 0 {
+			t.Errorf("Expected the expired token and its set to be deleted, got %q", keys)
+		}
+	})
+
+	t.Run("AfterGrace", func(t *testing.T) {
+		s, srv, clk := newStore(0)
+		clk.Advance(time.Minute + store.DefaultRedisExpiryGrace + time.Second)
+		if keys := srv.Keys(0); len(keys) != 0 {
+			t.Errorf("Expected Redis to expire the keys, got %q", keys)
+		}
+		if _, err := s.VerifyAndConsume(ctx, "native", "123456", 3); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Errorf("Expected ErrTokenNotFound, got %v", err)
+		}
+	})
+
+	t.Run("NoGrace", func(t *testing.T) {
+		_, srv, clk := newStore(-1)
+		clk.Advance(time.Minute)
+		if keys := srv.Keys(0); len(keys) != 0 {
+			t.Errorf("Expected the keys to expire with the token, got %q", keys)
+		}
+	})
+}
+
+func TestRedisStore_Auth(t *testing.T) {
+	ctx := context.Background()
+	srv := &mockredis.Server{Password: "s3cret", Username: "app"}
+	newRedisStore(t, srv)
+
+	for _, tt := range []struct {
+		name, user, password string
+		want                 string // Substring of the error; empty for success
+	}{
+		{"NoPassword", "", "", "NOAUTH"},
+		{"WrongPassword", "", "wrong", "WRONGPASS"},
+		{"DefaultUser", "", "s3cret", ""},
+		{"ACLUser", "app", "s3cret", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := store.NewRedisStore(srv.Addr())
+			s.Username, s.Password = tt.user, tt.password
+			defer s.Close()
+			err := s.Ping(ctx)
+			if tt.want == "" && err != nil {
+				t.Errorf("Ping() error: %v", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("SelectDB", func(t *testing.T) {
+		s := store.NewRedisStore(srv.Addr())
+		s.Password, s.DB = "s3cret", 3
+		defer s.Close()
+		if err := s.Store(ctx, store.Token{ID: "db3", ExpiresAt: time.Now().Add(time.Minute)}); err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		if len(srv.Keys(0)) != 0 || srv.HGetAll(3, "passwordless:token:db3") == nil {
+			t.Errorf("Expected the token in database 3, got %q", srv.Keys(3))
+		}
+	})
+}
+
+func TestRedisStore_Connections(t *testing.T) {
+	ctx := context.Background()
+	srv := mockredis.NewServer()
+	s := newRedisStore(t, srv)
+	clk := clocktest.NewFake(time.Now())
+	s.Clock = clk
+	codeHash := sha256.Sum256([]byte("123456"))
+	tok := store.Token{ID: "conn", Recipient: "conn@example.com", CodeHash: codeHash[:], ExpiresAt: clk.Now().Add(time.Hour)}
+	if err := s.Store(ctx, tok); err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+
+	t.Run("ReconnectsAfterIdle", func(t *testing.T) {
+		// An idle connection is checked with PING before it is used again.
+		srv.CloseConnections()
+		clk.Advance(time.Minute)
+		if _, err := s.Exists(ctx, "conn"); err != nil {
+			t.Errorf("Expected a new connection after the server dropped the old one, got %v", err)
+		}
+	})
+
+	t.Run("NoRetryAfterSending", func(t *testing.T) {
+		// A command that went out on a dropped connection may have run, so
+		// its error is reported rather than the command sent again.
+		srv.CloseConnections()
+		before := srv.CommandCount("HGETALL")
+		if _, err := s.Exists(ctx, "conn"); err == nil {
+			t.Error("Expected an error from the dropped connection")
+		}
+		if n := srv.CommandCount("HGETALL"); n != before {
+			t.Errorf("Expected HGETALL not to be re-sent, got %d more", n-before)
+		}
+		if _, err := s.Exists(ctx, "conn"); err != nil {
+			t.Errorf("Expected the next call to use a new connection, got %v", err)
+		}
+	})
+
+	t.Run("ServerError", func(t *testing.T) {
+		srv.FailOnce("EXEC", "LOADING Redis is loading the dataset in memory")
+		if _, err := s.VerifyAndConsume(ctx, "conn", "000000", 3); err == nil || !strings.Contains(err.Error(), "LOADING") {
+			t.Errorf("Expected the server's error, got %v", err)
+		}
+		if tok, err := s.Exists(ctx, "conn"); err != nil || tok.Attempts != 0 {
+			t.Errorf("Expected the failed transaction to change nothing, got %+v (%v)", tok, err)
+		}
+	})
+
+	t.Run("TokenErrorsKeepConnection", func(t *testing.T) {
+		// Token outcomes come from a healthy connection, which goes back to the pool.
+		expired := store.Token{ID: "conn-expired", Recipient: "conn@example.com", CodeHash: codeHash[:], ExpiresAt: clk.Now().Add(time.Second)}
+		if err := s.Store(ctx, expired); err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		clk.Advance(2 * time.Second)
+		before := srv.Connections()
+
+		if err := s.Reissue(ctx, store.Token{ID: "conn", CodeHash: codeHash[:], ExpiresAt: tok.ExpiresAt}, 5); !errors.Is(err, store.ErrTokenConflict) {
+			t.Errorf("Expected ErrTokenConflict, got %v", err)
+		}
+		if _, err := s.VerifyAndConsume(ctx, "missing", "123456", 3); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Errorf("Expected ErrTokenNotFound, got %v", err)
+		}
+		if _, err := s.VerifyAndConsume(ctx, "conn-expired", "123456", 3); !errors.Is(err, store.ErrTokenExpired) {
+			t.Errorf("Expected ErrTokenExpired, got %v", err)
+		}
+		if _, err := s.VerifyAndConsume(ctx, "conn", "000000", 3); !errors.Is(err, store.ErrInvalidCode) {
+			t.Errorf("Expected ErrInvalidCode, got %v", err)
+		}
+		if n := srv.Connections(); n != before {
+			t.Errorf("Expected token errors to reuse the connection, got %d new connections", n-before)
+		}
+		_ = s.UpdateAttempts(ctx, "conn", 0)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := s.Exists(canceled, "conn"); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		s.Close()
+		if err := s.Ping(ctx); !errors.Is(err, store.ErrRedisClosed) {
+			t.Errorf("Expected ErrRedisClosed, got %v", err)
+		}
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/rlnorthcutt/go-passwordless/clock"
 	"github.com/rlnorthcutt/go-passwordless/store"
 )
 
@@ -26,10 +27,13 @@ func WithRequestResponse(ctx context.Context, r *http.Request, w http.ResponseWr
 }
 
 // CookieStore manages passwordless tokens using Gorilla Sessions.
+// The token, attempt counter included, lives in the client's cookie, so its
+// operations are not atomic and a client can replay an older cookie.
 type CookieStore struct {
 	store         *sessions.CookieStore
 	CookieName    string
 	DefaultExpiry time.Duration
+	Clock         clock.Clock // Optional; decides whether tokens have expired (defaults to the real clock)
 }
 
 // NewCookieStore initializes a new cookie store with encryption keys.
@@ -73,14 +77,27 @@ func (cs *CookieStore) Exists(ctx context.Context, tokenID string) (*store.Token
 		return nil, errors.New("failed to retrieve session")
 	}
 
-	tok, err := getSessionToken(session, tokenID)
+	tok, err := getSessionToken(session, tokenID, cs.Clock)
 	if err != nil {
 		// Token not found or expired
-		return nil, cs.Delete(ctx, tokenID)
+		if delErr := cs.Delete(ctx, tokenID); delErr != nil {
+			return nil, delErr
+		}
+		return nil, err
 	}
 	return tok, nil
 }
 
+// UpdateAttempts persists the failed-attempt count for the token held in the session.
+func (cs *CookieStore) UpdateAttempts(ctx context.Context, tokenID string, attempts int) error {
+	tok, err := cs.Exists(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	tok.Attempts = attempts
+	return cs.Store(ctx, *tok)
+}
+
 // Verify checks if the provided code matches the stored token's hash.
 func (cs *CookieStore) Verify(ctx context.Context, tokenID, code string) (bool, error) {
 	tok, err := cs.Exists(ctx, tokenID)
@@ -92,14 +109,39 @@ func (cs *CookieStore) Verify(ctx context.Context, tokenID, code string) (bool,
 	if !store.VerifyToken(tok, code) {
 		tok.Attempts++
 		_ = cs.Store(ctx, *tok) // Save attempts count
-		return false, errors.New("invalid code")
+		return false, store.ErrInvalidCode
 	}
 
 	// Token is verified; remove it for one-time use.
 	return true, cs.Delete(ctx, tokenID)
 }
 
+// Reissue replaces the code and link hashes, expiry and resend bookkeeping of the token held in the session.
+func (cs *CookieStore) Reissue(ctx context.Context, tok store.Token, prevResendCount int) error {
+	return reissue(ctx, cs, tok, prevResendCount)
+}
+
+// VerifyAndConsume checks the code, then consumes the token or records the failed attempt.
+func (cs *CookieStore) VerifyAndConsume(ctx context.Context, tokenID, code string, maxAttempts int) (*store.Token, error) {
+	return verifyAndConsume(ctx, cs, tokenID, maxAttempts, func(tok *store.Token) bool {
+		return store.VerifyToken(tok, code)
+	})
+}
+
+// VerifyLinkAndConsume checks the link secret, then consumes the token or records the failed attempt.
+func (cs *CookieStore) VerifyLinkAndConsume(ctx context.Context, tokenID, secret string, maxAttempts int) (*store.Token, error) {
+	return verifyAndConsume(ctx, cs, tokenID, maxAttempts, func(tok *store.Token) bool {
+		return store.VerifyLink(tok, secret)
+	})
+}
+
 // Delete removes the session token from the store.
 func (cs *CookieStore) Delete(ctx context.Context, tokenID string) error {
 	return deleteSession(ctx, cs.store, cs.CookieName, tokenID)
 }
+
+// DeleteByRecipient removes the session token if it belongs to recipient.
+// Only the session of the request in ctx can be reached.
+func (cs *CookieStore) DeleteByRecipient(ctx context.Context, recipient string) (int, error) {
+	return deleteSessionByRecipient(ctx, cs.store, cs.CookieName, recipient)
+}
@@ -3,6 +3,7 @@ package session_test
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -94,6 +95,19 @@ func TestCookieStore(t *testing.T) {
 		})
 	})
 
+	t.Run("NotFound", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		ctx := session.WithRequestResponse(context.Background(), req, w)
+
+		if _, err := cs.Exists(ctx, "no-such-token"); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Fatalf("Expected ErrTokenNotFound, got %v", err)
+		}
+		if _, err := cs.Verify(ctx, "no-such-token", "code"); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Fatalf("Expected ErrTokenNotFound from Verify, got %v", err)
+		}
+	})
+
 	t.Run("ManualDeletion", func(t *testing.T) {
 		tokenID := "testid-delete"
 		code := "delete-code"
@@ -132,4 +146,36 @@ func TestCookieStore(t *testing.T) {
 		}
 		t.Logf("Token ID: %s correctly deleted", tokenID)
 	})
+	t.Run("DeleteByRecipient", func(t *testing.T) {
+		tokenID := "testid-recipient"
+		codeHash := sha256.Sum256([]byte("recipient-code"))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		ctx := session.WithRequestResponse(context.Background(), req, w)
+
+		err := cs.Store(ctx, store.Token{
+			ID:        tokenID,
+			Recipient: "owner@test",
+			CodeHash:  codeHash[:],
+			ExpiresAt: time.Now().Add(5 * time.Minute),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to store token: %v", err)
+		}
+
+		req.AddCookie(w.Result().Cookies()[0])
+		ctx = session.WithRequestResponse(context.Background(), req, httptest.NewRecorder())
+
+		if n, err := cs.DeleteByRecipient(ctx, "someone-else@test"); err != nil || n != 0 {
+			t.Fatalf("Expected no tokens removed for another recipient, got n=%d err=%v", n, err)
+		}
+		if n, err := cs.DeleteByRecipient(ctx, "owner@test"); err != nil || n != 1 {
+			t.Fatalf("Expected 1 token removed, got n=%d err=%v", n, err)
+		}
+		if _, err := cs.Exists(ctx, tokenID); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Fatalf("Expected ErrTokenNotFound after DeleteByRecipient, got %v", err)
+		}
+	})
 }
@@ -7,14 +7,21 @@ import (
 	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/rlnorthcutt/go-passwordless/clock"
 	"github.com/rlnorthcutt/go-passwordless/store"
 )
 
 // FileStore manages passwordless tokens using Gorilla Sessions with file storage.
+// Operations that read and then rewrite a token hold a per-token lock, so
+// concurrent requests in one process cannot both redeem a code. The lock does
+// not extend to other processes sharing the session directory.
 type FileStore struct {
 	store         *sessions.FilesystemStore
 	CookieName    string
 	DefaultExpiry time.Duration
+	Clock         clock.Clock // Optional; decides whether tokens have expired (defaults to the real clock)
+
+	locks tokenLocks
 }
 
 // NewFileStore initializes a new file-based session store.
@@ -68,16 +75,31 @@ func (fs *FileStore) Exists(ctx context.Context, tokenID string) (*store.Token,
 		return nil, errors.New("failed to retrieve session")
 	}
 
-	tok, err := getSessionToken(session, tokenID)
+	tok, err := getSessionToken(session, tokenID, fs.Clock)
 	if err != nil {
 		// Token not found or expired
-		return nil, fs.Delete(ctx, tokenID)
+		if delErr := fs.Delete(ctx, tokenID); delErr != nil {
+			return nil, delErr
+		}
+		return nil, err
 	}
 	return tok, nil
 }
 
+// UpdateAttempts persists the failed-attempt count for the token held in the session.
+func (fs *FileStore) UpdateAttempts(ctx context.Context, tokenID string, attempts int) error {
+	defer fs.locks.lock(tokenID)()
+	tok, err := fs.Exists(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	tok.Attempts = attempts
+	return fs.Store(ctx, *tok)
+}
+
 // Verify checks if the provided code matches the stored token's hash.
 func (fs *FileStore) Verify(ctx context.Context, tokenID, code string) (bool, error) {
+	defer fs.locks.lock(tokenID)()
 	tok, err := fs.Exists(ctx, tokenID)
 	if err != nil {
 		return false, err
@@ -87,14 +109,42 @@ func (fs *FileStore) Verify(ctx context.Context, tokenID, code string) (bool, er
 	if !store.VerifyToken(tok, code) {
 		tok.Attempts++
 		_ = fs.Store(ctx, *tok) // Save attempts count
-		return false, errors.New("invalid code")
+		return false, store.ErrInvalidCode
 	}
 
 	// Delete token after successful verification (one-time use)
 	return true, fs.Delete(ctx, tokenID)
 }
 
+// Reissue replaces the code and link hashes, expiry and resend bookkeeping of the token held in the session.
+func (fs *FileStore) Reissue(ctx context.Context, tok store.Token, prevResendCount int) error {
+	defer fs.locks.lock(tok.ID)()
+	return reissue(ctx, fs, tok, prevResendCount)
+}
+
+// VerifyAndConsume checks the code, then consumes the token or records the failed attempt.
+func (fs *FileStore) VerifyAndConsume(ctx context.Context, tokenID, code string, maxAttempts int) (*store.Token, error) {
+	defer fs.locks.lock(tokenID)()
+	return verifyAndConsume(ctx, fs, tokenID, maxAttempts, func(tok *store.Token) bool {
+		return store.VerifyToken(tok, code)
+	})
+}
+
+// VerifyLinkAndConsume checks the link secret, then consumes the token or records the failed attempt.
+func (fs *FileStore) VerifyLinkAndConsume(ctx context.Context, tokenID, secret string, maxAttempts int) (*store.Token, error) {
+	defer fs.locks.lock(tokenID)()
+	return verifyAndConsume(ctx, fs, tokenID, maxAttempts, func(tok *store.Token) bool {
+		return store.VerifyLink(tok, secret)
+	})
+}
+
 // Delete removes the session token from the store.
 func (fs *FileStore) Delete(ctx context.Context, tokenID string) error {
 	return deleteSession(ctx, fs.store, fs.CookieName, tokenID)
 }
+
+// DeleteByRecipient removes the session token if it belongs to recipient.
+// Only the session of the request in ctx can be reached.
+func (fs *FileStore) DeleteByRecipient(ctx context.Context, recipient string) (int, error) {
+	return deleteSessionByRecipient(ctx, fs.store, fs.CookieName, recipient)
+}
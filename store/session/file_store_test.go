@@ -3,9 +3,11 @@ package session_test
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -99,6 +101,61 @@ func TestFileStore(t *testing.T) {
 		})
 	})
 
+	t.Run("NotFound", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		ctx := session.WithRequestResponse(context.Background(), req, w)
+
+		if _, err := fs.Exists(ctx, "no-such-token"); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Fatalf("Expected ErrTokenNotFound, got %v", err)
+		}
+		if _, err := fs.Verify(ctx, "no-such-token", "code"); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Fatalf("Expected ErrTokenNotFound from Verify, got %v", err)
+		}
+	})
+
+	t.Run("ConcurrentVerifyAndConsume", func(t *testing.T) {
+		tokenID := "testid-concurrent"
+		codeHash := sha256.Sum256([]byte("race-code"))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		err := fs.Store(session.WithRequestResponse(context.Background(), req, w), store.Token{
+			ID: tokenID, Recipient: "race@test", CodeHash: codeHash[:],
+			ExpiresAt: time.Now().Add(time.Minute), CreatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to store token: %v", err)
+		}
+		cookie := w.Result().Cookies()[0]
+
+		// Every request carries the same cookie; only one may redeem the code.
+		var wg sync.WaitGroup
+		results := make(chan error, 10)
+		for i := 0; i < cap(results); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.AddCookie(cookie)
+				ctx := session.WithRequestResponse(context.Background(), req, httptest.NewRecorder())
+				_, err := fs.VerifyAndConsume(ctx, tokenID, "race-code", 3)
+				results <- err
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		redeemed := 0
+		for err := range results {
+			if err == nil {
+				redeemed++
+			}
+		}
+		if redeemed != 1 {
+			t.Errorf("Expected the code to be redeemed once, got %d", redeemed)
+		}
+	})
+
 	t.Run("ManualDeletion", func(t *testing.T) {
 		tokenID := "testid-delete"
 		code := "delete-code"
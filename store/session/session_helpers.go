@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/rlnorthcutt/go-passwordless/clock"
 	"github.com/rlnorthcutt/go-passwordless/store"
 )
 
@@ -25,33 +27,96 @@ func setSessionValues(session *sessions.Session, tok store.Token) {
 	session.Values["tokenID"] = tok.ID
 	session.Values["recipient"] = tok.Recipient
 	session.Values["codeHash"] = tok.CodeHash
+	session.Values["linkHash"] = tok.LinkHash
 	session.Values["expiresAt"] = tok.ExpiresAt.Unix()
 	session.Values["createdAt"] = tok.CreatedAt.Unix()
 	session.Values["attempts"] = tok.Attempts
+	session.Values["resendCount"] = tok.ResendCount
+	session.Values["lastSentAt"] = tok.LastSentAt.Unix()
 }
 
-// getSessionToken retrieves the token from session and checks expiration.
-func getSessionToken(session *sessions.Session, tokenID string) (*store.Token, error) {
+// getSessionToken retrieves the token from session and checks expiration against c.
+func getSessionToken(session *sessions.Session, tokenID string, c clock.Clock) (*store.Token, error) {
 	if session.Values["tokenID"] != tokenID {
-		return nil, errors.New("token not found")
+		return nil, store.ErrTokenNotFound
 	}
 
 	expiresAtUnix, ok := session.Values["expiresAt"].(int64)
 	if !ok {
 		return nil, errors.New("invalid session expiration")
 	}
-	if time.Now().After(time.Unix(expiresAtUnix, 0)) { // Using the generic store method
-		return nil, errors.New("token expired")
-	}
 
-	return &store.Token{
+	tok := &store.Token{
 		ID:        session.Values["tokenID"].(string),
 		Recipient: session.Values["recipient"].(string),
 		CodeHash:  session.Values["codeHash"].([]byte),
 		ExpiresAt: time.Unix(expiresAtUnix, 0),
 		CreatedAt: time.Unix(session.Values["createdAt"].(int64), 0),
 		Attempts:  session.Values["attempts"].(int),
-	}, nil
+	}
+	if store.IsTokenExpired(tok, c) {
+		return nil, store.ErrTokenExpired
+	}
+
+	// Resend bookkeeping may be missing from sessions written by older versions.
+	if n, ok := session.Values["resendCount"].(int); ok {
+		tok.ResendCount = n
+	}
+	if ts, ok := session.Values["lastSentAt"].(int64); ok {
+		tok.LastSentAt = time.Unix(ts, 0)
+	}
+	if h, ok := session.Values["linkHash"].([]byte); ok {
+		tok.LinkHash = h
+	}
+	return tok, nil
+}
+
+// reissue implements TokenStore.Reissue on top of a session store.
+func reissue(ctx context.Context, s store.TokenStore, tok store.Token, prevResendCount int) error {
+	existing, err := s.Exists(ctx, tok.ID)
+	if err != nil {
+		return err
+	}
+	if existing.ResendCount != prevResendCount {
+		return store.ErrTokenConflict
+	}
+	existing.CodeHash = tok.CodeHash
+	existing.LinkHash = tok.LinkHash
+	existing.ExpiresAt = tok.ExpiresAt
+	existing.ResendCount = tok.ResendCount
+	existing.LastSentAt = tok.LastSentAt
+	return s.Store(ctx, *existing)
+}
+
+// verifyAndConsume implements TokenStore.VerifyAndConsume and
+// VerifyLinkAndConsume on top of a session store; match reports whether the
+// presented credential is correct. It is not atomic by itself: FileStore
+// holds a tokenLocks lock around it, and CookieStore cannot be made atomic
+// because the client holds the state (see the package README).
+func verifyAndConsume(ctx context.Context, s store.TokenStore, tokenID string, maxAttempts int, match func(*store.Token) bool) (*store.Token, error) {
+	tok, err := s.Exists(ctx, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !match(tok) {
+		tok.Attempts++
+		if tok.Attempts >= maxAttempts {
+			if err := s.Delete(ctx, tokenID); err != nil {
+				return nil, err
+			}
+			return nil, store.ErrTokenLocked
+		}
+		if err := s.Store(ctx, *tok); err != nil {
+			return nil, err
+		}
+		return nil, store.NewInvalidCodeError(tok.Attempts, maxAttempts)
+	}
+
+	if err := s.Delete(ctx, tokenID); err != nil {
+		return nil, err
+	}
+	return tok, nil
 }
 
 // deleteSession invalidates the session and removes token data.
@@ -72,3 +137,60 @@ func deleteSession(ctx context.Context, store sessions.Store, cookieName, tokenI
 	err = session.Save(req, rsp)
 	return err
 }
+
+// deleteSessionByRecipient invalidates the session if its token was issued to recipient.
+func deleteSessionByRecipient(ctx context.Context, store sessions.Store, cookieName, recipient string) (int, error) {
+	req, _, err := getContextRequestResponse(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	session, _ := store.Get(req, cookieName)
+	tokenID, ok := session.Values["tokenID"].(string)
+	if !ok || session.Values["recipient"] != recipient {
+		return 0, nil
+	}
+
+	if err := deleteSession(ctx, store, cookieName, tokenID); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// tokenLocks serializes read-modify-write operations on the same token ID
+// within a process. The zero value is ready to use.
+type tokenLocks struct {
+	mu    sync.Mutex
+	locks map[string]*tokenLock
+}
+
+type tokenLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock blocks until no other caller holds tokenID and returns the function
+// that releases it.
+func (l *tokenLocks) lock(tokenID string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*tokenLock)
+	}
+	tl, ok := l.locks[tokenID]
+	if !ok {
+		tl = &tokenLock{}
+		l.locks[tokenID] = tl
+	}
+	tl.refs++
+	l.mu.Unlock()
+
+	tl.mu.Lock()
+	return func() {
+		tl.mu.Unlock()
+		l.mu.Lock()
+		if tl.refs--; tl.refs == 0 {
+			delete(l.locks, tokenID)
+		}
+		l.mu.Unlock()
+	}
+}
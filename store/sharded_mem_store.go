@@ -0,0 +1,416 @@
+package store
+
+import (
+	"container/heap"
+	"context"
+	"hash/maphash"
+	"log/slog"
+	"sync"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+	"github.com/rlnorthcutt/go-passwordless/logging"
+)
+
+// DefaultShardCount is the number of shards NewShardedMemStore uses when
+// given zero.
+const DefaultShardCount = 32
+
+// ShardedMemStore is an in-memory TokenStore for high request rates. Tokens
+// are spread by ID over shards that are locked independently, so concurrent
+// logins rarely wait for each other. Each shard keeps its tokens in a heap
+// ordered by expiry, which lets PurgeExpired touch only expired tokens and
+// makes eviction cheap.
+//
+// A store created with a token limit never holds more than that many tokens:
+// storing a new token into a full shard first evicts the shard's token that
+// expires soonest (an expired one, if any). This bounds memory use when
+// StartLogin is flooded, at the cost of invalidating the oldest pending logins.
+type ShardedMemStore struct {
+	// Logger receives debug records for stored, consumed, expired, locked and
+	// evicted tokens. If nil, nothing is logged. Recipients are masked (see logging.Redacted).
+	Logger *slog.Logger
+
+	// Clock is used to decide whether tokens have expired. If nil, the real clock is used.
+	Clock clock.Clock
+
+	seed   maphash.Seed
+	shards []*memShard
+}
+
+// memShard is one independently locked part of a ShardedMemStore.
+type memShard struct {
+	mu          sync.Mutex
+	tokens      map[string]*memEntry
+	byRecipient map[string]map[string]struct{} // recipient -> token IDs
+	expiry      expiryHeap
+	capacity    int // Zero means unbounded
+}
+
+// memEntry is a stored token and its position in the shard's expiry heap.
+type memEntry struct {
+	tok   Token
+	index int
+}
+
+// NewShardedMemStore returns a store with the given number of shards
+// (DefaultShardCount if shards <= 0) holding at most maxTokens tokens
+// (unbounded if maxTokens <= 0). The limit is split between the shards.
+func NewShardedMemStore(shards, maxTokens int) *ShardedMemStore {
+	if shards <= 0 {
+		shards = DefaultShardCount
+	}
+	if maxTokens > 0 && shards > maxTokens {
+		shards = maxTokens
+	}
+
+	s := &ShardedMemStore{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*memShard, shards),
+	}
+	for i := range s.shards {
+		sh := &memShard{
+			tokens:      make(map[string]*memEntry),
+			byRecipient: make(map[string]map[string]struct{}),
+		}
+		if maxTokens > 0 {
+			// Spread the remainder so the capacities add up to maxTokens.
+			sh.capacity = maxTokens / shards
+			if i < maxTokens%shards {
+				sh.capacity++
+			}
+		}
+		s.shards[i] = sh
+	}
+	return s
+}
+
+// shard returns the shard holding tokenID.
+func (s *ShardedMemStore) shard(tokenID string) *memShard {
+	return s.shards[maphash.String(s.seed, tokenID)%uint64(len(s.shards))]
+}
+
+// Store saves tok, evicting the shard's soonest-expiring token if the shard is full.
+func (s *ShardedMemStore) Store(ctx context.Context, tok Token) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	sh := s.shard(tok.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if e, ok := sh.tokens[tok.ID]; ok {
+		sh.unindex(e.tok)
+		e.tok = tok
+		heap.Fix(&sh.expiry, e.index)
+	} else {
+		if sh.capacity > 0 && len(sh.tokens) >= sh.capacity {
+			victim := sh.expiry[0].tok
+			sh.remove(victim.ID)
+			s.logger().DebugContext(ctx, "token evicted",
+				logging.KeyTokenID, victim.ID, logging.KeyRecipient, victim.Recipient)
+		}
+		e := &memEntry{tok: tok}
+		heap.Push(&sh.expiry, e)
+		sh.tokens[tok.ID] = e
+	}
+	sh.index(tok)
+
+	s.logger().DebugContext(ctx, "token stored",
+		logging.KeyTokenID, tok.ID, logging.KeyRecipient, tok.Recipient)
+	return nil
+}
+
+// Exists returns the token, removing it and returning ErrTokenExpired if it has expired.
+func (s *ShardedMemStore) Exists(ctx context.Context, tokenID string) (*Token, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	sh := s.shard(tokenID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	tok, err := s.live(ctx, sh, tokenID)
+	if err != nil {
+		return nil, err
+	}
+	return &tok, nil
+}
+
+// UpdateAttempts sets the failed-attempt counter of a token.
+func (s *ShardedMemStore) UpdateAttempts(ctx context.Context, tokenID string, attempts int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	sh := s.shard(tokenID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := sh.tokens[tokenID]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	e.tok.Attempts = attempts
+	return nil
+}
+
+// Reissue replaces the code and link hashes, expiry and resend bookkeeping of a token
+// unless another request reissued it since prevResendCount was read.
+func (s *ShardedMemStore) Reissue(ctx context.Context, tok Token, prevResendCount int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	sh := s.shard(tok.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := sh.tokens[tok.ID]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	if e.tok.ResendCount != prevResendCount {
+		return ErrTokenConflict
+	}
+	e.tok.CodeHash = tok.CodeHash
+	e.tok.LinkHash = tok.LinkHash
+	e.tok.ExpiresAt = tok.ExpiresAt
+	e.tok.ResendCount = tok.ResendCount
+	e.tok.LastSentAt = tok.LastSentAt
+	heap.Fix(&sh.expiry, e.index)
+	return nil
+}
+
+// Verify checks the code and, if it matches, consumes the token.
+func (s *ShardedMemStore) Verify(ctx context.Context, tokenID, code string) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	default:
+	}
+
+	sh := s.shard(tokenID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	tok, err := s.live(ctx, sh, tokenID)
+	if err != nil {
+		return false, err
+	}
+	if !VerifyToken(&tok, code) {
+		return false, ErrInvalidCode
+	}
+	sh.remove(tokenID)
+	return true, nil
+}
+
+// VerifyAndConsume checks the code and updates the token while holding the
+// shard's lock, so concurrent callers for one token are fully serialized.
+func (s *ShardedMemStore) VerifyAndConsume(ctx context.Context, tokenID, code string, maxAttempts int) (*Token, error) {
+	return s.consume(ctx, tokenID, maxAttempts, func(tok *Token) bool {
+		return VerifyToken(tok, code)
+	})
+}
+
+// VerifyLinkAndConsume checks the link secret like VerifyAndConsume checks a code.
+func (s *ShardedMemStore) VerifyLinkAndConsume(ctx context.Context, tokenID, secret string, maxAttempts int) (*Token, error) {
+	return s.consume(ctx, tokenID, maxAttempts, func(tok *Token) bool {
+		return VerifyLink(tok, secret)
+	})
+}
+
+// consume implements VerifyAndConsume and VerifyLinkAndConsume; match reports
+// whether the presented credential is correct for the token.
+func (s *ShardedMemStore) consume(ctx context.Context, tokenID string, maxAttempts int, match func(*Token) bool) (*Token, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	sh := s.shard(tokenID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	tok, err := s.live(ctx, sh, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !match(&tok) {
+		tok.Attempts++
+		if tok.Attempts >= maxAttempts {
+			sh.remove(tokenID)
+			s.logger().DebugContext(ctx, "token locked",
+				logging.KeyTokenID, tokenID, logging.KeyAttempts, tok.Attempts)
+			return nil, ErrTokenLocked
+		}
+		sh.tokens[tokenID].tok.Attempts = tok.Attempts
+		return nil, NewInvalidCodeError(tok.Attempts, maxAttempts)
+	}
+
+	sh.remove(tokenID)
+	s.logger().DebugContext(ctx, "token consumed", logging.KeyTokenID, tokenID)
+	return &tok, nil
+}
+
+// Delete removes a token.
+func (s *ShardedMemStore) Delete(ctx context.Context, tokenID string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	sh := s.shard(tokenID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.remove(tokenID)
+	return nil
+}
+
+// DeleteByRecipient removes every token issued to recipient. A recipient's
+// tokens can be in any shard, so each shard is locked in turn.
+func (s *ShardedMemStore) DeleteByRecipient(ctx context.Context, recipient string) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	n := 0
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for id := range sh.byRecipient[recipient] {
+			sh.remove(id)
+			n++
+		}
+		sh.mu.Unlock()
+	}
+	return n, nil
+}
+
+// PurgeExpired removes up to limit expired tokens (all of them if limit <= 0).
+// Expired tokens are at the top of each shard's heap, so the cost depends on
+// how many tokens are removed, not on how many are stored.
+func (s *ShardedMemStore) PurgeExpired(ctx context.Context, limit int) (int, error) {
+	now := clock.Or(s.Clock).Now()
+	n := 0
+	for _, sh := range s.shards {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		sh.mu.Lock()
+		for len(sh.expiry) > 0 && now.After(sh.expiry[0].tok.ExpiresAt) && (limit <= 0 || n < limit) {
+			sh.remove(sh.expiry[0].tok.ID)
+			n++
+		}
+		sh.mu.Unlock()
+	}
+	if n > 0 {
+		s.logger().DebugContext(ctx, "expired tokens purged", logging.KeyCount, n)
+	}
+	return n, nil
+}
+
+// Len reports how many tokens are stored, including expired ones not yet removed.
+func (s *ShardedMemStore) Len() int {
+	n := 0
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		n += len(sh.tokens)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// live returns a copy of the token, removing it if it has expired. The caller
+// must hold sh.mu.
+func (s *ShardedMemStore) live(ctx context.Context, sh *memShard, tokenID string) (Token, error) {
+	e, ok := sh.tokens[tokenID]
+	if !ok {
+		return Token{}, ErrTokenNotFound
+	}
+	if IsTokenExpired(&e.tok, s.Clock) {
+		sh.remove(tokenID)
+		s.logger().DebugContext(ctx, "token expired", logging.KeyTokenID, tokenID)
+		return Token{}, ErrTokenExpired
+	}
+	return e.tok, nil
+}
+
+// logger returns the configured logger with redaction applied.
+func (s *ShardedMemStore) logger() *slog.Logger {
+	return logging.Redacted(s.Logger)
+}
+
+// remove deletes a token from the map, heap and recipient index. The caller
+// must hold sh.mu.
+func (sh *memShard) remove(tokenID string) {
+	e, ok := sh.tokens[tokenID]
+	if !ok {
+		return
+	}
+	delete(sh.tokens, tokenID)
+	heap.Remove(&sh.expiry, e.index)
+	sh.unindex(e.tok)
+}
+
+// index adds tok to the recipient index. The caller must hold sh.mu.
+func (sh *memShard) index(tok Token) {
+	ids, ok := sh.byRecipient[tok.Recipient]
+	if !ok {
+		ids = make(map[string]struct{})
+		sh.byRecipient[tok.Recipient] = ids
+	}
+	ids[tok.ID] = struct{}{}
+}
+
+// unindex drops tok from the recipient index. The caller must hold sh.mu.
+func (sh *memShard) unindex(tok Token) {
+	ids := sh.byRecipient[tok.Recipient]
+	delete(ids, tok.ID)
+	if len(ids) == 0 {
+		delete(sh.byRecipient, tok.Recipient)
+	}
+}
+
+// expiryHeap is a container/heap of entries, soonest expiry first.
+type expiryHeap []*memEntry
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool {
+	return h[i].tok.ExpiresAt.Before(h[j].tok.ExpiresAt)
+}
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	e := x.(*memEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
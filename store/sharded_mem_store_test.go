@@ -0,0 +1,198 @@
+package store_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/store"
+)
+
+func TestShardedMemStore_Eviction(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	put := func(s store.TokenStore, id string, ttl time.Duration) {
+		t.Helper()
+		err := s.Store(ctx, store.Token{ID: id, Recipient: "evict@example.com", ExpiresAt: now.Add(ttl), CreatedAt: now})
+		if err != nil {
+			t.Fatalf("Store(%s) error: %v", id, err)
+		}
+	}
+
+	t.Run("SoonestExpiringFirst", func(t *testing.T) {
+		s := store.NewShardedMemStore(1, 3)
+		put(s, "b", 3*time.Minute)
+		put(s, "a", time.Minute)
+		put(s, "c", 2*time.Minute)
+		put(s, "d", 4*time.Minute)
+
+		if s.Len() != 3 {
+			t.Fatalf("Expected 3 tokens, got %d", s.Len())
+		}
+		if _, err := s.Exists(ctx, "a"); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Errorf("Expected the soonest-expiring token to be evicted, got %v", err)
+		}
+		for _, id := range []string{"b", "c", "d"} {
+			if _, err := s.Exists(ctx, id); err != nil {
+				t.Errorf("Expected %s to be kept, got %v", id, err)
+			}
+		}
+	})
+
+	t.Run("ReissueReordersExpiry", func(t *testing.T) {
+		s := store.NewShardedMemStore(1, 2)
+		put(s, "a", time.Minute)
+		put(s, "b", 2*time.Minute)
+		if err := s.Reissue(ctx, store.Token{ID: "a", ExpiresAt: now.Add(5 * time.Minute)}, 0); err != nil {
+			t.Fatalf("Reissue error: %v", err)
+		}
+		put(s, "c", 3*time.Minute)
+
+		if _, err := s.Exists(ctx, "b"); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Errorf("Expected b to be evicted after a was extended, got %v", err)
+		}
+		if _, err := s.Exists(ctx, "a"); err != nil {
+			t.Errorf("Expected the reissued token to be kept, got %v", err)
+		}
+	})
+
+	t.Run("ReplacingDoesNotEvict", func(t *testing.T) {
+		s := store.NewShardedMemStore(1, 2)
+		put(s, "a", time.Minute)
+		put(s, "b", 2*time.Minute)
+		put(s, "a", 3*time.Minute)
+		if s.Len() != 2 {
+			t.Errorf("Expected 2 tokens, got %d", s.Len())
+		}
+		if _, err := s.Exists(ctx, "b"); err != nil {
+			t.Errorf("Expected b to be kept, got %v", err)
+		}
+	})
+
+	t.Run("BoundAcrossShards", func(t *testing.T) {
+		for _, tt := range []struct{ shards, max int }{{8, 100}, {32, 5}, {0, 1}} {
+			s := store.NewShardedMemStore(tt.shards, tt.max)
+			for i := 0; i < 20*tt.max; i++ {
+				put(s, strconv.Itoa(i), time.Duration(i)*time.Second)
+			}
+			if s.Len() > tt.max {
+				t.Errorf("NewShardedMemStore(%d, %d): expected at most %d tokens, got %d", tt.shards, tt.max, tt.max, s.Len())
+			}
+		}
+	})
+
+	t.Run("DeleteByRecipientKeepsIndex", func(t *testing.T) {
+		s := store.NewShardedMemStore(1, 1)
+		put(s, "a", time.Minute)
+		put(s, "b", 2*time.Minute) // Evicts a
+		if n, err := s.DeleteByRecipient(ctx, "evict@example.com"); err != nil || n != 1 {
+			t.Errorf("Expected only the remaining token to be deleted, got %d (%v)", n, err)
+		}
+	})
+}
+
+func TestShardedMemStore_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewShardedMemStore(4, 64)
+	codeHash := sha256.Sum256([]byte("123456"))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				id := fmt.Sprintf("%d-%d", w, i)
+				s.Store(ctx, store.Token{
+					ID:        id,
+					Recipient: fmt.Sprintf("user%d@example.com", i%5),
+					CodeHash:  codeHash[:],
+					ExpiresAt: time.Now().Add(time.Duration(i%3-1) * time.Minute),
+				})
+				s.VerifyAndConsume(ctx, id, "000000", 3)
+				if i%10 == 0 {
+					s.PurgeExpired(ctx, 5)
+					s.DeleteByRecipient(ctx, "user0@example.com")
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if s.Len() > 64 {
+		t.Errorf("Expected at most 64 tokens, got %d", s.Len())
+	}
+}
+
+// benchmarkLogins runs a Store, Exists and VerifyAndConsume per iteration from
+// parallel goroutines, like concurrent StartLogin and VerifyLogin calls.
+func benchmarkLogins(b *testing.B, s store.TokenStore) {
+	ctx := context.Background()
+	codeHash := sha256.Sum256([]byte("123456"))
+	var next atomic.Int64
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := strconv.FormatInt(next.Add(1), 10)
+			s.Store(ctx, store.Token{
+				ID:        id,
+				Recipient: "bench@example.com" + id,
+				CodeHash:  codeHash[:],
+				ExpiresAt: time.Now().Add(time.Minute),
+			})
+			if _, err := s.Exists(ctx, id); err != nil {
+				b.Error(err)
+			}
+			if _, err := s.VerifyAndConsume(ctx, id, "123456", 3); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMemStore_Logins(b *testing.B) {
+	benchmarkLogins(b, store.NewMemStore())
+}
+
+func BenchmarkShardedMemStore_Logins(b *testing.B) {
+	benchmarkLogins(b, store.NewShardedMemStore(0, 0))
+}
+
+// benchmarkPurge measures PurgeExpired removing 10 expired tokens from a
+// store that also holds 100,000 live ones.
+func benchmarkPurge(b *testing.B, s interface {
+	store.TokenStore
+	store.Purger
+}) {
+	ctx := context.Background()
+	for i := 0; i < 100_000; i++ {
+		s.Store(ctx, store.Token{ID: "live-" + strconv.Itoa(i), ExpiresAt: time.Now().Add(time.Hour)})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j := 0; j < 10; j++ {
+			s.Store(ctx, store.Token{ID: "expired-" + strconv.Itoa(j), ExpiresAt: time.Now().Add(-time.Minute)})
+		}
+		b.StartTimer()
+		if n, _ := s.PurgeExpired(ctx, 0); n != 10 {
+			b.Fatalf("Expected 10 tokens purged, got %d", n)
+		}
+	}
+}
+
+func BenchmarkMemStore_PurgeExpired(b *testing.B) {
+	benchmarkPurge(b, store.NewMemStore())
+}
+
+func BenchmarkShardedMemStore_PurgeExpired(b *testing.B) {
+	benchmarkPurge(b, store.NewShardedMemStore(0, 0))
+}
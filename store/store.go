@@ -4,17 +4,27 @@ import (
 	"context"
 	"crypto/sha256"
 	"crypto/subtle"
+	"errors"
 	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
 )
 
 // Token represents the stored code and associated data.
 type Token struct {
 	ID        string
 	Recipient string
-	CodeHash  []byte
+	CodeHash  []byte // Empty for link-only tokens, so no code can match
 	ExpiresAt time.Time
 	CreatedAt time.Time
 	Attempts  int // Track number of failed attempts
+
+	// LinkHash is the SHA-256 hash of the token's login-link secret. It is
+	// independent of CodeHash and nil for tokens that have no login link.
+	LinkHash []byte
+
+	ResendCount int       // Number of times the code has been re-sent
+	LastSentAt  time.Time // When the current code was last delivered
 }
 
 // TokenStore defines how tokens are saved, retrieved, verified, and deleted.
@@ -30,25 +40,75 @@ type TokenStore interface {
 	// Implementations should not reset expiry or other fields when updating attempts.
 	UpdateAttempts(ctx context.Context, tokenID string, attempts int) error
 
+	// Reissue replaces the code and link hashes, expiry and resend bookkeeping
+	// (CodeHash, LinkHash, ExpiresAt, ResendCount and LastSentAt) of an
+	// existing token. The ID, recipient and attempt count are left unchanged. The update only
+	// applies while the stored ResendCount still equals prevResendCount, the
+	// value the caller read; otherwise ErrTokenConflict is returned, so two
+	// concurrent resends cannot both succeed. Returns ErrTokenNotFound if the
+	// token no longer exists.
+	Reissue(ctx context.Context, tok Token, prevResendCount int) error
+
 	// Verify checks if `code` matches the stored hash for tokenID, and
 	// whether it's still valid. If valid, it may also consume or remove the token.
 	Verify(ctx context.Context, tokenID, code string) (bool, error)
 
+	// VerifyAndConsume compares `code` against the stored hash and updates the
+	// token in a single atomic step. On a match the token is deleted and returned,
+	// so a code can only ever be redeemed once. On a mismatch the attempt counter
+	// is incremented; once it reaches maxAttempts the token is deleted and
+	// ErrTokenLocked is returned, otherwise an *InvalidCodeError is returned.
+	VerifyAndConsume(ctx context.Context, tokenID, code string, maxAttempts int) (*Token, error)
+
+	// VerifyLinkAndConsume is VerifyAndConsume for login links: `secret` is
+	// compared against LinkHash instead of CodeHash, with the same consume and
+	// attempt accounting. A token without a LinkHash never matches.
+	VerifyLinkAndConsume(ctx context.Context, tokenID, secret string, maxAttempts int) (*Token, error)
+
 	// Delete permanently removes a token by ID (e.g. after verification).
 	Delete(ctx context.Context, tokenID string) error
+
+	// DeleteByRecipient removes every outstanding token issued to recipient and
+	// reports how many were removed.
+	DeleteByRecipient(ctx context.Context, recipient string) (int, error)
 }
 
-// Checks whether a given token is expired.
-func IsTokenExpired(tok *Token) bool {
-	return time.Now().After(tok.ExpiresAt)
+// Purger is implemented by stores that can remove expired tokens in bulk.
+// Without it, an expired token is only removed when it is next looked up, so
+// abandoned logins accumulate. MemStore, ShardedMemStore and DbStore
+// implement it; see Manager.StartJanitor for running it periodically.
+// RedisStore does not need it, as Redis expires tokens itself.
+type Purger interface {
+	// PurgeExpired removes up to limit expired tokens, or every expired token
+	// if limit <= 0, and reports how many were removed.
+	PurgeExpired(ctx context.Context, limit int) (int, error)
+}
+
+// ErrPurgeUnsupported is returned by PurgeExpired on wrappers (such as the
+// metrics decorator) around a store that does not implement Purger.
+var ErrPurgeUnsupported = errors.New("store does not support purging expired tokens")
+
+// Checks whether a given token is expired according to c (the real clock if nil).
+func IsTokenExpired(tok *Token, c clock.Clock) bool {
+	return clock.Or(c).Now().After(tok.ExpiresAt)
 }
 
 // Verifies the provided code against the stored token's hash.
 func VerifyToken(tok *Token, code string) bool {
-	codeHash := sha256.Sum256([]byte(code))
+	return matchHash(tok.CodeHash, code)
+}
+
+// Verifies the provided link secret against the stored token's link hash.
+func VerifyLink(tok *Token, secret string) bool {
+	return matchHash(tok.LinkHash, secret)
+}
+
+// matchHash reports whether value hashes to stored.
+func matchHash(stored []byte, value string) bool {
+	hash := sha256.Sum256([]byte(value))
 	// Securely compares two hashes using constant-time comparison.
-	if len(tok.CodeHash) != len(codeHash) {
+	if len(stored) != len(hash) {
 		return false
 	}
-	return subtle.ConstantTimeCompare(codeHash[:], tok.CodeHash) == 1
+	return subtle.ConstantTimeCompare(hash[:], stored) == 1
 }
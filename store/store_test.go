@@ -3,9 +3,14 @@ package store_test
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/mockredis"
 	"github.com/rlnorthcutt/go-passwordless/store"
 )
 
@@ -14,8 +19,12 @@ func TestTokenStore(t *testing.T) {
 	mem := store.NewMemStore()
 	// Add more stores as needed (e.g. DbStore, CookieStore)
 
+	redis := newRedisStore(t, mockredis.NewServer())
+
 	testers := map[string]store.TokenStore{
-		"mem": mem,
+		"mem":     mem,
+		"sharded": store.NewShardedMemStore(0, 0),
+		"redis":   redis,
 	}
 
 	for name, s := range testers {
@@ -23,11 +32,168 @@ func TestTokenStore(t *testing.T) {
 			t.Logf("[DEBUG] Running store verification test for: %s", name)
 			runStoreVerifyTest(t, s)
 		})
-		t.Run(name+"_expiry", func(t *testing.T) {
-			t.Logf("[DEBUG] Running store expiry test for: %s", name)
-			runStoreExpiryTest(t, s)
+		t.Run(name+"_concurrent_verify", func(t *testing.T) {
+			t.Logf("[DEBUG] Running concurrent verification test for: %s", name)
+			runStoreConcurrentVerifyTest(t, s)
+		})
+		t.Run(name+"_reissue", func(t *testing.T) {
+			t.Logf("[DEBUG] Running reissue test for: %s", name)
+			runStoreReissueTest(t, s)
+		})
+		t.Run(name+"_errors", func(t *testing.T) {
+			t.Logf("[DEBUG] Running store error test for: %s", name)
+			runStoreErrorsTest(t, s)
+		})
+		t.Run(name+"_delete_by_recipient", func(t *testing.T) {
+			t.Logf("[DEBUG] Running delete by recipient test for: %s", name)
+			runStoreDeleteByRecipientTest(t, s)
+		})
+		t.Run(name+"_verify_link", func(t *testing.T) {
+			t.Logf("[DEBUG] Running link verification test for: %s", name)
+			runStoreVerifyLinkTest(t, s)
 		})
 	}
+
+	// Expiry needs a store reading a clock the test controls
+	t.Run("mem_expiry", func(t *testing.T) {
+		clk := clocktest.NewFake(time.Now())
+		expiring := store.NewMemStore()
+		expiring.Clock = clk
+		runStoreExpiryTest(t, expiring, clk)
+	})
+	t.Run("mem_purge_expired", func(t *testing.T) {
+		clk := clocktest.NewFake(time.Now())
+		purging := store.NewMemStore()
+		purging.Clock = clk
+		runStorePurgeExpiredTest(t, purging, clk)
+	})
+	t.Run("sharded_expiry", func(t *testing.T) {
+		clk := clocktest.NewFake(time.Now())
+		expiring := store.NewShardedMemStore(4, 0)
+		expiring.Clock = clk
+		runStoreExpiryTest(t, expiring, clk)
+	})
+	t.Run("sharded_purge_expired", func(t *testing.T) {
+		clk := clocktest.NewFake(time.Now())
+		purging := store.NewShardedMemStore(4, 0)
+		purging.Clock = clk
+		runStorePurgeExpiredTest(t, purging, clk)
+	})
+	t.Run("redis_expiry", func(t *testing.T) {
+		clk := clocktest.NewFake(time.Now())
+		expiring := newRedisStore(t, &mockredis.Server{Clock: clk})
+		expiring.Clock = clk
+		runStoreExpiryTest(t, expiring, clk)
+	})
+}
+
+// purgingStore is a TokenStore that can also purge expired tokens.
+type purgingStore interface {
+	store.TokenStore
+	store.Purger
+}
+
+// runStorePurgeExpiredTest checks that PurgeExpired removes only expired
+// tokens, honors its limit and keeps the recipient index consistent. The
+// store must be empty and read clk.
+func runStorePurgeExpiredTest(t *testing.T, s purgingStore, clk *clocktest.Fake) {
+	ctx := context.Background()
+	codeHash := sha256.Sum256([]byte("123456"))
+	for i, ttl := range []time.Duration{time.Minute, time.Minute, time.Minute, time.Hour, time.Hour} {
+		err := s.Store(ctx, store.Token{
+			ID:        fmt.Sprintf("purge-%d", i),
+			Recipient: "purge@example.com",
+			CodeHash:  codeHash[:],
+			ExpiresAt: clk.Now().Add(ttl),
+			CreatedAt: clk.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+	}
+
+	if n, err := s.PurgeExpired(ctx, 0); err != nil || n != 0 {
+		t.Fatalf("Expected nothing to purge before expiry, got %d (%v)", n, err)
+	}
+
+	clk.Advance(2 * time.Minute)
+	if n, err := s.PurgeExpired(ctx, 2); err != nil || n != 2 {
+		t.Fatalf("Expected the limit to cap the purge at 2, got %d (%v)", n, err)
+	}
+	if n, err := s.PurgeExpired(ctx, 0); err != nil || n != 1 {
+		t.Fatalf("Expected the last expired token to be purged, got %d (%v)", n, err)
+	}
+	if n, err := s.PurgeExpired(ctx, 0); err != nil || n != 0 {
+		t.Fatalf("Expected nothing left to purge, got %d (%v)", n, err)
+	}
+
+	for _, id := range []string{"purge-3", "purge-4"} {
+		if _, err := s.Exists(ctx, id); err != nil {
+			t.Errorf("Expected unexpired token %s to survive, got %v", id, err)
+		}
+	}
+	if n, err := s.DeleteByRecipient(ctx, "purge@example.com"); err != nil || n != 2 {
+		t.Errorf("Expected 2 remaining tokens for the recipient, got %d (%v)", n, err)
+	}
+}
+
+// runStoreErrorsTest checks that a store reports each failure condition with
+// the shared sentinel errors.
+func runStoreErrorsTest(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	code := "424242"
+	codeHash := sha256.Sum256([]byte(code))
+
+	t.Run("NotFound", func(t *testing.T) {
+		if _, err := s.Exists(ctx, "no-such-token"); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Errorf("Exists: expected ErrTokenNotFound, got %v", err)
+		}
+		if _, err := s.Verify(ctx, "no-such-token", code); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Errorf("Verify: expected ErrTokenNotFound, got %v", err)
+		}
+		if err := s.UpdateAttempts(ctx, "no-such-token", 1); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Errorf("UpdateAttempts: expected ErrTokenNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		tokenID := "errors-expired"
+		err := s.Store(ctx, store.Token{
+			ID:        tokenID,
+			Recipient: "errors@example.com",
+			CodeHash:  codeHash[:],
+			ExpiresAt: time.Now().Add(-1 * time.Minute),
+			CreatedAt: time.Now().Add(-2 * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		if _, err := s.Exists(ctx, tokenID); !errors.Is(err, store.ErrTokenExpired) {
+			t.Errorf("Exists: expected ErrTokenExpired, got %v", err)
+		}
+		if _, err := s.Exists(ctx, tokenID); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Errorf("Exists: expected expired token to be removed, got %v", err)
+		}
+	})
+
+	t.Run("InvalidCode", func(t *testing.T) {
+		tokenID := "errors-invalid"
+		err := s.Store(ctx, store.Token{
+			ID:        tokenID,
+			Recipient: "errors@example.com",
+			CodeHash:  codeHash[:],
+			ExpiresAt: time.Now().Add(5 * time.Minute),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		ok, err := s.Verify(ctx, tokenID, "000000")
+		if ok || !errors.Is(err, store.ErrInvalidCode) {
+			t.Errorf("Verify: expected ErrInvalidCode, got ok=%v err=%v", ok, err)
+		}
+		_ = s.Delete(ctx, tokenID)
+	})
 }
 
 func runStoreVerifyTest(t *testing.T, s store.TokenStore) {
@@ -71,7 +237,8 @@ func runStoreVerifyTest(t *testing.T, s store.TokenStore) {
 	}
 }
 
-func runStoreExpiryTest(t *testing.T, s store.TokenStore) {
+// runStoreExpiryTest expects s to read the time from clk.
+func runStoreExpiryTest(t *testing.T, s store.TokenStore, clk *clocktest.Fake) {
 	ctx := context.Background()
 	tokenID := "expire-token"
 	code := "999999"
@@ -83,15 +250,20 @@ func runStoreExpiryTest(t *testing.T, s store.TokenStore) {
 		ID:        tokenID,
 		Recipient: "expire@example.com",
 		CodeHash:  codeHash[:],
-		ExpiresAt: time.Now().Add(1 * time.Second),
-		CreatedAt: time.Now(),
+		ExpiresAt: clk.Now().Add(15 * time.Minute),
+		CreatedAt: clk.Now(),
 	})
 	if err != nil {
 		t.Fatalf("Store() error: %v", err)
 	}
 
-	t.Logf("[DEBUG] Waiting for token ID: %s to expire", tokenID)
-	time.Sleep(2 * time.Second)
+	clk.Advance(14 * time.Minute)
+	if _, err := s.Exists(ctx, tokenID); err != nil {
+		t.Fatalf("Expected token to still be valid before TokenExpiry, got %v", err)
+	}
+
+	t.Logf("[DEBUG] Advancing clock past expiry for token ID: %s", tokenID)
+	clk.Advance(2 * time.Minute)
 
 	// Try to verify expired token
 	ok, err := s.Verify(ctx, tokenID, code)
@@ -104,3 +276,281 @@ func runStoreExpiryTest(t *testing.T, s store.TokenStore) {
 		t.Error("Expired token should fail verification, got ok=true")
 	}
 }
+
+// runStoreConcurrentVerifyTest hammers a single token from many goroutines and
+// checks that it can only be redeemed once and never exceeds maxAttempts.
+func runStoreConcurrentVerifyTest(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	code := "314159"
+	codeHash := sha256.Sum256([]byte(code))
+	const workers = 50
+	const maxAttempts = 3
+
+	newToken := func(id string) {
+		err := s.Store(ctx, store.Token{
+			ID:        id,
+			Recipient: "race@example.com",
+			CodeHash:  codeHash[:],
+			ExpiresAt: time.Now().Add(5 * time.Minute),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+	}
+
+	// hammer runs workers concurrent verifications and returns their errors.
+	hammer := func(id, guess string) []error {
+		var wg sync.WaitGroup
+		errs := make([]error, workers)
+		start := make(chan struct{})
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				_, errs[i] = s.VerifyAndConsume(ctx, id, guess, maxAttempts)
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+		return errs
+	}
+
+	t.Run("CorrectCode", func(t *testing.T) {
+		tokenID := "race-correct"
+		newToken(tokenID)
+
+		successes := 0
+		for _, err := range hammer(tokenID, code) {
+			switch {
+			case err == nil:
+				successes++
+			case errors.Is(err, store.ErrTokenConsumed), errors.Is(err, store.ErrTokenNotFound):
+			default:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}
+		if successes != 1 {
+			t.Fatalf("Expected exactly one successful redemption, got %d", successes)
+		}
+	})
+
+	t.Run("WrongCode", func(t *testing.T) {
+		tokenID := "race-wrong"
+		newToken(tokenID)
+
+		invalid, locked := 0, 0
+		for _, err := range hammer(tokenID, "000000") {
+			switch {
+			case errors.Is(err, store.ErrInvalidCode):
+				invalid++
+			case errors.Is(err, store.ErrTokenLocked):
+				locked++
+			case errors.Is(err, store.ErrTokenConsumed), errors.Is(err, store.ErrTokenNotFound):
+			default:
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}
+		if invalid != maxAttempts-1 || locked != 1 {
+			t.Fatalf("Expected %d invalid attempts and 1 lockout, got %d and %d", maxAttempts-1, invalid, locked)
+		}
+	})
+}
+
+// runStoreReissueTest checks that Reissue swaps the code, link and resend
+// bookkeeping while preserving the attempt count.
+func runStoreReissueTest(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	tokenID := "reissue-token"
+	oldHash := sha256.Sum256([]byte("111111"))
+	newHash := sha256.Sum256([]byte("222222"))
+	now := time.Now()
+
+	err := s.Store(ctx, store.Token{
+		ID:         tokenID,
+		Recipient:  "reissue@example.com",
+		CodeHash:   oldHash[:],
+		ExpiresAt:  now.Add(5 * time.Minute),
+		CreatedAt:  now,
+		LastSentAt: now,
+	})
+	if err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+	if err := s.UpdateAttempts(ctx, tokenID, 1); err != nil {
+		t.Fatalf("UpdateAttempts() error: %v", err)
+	}
+
+	linkHash := sha256.Sum256([]byte("new-link-secret"))
+	err = s.Reissue(ctx, store.Token{
+		ID:          tokenID,
+		CodeHash:    newHash[:],
+		LinkHash:    linkHash[:],
+		ExpiresAt:   now.Add(10 * time.Minute),
+		ResendCount: 1,
+		LastSentAt:  now.Add(time.Second),
+	}, 0)
+	if err != nil {
+		t.Fatalf("Reissue() error: %v", err)
+	}
+
+	tok, err := s.Exists(ctx, tokenID)
+	if err != nil {
+		t.Fatalf("Exists() error: %v", err)
+	}
+	if !store.VerifyToken(tok, "222222") {
+		t.Error("Expected the new code to match after Reissue")
+	}
+	if !store.VerifyLink(tok, "new-link-secret") {
+		t.Error("Expected the new link secret to match after Reissue")
+	}
+	if tok.ResendCount != 1 || tok.Attempts != 1 || tok.Recipient != "reissue@example.com" {
+		t.Errorf("Unexpected token after Reissue: %+v", tok)
+	}
+
+	// A second reissue based on the same read loses the race.
+	stale := store.Token{ID: tokenID, CodeHash: oldHash[:], ExpiresAt: now.Add(10 * time.Minute), ResendCount: 1}
+	if err := s.Reissue(ctx, stale, 0); !errors.Is(err, store.ErrTokenConflict) {
+		t.Errorf("Expected ErrTokenConflict reissuing from a stale resend count, got %v", err)
+	}
+	if tok, _ := s.Exists(ctx, tokenID); tok == nil || !store.VerifyToken(tok, "222222") {
+		t.Error("Expected a conflicting Reissue to leave the token unchanged")
+	}
+
+	if err := s.Reissue(ctx, store.Token{ID: "no-such-token"}, 0); !errors.Is(err, store.ErrTokenNotFound) {
+		t.Errorf("Expected ErrTokenNotFound reissuing a missing token, got %v", err)
+	}
+	_ = s.Delete(ctx, tokenID)
+}
+
+// runStoreDeleteByRecipientTest checks that DeleteByRecipient removes every
+// token for one recipient and leaves other recipients alone.
+func runStoreDeleteByRecipientTest(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	codeHash := sha256.Sum256([]byte("123456"))
+
+	tokens := []store.Token{
+		{ID: "revoke-1", Recipient: "revoke@example.com"},
+		{ID: "revoke-2", Recipient: "revoke@example.com"},
+		{ID: "revoke-keep", Recipient: "keep@example.com"},
+	}
+	for _, tok := range tokens {
+		tok.CodeHash = codeHash[:]
+		tok.ExpiresAt = time.Now().Add(5 * time.Minute)
+		tok.CreatedAt = time.Now()
+		tok.LastSentAt = tok.CreatedAt
+		if err := s.Store(ctx, tok); err != nil {
+			t.Fatalf("Store(%s) error: %v", tok.ID, err)
+		}
+	}
+
+	n, err := s.DeleteByRecipient(ctx, "revoke@example.com")
+	if err != nil {
+		t.Fatalf("DeleteByRecipient() error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 tokens removed, got %d", n)
+	}
+
+	for _, id := range []string{"revoke-1", "revoke-2"} {
+		if _, err := s.Exists(ctx, id); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Errorf("Expected %s to be removed, got %v", id, err)
+		}
+	}
+	if _, err := s.Exists(ctx, "revoke-keep"); err != nil {
+		t.Errorf("Expected other recipient's token to remain, got %v", err)
+	}
+	_ = s.Delete(ctx, "revoke-keep")
+
+	if n, err := s.DeleteByRecipient(ctx, "revoke@example.com"); err != nil || n != 0 {
+		t.Errorf("Expected a second call to remove nothing, got n=%d err=%v", n, err)
+	}
+}
+
+// runStoreVerifyLinkTest checks that VerifyLinkAndConsume matches the link
+// secret rather than the code and shares the attempt accounting.
+func runStoreVerifyLinkTest(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	codeHash := sha256.Sum256([]byte("123456"))
+	linkHash := sha256.Sum256([]byte("link-secret"))
+	maxAttempts := 2
+
+	newToken := func(id string, withLink bool) {
+		tok := store.Token{
+			ID:         id,
+			Recipient:  "link@example.com",
+			CodeHash:   codeHash[:],
+			ExpiresAt:  time.Now().Add(5 * time.Minute),
+			CreatedAt:  time.Now(),
+			LastSentAt: time.Now(),
+		}
+		if withLink {
+			tok.LinkHash = linkHash[:]
+		}
+		if err := s.Store(ctx, tok); err != nil {
+			t.Fatalf("Store(%s) error: %v", id, err)
+		}
+	}
+
+	t.Run("CorrectSecret", func(t *testing.T) {
+		newToken("link-correct", true)
+
+		// The code is not a valid link secret
+		if _, err := s.VerifyLinkAndConsume(ctx, "link-correct", "123456", maxAttempts); !errors.Is(err, store.ErrInvalidCode) {
+			t.Fatalf("Expected ErrInvalidCode for the code, got %v", err)
+		}
+		tok, err := s.VerifyLinkAndConsume(ctx, "link-correct", "link-secret", maxAttempts)
+		if err != nil {
+			t.Fatalf("VerifyLinkAndConsume() error: %v", err)
+		}
+		if tok.Attempts != 1 {
+			t.Errorf("Expected the failed attempt to be recorded, got %d", tok.Attempts)
+		}
+		if _, err := s.Exists(ctx, "link-correct"); !errors.Is(err, store.ErrTokenNotFound) {
+			t.Errorf("Expected token to be consumed, got %v", err)
+		}
+	})
+
+	t.Run("Lockout", func(t *testing.T) {
+		newToken("link-lockout", true)
+
+		_, _ = s.VerifyLinkAndConsume(ctx, "link-lockout", "wrong", maxAttempts)
+		if _, err := s.VerifyLinkAndConsume(ctx, "link-lockout", "wrong", maxAttempts); !errors.Is(err, store.ErrTokenLocked) {
+			t.Fatalf("Expected ErrTokenLocked, got %v", err)
+		}
+	})
+
+	t.Run("NoLink", func(t *testing.T) {
+		newToken("link-none", false)
+
+		if _, err := s.VerifyLinkAndConsume(ctx, "link-none", "", maxAttempts); !errors.Is(err, store.ErrInvalidCode) {
+			t.Fatalf("Expected a token without a link to reject every secret, got %v", err)
+		}
+		_ = s.Delete(ctx, "link-none")
+	})
+
+	t.Run("LinkOnly", func(t *testing.T) {
+		err := s.Store(ctx, store.Token{
+			ID:        "link-only",
+			Recipient: "link@example.com",
+			CodeHash:  []byte{},
+			LinkHash:  linkHash[:],
+			ExpiresAt: time.Now().Add(5 * time.Minute),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Store() error for a token with an empty code hash: %v", err)
+		}
+		tok, err := s.Exists(ctx, "link-only")
+		if err != nil {
+			t.Fatalf("Exists() error: %v", err)
+		}
+		if len(tok.CodeHash) != 0 || store.VerifyToken(tok, "") {
+			t.Fatalf("Expected the empty code hash to round-trip and match nothing, got %x", tok.CodeHash)
+		}
+		if _, err := s.VerifyLinkAndConsume(ctx, "link-only", "link-secret", maxAttempts); err != nil {
+			t.Fatalf("VerifyLinkAndConsume() error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package passwordless
+
+import (
+	"context"
+
+	"github.com/rlnorthcutt/go-passwordless/store"
+	"github.com/rlnorthcutt/go-passwordless/tracing"
+)
+
+// Span names used by the Manager.
+const (
+	spanStoreToken = "passwordless.store.store"
+	spanLoadToken  = "passwordless.store.exists"
+	spanSend       = "passwordless.transport.send"
+	spanVerify     = "passwordless.verify"
+)
+
+// tracer returns the configured tracer, or one whose spans do nothing.
+func (m *Manager) tracer() tracing.Tracer {
+	if m.Config.Tracer == nil {
+		return tracing.Noop
+	}
+	return m.Config.Tracer
+}
+
+// storeToken saves tok inside a span.
+func (m *Manager) storeToken(ctx context.Context, tok store.Token) error {
+	ctx, span := m.tracer().Start(ctx, spanStoreToken)
+	span.SetAttribute(tracing.AttrTokenID, tok.ID)
+	err := m.Store.Store(ctx, tok)
+	span.End(err)
+	return err
+}
+
+// loadToken looks up a token inside a span.
+func (m *Manager) loadToken(ctx context.Context, tokenID string) (*store.Token, error) {
+	ctx, span := m.tracer().Start(ctx, spanLoadToken)
+	span.SetAttribute(tracing.AttrTokenID, tokenID)
+	tok, err := m.Store.Exists(ctx, tokenID)
+	span.End(err)
+	return tok, err
+}
@@ -0,0 +1,66 @@
+// Package tracing defines the minimal tracing abstraction used by the Manager
+// to time store, transport and verification calls.
+//
+// The interfaces mirror the subset of OpenTelemetry the library needs, so an
+// adapter is a few lines of code in your application and this module never
+// imports a tracing SDK:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+//		ctx, span := o.t.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ s trace.Span }
+//
+//	func (o otelSpan) SetAttribute(key string, value any) {
+//		o.s.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+//	}
+//
+//	func (o otelSpan) End(err error) {
+//		if err != nil {
+//			o.s.RecordError(err)
+//			o.s.SetStatus(codes.Error, err.Error())
+//		}
+//		o.s.End()
+//	}
+package tracing
+
+import "context"
+
+// Attribute keys set on spans created by the library.
+const (
+	AttrTokenID = "passwordless.token_id"
+	AttrResult  = "passwordless.result"
+)
+
+// Tracer starts spans.
+type Tracer interface {
+	// Start begins a span named name as a child of any span in ctx and returns
+	// a context carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single timed operation.
+type Span interface {
+	// SetAttribute attaches a key/value pair to the span.
+	SetAttribute(key string, value any)
+
+	// End finishes the span, marking it as failed if err is non-nil.
+	End(err error)
+}
+
+// Noop is a Tracer whose spans do nothing. It is used when no tracer is configured.
+var Noop Tracer = noopTracer{}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, any) {}
+func (noopSpan) End(error)                {}
@@ -0,0 +1,21 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rlnorthcutt/go-passwordless/tracing"
+)
+
+func TestNoop(t *testing.T) {
+	ctx := context.Background()
+
+	spanCtx, span := tracing.Noop.Start(ctx, "test")
+	if spanCtx != ctx {
+		t.Errorf("Expected Noop to return the original context")
+	}
+	span.SetAttribute(tracing.AttrTokenID, "abc")
+	span.End(errors.New("ignored"))
+	span.End(nil)
+}
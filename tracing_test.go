@@ -0,0 +1,135 @@
+package passwordless_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/rlnorthcutt/go-passwordless"
+	"github.com/rlnorthcutt/go-passwordless/store"
+	"github.com/rlnorthcutt/go-passwordless/tracing"
+)
+
+// recordedSpan is a finished span captured by recordingTracer.
+type recordedSpan struct {
+	Name   string
+	Parent string
+	Attrs  map[string]any
+	Err    error
+}
+
+type spanKey struct{}
+
+// recordingTracer records every span it starts.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []recordedSpan
+}
+
+func (rt *recordingTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	parent, _ := ctx.Value(spanKey{}).(string)
+	s := &recordingSpan{tracer: rt, rec: recordedSpan{Name: name, Parent: parent, Attrs: map[string]any{}}}
+	return context.WithValue(ctx, spanKey{}, name), s
+}
+
+func (rt *recordingTracer) find(name string) []recordedSpan {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	var out []recordedSpan
+	for _, s := range rt.spans {
+		if s.Name == name {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	rec    recordedSpan
+}
+
+func (s *recordingSpan) SetAttribute(key string, value any) { s.rec.Attrs[key] = value }
+
+func (s *recordingSpan) End(err error) {
+	s.rec.Err = err
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s.rec)
+	s.tracer.mu.Unlock()
+}
+
+func TestManagerTracing(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("CodeFlow", func(t *testing.T) {
+		tracer := &recordingTracer{}
+		cfg := passwordless.DefaultConfig()
+		cfg.Tracer = tracer
+		tt := &TestTransport{}
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), tt, cfg)
+
+		tokenID, err := mgr.StartLogin(ctx, "trace@example.com")
+		if err != nil {
+			t.Fatalf("StartLogin returned error: %v", err)
+		}
+		_, _ = mgr.VerifyLogin(ctx, tokenID, "wrong")
+		if _, err := mgr.VerifyLogin(ctx, tokenID, tt.LastCode); err != nil {
+			t.Fatalf("VerifyLogin returned error: %v", err)
+		}
+
+		stored := tracer.find("passwordless.store.store")
+		if len(stored) != 1 || stored[0].Attrs[tracing.AttrTokenID] != tokenID {
+			t.Errorf("Expected one store span for %s, got %+v", tokenID, stored)
+		}
+		if sent := tracer.find("passwordless.transport.send"); len(sent) != 1 || sent[0].Err != nil {
+			t.Errorf("Expected one successful send span, got %+v", sent)
+		}
+
+		verified := tracer.find("passwordless.verify")
+		if len(verified) != 2 {
+			t.Fatalf("Expected 2 verify spans, got %d", len(verified))
+		}
+		if !errors.Is(verified[0].Err, passwordless.ErrInvalidCode) {
+			t.Errorf("Expected first verify span to end with ErrInvalidCode, got %v", verified[0].Err)
+		}
+		if verified[1].Err != nil {
+			t.Errorf("Expected second verify span to succeed, got %v", verified[1].Err)
+		}
+	})
+
+	t.Run("LinkFlow", func(t *testing.T) {
+		tracer := &recordingTracer{}
+		cfg := passwordless.DefaultConfig()
+		cfg.Tracer = tracer
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), &TestTransport{}, cfg)
+
+		if _, err := mgr.VerifyLoginLink(ctx, "missing", "hash"); !errors.Is(err, passwordless.ErrTokenNotFound) {
+			t.Fatalf("Expected ErrTokenNotFound, got %v", err)
+		}
+
+		// The link is checked and consumed in one store call, like a code
+		verified := tracer.find("passwordless.verify")
+		if len(verified) != 1 || !errors.Is(verified[0].Err, passwordless.ErrTokenNotFound) {
+			t.Fatalf("Expected one verify span recording ErrTokenNotFound, got %+v", verified)
+		}
+		if lookups := tracer.find("passwordless.store.exists"); len(lookups) != 0 {
+			t.Errorf("Expected no separate exists lookup, got %d", len(lookups))
+		}
+	})
+
+	t.Run("SendFailure", func(t *testing.T) {
+		tracer := &recordingTracer{}
+		cfg := passwordless.DefaultConfig()
+		cfg.Tracer = tracer
+		sendErr := errors.New("smtp down")
+		mgr := passwordless.NewManagerWithConfig(store.NewMemStore(), &FailingTransport{Err: sendErr}, cfg)
+
+		_, _ = mgr.StartLogin(ctx, "trace@example.com")
+
+		sent := tracer.find("passwordless.transport.send")
+		if len(sent) != 1 || !errors.Is(sent[0].Err, sendErr) {
+			t.Errorf("Expected send span to record the transport error, got %+v", sent)
+		}
+	})
+}
@@ -0,0 +1,287 @@
+package transport
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+)
+
+// DKIM signing algorithms (RFC 6376 and RFC 8463).
+const (
+	DKIMRSASHA256     = "rsa-sha256"
+	DKIMEd25519SHA256 = "ed25519-sha256"
+)
+
+// DefaultDKIMHeaders are the header fields signed when DKIMSigner.Headers is
+// empty. Fields missing from a message are skipped.
+var DefaultDKIMHeaders = []string{
+	"From", "To", "Subject", "Date", "Message-ID",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// minDKIMRSABits is the smallest RSA key accepted (RFC 8301, section 3.2).
+const minDKIMRSABits = 1024
+
+// ErrDKIMKey is returned for private keys that cannot be used for DKIM.
+var ErrDKIMKey = errors.New("unsupported DKIM private key")
+
+// DKIMSigner adds a DKIM-Signature header (relaxed/relaxed canonicalization)
+// to outgoing messages, so receivers enforcing DMARC can verify that the mail
+// was sent on behalf of Domain. Set it as SMTPTransport.DKIM and publish
+// Record() as a TXT record at <Selector>._domainkey.<Domain>.
+type DKIMSigner struct {
+	Domain   string // d= tag, e.g. "example.com"
+	Selector string // s= tag, e.g. "login2024"
+
+	// Key is an *rsa.PrivateKey (rsa-sha256) or ed25519.PrivateKey
+	// (ed25519-sha256). See ParseDKIMKey.
+	Key crypto.Signer
+
+	// Headers lists the header fields to sign. Defaults to DefaultDKIMHeaders.
+	// From is always signed.
+	Headers []string
+
+	// Clock sets the t= timestamp. Defaults to clock.Real.
+	Clock clock.Clock
+}
+
+// NewDKIMSigner returns a signer for domain and selector, checking that key
+// is usable for DKIM.
+func NewDKIMSigner(domain, selector string, key crypto.Signer) (*DKIMSigner, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim: domain and selector are required")
+	}
+	s := &DKIMSigner{Domain: domain, Selector: selector, Key: key}
+	if _, err := s.algorithm(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ParseDKIMKey parses a PEM-encoded RSA (PKCS #1 or PKCS #8) or Ed25519
+// (PKCS #8) private key.
+func ParseDKIMKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrDKIMKey)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDKIMKey, err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrDKIMKey, key)
+	}
+}
+
+// Record returns the DNS TXT record value publishing the signer's public key.
+func (s *DKIMSigner) Record() (string, error) {
+	alg, err := s.algorithm()
+	if err != nil {
+		return "", err
+	}
+	var keyType string
+	var pub []byte
+	switch alg {
+	case DKIMRSASHA256:
+		keyType = "rsa"
+		if pub, err = x509.MarshalPKIXPublicKey(s.Key.Public()); err != nil {
+			return "", err
+		}
+	case DKIMEd25519SHA256:
+		keyType = "ed25519"
+		pub = s.Key.Public().(ed25519.PublicKey)
+	}
+	return "v=DKIM1; k=" + keyType + "; p=" + base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// Sign returns msg, a complete message with CRLF line endings, with a
+// DKIM-Signature header prepended.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	alg, err := s.algorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	header, body, ok := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !ok {
+		header, body = bytes.TrimSuffix(msg, []byte("\r\n")), nil
+	}
+	fields := splitHeaderFields(string(header))
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	// Sign each listed field once, taking the last instance as RFC 6376,
+	// section 5.4.2 requires.
+	var signed, names []string
+	seen := make(map[string]bool)
+	hasFrom := false
+	for _, name := range s.headers() {
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		for i := len(fields) - 1; i >= 0; i-- {
+			if strings.EqualFold(fieldName(fields[i]), name) {
+				signed = append(signed, relaxedHeader(fields[i]))
+				names = append(names, key)
+				hasFrom = hasFrom || key == "from"
+				break
+			}
+		}
+	}
+	if !hasFrom {
+		return nil, errors.New("dkim: message has no From header")
+	}
+
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		alg, s.Domain, s.Selector, clock.Or(s.Clock).Now().Unix(),
+		strings.Join(names, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	sigField := "DKIM-Signature: " + value
+
+	data := strings.Join(signed, "") + strings.TrimSuffix(relaxedHeader(sigField), "\r\n")
+	digest := sha256.Sum256([]byte(data))
+
+	var opts crypto.SignerOpts = crypto.SHA256
+	if alg == DKIMEd25519SHA256 {
+		// RFC 8463: Ed25519 signs the SHA-256 hash itself (PureEdDSA).
+		opts = crypto.Hash(0)
+	}
+	sig, err := s.Key.Sign(rand.Reader, digest[:], opts)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: signing failed: %w", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sigField)
+	b := base64.StdEncoding.EncodeToString(sig)
+	for len(b) > 0 {
+		n := min(len(b), 64)
+		out.WriteString(b[:n])
+		b = b[n:]
+		if len(b) > 0 {
+			out.WriteString("\r\n\t")
+		}
+	}
+	out.WriteString("\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+// algorithm returns the a= tag matching the key type.
+func (s *DKIMSigner) algorithm() (string, error) {
+	switch k := s.Key.(type) {
+	case *rsa.PrivateKey:
+		if bits := k.N.BitLen(); bits < minDKIMRSABits {
+			return "", fmt.Errorf("%w: RSA key has %d bits, need at least %d", ErrDKIMKey, bits, minDKIMRSABits)
+		}
+		return DKIMRSASHA256, nil
+	case ed25519.PrivateKey:
+		return DKIMEd25519SHA256, nil
+	case nil:
+		return "", fmt.Errorf("%w: no key configured", ErrDKIMKey)
+	default:
+		return "", fmt.Errorf("%w: %T", ErrDKIMKey, s.Key)
+	}
+}
+
+// headers returns the fields to sign, with From first if it was not listed.
+func (s *DKIMSigner) headers() []string {
+	if len(s.Headers) == 0 {
+		return DefaultDKIMHeaders
+	}
+	for _, name := range s.Headers {
+		if strings.EqualFold(name, "From") {
+			return s.Headers
+		}
+	}
+	return append([]string{"From"}, s.Headers...)
+}
+
+// splitHeaderFields splits a header block into fields, keeping folded
+// continuation lines with their field.
+func splitHeaderFields(header string) []string {
+	var fields []string
+	for _, line := range strings.Split(header, "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+func fieldName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.TrimSpace(name)
+}
+
+// relaxedHeader canonicalizes a header field (RFC 6376, section 3.4.2):
+// lowercase name, unfolded value, runs of whitespace reduced to one space and
+// no whitespace around the colon or at the end.
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// relaxedBody canonicalizes a body (RFC 6376, section 3.4.4): whitespace runs
+// reduced to one space, trailing whitespace and trailing empty lines removed.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(collapseWSP(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// collapseWSP replaces each run of spaces and tabs with a single space.
+func collapseWSP(s string) string {
+	var b strings.Builder
+	inWSP := false
+	for _, r := range s {
+		if isWSP(r) {
+			inWSP = true
+			continue
+		}
+		if inWSP {
+			b.WriteByte(' ')
+			inWSP = false
+		}
+		b.WriteRune(r)
+	}
+	if inWSP {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func isWSP(r rune) bool { return r == ' ' || r == '\t' }
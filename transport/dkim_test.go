@@ -0,0 +1,313 @@
+package transport_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/mail"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/transport"
+)
+
+var (
+	wspRun     = regexp.MustCompile(`[ \t]+`)
+	trailingB  = regexp.MustCompile(`b=[^;]*$`)
+	dkimRSAKey = mustRSAKey()
+)
+
+func mustRSAKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// verifyDKIM checks the DKIM-Signature on raw (relaxed/relaxed only) with an
+// implementation independent of the signer, and returns the signature's tags.
+func verifyDKIM(t *testing.T, raw []byte, pub crypto.PublicKey) (map[string]string, error) {
+	t.Helper()
+
+	header, body, _ := strings.Cut(string(raw), "\r\n\r\n")
+	var fields []string
+	for _, line := range strings.Split(header, "\r\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			fields[len(fields)-1] += "\r\n" + line
+		} else {
+			fields = append(fields, line)
+		}
+	}
+	canonHeader := func(f string) string {
+		name, value, _ := strings.Cut(f, ":")
+		value = strings.TrimSpace(wspRun.ReplaceAllString(strings.ReplaceAll(value, "\r\n", ""), " "))
+		return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+	}
+
+	var sigField string
+	for _, f := range fields {
+		if strings.HasPrefix(strings.ToLower(f), "dkim-signature:") {
+			sigField = f
+			break
+		}
+	}
+	if sigField == "" {
+		t.Fatal("No DKIM-Signature header")
+	}
+	tags := make(map[string]string)
+	_, value, _ := strings.Cut(sigField, ":")
+	for _, tag := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(k)] = strings.Join(strings.Fields(v), "")
+	}
+	if tags["c"] != "relaxed/relaxed" {
+		t.Fatalf("Expected relaxed/relaxed canonicalization, got %q", tags["c"])
+	}
+
+	lines := strings.Split(body, "\r\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(wspRun.ReplaceAllString(l, " "), " ")
+	}
+	canonBody := strings.TrimRight(strings.Join(lines, "\r\n"), "\r\n")
+	if canonBody != "" {
+		canonBody += "\r\n"
+	}
+	bh := sha256.Sum256([]byte(canonBody))
+	if got := base64.StdEncoding.EncodeToString(bh[:]); got != tags["bh"] {
+		return tags, errors.New("body hash mismatch")
+	}
+
+	var data strings.Builder
+	used := make(map[int]bool)
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i >= 0; i-- {
+			fname, _, _ := strings.Cut(fields[i], ":")
+			if !used[i] && strings.EqualFold(strings.TrimSpace(fname), name) {
+				used[i] = true
+				data.WriteString(canonHeader(fields[i]) + "\r\n")
+				break
+			}
+		}
+	}
+	data.WriteString(trailingB.ReplaceAllString(canonHeader(sigField), "b="))
+	digest := sha256.Sum256([]byte(data.String()))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatalf("Invalid b= tag: %v", err)
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			t.Fatalf("Expected a=rsa-sha256, got %q", tags["a"])
+		}
+		return tags, rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			t.Fatalf("Expected a=ed25519-sha256, got %q", tags["a"])
+		}
+		if !ed25519.Verify(pub, digest[:], sig) {
+			return tags, errors.New("ed25519 signature mismatch")
+		}
+		return tags, nil
+	}
+	t.Fatalf("Unsupported public key %T", pub)
+	return nil, nil
+}
+
+func testEmail(t *testing.T) []byte {
+	t.Helper()
+	raw, err := (&transport.Email{
+		From:    &mail.Address{Name: "Example", Address: "noreply@example.com"},
+		To:      []*mail.Address{{Address: "user@example.com"}},
+		Subject: "Your login code",
+		Text:    "Your code is 123456.\n\nIt expires in 10 minutes.",
+		HTML:    "<p>Your code is <b>123456</b>.</p>",
+		Date:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}).Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error: %v", err)
+	}
+	return raw
+}
+
+func TestDKIMSigner(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		key  crypto.Signer
+	}{
+		{"RSA", dkimRSAKey},
+		{"Ed25519", edKey},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := transport.NewDKIMSigner("example.com", "login", tt.key)
+			if err != nil {
+				t.Fatalf("NewDKIMSigner error: %v", err)
+			}
+			signer.Clock = clocktest.NewFake(time.Unix(1714564800, 0))
+
+			raw := testEmail(t)
+			signed, err := signer.Sign(raw)
+			if err != nil {
+				t.Fatalf("Sign error: %v", err)
+			}
+			if !strings.HasPrefix(string(signed), "DKIM-Signature: ") || !strings.HasSuffix(string(signed), string(raw)) {
+				t.Fatal("Expected the signature to be prepended to the unchanged message")
+			}
+
+			tags, err := verifyDKIM(t, signed, tt.key.Public())
+			if err != nil {
+				t.Fatalf("Signature did not verify: %v", err)
+			}
+			want := map[string]string{"v": "1", "d": "example.com", "s": "login", "t": "1714564800"}
+			for k, v := range want {
+				if tags[k] != v {
+					t.Errorf("Expected %s=%s, got %q", k, v, tags[k])
+				}
+			}
+			if !strings.HasPrefix(tags["h"], "from:to:subject:date:message-id:") {
+				t.Errorf("Unexpected signed headers %q", tags["h"])
+			}
+
+			// Relaxed canonicalization tolerates whitespace changes in transit...
+			relaxed := strings.Replace(string(signed), "Subject: Your login code", "SUBJECT:   Your\r\n\tlogin   code ", 1)
+			relaxed = strings.TrimSuffix(relaxed, "\r\n") + "  \r\n\r\n\r\n"
+			if _, err := verifyDKIM(t, []byte(relaxed), tt.key.Public()); err != nil {
+				t.Errorf("Expected whitespace changes to verify, got %v", err)
+			}
+
+			// ...but not changes to content.
+			for _, tampered := range []string{
+				strings.Replace(string(signed), "123456", "654321", 1),
+				strings.Replace(string(signed), "Subject: Your login code", "Subject: Your login code!", 1),
+			} {
+				if _, err := verifyDKIM(t, []byte(tampered), tt.key.Public()); err == nil {
+					t.Error("Expected tampered message to fail verification")
+				}
+			}
+		})
+	}
+}
+
+func TestDKIMSigner_BodyCanonicalization(t *testing.T) {
+	// The body from RFC 6376, section 3.4.5, which canonicalizes to " C\r\nD E\r\n".
+	signer, err := transport.NewDKIMSigner("example.com", "login", dkimRSAKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.Sign([]byte("From: a@example.com\r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	tags, err := verifyDKIM(t, signed, dkimRSAKey.Public())
+	if err != nil {
+		t.Fatalf("Signature did not verify: %v", err)
+	}
+	want := sha256.Sum256([]byte(" C\r\nD E\r\n"))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(want[:]) {
+		t.Errorf("Unexpected body hash %q", tags["bh"])
+	}
+}
+
+func TestDKIMSigner_Errors(t *testing.T) {
+	short := &rsa.PrivateKey{PublicKey: rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 511), E: 65537}}
+	if _, err := transport.NewDKIMSigner("example.com", "login", short); !errors.Is(err, transport.ErrDKIMKey) {
+		t.Errorf("Expected short RSA key to be rejected, got %v", err)
+	}
+	if _, err := transport.NewDKIMSigner("", "login", dkimRSAKey); err == nil {
+		t.Error("Expected missing domain to be rejected")
+	}
+
+	signer := &transport.DKIMSigner{Domain: "example.com", Selector: "login", Key: dkimRSAKey, Headers: []string{"Subject"}}
+	signed, err := signer.Sign(testEmail(t))
+	if err != nil {
+		t.Fatalf("Sign error: %v", err)
+	}
+	if tags, _ := verifyDKIM(t, signed, dkimRSAKey.Public()); tags["h"] != "from:subject" {
+		t.Errorf("Expected From to be signed even when not listed, got h=%q", tags["h"])
+	}
+	if _, err := signer.Sign([]byte("Subject: no sender\r\n\r\nbody\r\n")); err == nil {
+		t.Error("Expected a message without From to be rejected")
+	}
+}
+
+func TestParseDKIMKey(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	pkcs8RSA, _ := x509.MarshalPKCS8PrivateKey(dkimRSAKey)
+	pkcs8Ed, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	for name, block := range map[string]*pem.Block{
+		"PKCS1 RSA": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(dkimRSAKey)},
+		"PKCS8 RSA": {Type: "PRIVATE KEY", Bytes: pkcs8RSA},
+		"Ed25519":   {Type: "PRIVATE KEY", Bytes: pkcs8Ed},
+	} {
+		key, err := transport.ParseDKIMKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if _, err := transport.NewDKIMSigner("example.com", "login", key); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	if _, err := transport.ParseDKIMKey([]byte("not a key")); !errors.Is(err, transport.ErrDKIMKey) {
+		t.Errorf("Expected ErrDKIMKey, got %v", err)
+	}
+}
+
+func TestDKIMSigner_Record(t *testing.T) {
+	pub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := transport.NewDKIMSigner("example.com", "login", edKey)
+
+	record, err := signer.Record()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record != "v=DKIM1; k=ed25519; p="+base64.StdEncoding.EncodeToString(pub) {
+		t.Errorf("Unexpected record %q", record)
+	}
+
+	signer, _ = transport.NewDKIMSigner("example.com", "login", dkimRSAKey)
+	if record, _ = signer.Record(); !strings.HasPrefix(record, "v=DKIM1; k=rsa; p=") {
+		t.Errorf("Unexpected record %q", record)
+	}
+}
+
+func TestSMTPTransport_DKIM(t *testing.T) {
+	srv := newTestServer(t, nil)
+	signer, err := transport.NewDKIMSigner("example.com", "login", dkimRSAKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newTestTransport(srv)
+	tr.DKIM = signer
+
+	if err := tr.Send(context.Background(), "user@example.com", "123456"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(msgs))
+	}
+	if _, err := verifyDKIM(t, msgs[0].Data, dkimRSAKey.Public()); err != nil {
+		t.Fatalf("Delivered message did not verify: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidAddress is returned for email addresses that are malformed or
+// contain characters (such as CR or LF) that could inject headers.
+var ErrInvalidAddress = errors.New("invalid email address")
+
+// maxHeaderLine is the line length headers are folded at (RFC 5322, section 2.1.1).
+const maxHeaderLine = 78
+
+// Email is an RFC 5322 message with a plain-text body and an optional HTML
+// alternative (RFC 2045/2046).
+type Email struct {
+	From      *mail.Address
+	To        []*mail.Address
+	Subject   string
+	Text      string
+	HTML      string            // Optional; sent as multipart/alternative with Text when set
+	Date      time.Time         // Defaults to the current time
+	MessageID string            // Without angle brackets; generated from From's domain if empty
+	Headers   map[string]string // Additional headers (e.g. "List-Unsubscribe")
+}
+
+// ParseAddress parses a single address such as "user@example.com" or
+// "Jane Doe <jane@example.com>". Lists, groups and any value containing CR or
+// LF are rejected with ErrInvalidAddress.
+func ParseAddress(address string) (*mail.Address, error) {
+	if strings.ContainsAny(address, "\r\n") {
+		return nil, fmt.Errorf("%w: contains a line break", ErrInvalidAddress)
+	}
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	return addr, nil
+}
+
+// Bytes renders the email with CRLF line endings, ready to hand to an SMTP server.
+func (e *Email) Bytes() ([]byte, error) {
+	if e.From == nil || len(e.To) == 0 {
+		return nil, fmt.Errorf("%w: email needs a sender and at least one recipient", ErrInvalidAddress)
+	}
+
+	date := e.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID := e.MessageID
+	if messageID == "" {
+		var err error
+		if messageID, err = newMessageID(e.From.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	to := make([]string, len(e.To))
+	for i, addr := range e.To {
+		to[i] = addr.String()
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", e.From.String())
+	writeHeader(&buf, "To", strings.Join(to, ", "))
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	writeHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", "<"+messageID+">")
+	names := make([]string, 0, len(e.Headers))
+	for name := range e.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := e.Headers[name]
+		if strings.ContainsAny(name+value, "\r\n") {
+			return nil, fmt.Errorf("header %q contains a line break", name)
+		}
+		writeHeader(&buf, textproto.CanonicalMIMEHeaderKey(name), mime.QEncoding.Encode("utf-8", value))
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	if e.HTML == "" {
+		writeHeader(&buf, "Content-Type", "text/plain; charset=utf-8")
+		writeHeader(&buf, "Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, e.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	writeHeader(&buf, "Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", e.Text},
+		{"text/html; charset=utf-8", e.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// writeHeader writes "name: value", folding the line at whitespace so that no
+// line exceeds maxHeaderLine where possible.
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	line := name + ":"
+	for _, word := range strings.Fields(value) {
+		if len(line)+1+len(word) > maxHeaderLine && strings.Contains(line, " ") {
+			buf.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+// writeQuotedPrintable writes s with CRLF line endings, quoted-printable encoded.
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(crlf(s))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// newMessageID returns a random Message-ID in the domain of from.
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate Message-ID: %w", err)
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	return hex.EncodeToString(b) + "@" + domain, nil
+}
+
+// crlf converts line endings to the CRLF required by SMTP.
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
@@ -0,0 +1,140 @@
+package transport_test
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/transport"
+)
+
+func TestParseAddress(t *testing.T) {
+	valid := map[string]string{
+		"user@example.com":                 "user@example.com",
+		"Jane Doe <jane@example.com>":      "jane@example.com",
+		"=?utf-8?q?J=C3=BCrgen?= <j@x.de>": "j@x.de",
+	}
+	for in, want := range valid {
+		addr, err := transport.ParseAddress(in)
+		if err != nil || addr.Address != want {
+			t.Errorf("ParseAddress(%q) = %v, %v; want %s", in, addr, err, want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"not-an-address",
+		"user@example.com\r\nBcc: victim@example.com",
+		"user@example.com\nSubject: spam",
+		"a@example.com, b@example.com",
+	}
+	for _, in := range invalid {
+		if _, err := transport.ParseAddress(in); !errors.Is(err, transport.ErrInvalidAddress) {
+			t.Errorf("ParseAddress(%q): expected ErrInvalidAddress, got %v", in, err)
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	date := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	email := &transport.Email{
+		From:      &mail.Address{Name: "Exämple", Address: "noreply@example.com"},
+		To:        []*mail.Address{{Address: "user@example.com"}},
+		Subject:   "Ihr Anmeldecode lautet 123456 – gültig für 15 Minuten",
+		Text:      "Code: 123456\nGrüße",
+		HTML:      "<p>Code: <b>123456</b></p>",
+		Date:      date,
+		MessageID: "abc@example.com",
+		Headers:   map[string]string{"x-campaign": "login"},
+	}
+
+	raw, err := email.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error: %v", err)
+	}
+	for i, line := range strings.Split(string(raw), "\r\n") {
+		if len(line) > 998 || strings.Contains(line, "\n") {
+			t.Fatalf("Line %d is not a valid RFC 5322 line: %q", i, line)
+		}
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("Failed to parse generated email: %v", err)
+	}
+
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != email.Subject {
+		t.Errorf("Expected subject to round-trip, got %q (%v)", subject, err)
+	}
+	from, err := msg.Header.AddressList("From")
+	if err != nil || from[0].Name != "Exämple" || from[0].Address != "noreply@example.com" {
+		t.Errorf("Expected From to round-trip, got %v (%v)", from, err)
+	}
+	if got, _ := msg.Header.Date(); !got.Equal(date) {
+		t.Errorf("Expected Date %v, got %v", date, got)
+	}
+	if msg.Header.Get("Message-ID") != "<abc@example.com>" || msg.Header.Get("MIME-Version") != "1.0" {
+		t.Errorf("Unexpected Message-ID or MIME-Version: %v", msg.Header)
+	}
+	if msg.Header.Get("X-Campaign") != "login" {
+		t.Errorf("Expected extra header, got %v", msg.Header)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %q (%v)", mediaType, err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var types, bodies []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		body, _ := io.ReadAll(p) // multipart.Reader decodes quoted-printable
+		types = append(types, p.Header.Get("Content-Type"))
+		bodies = append(bodies, string(body))
+	}
+	if len(types) != 2 || !strings.HasPrefix(types[0], "text/plain") || !strings.HasPrefix(types[1], "text/html") {
+		t.Fatalf("Expected text and HTML parts, got %v", types)
+	}
+	if bodies[0] != "Code: 123456\r\nGrüße" {
+		t.Errorf("Unexpected text part %q", bodies[0])
+	}
+
+	t.Run("TextOnly", func(t *testing.T) {
+		raw, err := (&transport.Email{
+			From: email.From, To: email.To, Subject: "Hello", Text: "Plain",
+		}).Bytes()
+		if err != nil {
+			t.Fatalf("Bytes() error: %v", err)
+		}
+		msg, _ := mail.ReadMessage(strings.NewReader(string(raw)))
+		if !strings.HasPrefix(msg.Header.Get("Content-Type"), "text/plain") || msg.Header.Get("Message-ID") == "" {
+			t.Errorf("Expected a text/plain email with a generated Message-ID, got %v", msg.Header)
+		}
+		if body, _ := io.ReadAll(msg.Body); string(body) != "Plain" {
+			t.Errorf("Expected body %q, got %q", "Plain", body)
+		}
+	})
+
+	t.Run("HeaderInjection", func(t *testing.T) {
+		_, err := (&transport.Email{
+			From: email.From, To: email.To, Text: "x",
+			Headers: map[string]string{"X-Evil": "a\r\nBcc: victim@example.com"},
+		}).Bytes()
+		if err == nil {
+			t.Error("Expected a header containing CRLF to be rejected")
+		}
+	})
+}
@@ -4,14 +4,28 @@ package transport
 
 import (
 	"context"
-	"log"
+	"log/slog"
+
+	"github.com/rlnorthcutt/go-passwordless/logging"
 )
 
 // LogTransport simply logs the token code (for testing/dev).
-type LogTransport struct{}
+//
+// The code is redacted and the recipient masked unless Logger is wrapped with
+// logging.DevMode, which is what you want on a developer machine:
+//
+//	&transport.LogTransport{Logger: logging.DevMode(slog.Default())}
+type LogTransport struct {
+	Logger *slog.Logger // Defaults to slog.Default()
+}
 
 // Send checks for cancellation via ctx, then logs the token code.
 func (l *LogTransport) Send(ctx context.Context, recipient, tokenCode string) error {
+	return l.SendMessage(ctx, Message{Recipient: recipient, Code: tokenCode})
+}
+
+// SendMessage checks for cancellation via ctx, then logs the code and link.
+func (l *LogTransport) SendMessage(ctx context.Context, msg Message) error {
 	// Optionally check for cancellation before doing anything.
 	select {
 	case <-ctx.Done():
@@ -20,6 +34,17 @@ func (l *LogTransport) Send(ctx context.Context, recipient, tokenCode string) er
 	default:
 	}
 
-	log.Printf("[LOG TRANSPORT] Recipient: %s, Code: %s\n", recipient, tokenCode)
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	attrs := []any{logging.KeyRecipient, msg.Recipient}
+	if msg.Code != "" {
+		attrs = append(attrs, logging.KeyCode, msg.Code)
+	}
+	if msg.Link != "" {
+		attrs = append(attrs, logging.KeyLink, msg.Link)
+	}
+	logging.Redacted(logger).InfoContext(ctx, "[LOG TRANSPORT] login code", attrs...)
 	return nil
 }
@@ -1,10 +1,14 @@
 package transport_test
 
 import (
+	"bytes"
 	"context"
+	"log/slog"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/rlnorthcutt/go-passwordless/logging"
 	"github.com/rlnorthcutt/go-passwordless/transport"
 )
 
@@ -44,3 +48,32 @@ func TestLogTransport_Send(t *testing.T) {
 
 	t.Logf("[DEBUG] TestLogTransport_Send completed successfully")
 }
+
+func TestLogTransport_Redaction(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Production", func(t *testing.T) {
+		var buf bytes.Buffer
+		lt := &transport.LogTransport{Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+
+		if err := lt.Send(ctx, "secret@example.com", "123456"); err != nil {
+			t.Fatalf("LogTransport.Send returned error: %v", err)
+		}
+		out := buf.String()
+		if strings.Contains(out, "123456") || strings.Contains(out, "secret@example.com") {
+			t.Fatalf("Expected code and recipient to be redacted, got %s", out)
+		}
+	})
+
+	t.Run("DevMode", func(t *testing.T) {
+		var buf bytes.Buffer
+		lt := &transport.LogTransport{Logger: logging.DevMode(slog.New(slog.NewTextHandler(&buf, nil)))}
+
+		if err := lt.Send(ctx, "dev@example.com", "654321"); err != nil {
+			t.Fatalf("LogTransport.Send returned error: %v", err)
+		}
+		if out := buf.String(); !strings.Contains(out, "654321") {
+			t.Fatalf("Expected the code to be logged in dev mode, got %s", out)
+		}
+	})
+}
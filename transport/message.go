@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"context"
+	"time"
+)
+
+// PurposeLogin is the Message purpose used for passwordless logins.
+const PurposeLogin = "login"
+
+// Message is everything a transport may need to compose a login message.
+type Message struct {
+	Recipient string            // The user's "address" (email address, phone number, ...)
+	Code      string            // The one-time code; empty for link-only logins
+	Link      string            // The one-time login link; empty if none was requested
+	ExpiresAt time.Time         // When the code and link stop working
+	Purpose   string            // Why the message is sent (e.g. PurposeLogin)
+	Locale    string            // Preferred language as a BCP 47 tag (e.g. "de-CH"); empty for the default
+	Metadata  map[string]string // Application-defined values (e.g. the user's name)
+}
+
+// MessageTransport delivers a complete Message. Transports that can make use
+// of the link, expiry or locale implement it in addition to Transport; the
+// Manager prefers SendMessage whenever it is available.
+type MessageTransport interface {
+	// SendMessage delivers msg to msg.Recipient.
+	// The context can handle cancellation or timeouts.
+	SendMessage(ctx context.Context, msg Message) error
+}
+
+// Adapt returns t as a MessageTransport. If t already implements
+// MessageTransport it is returned unchanged; otherwise Send is called with
+// the message's code, or with its link for link-only logins.
+func Adapt(t Transport) MessageTransport {
+	if mt, ok := t.(MessageTransport); ok {
+		return mt
+	}
+	return adapter{t}
+}
+
+// adapter lets a plain Transport receive Messages.
+type adapter struct {
+	t Transport
+}
+
+func (a adapter) SendMessage(ctx context.Context, msg Message) error {
+	body := msg.Code
+	if body == "" {
+		body = msg.Link
+	}
+	return a.t.Send(ctx, msg.Recipient, body)
+}
@@ -0,0 +1,85 @@
+package transport_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/rlnorthcutt/go-passwordless/logging"
+	"github.com/rlnorthcutt/go-passwordless/transport"
+)
+
+// codeTransport only implements the plain Transport interface.
+type codeTransport struct {
+	recipient, body string
+}
+
+func (c *codeTransport) Send(ctx context.Context, recipient, tokenCode string) error {
+	c.recipient, c.body = recipient, tokenCode
+	return nil
+}
+
+func TestAdapt(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("PlainTransport", func(t *testing.T) {
+		ct := &codeTransport{}
+		mt := transport.Adapt(ct)
+
+		msg := transport.Message{Recipient: "user@example.com", Code: "123456", Link: "https://example.com/login"}
+		if err := mt.SendMessage(ctx, msg); err != nil {
+			t.Fatalf("SendMessage returned error: %v", err)
+		}
+		if ct.recipient != "user@example.com" || ct.body != "123456" {
+			t.Errorf("Expected the code to be sent, got %q to %q", ct.body, ct.recipient)
+		}
+
+		// Link-only messages fall back to the link
+		msg.Code = ""
+		_ = mt.SendMessage(ctx, msg)
+		if ct.body != "https://example.com/login" {
+			t.Errorf("Expected the link to be sent for a link-only message, got %q", ct.body)
+		}
+	})
+
+	t.Run("MessageTransport", func(t *testing.T) {
+		lt := &transport.LogTransport{}
+		if mt := transport.Adapt(lt); mt != transport.MessageTransport(lt) {
+			t.Errorf("Expected a MessageTransport to be returned unchanged")
+		}
+	})
+}
+
+func TestLogTransport_SendMessage(t *testing.T) {
+	var buf bytes.Buffer
+	lt := &transport.LogTransport{Logger: logging.DevMode(slog.New(slog.NewTextHandler(&buf, nil)))}
+
+	err := lt.SendMessage(context.Background(), transport.Message{
+		Recipient: "dev@example.com",
+		Code:      "654321",
+		Link:      "https://example.com/login?secret=abc",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage returned error: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "654321") || !strings.Contains(out, "secret=abc") {
+		t.Fatalf("Expected code and link to be logged in dev mode, got %s", out)
+	}
+}
+
+func TestRenderMessage(t *testing.T) {
+	c, err := transport.RenderMessage(context.Background(), nil, transport.Message{
+		Recipient: "user@example.com",
+		Code:      "246810",
+		Link:      "https://example.com/login",
+	})
+	if err != nil {
+		t.Fatalf("RenderMessage returned error: %v", err)
+	}
+	if !strings.Contains(c.Subject, "246810") || !strings.Contains(c.Text, "https://example.com/login") || c.HTML == "" {
+		t.Errorf("Expected the built-in login templates to be used, got %+v", c)
+	}
+}
@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/rlnorthcutt/go-passwordless/render"
+)
+
+// RenderMessage renders msg with r, or with the built-in templates if r is
+// nil. Messages without a purpose are rendered as PurposeLogin. Custom
+// transports (e.g. for SMS) can use it to produce the same wording as
+// SMTPTransport.
+func RenderMessage(ctx context.Context, r render.Renderer, msg Message) (*render.Content, error) {
+	if r == nil {
+		r = render.Default()
+	}
+	purpose := msg.Purpose
+	if purpose == "" {
+		purpose = PurposeLogin
+	}
+	return r.Render(ctx, render.Data{
+		Recipient: msg.Recipient,
+		Code:      msg.Code,
+		Link:      msg.Link,
+		ExpiresAt: msg.ExpiresAt,
+		Purpose:   purpose,
+		Locale:    msg.Locale,
+		Metadata:  msg.Metadata,
+	})
+}
@@ -0,0 +1,332 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// TLSMode selects how SMTPTransport secures its connection.
+type TLSMode int
+
+const (
+	// TLSOpportunistic upgrades the connection with STARTTLS when the server
+	// offers it and continues in plaintext otherwise (the default, matching
+	// smtp.SendMail).
+	TLSOpportunistic TLSMode = iota
+
+	// TLSRequired upgrades the connection with STARTTLS and fails if the
+	// server does not offer it (submission on port 587).
+	TLSRequired
+
+	// TLSImplicit speaks TLS from the first byte (SMTPS on port 465).
+	TLSImplicit
+
+	// TLSDisabled never uses TLS. Only use it for local relays and tests.
+	TLSDisabled
+)
+
+// SASL mechanisms accepted in SMTPTransport.AuthMechanism.
+const (
+	AuthPlain   = "PLAIN"
+	AuthLogin   = "LOGIN"
+	AuthCRAMMD5 = "CRAM-MD5"
+)
+
+// ErrTLSUnavailable is returned in TLSRequired mode when the server does not offer STARTTLS.
+var ErrTLSUnavailable = errors.New("smtp server does not support STARTTLS")
+
+// Defaults for the SMTPTransport timeouts.
+const (
+	defaultDialTimeout = 10 * time.Second
+	defaultSendTimeout = 30 * time.Second
+)
+
+// smtpConn is an SMTP session that has completed the greeting, TLS
+// negotiation and authentication and is ready for MAIL FROM.
+type smtpConn struct {
+	client *smtp.Client
+	conn   net.Conn
+}
+
+// connect dials the server and prepares a session according to the transport's
+// TLS and authentication settings. ctx bounds the dial and the handshakes.
+func (t *SMTPTransport) connect(ctx context.Context) (*smtpConn, error) {
+	addr := net.JoinHostPort(t.Host, t.Port)
+
+	dialTimeout := t.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	sc, err := t.handshake(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+// handshake runs the greeting, EHLO, TLS and AUTH steps on conn.
+func (t *SMTPTransport) handshake(ctx context.Context, conn net.Conn) (*smtpConn, error) {
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	tlsConfig := t.tlsConfig()
+	if t.TLS == TLSImplicit {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, contextError(ctx, fmt.Errorf("tls handshake failed: %w", err))
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	sc := &smtpConn{client: client, conn: conn}
+
+	if err := client.Hello(t.localName()); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	if t.TLS == TLSOpportunistic || t.TLS == TLSRequired {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return nil, contextError(ctx, fmt.Errorf("starttls failed: %w", err))
+			}
+		} else if t.TLS == TLSRequired {
+			return nil, ErrTLSUnavailable
+		}
+	}
+
+	auth, err := t.auth(client)
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return nil, contextError(ctx, fmt.Errorf("smtp authentication failed: %w", err))
+		}
+	}
+	return sc, nil
+}
+
+// send delivers one message over an established session, bounded by ctx.
+func (sc *smtpConn) send(ctx context.Context, from, to string, msg []byte) error {
+	stop := watchContext(ctx, sc.conn)
+	defer stop()
+
+	if err := sc.client.Mail(from); err != nil {
+		return contextError(ctx, err)
+	}
+	if err := sc.client.Rcpt(to); err != nil {
+		return contextError(ctx, err)
+	}
+	w, err := sc.client.Data()
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return contextError(ctx, err)
+	}
+	if err := w.Close(); err != nil {
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+// reset aborts any transaction state with RSET so the session can carry
+// another message.
+func (sc *smtpConn) reset(ctx context.Context) error {
+	stop := watchContext(ctx, sc.conn)
+	defer stop()
+	return contextError(ctx, sc.client.Reset())
+}
+
+// noop checks that the server still answers on this session.
+func (sc *smtpConn) noop(ctx context.Context) error {
+	stop := watchContext(ctx, sc.conn)
+	defer stop()
+	return contextError(ctx, sc.client.Noop())
+}
+
+// close ends the session with QUIT, bounded by ctx, and drops the connection
+// if the server does not answer.
+func (sc *smtpConn) close(ctx context.Context) error {
+	stop := watchContext(ctx, sc.conn)
+	defer stop()
+
+	if err := sc.client.Quit(); err != nil {
+		sc.client.Close()
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+// auth returns the configured smtp.Auth, or one built from Username and
+// Password using AuthMechanism (or the best mechanism the server offers).
+func (t *SMTPTransport) auth(client *smtp.Client) (smtp.Auth, error) {
+	if t.Auth != nil {
+		return t.Auth, nil
+	}
+	if t.Username == "" {
+		return nil, nil
+	}
+
+	mechanism := strings.ToUpper(t.AuthMechanism)
+	if mechanism == "" {
+		_, offered := client.Extension("AUTH")
+		mechanism = chooseMechanism(strings.Fields(strings.ToUpper(offered)), isTLS(client))
+		if mechanism == "" {
+			return nil, fmt.Errorf("smtp server offers no supported AUTH mechanism (offered %q)", offered)
+		}
+	}
+
+	switch mechanism {
+	case AuthPlain:
+		return smtp.PlainAuth("", t.Username, t.Password, t.Host), nil
+	case AuthLogin:
+		return &loginAuth{username: t.Username, password: t.Password, host: t.Host}, nil
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(t.Username, t.Password), nil
+	default:
+		return nil, fmt.Errorf("unsupported smtp auth mechanism %q", t.AuthMechanism)
+	}
+}
+
+// chooseMechanism picks the preferred mechanism among those offered. Without
+// TLS only CRAM-MD5 is chosen, since PLAIN and LOGIN would expose the password.
+func chooseMechanism(offered []string, tlsActive bool) string {
+	preference := []string{AuthCRAMMD5}
+	if tlsActive {
+		preference = []string{AuthPlain, AuthLogin, AuthCRAMMD5}
+	}
+	for _, want := range preference {
+		for _, m := range offered {
+			if m == want {
+				return want
+			}
+		}
+	}
+	return ""
+}
+
+// isTLS reports whether the session is encrypted.
+func isTLS(client *smtp.Client) bool {
+	_, ok := client.TLSConnectionState()
+	return ok
+}
+
+// tlsConfig returns the configured TLS settings with ServerName defaulted to Host.
+func (t *SMTPTransport) tlsConfig() *tls.Config {
+	var cfg *tls.Config
+	if t.TLSConfig != nil {
+		cfg = t.TLSConfig.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = t.Host
+	}
+	return cfg
+}
+
+// localName is the name announced in EHLO.
+func (t *SMTPTransport) localName() string {
+	if t.LocalName == "" {
+		return "localhost"
+	}
+	return t.LocalName
+}
+
+// withTimeout bounds ctx by Timeout unless it already has a deadline.
+func (t *SMTPTransport) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = defaultSendTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// watchContext applies ctx's deadline to conn and interrupts any blocked read
+// or write when ctx is canceled. The returned function stops watching and
+// clears the deadline.
+func watchContext(ctx context.Context, conn net.Conn) (stop func()) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stopAfter := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	return func() {
+		stopAfter()
+		_ = conn.SetDeadline(time.Time{})
+	}
+}
+
+// contextError prefers ctx's error over err once ctx is done, so callers see
+// context.Canceled or context.DeadlineExceeded instead of an I/O timeout.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	// The connection deadline can fire just before ctx notices its own.
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
+// loginAuth implements the non-standard but widely deployed LOGIN mechanism.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Like smtp.PlainAuth, refuse to send the password in the clear except to localhost.
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return AuthLogin, nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+// isLocalhost reports whether name refers to the local machine.
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
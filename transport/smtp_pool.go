@@ -0,0 +1,241 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock"
+)
+
+// ErrPoolClosed is returned by SMTPPool once Close has been called.
+var ErrPoolClosed = errors.New("smtp pool is closed")
+
+// Defaults for SMTPPool.
+const (
+	DefaultPoolSize            = 4
+	DefaultIdleTimeout         = time.Minute
+	DefaultHealthCheckInterval = 5 * time.Second
+)
+
+// SMTPPool sends email like SMTPTransport but keeps up to MaxConns
+// authenticated connections open and reuses them, so high-volume senders pay
+// for the TCP and TLS handshakes once per connection instead of once per
+// message. Sessions are reset with RSET between messages, connections idle
+// longer than HealthCheckInterval are probed with NOOP before reuse, and dead
+// connections are replaced transparently.
+//
+// It is safe for concurrent use. Call Close when done to QUIT idle connections.
+type SMTPPool struct {
+	// Transport provides the server, TLS, authentication, timeout, rendering
+	// and logging settings. Its fields must not change once the pool is in use.
+	Transport *SMTPTransport
+
+	// MaxConns bounds the number of open connections, and therefore of
+	// concurrent deliveries (default DefaultPoolSize).
+	MaxConns int
+
+	// IdleTimeout closes connections that have not been used for this long
+	// (default DefaultIdleTimeout). Many servers drop idle clients after a
+	// minute or so.
+	IdleTimeout time.Duration
+
+	// HealthCheckInterval is how long a connection may sit idle before it is
+	// checked with NOOP on reuse (default DefaultHealthCheckInterval).
+	HealthCheckInterval time.Duration
+
+	// MaxMessages recycles a connection after it has carried this many
+	// messages, for servers that limit messages per session. Zero means no limit.
+	MaxMessages int
+
+	// Clock is used to track idle time. Defaults to clock.Real.
+	Clock clock.Clock
+
+	initOnce sync.Once
+	slots    chan struct{}
+
+	mu     sync.Mutex
+	idle   []*pooledConn
+	closed bool
+}
+
+// pooledConn is an open session with its bookkeeping.
+type pooledConn struct {
+	*smtpConn
+	lastUsed time.Time
+	sent     int
+}
+
+// NewSMTPPool returns a pool of up to maxConns connections configured by t.
+func NewSMTPPool(t *SMTPTransport, maxConns int) *SMTPPool {
+	return &SMTPPool{Transport: t, MaxConns: maxConns}
+}
+
+// Send emails tokenCode to the recipient over a pooled connection.
+func (p *SMTPPool) Send(ctx context.Context, to, tokenCode string) error {
+	return p.SendMessage(ctx, Message{Recipient: to, Code: tokenCode})
+}
+
+// SendMessage renders m like SMTPTransport.SendMessage and delivers it over a
+// pooled connection.
+func (p *SMTPPool) SendMessage(ctx context.Context, m Message) error {
+	return p.Transport.sendMessage(ctx, m, p.deliver)
+}
+
+// Close QUITs all idle connections. Connections in use are closed when their
+// delivery finishes, and later sends fail with ErrPoolClosed.
+func (p *SMTPPool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	var errs []error
+	for _, pc := range idle {
+		errs = append(errs, p.quit(pc))
+	}
+	return errors.Join(errs...)
+}
+
+func (p *SMTPPool) init() {
+	p.initOnce.Do(func() {
+		size := p.MaxConns
+		if size <= 0 {
+			size = DefaultPoolSize
+		}
+		p.slots = make(chan struct{}, size)
+	})
+}
+
+// deliver sends msg over an idle connection, or a new one if none is usable.
+func (p *SMTPPool) deliver(ctx context.Context, from, to string, msg []byte) error {
+	p.init()
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	pc, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := pc.send(ctx, from, to, msg); err != nil {
+		// A rejection from the server leaves the session usable; anything
+		// else (I/O errors, cancellation) leaves it in an unknown state.
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) && pc.reset(ctx) == nil {
+			p.put(pc)
+		} else {
+			pc.client.Close()
+		}
+		return err
+	}
+	pc.sent++
+	p.put(pc)
+	return nil
+}
+
+// get returns a healthy, reset idle connection or dials a new one.
+func (p *SMTPPool) get(ctx context.Context) (*pooledConn, error) {
+	for {
+		pc, err := p.popIdle()
+		if err != nil {
+			return nil, err
+		}
+		if pc == nil {
+			break
+		}
+
+		idleFor := clock.Or(p.Clock).Now().Sub(pc.lastUsed)
+		if idleFor > p.idleTimeout() {
+			p.quit(pc)
+			continue
+		}
+		if idleFor > p.healthCheckInterval() {
+			if err := pc.noop(ctx); err != nil {
+				pc.client.Close()
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				continue
+			}
+		}
+		if err := pc.reset(ctx); err != nil {
+			pc.client.Close()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		return pc, nil
+	}
+
+	sc, err := p.Transport.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledConn{smtpConn: sc}, nil
+}
+
+// popIdle takes the most recently used idle connection, or nil if there is none.
+func (p *SMTPPool) popIdle() (*pooledConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	n := len(p.idle)
+	if n == 0 {
+		return nil, nil
+	}
+	pc := p.idle[n-1]
+	p.idle = p.idle[:n-1]
+	return pc, nil
+}
+
+// put returns pc to the idle list, or closes it if it is spent or the pool is closed.
+func (p *SMTPPool) put(pc *pooledConn) {
+	pc.lastUsed = clock.Or(p.Clock).Now()
+
+	p.mu.Lock()
+	keep := !p.closed && (p.MaxMessages <= 0 || pc.sent < p.MaxMessages)
+	if keep {
+		p.idle = append(p.idle, pc)
+	}
+	p.mu.Unlock()
+
+	if !keep {
+		p.quit(pc)
+	}
+}
+
+// quit ends pc's session, waiting at most DialTimeout for the server's reply.
+func (p *SMTPPool) quit(pc *pooledConn) error {
+	timeout := p.Transport.DialTimeout
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return pc.close(ctx)
+}
+
+func (p *SMTPPool) idleTimeout() time.Duration {
+	if p.IdleTimeout == 0 {
+		return DefaultIdleTimeout
+	}
+	return p.IdleTimeout
+}
+
+func (p *SMTPPool) healthCheckInterval() time.Duration {
+	if p.HealthCheckInterval == 0 {
+		return DefaultHealthCheckInterval
+	}
+	return p.HealthCheckInterval
+}
@@ -0,0 +1,234 @@
+package transport_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/clock/clocktest"
+	"github.com/rlnorthcutt/go-passwordless/mocksmtp"
+	"github.com/rlnorthcutt/go-passwordless/transport"
+)
+
+func newTestPool(t testing.TB, srv *mocksmtp.Server, maxConns int) *transport.SMTPPool {
+	tr := newTestTransport(srv)
+	tr.TLS = transport.TLSRequired
+	pool := transport.NewSMTPPool(tr, maxConns)
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func TestSMTPPool_ReusesConnections(t *testing.T) {
+	srv := newTestServer(t, nil)
+	pool := newTestPool(t, srv, 2)
+
+	for i := 0; i < 5; i++ {
+		if err := pool.Send(context.Background(), fmt.Sprintf("user%d@example.com", i), "123456"); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+
+	if n := len(srv.Messages()); n != 5 {
+		t.Fatalf("Expected 5 messages, got %d", n)
+	}
+	if n := srv.Connections(); n != 1 {
+		t.Errorf("Expected sequential sends to share 1 connection, got %d", n)
+	}
+	if n := srv.CommandCount("STARTTLS"); n != 1 {
+		t.Errorf("Expected 1 TLS handshake, got %d", n)
+	}
+	if n := srv.CommandCount("RSET"); n != 4 {
+		t.Errorf("Expected RSET before each reuse (4), got %d", n)
+	}
+	if n := srv.CommandCount("QUIT"); n != 0 {
+		t.Errorf("Expected connection to stay open, got %d QUITs", n)
+	}
+}
+
+func TestSMTPPool_BoundsConnections(t *testing.T) {
+	srv := newTestServer(t, nil)
+	pool := newTestPool(t, srv, 3)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- pool.Send(context.Background(), fmt.Sprintf("user%d@example.com", i), "123456")
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	if n := len(srv.Messages()); n != 50 {
+		t.Fatalf("Expected 50 messages, got %d", n)
+	}
+	if n := srv.Connections(); n > 3 {
+		t.Errorf("Expected at most 3 connections, got %d", n)
+	}
+}
+
+func TestSMTPPool_HealthCheck(t *testing.T) {
+	srv := newTestServer(t, nil)
+	pool := newTestPool(t, srv, 1)
+	fake := clocktest.NewFake(time.Now())
+	pool.Clock = fake
+	pool.HealthCheckInterval = 10 * time.Second
+	pool.IdleTimeout = time.Minute
+
+	send := func() {
+		t.Helper()
+		if err := pool.Send(context.Background(), "user@example.com", "123456"); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	send()
+	send()
+	if n := srv.CommandCount("NOOP"); n != 0 {
+		t.Fatalf("Expected no NOOP for a recently used connection, got %d", n)
+	}
+
+	fake.Advance(30 * time.Second)
+	send()
+	if n := srv.CommandCount("NOOP"); n != 1 {
+		t.Fatalf("Expected NOOP after idling, got %d", n)
+	}
+
+	fake.Advance(2 * time.Minute)
+	send()
+	if n := srv.Connections(); n != 2 {
+		t.Fatalf("Expected connection idle past IdleTimeout to be replaced, got %d connections", n)
+	}
+	if n := srv.CommandCount("QUIT"); n != 1 {
+		t.Errorf("Expected expired connection to be closed with QUIT, got %d", n)
+	}
+}
+
+func TestSMTPPool_Reconnects(t *testing.T) {
+	srv := newTestServer(t, nil)
+	pool := newTestPool(t, srv, 1)
+
+	if err := pool.Send(context.Background(), "user@example.com", "123456"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	srv.CloseConnections()
+
+	if err := pool.Send(context.Background(), "user@example.com", "654321"); err != nil {
+		t.Fatalf("Expected transparent reconnect, got %v", err)
+	}
+	if n := srv.Connections(); n != 2 {
+		t.Errorf("Expected 2 connections, got %d", n)
+	}
+	if n := len(srv.Messages()); n != 2 {
+		t.Errorf("Expected 2 messages, got %d", n)
+	}
+}
+
+func TestSMTPPool_KeepsConnectionAfterRejection(t *testing.T) {
+	srv := newTestServer(t, nil)
+	pool := newTestPool(t, srv, 1)
+	srv.FailOnce(mocksmtp.StageRcpt, 550)
+
+	err := pool.Send(context.Background(), "nobody@example.com", "123456")
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != 550 {
+		t.Fatalf("Expected 550 rejection, got %v", err)
+	}
+	if err := pool.Send(context.Background(), "user@example.com", "123456"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if n := srv.Connections(); n != 1 {
+		t.Errorf("Expected the connection to survive a rejection, got %d connections", n)
+	}
+}
+
+func TestSMTPPool_MaxMessages(t *testing.T) {
+	srv := newTestServer(t, nil)
+	pool := newTestPool(t, srv, 1)
+	pool.MaxMessages = 2
+
+	for i := 0; i < 5; i++ {
+		if err := pool.Send(context.Background(), "user@example.com", "123456"); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	if n := srv.Connections(); n != 3 {
+		t.Errorf("Expected 3 connections for 5 messages at 2 per connection, got %d", n)
+	}
+}
+
+func TestSMTPPool_Close(t *testing.T) {
+	srv := newTestServer(t, nil)
+	pool := newTestPool(t, srv, 2)
+
+	if err := pool.Send(context.Background(), "user@example.com", "123456"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if n := srv.CommandCount("QUIT"); n != 1 {
+		t.Errorf("Expected idle connection to be closed with QUIT, got %d", n)
+	}
+	if err := pool.Send(context.Background(), "user@example.com", "123456"); !errors.Is(err, transport.ErrPoolClosed) {
+		t.Fatalf("Expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestSMTPPool_ContextCanceledWhileWaiting(t *testing.T) {
+	srv := newTestServer(t, stall(mocksmtp.StageMail))
+	pool := newTestPool(t, srv, 1)
+
+	// Occupy the only connection with a stalled delivery.
+	go pool.Send(context.Background(), "user@example.com", "123456")
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.Send(ctx, "user@example.com", "123456"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+// The benchmarks compare one connection (and TLS handshake) per message with
+// pooled connections, both over STARTTLS against the local test server.
+
+func BenchmarkSMTPTransport_Send(b *testing.B) {
+	srv := newTestServer(b, nil)
+	tr := newTestTransport(srv)
+	tr.TLS = transport.TLSRequired
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := tr.Send(context.Background(), "user@example.com", "123456"); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSMTPPool_Send(b *testing.B) {
+	srv := newTestServer(b, nil)
+	pool := newTestPool(b, srv, 4)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := pool.Send(context.Background(), "user@example.com", "123456"); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
@@ -2,30 +2,134 @@ package transport
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"time"
+
+	"github.com/rlnorthcutt/go-passwordless/logging"
+	"github.com/rlnorthcutt/go-passwordless/render"
 )
 
 // SMTPTransport sends token codes via an SMTP server.
 type SMTPTransport struct {
 	Host string // e.g. "smtp.example.com"
 	Port string // e.g. "587"
-	From string // e.g. "noreply@example.com"
-	Auth smtp.Auth
+	From string // e.g. "noreply@example.com" or "Example <noreply@example.com>"
+
+	// TLS selects plaintext, STARTTLS (opportunistic or required) or implicit
+	// TLS. The zero value upgrades with STARTTLS when the server offers it.
+	TLS TLSMode
+
+	// TLSConfig customizes the TLS client. ServerName defaults to Host.
+	TLSConfig *tls.Config
+
+	// Auth, if set, is used as is. Otherwise, when Username is set, the
+	// transport authenticates with AuthMechanism (AuthPlain, AuthLogin or
+	// AuthCRAMMD5), or picks the best mechanism the server offers.
+	Auth          smtp.Auth
+	Username      string
+	Password      string
+	AuthMechanism string
+
+	// LocalName is the host name sent in EHLO. Defaults to "localhost".
+	LocalName string
+
+	// DialTimeout bounds establishing the TCP connection (default 10s).
+	DialTimeout time.Duration
+
+	// Timeout bounds a whole delivery when ctx has no deadline of its own
+	// (default 30s). Deadlines on ctx are applied to every network operation.
+	Timeout time.Duration
+
+	// Renderer produces the subject and bodies of each email. If nil, the
+	// built-in templates are used (see render.Default).
+	Renderer render.Renderer
+
+	// DKIM, if set, signs every message so that receivers enforcing DMARC can
+	// authenticate it without routing through a signing relay.
+	DKIM *DKIMSigner
+
+	// Logger receives a record for every delivery attempt. If nil, nothing is
+	// logged. Recipients are masked (see logging.Redacted).
+	Logger *slog.Logger
 }
 
+// Send emails tokenCode to the recipient.
 func (t *SMTPTransport) Send(ctx context.Context, to, tokenCode string) error {
-	// Construct message
-	msg := []byte(fmt.Sprintf("Subject: Your Login Code\r\n\r\nYour code is: %s\r\n", tokenCode))
-	addr := t.Host + ":" + t.Port
+	return t.SendMessage(ctx, Message{Recipient: to, Code: tokenCode})
+}
+
+// SendMessage renders m and emails it to m.Recipient. The email has a plain
+// text part and, if the templates provide one, an HTML alternative. Malformed
+// recipients, and any containing line breaks, are rejected with ErrInvalidAddress.
+func (t *SMTPTransport) SendMessage(ctx context.Context, m Message) error {
+	return t.sendMessage(ctx, m, t.deliver)
+}
 
-	// net/smtp.SendMail doesn't directly accept context, so you can't forcibly cancel it mid-flight.
-	// But you can check ctx again before calling:
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+// deliverFunc hands a rendered message to an SMTP server.
+type deliverFunc func(ctx context.Context, from, to string, msg []byte) error
+
+// sendMessage validates, renders and logs m, and passes the encoded email to
+// deliver. It is shared by SMTPTransport and SMTPPool.
+func (t *SMTPTransport) sendMessage(ctx context.Context, m Message, deliver deliverFunc) error {
+	to := m.Recipient
+	rcpt, err := ParseAddress(to)
+	if err != nil {
+		return err
+	}
+	from, err := ParseAddress(t.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+
+	content, err := RenderMessage(ctx, t.Renderer, m)
+	if err != nil {
+		return err
+	}
+	email := &Email{
+		From:    from,
+		To:      []*mail.Address{rcpt},
+		Subject: content.Subject,
+		Text:    content.Text,
+		HTML:    content.HTML,
+	}
+	msg, err := email.Bytes()
+	if err != nil {
+		return err
+	}
+	if t.DKIM != nil {
+		if msg, err = t.DKIM.Sign(msg); err != nil {
+			return err
+		}
 	}
+	addr := net.JoinHostPort(t.Host, t.Port)
 
-	return smtp.SendMail(addr, t.Auth, t.From, []string{to}, msg)
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	logger := logging.Redacted(t.Logger)
+	if err := deliver(ctx, from.Address, rcpt.Address, msg); err != nil {
+		logger.ErrorContext(ctx, "smtp delivery failed",
+			logging.KeyRecipient, to, logging.KeyServer, addr, logging.KeyError, err)
+		return err
+	}
+	logger.DebugContext(ctx, "smtp delivery succeeded", logging.KeyRecipient, to, logging.KeyServer, addr)
+	return nil
+}
+
+// deliver sends msg over a fresh connection that is closed afterwards.
+func (t *SMTPTransport) deliver(ctx context.Context, from, to string, msg []byte) error {
+	sc, err := t.connect(ctx)
+	if err != nil {
+		return err
+	}
+	if err := sc.send(ctx, from, to, msg); err != nil {
+		sc.client.Close()
+		return err
+	}
+	return sc.close(ctx)
 }
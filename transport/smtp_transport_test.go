@@ -2,6 +2,8 @@ package transport_test
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,40 +12,249 @@ import (
 )
 
 func TestSMTPTransport_Send(t *testing.T) {
-	t.Logf("[DEBUG] Starting TestSMTPTransport_Send...")
+	srv := newTestServer(t, nil)
+	tr := newTestTransport(srv)
 
-	// Step 1: Start mock SMTP server on port 2525
-	go mocksmtp.StartMockSMTPServer("2525")
-	time.Sleep(1 * time.Second) // Give the server time to start
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Send(ctx, "user@example.com", "999999"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
 
-	tr := &transport.SMTPTransport{
-		Host: "localhost",
-		Port: "2525",
-		From: "test@example.com",
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(msgs))
+	}
+	text, err := msgs[0].Text()
+	if err != nil || !strings.Contains(text, "999999") {
+		t.Errorf("Expected the code in the text body, got %q (%v)", text, err)
 	}
+	if from := msgs[0].Header.Get("From"); !strings.Contains(from, "<noreply@example.com>") {
+		t.Errorf("Unexpected From header %q", from)
+	}
+}
 
-	t.Logf("[DEBUG] SMTP Transport configured: host=%s, port=%s, from=%s", tr.Host, tr.Port, tr.From)
+func TestSMTPTransport_RejectsInvalidRecipient(t *testing.T) {
+	tr := &transport.SMTPTransport{Host: "localhost", Port: "1", From: "test@example.com"}
 
-	// Step 2: Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	t.Logf("[DEBUG] Context with timeout set to 5 seconds")
+	err := tr.Send(context.Background(), "user@example.com\r\nBcc: victim@example.com", "123456")
+	if !errors.Is(err, transport.ErrInvalidAddress) {
+		t.Fatalf("Expected ErrInvalidAddress, got %v", err)
+	}
+}
+
+// newTestServer starts a mock SMTP server, applying configure before Start.
+func newTestServer(t testing.TB, configure func(*mocksmtp.Server)) *mocksmtp.Server {
+	t.Helper()
+	srv := mocksmtp.NewServer()
+	if configure != nil {
+		configure(srv)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start mock SMTP server: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func newTestTransport(srv *mocksmtp.Server) *transport.SMTPTransport {
+	return &transport.SMTPTransport{
+		Host:      srv.Host(),
+		Port:      srv.Port(),
+		From:      "Example <noreply@example.com>",
+		TLSConfig: srv.ClientTLSConfig(),
+	}
+}
+
+func disableSTARTTLS(s *mocksmtp.Server) { s.DisableSTARTTLS = true }
+
+// stall makes the server hang at stage for longer than any test waits.
+func stall(stage mocksmtp.Stage) func(*mocksmtp.Server) {
+	return func(s *mocksmtp.Server) {
+		s.Inject(mocksmtp.Fault{Stage: stage, Delay: time.Minute})
+	}
+}
+
+func TestSMTPTransport_TLSModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  func(*mocksmtp.Server)
+		mode    transport.TLSMode
+		wantTLS bool
+		wantErr error
+	}{
+		{name: "opportunistic upgrades", mode: transport.TLSOpportunistic, wantTLS: true},
+		{name: "opportunistic falls back", server: disableSTARTTLS, mode: transport.TLSOpportunistic},
+		{name: "required", mode: transport.TLSRequired, wantTLS: true},
+		{name: "required unavailable", server: disableSTARTTLS, mode: transport.TLSRequired, wantErr: transport.ErrTLSUnavailable},
+		{name: "implicit", server: func(s *mocksmtp.Server) { s.ImplicitTLS = true }, mode: transport.TLSImplicit, wantTLS: true},
+		{name: "disabled", mode: transport.TLSDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, tt.server)
+			tr := newTestTransport(srv)
+			tr.TLS = tt.mode
+
+			err := tr.Send(context.Background(), "user@example.com", "123456")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+				}
+				if len(srv.Messages()) != 0 {
+					t.Fatal("Expected no message to be delivered")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
 
-	// Step 3: Send email
-	recipient := "test@example.com"
-	code := "999999"
+			msgs := srv.Messages()
+			if len(msgs) != 1 {
+				t.Fatalf("Expected 1 message, got %d", len(msgs))
+			}
+			m := msgs[0]
+			if m.TLS != tt.wantTLS {
+				t.Errorf("Expected TLS=%v, got %v", tt.wantTLS, m.TLS)
+			}
+			if m.From != "noreply@example.com" || len(m.To) != 1 || m.To[0] != "user@example.com" {
+				t.Errorf("Unexpected envelope %q -> %v", m.From, m.To)
+			}
+			if !strings.Contains(string(m.Data), "123456") {
+				t.Errorf("Expected code in message body, got:\n%s", m.Data)
+			}
+		})
+	}
+}
 
-	t.Logf("[DEBUG] Attempting to send code %s to recipient %s", code, recipient)
-	err := tr.Send(ctx, recipient, code)
+func TestSMTPTransport_UntrustedCertificate(t *testing.T) {
+	srv := newTestServer(t, nil)
+	tr := newTestTransport(srv)
+	tr.TLS = transport.TLSRequired
+	tr.TLSConfig = nil
 
-	// Step 4: Handle expected error if no SMTP server is running
-	if err != nil {
-		t.Logf("[DEBUG] Expected error due to missing test SMTP server: %v", err)
-		t.Logf("[INFO] If you want to run real tests, start a mock SMTP server like MailHog.")
-		t.Skip("Skipping test due to no running SMTP server.")
-	} else {
-		t.Logf("[DEBUG] Email sent successfully to recipient: %s", recipient)
+	if err := tr.Send(context.Background(), "user@example.com", "123456"); err == nil {
+		t.Fatal("Expected certificate verification to fail")
 	}
+}
+
+func TestSMTPTransport_Auth(t *testing.T) {
+	all := []string{transport.AuthCRAMMD5, transport.AuthLogin, transport.AuthPlain}
+	tests := []struct {
+		name      string
+		offered   []string
+		mechanism string
+		mode      transport.TLSMode
+		want      string
+	}{
+		{name: "auto prefers PLAIN over TLS", offered: all, mode: transport.TLSRequired, want: transport.AuthPlain},
+		{name: "auto falls back to LOGIN", offered: []string{transport.AuthLogin}, mode: transport.TLSRequired, want: transport.AuthLogin},
+		{name: "auto uses CRAM-MD5 without TLS", offered: all, mode: transport.TLSDisabled, want: transport.AuthCRAMMD5},
+		{name: "explicit LOGIN", offered: all, mechanism: "login", mode: transport.TLSRequired, want: transport.AuthLogin},
+		{name: "explicit CRAM-MD5", offered: all, mechanism: transport.AuthCRAMMD5, mode: transport.TLSRequired, want: transport.AuthCRAMMD5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, func(s *mocksmtp.Server) {
+				s.AuthMechanisms = tt.offered
+				s.Credentials = map[string]string{"mailer": "s3cret"}
+			})
+			tr := newTestTransport(srv)
+			tr.TLS = tt.mode
+			tr.Username = "mailer"
+			tr.Password = "s3cret"
+			tr.AuthMechanism = tt.mechanism
+
+			if err := tr.Send(context.Background(), "user@example.com", "123456"); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			if msgs := srv.Messages(); len(msgs) != 1 || msgs[0].AuthMechanism != tt.want {
+				t.Errorf("Expected auth with %s, got %+v", tt.want, msgs)
+			}
+		})
+	}
+}
+
+func TestSMTPTransport_AuthFailures(t *testing.T) {
+	srv := newTestServer(t, func(s *mocksmtp.Server) {
+		s.AuthMechanisms = []string{transport.AuthPlain}
+		s.Credentials = map[string]string{"mailer": "s3cret"}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		tr := newTestTransport(srv)
+		tr.Username, tr.Password = "mailer", "wrong"
+		if err := tr.Send(context.Background(), "user@example.com", "123456"); err == nil {
+			t.Fatal("Expected authentication to fail")
+		}
+	})
+
+	t.Run("no usable mechanism without TLS", func(t *testing.T) {
+		tr := newTestTransport(srv)
+		tr.TLS = transport.TLSDisabled
+		tr.Username, tr.Password = "mailer", "s3cret"
+		if err := tr.Send(context.Background(), "user@example.com", "123456"); err == nil {
+			t.Fatal("Expected PLAIN to be refused over plaintext")
+		}
+	})
+
+	t.Run("unknown mechanism", func(t *testing.T) {
+		tr := newTestTransport(srv)
+		tr.Username, tr.Password = "mailer", "s3cret"
+		tr.AuthMechanism = "XOAUTH2"
+		if err := tr.Send(context.Background(), "user@example.com", "123456"); err == nil {
+			t.Fatal("Expected unsupported mechanism error")
+		}
+	})
+
+	if n := len(srv.Messages()); n != 0 {
+		t.Fatalf("Expected no messages, got %d", n)
+	}
+}
+
+func TestSMTPTransport_ContextCanceled(t *testing.T) {
+	srv := newTestServer(t, stall(mocksmtp.StageData))
+	tr := newTestTransport(srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := tr.Send(ctx, "user@example.com", "123456")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Send took %v after cancellation", elapsed)
+	}
+}
+
+func TestSMTPTransport_Timeouts(t *testing.T) {
+	t.Run("context deadline", func(t *testing.T) {
+		srv := newTestServer(t, stall(mocksmtp.StageMail))
+		tr := newTestTransport(srv)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := tr.Send(ctx, "user@example.com", "123456"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("transport timeout", func(t *testing.T) {
+		srv := newTestServer(t, stall(mocksmtp.StageHelo))
+		tr := newTestTransport(srv)
+		tr.Timeout = 100 * time.Millisecond
 
-	t.Logf("[DEBUG] TestSMTPTransport_Send completed successfully")
+		start := time.Now()
+		if err := tr.Send(context.Background(), "user@example.com", "123456"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("Send took %v with a 100ms timeout", elapsed)
+		}
+	})
 }
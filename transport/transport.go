@@ -4,6 +4,9 @@ import "context"
 
 // Transport is responsible for delivering a token code to a user's "address."
 // Example "address" might be an email address, phone number, etc.
+//
+// Transports that need the login link, expiry or locale should also implement
+// MessageTransport.
 type Transport interface {
 	// Send delivers `tokenCode` to the user's `recipient`.
 	// The context can handle cancellation or timeouts.